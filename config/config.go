@@ -50,6 +50,9 @@ func ValidateEnv() error {
 	if os.Getenv("ADMIN_URL") == "" {
 		log.Println("WARNING: ADMIN_URL not set")
 	}
+	if os.Getenv("PAYMENT_WEBHOOK_SECRET") == "" {
+		log.Println("WARNING: PAYMENT_WEBHOOK_SECRET not set - payment webhooks will be rejected")
+	}
 	if os.Getenv("SMTP_HOST") == "" {
 		log.Println("WARNING: SMTP_HOST not set - email notifications will not work")
 	}
@@ -67,6 +67,31 @@ func Migrate(db *gorm.DB) error {
 		&models.PasswordResetToken{},
 		&models.LoyaltyHistory{},
 		&models.RefreshToken{},
+		&models.OrderStatusEvent{},
+		&models.Payment{},
+		&models.PaymentEvent{},
+		&models.Refund{},
+		&models.RefundItem{},
+		&models.IdempotencyRecord{},
+		&models.DeliverySlot{},
+		&models.StockReservation{},
+		&models.Coupon{},
+		&models.CouponTarget{},
+		&models.CouponRedemption{},
+		&models.PricingRule{},
+		&models.OrderItemDiscount{},
+		&models.LoyaltyTier{},
+		&models.LoyaltyCampaign{},
+		&models.Referral{},
+		&models.GiftCard{},
+		&models.GiftCardTransaction{},
+		&models.UserAddress{},
+		&models.GuestCart{},
+		&models.GuestCartItem{},
+		&models.WishlistItem{},
+		&models.Subscription{},
+		&models.SubscriptionItem{},
+		&models.OrderItemChange{},
 	); err != nil {
 		return err
 	}
@@ -161,6 +186,28 @@ func CreateDefaultAdmin(db *gorm.DB) error {
 	return nil
 }
 
+// CreateDefaultLoyaltyTiers sets up a starter loyalty programme the first time
+// the server runs. Admins can change the tiers afterwards.
+func CreateDefaultLoyaltyTiers(db *gorm.DB) error {
+	var count int64
+	db.Model(&models.LoyaltyTier{}).Count(&count)
+	if count > 0 {
+		return nil
+	}
+
+	tiers := []models.LoyaltyTier{
+		{Name: "Bronze", MinSpend: 0, Multiplier: 1},
+		{Name: "Silver", MinSpend: 250, Multiplier: 1.25},
+		{Name: "Gold", MinSpend: 1000, Multiplier: 1.5},
+	}
+	if err := db.Create(&tiers).Error; err != nil {
+		return fmt.Errorf("failed to create default loyalty tiers: %w", err)
+	}
+
+	log.Println("Default loyalty tiers created")
+	return nil
+}
+
 func CreateDefaultFranchise(db *gorm.DB) error {
 	// Check if a franchise already exists
 	var count int64
@@ -25,6 +25,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 			"role" TEXT DEFAULT 'customer',
 			"franchise_id" TEXT,
 			"loyalty_points" INTEGER DEFAULT 0,
+			"referral_code" TEXT UNIQUE,
 			"phone" TEXT,
 			"is_blocked" INTEGER DEFAULT 0,
 			"created_at" DATETIME,
@@ -97,6 +98,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 			"delivery_radius" REAL DEFAULT 5,
 			"delivery_fee" REAL DEFAULT 4.99,
 			"free_delivery_min" REAL DEFAULT 50,
+			"slot_capacity" INTEGER DEFAULT 10,
 			"phone" TEXT,
 			"email" TEXT,
 			"is_active" INTEGER DEFAULT 1,
@@ -0,0 +1,263 @@
+// Package giftcards issues gift cards and moves money on and off them. Every
+// change to a card's balance is written as a GiftCardTransaction in the same
+// transaction, so a card's history always explains its balance.
+package giftcards
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"grabbi-backend/models"
+	"grabbi-backend/pricing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Transaction types.
+const (
+	TypeIssued   = "issued"
+	TypeRedeemed = "redeemed"
+	TypeRefunded = "refunded"
+)
+
+var (
+	ErrNotFound      = errors.New("gift card not found")
+	ErrInactive      = errors.New("gift card is no longer active")
+	ErrExpired       = errors.New("gift card has expired")
+	ErrWrongStore    = errors.New("gift card cannot be used at this store")
+	ErrEmpty         = errors.New("gift card has no balance left")
+	ErrBalanceMoved  = errors.New("gift card balance has changed")
+	ErrDuplicateCode = errors.New("gift card code already exists")
+)
+
+// codeAlphabet leaves out characters that are easily confused: 0/O and 1/I/L.
+const codeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// NormaliseCode upper-cases a code and drops spaces and dashes, so codes can
+// be typed as printed or not.
+func NormaliseCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// NewCode returns a 16 character code no card has yet.
+func NewCode(db *gorm.DB) (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		b := make([]byte, 16)
+		for i := range b {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+			if err != nil {
+				return "", err
+			}
+			b[i] = codeAlphabet[n.Int64()]
+		}
+		code := string(b)
+
+		var count int64
+		if err := db.Model(&models.GiftCard{}).Unscoped().Where("code = ?", code).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return code, nil
+		}
+	}
+	return "", errors.New("could not generate a unique gift card code")
+}
+
+// Issue creates the card with its balance and records the issue. A card
+// without a code is given one.
+func Issue(db *gorm.DB, card *models.GiftCard) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if card.Code == "" {
+			code, err := NewCode(tx)
+			if err != nil {
+				return err
+			}
+			card.Code = code
+		} else {
+			card.Code = NormaliseCode(card.Code)
+			var count int64
+			tx.Model(&models.GiftCard{}).Unscoped().Where("code = ?", card.Code).Count(&count)
+			if count > 0 {
+				return ErrDuplicateCode
+			}
+		}
+		card.Balance = card.InitialBalance
+		if err := tx.Create(card).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.GiftCardTransaction{
+			GiftCardID:   card.ID,
+			Type:         TypeIssued,
+			Amount:       card.InitialBalance,
+			BalanceAfter: card.Balance,
+			Description:  "Card issued",
+		}).Error
+	})
+}
+
+// Lookup finds a card by its code.
+func Lookup(db *gorm.DB, code string) (*models.GiftCard, error) {
+	var card models.GiftCard
+	err := db.Where("code = ?", NormaliseCode(code)).First(&card).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// CheckUsable reports why the card cannot be spent on an order at the
+// franchise at t, or nil when it can.
+func CheckUsable(card models.GiftCard, franchiseID *uuid.UUID, t time.Time) error {
+	if !card.IsActive {
+		return ErrInactive
+	}
+	if card.ExpiresAt != nil && !t.Before(*card.ExpiresAt) {
+		return ErrExpired
+	}
+	if card.FranchiseID != nil && (franchiseID == nil || *franchiseID != *card.FranchiseID) {
+		return ErrWrongStore
+	}
+	if card.Balance <= 0 {
+		return ErrEmpty
+	}
+	return nil
+}
+
+// Spend is the part of an order paid with one card.
+type Spend struct {
+	Card   models.GiftCard
+	Amount float64
+}
+
+// Plan spends the cards in the order given until amount is covered, returning
+// what each card pays and the total.
+func Plan(cards []models.GiftCard, amount float64) ([]Spend, float64) {
+	var spends []Spend
+	total := 0.0
+	for _, card := range cards {
+		remaining := pricing.RoundMoney(amount - total)
+		if remaining <= 0 {
+			break
+		}
+		portion := pricing.RoundMoney(math.Min(card.Balance, remaining))
+		if portion <= 0 {
+			continue
+		}
+		spends = append(spends, Spend{Card: card, Amount: portion})
+		total = pricing.RoundMoney(total + portion)
+	}
+	return spends, total
+}
+
+// Redeem takes each planned spend off its card for the order. The balance
+// check and decrement are one conditional update, so a card spent elsewhere
+// since it was planned fails with ErrBalanceMoved. Call it inside the order's
+// transaction.
+func Redeem(tx *gorm.DB, spends []Spend, order models.Order) error {
+	orderID := order.ID
+	for _, s := range spends {
+		err := post(tx, s.Card.ID, -s.Amount, models.GiftCardTransaction{
+			OrderID:     &orderID,
+			Type:        TypeRedeemed,
+			Description: fmt.Sprintf("Spent on order %s", order.OrderNumber),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refund returns up to amount of what the order spent on gift cards to the
+// cards it came from, the most recently spent first. It returns how much it
+// returned.
+func Refund(tx *gorm.DB, order models.Order, amount float64) (float64, error) {
+	var spent []struct {
+		GiftCardID uuid.UUID
+		Net        float64
+	}
+	// What is still refundable per card: spends less earlier refunds
+	if err := tx.Model(&models.GiftCardTransaction{}).
+		Where("order_id = ? AND type IN ?", order.ID, []string{TypeRedeemed, TypeRefunded}).
+		Select("gift_card_id, -SUM(amount) AS net, MAX(CASE WHEN type = ? THEN created_at END) AS last_spent", TypeRedeemed).
+		Group("gift_card_id").Order("last_spent DESC").Scan(&spent).Error; err != nil {
+		return 0, err
+	}
+
+	orderID := order.ID
+	returned := 0.0
+	for _, s := range spent {
+		remaining := pricing.RoundMoney(amount - returned)
+		if remaining <= 0 {
+			break
+		}
+		portion := pricing.RoundMoney(math.Min(s.Net, remaining))
+		if portion <= 0 {
+			continue
+		}
+		err := post(tx, s.GiftCardID, portion, models.GiftCardTransaction{
+			OrderID:     &orderID,
+			Type:        TypeRefunded,
+			Description: fmt.Sprintf("Refunded from order %s", order.OrderNumber),
+		})
+		if err != nil {
+			return returned, err
+		}
+		returned = pricing.RoundMoney(returned + portion)
+	}
+	return returned, nil
+}
+
+// Release returns everything a cancelled order still holds on gift cards. It
+// is safe to call more than once.
+func Release(db *gorm.DB, order models.Order) error {
+	if order.GiftCardAmount <= 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		_, err := Refund(tx, order, order.GiftCardAmount)
+		return err
+	})
+}
+
+// post moves the card's balance by amount and records entry with it.
+func post(tx *gorm.DB, cardID uuid.UUID, amount float64, entry models.GiftCardTransaction) error {
+	query := tx.Model(&models.GiftCard{}).Where("id = ?", cardID)
+	if amount < 0 {
+		// A small tolerance so float balances can be spent down to zero
+		query = query.Where("balance >= ?", -amount-0.001)
+	}
+	result := query.UpdateColumn("balance", gorm.Expr("balance + ?", amount))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if amount < 0 {
+			return ErrBalanceMoved
+		}
+		return ErrNotFound
+	}
+
+	var card models.GiftCard
+	if err := tx.Select("id", "balance").Where("id = ?", cardID).First(&card).Error; err != nil {
+		return err
+	}
+	entry.GiftCardID = cardID
+	entry.Amount = amount
+	entry.BalanceAfter = card.Balance
+	return tx.Create(&entry).Error
+}
@@ -0,0 +1,217 @@
+package giftcards
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"grabbi-backend/models"
+	"grabbi-backend/pricing"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupGiftCardDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", uuid.New())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// Raw DDL because the models' uuid defaults are PostgreSQL-specific
+	for _, sql := range []string{
+		`CREATE TABLE "gift_cards" (
+			"id" TEXT PRIMARY KEY,
+			"code" TEXT NOT NULL UNIQUE,
+			"initial_balance" REAL NOT NULL,
+			"balance" REAL NOT NULL,
+			"franchise_id" TEXT,
+			"issued_by_id" TEXT NOT NULL,
+			"expires_at" DATETIME,
+			"is_active" BOOLEAN DEFAULT 1,
+			"created_at" DATETIME,
+			"updated_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+		`CREATE TABLE "gift_card_transactions" (
+			"id" TEXT PRIMARY KEY,
+			"gift_card_id" TEXT NOT NULL,
+			"order_id" TEXT,
+			"type" TEXT NOT NULL,
+			"amount" REAL NOT NULL,
+			"balance_after" REAL NOT NULL,
+			"description" TEXT,
+			"created_at" DATETIME
+		)`,
+	} {
+		if err := db.Exec(sql).Error; err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	return db
+}
+
+func issueCard(t *testing.T, db *gorm.DB, amount float64) models.GiftCard {
+	t.Helper()
+	card := models.GiftCard{InitialBalance: amount, IssuedByID: uuid.New(), IsActive: true}
+	if err := Issue(db, &card); err != nil {
+		t.Fatalf("failed to issue card: %v", err)
+	}
+	return card
+}
+
+func balanceOf(t *testing.T, db *gorm.DB, id uuid.UUID) float64 {
+	t.Helper()
+	var card models.GiftCard
+	if err := db.First(&card, "id = ?", id).Error; err != nil {
+		t.Fatalf("failed to load card: %v", err)
+	}
+	return card.Balance
+}
+
+// ledgerTotal sums the card's transactions, which must always equal its balance.
+func ledgerTotal(t *testing.T, db *gorm.DB, id uuid.UUID) float64 {
+	t.Helper()
+	var total float64
+	db.Model(&models.GiftCardTransaction{}).Where("gift_card_id = ?", id).Select("COALESCE(SUM(amount), 0)").Scan(&total)
+	return pricing.RoundMoney(total)
+}
+
+func TestIssueNormalisesCodeAndRejectsDuplicates(t *testing.T) {
+	db := setupGiftCardDB(t)
+
+	card := models.GiftCard{Code: "gift-1234 abcd", InitialBalance: 25, IssuedByID: uuid.New(), IsActive: true}
+	if err := Issue(db, &card); err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if card.Code != "GIFT1234ABCD" || card.Balance != 25 {
+		t.Errorf("expected code GIFT1234ABCD with 25, got %s with %v", card.Code, card.Balance)
+	}
+	if got := ledgerTotal(t, db, card.ID); got != 25 {
+		t.Errorf("expected an issued entry of 25, got %v", got)
+	}
+
+	dup := models.GiftCard{Code: "GIFT-1234-ABCD", InitialBalance: 10, IssuedByID: uuid.New()}
+	if err := Issue(db, &dup); !errors.Is(err, ErrDuplicateCode) {
+		t.Errorf("expected ErrDuplicateCode, got %v", err)
+	}
+
+	generated := issueCard(t, db, 10)
+	if len(generated.Code) != 16 {
+		t.Errorf("expected a generated 16 character code, got %q", generated.Code)
+	}
+}
+
+func TestCheckUsable(t *testing.T) {
+	store, other := uuid.New(), uuid.New()
+	past := time.Now().Add(-time.Hour)
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		card      models.GiftCard
+		franchise *uuid.UUID
+		want      error
+	}{
+		{"usable anywhere", models.GiftCard{IsActive: true, Balance: 5}, nil, nil},
+		{"inactive", models.GiftCard{IsActive: false, Balance: 5}, nil, ErrInactive},
+		{"expired", models.GiftCard{IsActive: true, Balance: 5, ExpiresAt: &past}, nil, ErrExpired},
+		{"empty", models.GiftCard{IsActive: true, Balance: 0}, nil, ErrEmpty},
+		{"own store", models.GiftCard{IsActive: true, Balance: 5, FranchiseID: &store}, &store, nil},
+		{"other store", models.GiftCard{IsActive: true, Balance: 5, FranchiseID: &store}, &other, ErrWrongStore},
+		{"no store", models.GiftCard{IsActive: true, Balance: 5, FranchiseID: &store}, nil, ErrWrongStore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckUsable(tt.card, tt.franchise, now); !errors.Is(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPlanSpendsCardsInOrder(t *testing.T) {
+	a := models.GiftCard{ID: uuid.New(), Balance: 10}
+	b := models.GiftCard{ID: uuid.New(), Balance: 30}
+	c := models.GiftCard{ID: uuid.New(), Balance: 5}
+
+	spends, total := Plan([]models.GiftCard{a, b, c}, 25.5)
+	if total != 25.5 {
+		t.Fatalf("expected 25.50 covered, got %v", total)
+	}
+	if len(spends) != 2 || spends[0].Amount != 10 || spends[1].Amount != 15.5 {
+		t.Errorf("expected 10 then 15.50, got %+v", spends)
+	}
+
+	_, total = Plan([]models.GiftCard{a, c}, 40)
+	if total != 15 {
+		t.Errorf("expected cards to cover 15 of 40, got %v", total)
+	}
+}
+
+func TestRedeemAndRefundKeepLedgerInStep(t *testing.T) {
+	db := setupGiftCardDB(t)
+	first := issueCard(t, db, 10)
+	second := issueCard(t, db, 20)
+	order := models.Order{ID: uuid.New(), OrderNumber: "ORD-1"}
+
+	spends, total := Plan([]models.GiftCard{first, second}, 18)
+	if err := db.Transaction(func(tx *gorm.DB) error { return Redeem(tx, spends, order) }); err != nil {
+		t.Fatalf("Redeem failed: %v", err)
+	}
+	order.GiftCardAmount = total
+	if balanceOf(t, db, first.ID) != 0 || balanceOf(t, db, second.ID) != 12 {
+		t.Fatalf("expected balances 0 and 12, got %v and %v", balanceOf(t, db, first.ID), balanceOf(t, db, second.ID))
+	}
+
+	// A partial refund goes back to the card spent last first
+	var returned float64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		returned, err = Refund(tx, order, 5)
+		return err
+	})
+	if err != nil || returned != 5 {
+		t.Fatalf("expected 5 refunded, got %v (%v)", returned, err)
+	}
+	if balanceOf(t, db, second.ID) != 17 {
+		t.Errorf("expected second card back to 17, got %v", balanceOf(t, db, second.ID))
+	}
+
+	// Releasing the order returns only what is still outstanding
+	if err := Release(db, order); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if err := Release(db, order); err != nil {
+		t.Fatalf("second Release failed: %v", err)
+	}
+	for _, card := range []models.GiftCard{first, second} {
+		if got := balanceOf(t, db, card.ID); got != card.InitialBalance {
+			t.Errorf("expected card restored to %v, got %v", card.InitialBalance, got)
+		}
+		if got := ledgerTotal(t, db, card.ID); got != card.InitialBalance {
+			t.Errorf("expected ledger to total %v, got %v", card.InitialBalance, got)
+		}
+	}
+}
+
+func TestRedeemFailsWhenBalanceMoved(t *testing.T) {
+	db := setupGiftCardDB(t)
+	card := issueCard(t, db, 10)
+
+	// Planned against a stale balance
+	spends, _ := Plan([]models.GiftCard{card}, 10)
+	db.Model(&models.GiftCard{}).Where("id = ?", card.ID).UpdateColumn("balance", 4)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return Redeem(tx, spends, models.Order{ID: uuid.New(), OrderNumber: "ORD-2"})
+	})
+	if !errors.Is(err, ErrBalanceMoved) {
+		t.Fatalf("expected ErrBalanceMoved, got %v", err)
+	}
+	if got := balanceOf(t, db, card.ID); got != 4 {
+		t.Errorf("expected balance untouched at 4, got %v", got)
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"grabbi-backend/models"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AddressHandler manages the caller's address book.
+type AddressHandler struct {
+	DB *gorm.DB
+}
+
+// addressRequest is the body for creating or updating an address. Fields left
+// out of an update are kept.
+type addressRequest struct {
+	Label                *string  `json:"label"`
+	Line1                *string  `json:"line1"`
+	Line2                *string  `json:"line2"`
+	City                 *string  `json:"city"`
+	Postcode             *string  `json:"postcode"`
+	Latitude             *float64 `json:"latitude"`
+	Longitude            *float64 `json:"longitude"`
+	IsDefault            *bool    `json:"is_default"`
+	DeliveryInstructions *string  `json:"delivery_instructions"`
+}
+
+// applyAddressRequest copies the request onto the address and checks the
+// result is a complete, geocoded address.
+func applyAddressRequest(address *models.UserAddress, req addressRequest) error {
+	if req.Label != nil {
+		address.Label = strings.TrimSpace(*req.Label)
+	}
+	if req.Line1 != nil {
+		address.Line1 = strings.TrimSpace(*req.Line1)
+	}
+	if req.Line2 != nil {
+		address.Line2 = strings.TrimSpace(*req.Line2)
+	}
+	if req.City != nil {
+		address.City = strings.TrimSpace(*req.City)
+	}
+	if req.Postcode != nil {
+		address.Postcode = strings.ToUpper(strings.TrimSpace(*req.Postcode))
+	}
+	if req.Latitude != nil {
+		address.Latitude = *req.Latitude
+	}
+	if req.Longitude != nil {
+		address.Longitude = *req.Longitude
+	}
+	if req.DeliveryInstructions != nil {
+		address.DeliveryInstructions = strings.TrimSpace(*req.DeliveryInstructions)
+	}
+
+	if address.Line1 == "" {
+		return errors.New("line1 is required")
+	}
+	if address.Postcode == "" {
+		return errors.New("postcode is required")
+	}
+	if address.ID == uuid.Nil && (req.Latitude == nil || req.Longitude == nil) {
+		return errors.New("latitude and longitude are required")
+	}
+	if address.Latitude < -90 || address.Latitude > 90 {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if address.Longitude < -180 || address.Longitude > 180 {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// makeDefaultAddress makes the address the user's only default.
+func makeDefaultAddress(tx *gorm.DB, address *models.UserAddress) error {
+	if err := tx.Model(&models.UserAddress{}).
+		Where("user_id = ? AND id <> ? AND is_default = ?", address.UserID, address.ID, true).
+		Update("is_default", false).Error; err != nil {
+		return err
+	}
+	address.IsDefault = true
+	return tx.Model(address).Update("is_default", true).Error
+}
+
+func (h *AddressHandler) ListAddresses(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var addresses []models.UserAddress
+	if err := h.DB.Where("user_id = ?", userID).Order("is_default DESC, created_at ASC").Find(&addresses).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch addresses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, addresses)
+}
+
+// CreateAddress saves an address. A customer's first address becomes their
+// default.
+func (h *AddressHandler) CreateAddress(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req addressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	address := models.UserAddress{UserID: userID.(uuid.UUID)}
+	if err := applyAddressRequest(&address, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.UserAddress{}).Where("user_id = ?", address.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&address).Error; err != nil {
+			return err
+		}
+		if count == 0 || (req.IsDefault != nil && *req.IsDefault) {
+			return makeDefaultAddress(tx, &address)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create address"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, address)
+}
+
+// UpdateAddress changes an address. Setting is_default moves the default to
+// it; the default can only be moved, not cleared.
+func (h *AddressHandler) UpdateAddress(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var address models.UserAddress
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&address).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	var req addressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	if err := applyAddressRequest(&address, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&address).Error; err != nil {
+			return err
+		}
+		if req.IsDefault != nil && *req.IsDefault {
+			return makeDefaultAddress(tx, &address)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update address"})
+		return
+	}
+
+	c.JSON(http.StatusOK, address)
+}
+
+// DeleteAddress removes an address. When it was the default, the most
+// recently added remaining address takes over. Orders placed to it keep their
+// copy of the address.
+func (h *AddressHandler) DeleteAddress(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var address models.UserAddress
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&address).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&address).Error; err != nil {
+			return err
+		}
+		if !address.IsDefault {
+			return nil
+		}
+		var next models.UserAddress
+		err := tx.Where("user_id = ?", address.UserID).Order("created_at DESC").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return makeDefaultAddress(tx, &next)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete address"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Address deleted"})
+}
+
+// orderAddress returns the saved address an order is placed to: the one named
+// by addressID, else the customer's default when useDefault is set. It returns
+// nil when there is none to use.
+func orderAddress(db *gorm.DB, userID interface{}, addressID string, useDefault bool) (*models.UserAddress, error) {
+	var address models.UserAddress
+	if addressID != "" {
+		aID, err := uuid.Parse(addressID)
+		if err != nil {
+			return nil, &checkoutError{http.StatusBadRequest, "Invalid address_id"}
+		}
+		if err := db.Where("id = ? AND user_id = ?", aID, userID).First(&address).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, &checkoutError{http.StatusNotFound, "Address not found"}
+			}
+			return nil, err
+		}
+		return &address, nil
+	}
+	if !useDefault {
+		return nil, nil
+	}
+	err := db.Where("user_id = ? AND is_default = ?", userID, true).First(&address).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
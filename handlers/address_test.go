@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// seedAddress saves an address for the user at the given coordinates.
+func seedAddress(db *gorm.DB, userID uuid.UUID, line1 string, lat, lng float64, isDefault bool) models.UserAddress {
+	address := models.UserAddress{
+		UserID:               userID,
+		Label:                "Home",
+		Line1:                line1,
+		City:                 "London",
+		Postcode:             "EC1A 1BB",
+		Latitude:             lat,
+		Longitude:            lng,
+		IsDefault:            isDefault,
+		DeliveryInstructions: "Leave with the concierge",
+	}
+	db.Create(&address)
+	return address
+}
+
+func TestAddressBookCRUDKeepsOneDefault(t *testing.T) {
+	db := freshDB()
+	router := setupAddressRouter(db)
+	_, token := seedTestUser(db, "addresses@test.com", "customer", nil)
+
+	create := func(body map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("POST", "/api/auth/addresses", body, token))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		return parseResponse(w)
+	}
+
+	home := create(map[string]interface{}{
+		"label": "Home", "line1": "1 High St", "postcode": "ec1a 1bb", "latitude": 51.5074, "longitude": -0.1278,
+	})
+	if home["is_default"] != true || home["postcode"] != "EC1A 1BB" {
+		t.Errorf("expected the first address to be the default with a normalised postcode, got %v / %v", home["is_default"], home["postcode"])
+	}
+	work := create(map[string]interface{}{
+		"label": "Work", "line1": "2 Office Rd", "postcode": "E1 6AN", "latitude": 51.52, "longitude": -0.08, "is_default": true,
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/auth/addresses", nil, token))
+	addresses := parseResponseArray(w)
+	if len(addresses) != 2 {
+		t.Fatalf("expected 2 addresses, got %d", len(addresses))
+	}
+	first := addresses[0].(map[string]interface{})
+	second := addresses[1].(map[string]interface{})
+	if first["id"] != work["id"] || first["is_default"] != true || second["is_default"] != false {
+		t.Errorf("expected work as the only default, listed first, got %v", addresses)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/auth/addresses/"+home["id"].(string), map[string]interface{}{
+		"delivery_instructions": "Ring twice",
+	}, token))
+	if w.Code != http.StatusOK || parseResponse(w)["delivery_instructions"] != "Ring twice" {
+		t.Errorf("expected instructions updated, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Deleting the default hands it to the remaining address
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("DELETE", "/api/auth/addresses/"+work["id"].(string), nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var remaining models.UserAddress
+	db.First(&remaining, "id = ?", home["id"])
+	if !remaining.IsDefault {
+		t.Error("expected the remaining address to become the default")
+	}
+}
+
+func TestAddressRequiresCoordinatesAndOwnership(t *testing.T) {
+	db := freshDB()
+	router := setupAddressRouter(db)
+	owner, _ := seedTestUser(db, "addrowner@test.com", "customer", nil)
+	_, otherToken := seedTestUser(db, "addrother@test.com", "customer", nil)
+	address := seedAddress(db, owner.ID, "1 Mine St", 51.5074, -0.1278, true)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/auth/addresses", map[string]interface{}{
+		"line1": "3 Nowhere Ln", "postcode": "N1 1AA",
+	}, otherToken))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without coordinates, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/auth/addresses/"+address.ID.String(), map[string]interface{}{"line1": "Taken"}, otherToken))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another customer's address, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("DELETE", "/api/auth/addresses/"+address.ID.String(), nil, otherToken))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another customer's address, got %d", w.Code)
+	}
+}
+
+func TestCreateOrderWithAddressIDPicksDeliveringFranchise(t *testing.T) {
+	db := freshDB()
+	router := setupAddressRouter(db)
+	cat := seedCategory(db, "AddrCat")
+	prod := seedProduct(db, "AddrProd", cat.ID, 10.00)
+	user, token := seedTestUser(db, "addrorder@test.com", "customer", nil)
+	owner, _ := seedTestUser(db, "addrfowner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "AddrFranch", owner.ID)
+	seedFranchiseProduct(db, franchise.ID, prod.ID)
+	db.Create(&models.CartItem{ID: uuid.New(), UserID: user.ID, ProductID: prod.ID, Quantity: 1})
+	address := seedAddress(db, user.ID, "10 Downing St", 51.5034, -0.1276, false)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{"payment_method": "cash", "address_id": address.ID.String()}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	if resp["franchise_id"] != franchise.ID.String() {
+		t.Errorf("expected the franchise delivering to the address, got %v", resp["franchise_id"])
+	}
+	if resp["delivery_address"] != "10 Downing St, London, EC1A 1BB" || resp["address_id"] != address.ID.String() {
+		t.Errorf("expected the saved address on the order, got %v / %v", resp["delivery_address"], resp["address_id"])
+	}
+	if resp["delivery_notes"] != "Leave with the concierge" || resp["customer_lat"] != 51.5034 {
+		t.Errorf("expected instructions and coordinates from the address, got %v / %v", resp["delivery_notes"], resp["customer_lat"])
+	}
+}
+
+func TestCreateOrderUsesDefaultAddress(t *testing.T) {
+	db := freshDB()
+	router := setupAddressRouter(db)
+	cat := seedCategory(db, "DefaultCat")
+	prod := seedProduct(db, "DefaultProd", cat.ID, 10.00)
+	user, token := seedTestUser(db, "addrdefault@test.com", "customer", nil)
+	db.Create(&models.CartItem{ID: uuid.New(), UserID: user.ID, ProductID: prod.ID, Quantity: 1})
+
+	// Far from every store: the default's coordinates are used to choose one
+	seedAddress(db, user.ID, "1 Shibuya", 35.6762, 139.6503, true)
+	owner, _ := seedTestUser(db, "addrfar@test.com", "franchise_owner", nil)
+	seedFranchise(db, "LondonFranch", owner.ID)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{"payment_method": "cash"}, token))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 as no store delivers to the default address, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{"payment_method": "cash", "address_id": uuid.New().String()}, token))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown address, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -3,13 +3,14 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"fmt"
+	"errors"
 	"math"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
 
+	"grabbi-backend/loyalty"
 	"grabbi-backend/models"
 	"grabbi-backend/utils"
 
@@ -25,9 +26,11 @@ type AuthHandler struct {
 
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req struct {
-		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required,min=8"`
-		Name     string `json:"name"`
+		Email        string `json:"email" binding:"required,email"`
+		Password     string `json:"password" binding:"required,min=8"`
+		Name         string `json:"name"`
+		ReferralCode string `json:"referral_code"`
+		CartToken    string `json:"cart_token"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -41,21 +44,53 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	var referrer *models.User
+	if req.ReferralCode != "" {
+		r, err := loyalty.FindReferrer(h.DB, req.ReferralCode)
+		if errors.Is(err, loyalty.ErrInvalidReferralCode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid referral code"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check referral code"})
+			return
+		}
+		referrer = r
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
 
+	referralCode, err := loyalty.NewReferralCode(h.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
 	user := models.User{
-		ID:       uuid.New(),
-		Email:    req.Email,
-		Password: string(hashedPassword),
-		Name:     req.Name,
-		Role:     "customer",
+		ID:           uuid.New(),
+		Email:        req.Email,
+		Password:     string(hashedPassword),
+		Name:         req.Name,
+		Role:         "customer",
+		ReferralCode: &referralCode,
 	}
 
-	if err := h.DB.Create(&user).Error; err != nil {
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if referrer != nil {
+			if _, err := loyalty.Refer(tx, *referrer, user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
@@ -83,7 +118,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	// Send welcome email (non-blocking)
 	utils.SendWelcomeEmail(user.Email, user.Name)
 
-	c.JSON(http.StatusCreated, gin.H{
+	response := gin.H{
 		"token":         token,
 		"refresh_token": refreshToken,
 		"user": gin.H{
@@ -92,14 +127,21 @@ func (h *AuthHandler) Register(c *gin.Context) {
 			"name":           user.Name,
 			"role":           user.Role,
 			"loyalty_points": user.LoyaltyPoints,
+			"referral_code":  user.ReferralCode,
 		},
-	})
+	}
+	if merge := mergeGuestCartOnSignIn(h.DB, user.ID, req.CartToken); merge != nil {
+		response["cart_merge"] = merge
+	}
+
+	c.JSON(http.StatusCreated, response)
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req struct {
-		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required"`
+		Email     string `json:"email" binding:"required,email"`
+		Password  string `json:"password" binding:"required"`
+		CartToken string `json:"cart_token"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -168,6 +210,9 @@ func (h *AuthHandler) Login(c *gin.Context) {
 			}
 		}
 	}
+	if merge := mergeGuestCartOnSignIn(h.DB, user.ID, req.CartToken); merge != nil {
+		response["cart_merge"] = merge
+	}
 
 	c.JSON(http.StatusOK, response)
 }
@@ -191,6 +236,7 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 		"name":           user.Name,
 		"role":           user.Role,
 		"loyalty_points": user.LoyaltyPoints,
+		"referral_code":  user.ReferralCode,
 		"franchise_id":   user.FranchiseID,
 		"phone":          user.Phone,
 	}
@@ -320,16 +366,23 @@ func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	// Update only the profile fields so a concurrent loyalty ledger change to the
+	// balance is not overwritten
+	updates := map[string]interface{}{}
 	if req.Name != nil {
 		user.Name = *req.Name
+		updates["name"] = user.Name
 	}
 	if req.Phone != nil {
 		user.Phone = *req.Phone
+		updates["phone"] = user.Phone
 	}
 
-	if err := h.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
-		return
+	if len(updates) > 0 {
+		if err := h.DB.Model(&user).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+			return
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -538,49 +591,11 @@ func (h *AuthHandler) UpdateUser(c *gin.Context) {
 	})
 }
 
+// RedeemPoints used to take points off the balance without giving anything
+// back. Points are now spent at checkout with points_to_redeem, so this only
+// tells old clients where to go.
 func (h *AuthHandler) RedeemPoints(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
-	}
-
-	var req struct {
-		Points int `json:"points" binding:"required,min=1"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
-		return
-	}
-
-	var user models.User
-	if err := h.DB.Where("id = ?", userID).First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	}
-
-	if user.LoyaltyPoints < req.Points {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Insufficient points. You have %d points.", user.LoyaltyPoints)})
-		return
-	}
-
-	user.LoyaltyPoints -= req.Points
-	h.DB.Save(&user)
-
-	history := models.LoyaltyHistory{
-		UserID:      user.ID,
-		Points:      req.Points,
-		Type:        "redeemed",
-		Description: fmt.Sprintf("Redeemed %d points", req.Points),
-	}
-	h.DB.Create(&history)
-
-	c.JSON(http.StatusOK, gin.H{
-		"message":          "Points redeemed successfully",
-		"points_redeemed":  req.Points,
-		"remaining_points": user.LoyaltyPoints,
-	})
+	c.JSON(http.StatusGone, gin.H{"error": "Redeem points at checkout by sending points_to_redeem with your order"})
 }
 
 func (h *AuthHandler) GetLoyaltyHistory(c *gin.Context) {
@@ -510,20 +510,5 @@ func TestRegisterDBCreateError(t *testing.T) {
 	}
 
 	// Recreate the users table for subsequent tests
-	db.Exec(`CREATE TABLE IF NOT EXISTS "users" (
-		"id" TEXT PRIMARY KEY,
-		"email" TEXT NOT NULL UNIQUE,
-		"password" TEXT NOT NULL,
-		"name" TEXT,
-		"role" TEXT DEFAULT 'customer',
-		"franchise_id" TEXT,
-		"loyalty_points" INTEGER DEFAULT 0,
-		"phone" TEXT,
-		"is_blocked" INTEGER DEFAULT 0,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		"deleted_at" DATETIME
-	)`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON "users"("deleted_at")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_users_franchise_id ON "users"("franchise_id")`)
+	createSQLiteTables(db)
 }
@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"grabbi-backend/models"
+	"grabbi-backend/pricing"
 	"grabbi-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -16,6 +20,256 @@ type CartHandler struct {
 	DB *gorm.DB
 }
 
+// cartItemResponse is a cart item priced at the store the cart is bound to.
+type cartItemResponse struct {
+	models.CartItem
+	UnitPrice float64 `json:"unit_price"`
+	LineTotal float64 `json:"line_total"`
+}
+
+// cartAdjustment reports a cart line changed by switching stores. Quantity is 0
+// when the line was removed.
+type cartAdjustment struct {
+	ProductID        uuid.UUID `json:"product_id"`
+	ProductName      string    `json:"product_name"`
+	PreviousQuantity int       `json:"previous_quantity"`
+	Quantity         int       `json:"quantity"`
+}
+
+// errCartFranchiseMismatch is returned when an item is added for a different
+// store from the one the cart is bound to.
+var errCartFranchiseMismatch = errors.New("cart belongs to a different store")
+
+// boundCartFranchise returns the store the user's cart is bound to, or nil when
+// the cart is empty or was filled from the master catalogue. Every item in a
+// cart shares one store, so the first item decides.
+func boundCartFranchise(db *gorm.DB, userID interface{}) (*uuid.UUID, bool, error) {
+	var item models.CartItem
+	err := db.Where("user_id = ?", userID).Order("created_at ASC").First(&item).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return item.FranchiseID, true, nil
+}
+
+// sameFranchise reports whether two optional franchise IDs name the same store.
+func sameFranchise(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// franchiseListing returns the franchise's listing of a product, or nil when the
+// store does not sell it: never listed, delisted, or marked unavailable.
+func franchiseListing(db *gorm.DB, franchiseID, productID uuid.UUID) (*models.FranchiseProduct, error) {
+	var fp models.FranchiseProduct
+	err := db.Where("franchise_id = ? AND product_id = ? AND deleted_at IS NULL", franchiseID, productID).First(&fp).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !fp.IsAvailable {
+		return nil, nil
+	}
+	return &fp, nil
+}
+
+// availableStock returns how many of a product can be put in a cart bound to
+// franchiseID: the store's stock, or the master catalogue's for unbound carts.
+// sold is false when the store does not sell the product at all.
+func availableStock(db *gorm.DB, franchiseID *uuid.UUID, product models.Product) (stock int, sold bool, err error) {
+	if franchiseID == nil {
+		return product.StockQuantity, true, nil
+	}
+	fp, err := franchiseListing(db, *franchiseID, product.ID)
+	if err != nil || fp == nil {
+		return 0, false, err
+	}
+	return fp.StockQuantity, true, nil
+}
+
+// cartUnitPrices prices each product at the store a cart is bound to, or at
+// master prices when franchiseID is nil.
+func cartUnitPrices(db *gorm.DB, franchiseID *uuid.UUID, products []models.Product) ([]float64, error) {
+	listings := make(map[uuid.UUID]models.FranchiseProduct)
+	if franchiseID != nil && len(products) > 0 {
+		productIDs := make([]uuid.UUID, len(products))
+		for i, product := range products {
+			productIDs[i] = product.ID
+		}
+		var fps []models.FranchiseProduct
+		if err := db.Where("franchise_id = ? AND product_id IN ?", *franchiseID, productIDs).Find(&fps).Error; err != nil {
+			return nil, err
+		}
+		for _, fp := range fps {
+			listings[fp.ProductID] = fp
+		}
+	}
+
+	now := time.Now()
+	prices := make([]float64, len(products))
+	for i, product := range products {
+		var fp *models.FranchiseProduct
+		if listing, ok := listings[product.ID]; ok {
+			fp = &listing
+		}
+		prices[i] = pricing.EffectivePrice(product, fp, now)
+	}
+	return prices, nil
+}
+
+// priceCartItems prices each item at the store its cart is bound to.
+func priceCartItems(db *gorm.DB, items []models.CartItem) ([]cartItemResponse, error) {
+	result := make([]cartItemResponse, len(items))
+	if len(items) == 0 {
+		return result, nil
+	}
+	products := make([]models.Product, len(items))
+	for i, item := range items {
+		products[i] = item.Product
+	}
+	prices, err := cartUnitPrices(db, items[0].FranchiseID, products)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		result[i] = cartItemResponse{
+			CartItem:  item,
+			UnitPrice: prices[i],
+			LineTotal: pricing.RoundMoney(prices[i] * float64(item.Quantity)),
+		}
+	}
+	return result, nil
+}
+
+// cartAddFranchise returns the store an item being added belongs to: the one
+// the cart is bound to, else the one requested. Items from another store need
+// an explicit switch so prices and stock stay consistent. It writes the error
+// response and returns false when the item cannot go in this cart.
+func cartAddFranchise(c *gin.Context, db *gorm.DB, bound *uuid.UUID, hasItems bool, requested *uuid.UUID) (*uuid.UUID, bool) {
+	if hasItems {
+		if requested == nil {
+			return bound, true
+		}
+		if !sameFranchise(requested, bound) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "Your cart is from a different store. Switch stores before adding this item.",
+				"cart_franchise_id": bound,
+			})
+			return nil, false
+		}
+		return requested, true
+	}
+	if requested != nil {
+		var franchise models.Franchise
+		if err := db.Where("id = ? AND is_active = ?", *requested, true).First(&franchise).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Franchise not found"})
+			return nil, false
+		}
+	}
+	return requested, true
+}
+
+// cartStock returns the stock a cart line can draw on. It writes the error
+// response and returns false when the store does not sell the product or has
+// fewer than quantity.
+func cartStock(c *gin.Context, db *gorm.DB, franchiseID *uuid.UUID, product models.Product, quantity int) (int, bool) {
+	stock, sold, err := availableStock(db, franchiseID, product)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check stock"})
+		return 0, false
+	}
+	if !sold {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product is not available at this store"})
+		return 0, false
+	}
+	if stock < quantity {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
+		return 0, false
+	}
+	return stock, true
+}
+
+// loadCart returns the user's cart with products preloaded and priced.
+func (h *CartHandler) loadCart(userID interface{}) ([]cartItemResponse, error) {
+	var cartItems []models.CartItem
+	if err := h.DB.Preload("Product").Preload("Product.Category").Preload("Product.Images").Where("user_id = ?", userID).Order("created_at ASC").Find(&cartItems).Error; err != nil {
+		return nil, err
+	}
+	return priceCartItems(h.DB, cartItems)
+}
+
+// respondCartItem reloads a cart item with its product and writes it priced.
+func (h *CartHandler) respondCartItem(c *gin.Context, cartItem models.CartItem) {
+	h.DB.Preload("Product").Preload("Product.Category").Preload("Product.Images").First(&cartItem, cartItem.ID)
+	priced, err := priceCartItems(h.DB, []models.CartItem{cartItem})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart item"})
+		return
+	}
+	c.JSON(http.StatusOK, priced[0])
+}
+
+// addCartLine puts quantity of a product in the user's cart under the store and
+// stock rules, adding to the line when the product is already there. It writes
+// the error response and returns false when the item cannot be added.
+func (h *CartHandler) addCartLine(c *gin.Context, userID uuid.UUID, product models.Product, quantity int, requested *uuid.UUID) (models.CartItem, bool) {
+	// The cart is bound to the store of its first item
+	boundFranchise, hasItems, err := boundCartFranchise(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return models.CartItem{}, false
+	}
+	franchiseID, ok := cartAddFranchise(c, h.DB, boundFranchise, hasItems, requested)
+	if !ok {
+		return models.CartItem{}, false
+	}
+
+	// Check stock
+	stock, ok := cartStock(c, h.DB, franchiseID, product, quantity)
+	if !ok {
+		return models.CartItem{}, false
+	}
+
+	// Check if item already in cart (active items only)
+	var cartItem models.CartItem
+	err = h.DB.Where("user_id = ? AND product_id = ?", userID, product.ID).First(&cartItem).Error
+
+	if err == nil {
+		// Update quantity for existing active cart item
+		newQuantity := cartItem.Quantity + quantity
+		if newQuantity > stock {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Cannot add %d more items. Only %d available (you already have %d in cart).",
+					quantity, stock-cartItem.Quantity, cartItem.Quantity),
+			})
+			return models.CartItem{}, false
+		}
+		cartItem.Quantity = newQuantity
+		h.DB.Save(&cartItem)
+	} else {
+		h.DB.Unscoped().Where("user_id = ? AND product_id = ?", userID, product.ID).Delete(&models.CartItem{})
+
+		// Create new cart item
+		cartItem = models.CartItem{
+			ID:          uuid.New(),
+			UserID:      userID,
+			ProductID:   product.ID,
+			FranchiseID: franchiseID,
+			Quantity:    quantity,
+		}
+		h.DB.Create(&cartItem)
+	}
+
+	return cartItem, true
+}
+
 func (h *CartHandler) GetCart(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -23,8 +277,8 @@ func (h *CartHandler) GetCart(c *gin.Context) {
 		return
 	}
 
-	var cartItems []models.CartItem
-	if err := h.DB.Preload("Product").Preload("Product.Category").Preload("Product.Images").Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
+	cartItems, err := h.loadCart(userID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
 		return
 	}
@@ -40,8 +294,9 @@ func (h *CartHandler) AddToCart(c *gin.Context) {
 	}
 
 	var req struct {
-		ProductID uuid.UUID `json:"product_id" binding:"required"`
-		Quantity  int       `json:"quantity" binding:"required,min=1"`
+		ProductID   uuid.UUID  `json:"product_id" binding:"required"`
+		Quantity    int        `json:"quantity" binding:"required,min=1"`
+		FranchiseID *uuid.UUID `json:"franchise_id"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -56,43 +311,12 @@ func (h *CartHandler) AddToCart(c *gin.Context) {
 		return
 	}
 
-	// Check stock
-	if product.StockQuantity < req.Quantity {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
+	cartItem, ok := h.addCartLine(c, userID.(uuid.UUID), product, req.Quantity, req.FranchiseID)
+	if !ok {
 		return
 	}
 
-	// Check if item already in cart (active items only)
-	var cartItem models.CartItem
-	err := h.DB.Where("user_id = ? AND product_id = ?", userID, req.ProductID).First(&cartItem).Error
-
-	if err == nil {
-		// Update quantity for existing active cart item
-		newQuantity := cartItem.Quantity + req.Quantity
-		if newQuantity > product.StockQuantity {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Cannot add %d more items. Only %d available (you already have %d in cart).",
-					req.Quantity, product.StockQuantity-cartItem.Quantity, cartItem.Quantity),
-			})
-			return
-		}
-		cartItem.Quantity = newQuantity
-		h.DB.Save(&cartItem)
-	} else {
-		h.DB.Unscoped().Where("user_id = ? AND product_id = ?", userID, req.ProductID).Delete(&models.CartItem{})
-
-		// Create new cart item
-		cartItem = models.CartItem{
-			ID:        uuid.New(),
-			UserID:    userID.(uuid.UUID),
-			ProductID: req.ProductID,
-			Quantity:  req.Quantity,
-		}
-		h.DB.Create(&cartItem)
-	}
-
-	h.DB.Preload("Product").Preload("Product.Category").Preload("Product.Images").First(&cartItem, cartItem.ID)
-	c.JSON(http.StatusOK, cartItem)
+	h.respondCartItem(c, cartItem)
 }
 
 func (h *CartHandler) UpdateCartItem(c *gin.Context) {
@@ -121,16 +345,98 @@ func (h *CartHandler) UpdateCartItem(c *gin.Context) {
 	// Check stock
 	var product models.Product
 	h.DB.Where("id = ?", cartItem.ProductID).First(&product)
-	if product.StockQuantity < req.Quantity {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
+	if _, ok := cartStock(c, h.DB, cartItem.FranchiseID, product, req.Quantity); !ok {
 		return
 	}
 
 	cartItem.Quantity = req.Quantity
 	h.DB.Save(&cartItem)
 
-	h.DB.Preload("Product").Preload("Product.Category").Preload("Product.Images").First(&cartItem, cartItem.ID)
-	c.JSON(http.StatusOK, cartItem)
+	h.respondCartItem(c, cartItem)
+}
+
+// SwitchFranchise moves the cart to another store. Each item is re-validated
+// against the new store: items it does not sell are removed and quantities are
+// reduced to its stock. The changes are reported so the client can tell the
+// customer what happened to their basket.
+func (h *CartHandler) SwitchFranchise(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		FranchiseID string `json:"franchise_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	franchise, err := resolveOrderFranchise(h.DB, req.FranchiseID, nil, nil)
+	if err != nil {
+		respondCheckoutError(c, err, "Failed to switch store")
+		return
+	}
+
+	removed := []cartAdjustment{}
+	adjusted := []cartAdjustment{}
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var cartItems []models.CartItem
+		if err := tx.Preload("Product").Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
+			return err
+		}
+
+		for _, item := range cartItems {
+			stock, sold, err := availableStock(tx, &franchise.ID, item.Product)
+			if err != nil {
+				return err
+			}
+			change := cartAdjustment{
+				ProductID:        item.ProductID,
+				ProductName:      item.Product.ItemName,
+				PreviousQuantity: item.Quantity,
+			}
+
+			if !sold || stock <= 0 {
+				if err := tx.Delete(&item).Error; err != nil {
+					return err
+				}
+				removed = append(removed, change)
+				continue
+			}
+
+			updates := map[string]interface{}{"franchise_id": franchise.ID}
+			if item.Quantity > stock {
+				updates["quantity"] = stock
+				change.Quantity = stock
+				adjusted = append(adjusted, change)
+			}
+			if err := tx.Model(&item).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch store"})
+		return
+	}
+
+	cartItems, err := h.loadCart(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"franchise_id": franchise.ID,
+		"items":        cartItems,
+		"removed":      removed,
+		"adjusted":     adjusted,
+	})
 }
 
 func (h *CartHandler) RemoveFromCart(c *gin.Context) {
@@ -163,3 +469,79 @@ func (h *CartHandler) ClearCart(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Cart cleared"})
 }
+
+// SaveForLater moves a cart line to the wishlist, keeping its quantity and
+// store. A product already on the wishlist takes the cart line's quantity.
+func (h *CartHandler) SaveForLater(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var cartItem models.CartItem
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&cartItem).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	}
+
+	var item models.WishlistItem
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND product_id = ?", userID, cartItem.ProductID).First(&item).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			item = models.WishlistItem{UserID: cartItem.UserID, ProductID: cartItem.ProductID}
+		} else if err != nil {
+			return err
+		}
+		item.FranchiseID = cartItem.FranchiseID
+		item.Quantity = cartItem.Quantity
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&cartItem).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save item for later"})
+		return
+	}
+
+	h.DB.Preload("Product").Preload("Product.Images").First(&item, item.ID)
+	c.JSON(http.StatusOK, item)
+}
+
+// MoveToCart moves a wishlist item into the cart under the usual store and
+// stock rules. The item's saved quantity is used unless the request sets one.
+func (h *CartHandler) MoveToCart(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Quantity *int `json:"quantity" binding:"omitempty,min=1"`
+	}
+	// The body is optional
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	var item models.WishlistItem
+	if err := h.DB.Preload("Product").Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&item).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist item not found"})
+		return
+	}
+	quantity := item.Quantity
+	if req.Quantity != nil {
+		quantity = *req.Quantity
+	}
+
+	cartItem, ok := h.addCartLine(c, item.UserID, item.Product, quantity, item.FranchiseID)
+	if !ok {
+		return
+	}
+	h.DB.Delete(&item)
+
+	h.respondCartItem(c, cartItem)
+}
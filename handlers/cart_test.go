@@ -790,21 +790,7 @@ func TestGetCartDBError(t *testing.T) {
 	}
 
 	// Recreate the table for subsequent tests
-	db.Exec(`CREATE TABLE IF NOT EXISTS "cart_items" (
-		"id" TEXT PRIMARY KEY,
-		"user_id" TEXT NOT NULL,
-		"product_id" TEXT NOT NULL,
-		"franchise_id" TEXT,
-		"quantity" INTEGER DEFAULT 1,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		"deleted_at" DATETIME,
-		CONSTRAINT fk_cart_items_user FOREIGN KEY ("user_id") REFERENCES "users"("id"),
-		CONSTRAINT fk_cart_items_product FOREIGN KEY ("product_id") REFERENCES "products"("id")
-	)`)
-	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_user_product ON "cart_items"("user_id","product_id")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_cart_items_deleted_at ON "cart_items"("deleted_at")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_cart_items_franchise_id ON "cart_items"("franchise_id")`)
+	createSQLiteTables(db)
 }
 
 // TestRemoveFromCartDBError tests the error branch when the DB delete fails.
@@ -828,21 +814,7 @@ func TestRemoveFromCartDBError(t *testing.T) {
 	}
 
 	// Recreate the table for subsequent tests
-	db.Exec(`CREATE TABLE IF NOT EXISTS "cart_items" (
-		"id" TEXT PRIMARY KEY,
-		"user_id" TEXT NOT NULL,
-		"product_id" TEXT NOT NULL,
-		"franchise_id" TEXT,
-		"quantity" INTEGER DEFAULT 1,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		"deleted_at" DATETIME,
-		CONSTRAINT fk_cart_items_user FOREIGN KEY ("user_id") REFERENCES "users"("id"),
-		CONSTRAINT fk_cart_items_product FOREIGN KEY ("product_id") REFERENCES "products"("id")
-	)`)
-	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_user_product ON "cart_items"("user_id","product_id")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_cart_items_deleted_at ON "cart_items"("deleted_at")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_cart_items_franchise_id ON "cart_items"("franchise_id")`)
+	createSQLiteTables(db)
 }
 
 // TestClearCartDBError tests the error branch when the DB delete fails.
@@ -866,19 +838,185 @@ func TestClearCartDBError(t *testing.T) {
 	}
 
 	// Recreate the table for subsequent tests
-	db.Exec(`CREATE TABLE IF NOT EXISTS "cart_items" (
-		"id" TEXT PRIMARY KEY,
-		"user_id" TEXT NOT NULL,
-		"product_id" TEXT NOT NULL,
-		"franchise_id" TEXT,
-		"quantity" INTEGER DEFAULT 1,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		"deleted_at" DATETIME,
-		CONSTRAINT fk_cart_items_user FOREIGN KEY ("user_id") REFERENCES "users"("id"),
-		CONSTRAINT fk_cart_items_product FOREIGN KEY ("product_id") REFERENCES "products"("id")
-	)`)
-	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_user_product ON "cart_items"("user_id","product_id")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_cart_items_deleted_at ON "cart_items"("deleted_at")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_cart_items_franchise_id ON "cart_items"("franchise_id")`)
+	createSQLiteTables(db)
+}
+
+func TestAddToCartBindsFranchiseAndUsesItsStock(t *testing.T) {
+	db := freshDB()
+	router := setupCartRouter(db)
+	user, token := seedTestUser(db, "cartstore@test.com", "customer", nil)
+	cat := seedCategory(db, "CartStoreCat")
+	prod := seedProduct(db, "Store Product", cat.ID, 5.00)
+	franchise := seedFranchise(db, "Cart Store", user.ID)
+	seedFranchiseProduct(db, franchise.ID, prod.ID)
+
+	// Master stock is 100 but the store only has 50
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/cart", map[string]interface{}{
+		"product_id": prod.ID.String(), "quantity": 60, "franchise_id": franchise.ID.String(),
+	}, token))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for more than the store's stock, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/cart", map[string]interface{}{
+		"product_id": prod.ID.String(), "quantity": 2, "franchise_id": franchise.ID.String(),
+	}, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if parseResponse(w)["franchise_id"] != franchise.ID.String() {
+		t.Errorf("expected the item to be bound to the store, got %v", parseResponse(w)["franchise_id"])
+	}
+}
+
+func TestAddToCartProductNotSoldAtFranchise(t *testing.T) {
+	db := freshDB()
+	router := setupCartRouter(db)
+	user, token := seedTestUser(db, "cartunsold@test.com", "customer", nil)
+	cat := seedCategory(db, "CartUnsoldCat")
+	prod := seedProduct(db, "Unsold Product", cat.ID, 5.00)
+	franchise := seedFranchise(db, "Unsold Store", user.ID)
+	fp := seedFranchiseProduct(db, franchise.ID, prod.ID)
+	db.Model(&fp).Update("is_available", false)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/cart", map[string]interface{}{
+		"product_id": prod.ID.String(), "quantity": 1, "franchise_id": franchise.ID.String(),
+	}, token))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if parseResponse(w)["error"] != "Product is not available at this store" {
+		t.Errorf("unexpected error: %v", parseResponse(w)["error"])
+	}
+}
+
+func TestAddToCartFromDifferentFranchiseConflicts(t *testing.T) {
+	db := freshDB()
+	router := setupCartRouter(db)
+	user, token := seedTestUser(db, "cartother@test.com", "customer", nil)
+	cat := seedCategory(db, "CartOtherCat")
+	prod := seedProduct(db, "Other Product", cat.ID, 5.00)
+	first := seedFranchise(db, "First Store", user.ID)
+	second := seedFranchise(db, "Second Store", user.ID)
+	seedFranchiseProduct(db, first.ID, prod.ID)
+	seedFranchiseProduct(db, second.ID, prod.ID)
+	db.Create(&models.CartItem{UserID: user.ID, ProductID: prod.ID, FranchiseID: &first.ID, Quantity: 1})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/cart", map[string]interface{}{
+		"product_id": prod.ID.String(), "quantity": 1, "franchise_id": second.ID.String(),
+	}, token))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if parseResponse(w)["cart_franchise_id"] != first.ID.String() {
+		t.Errorf("expected the cart's store to be reported, got %v", parseResponse(w)["cart_franchise_id"])
+	}
+}
+
+func TestGetCartReturnsFranchisePrices(t *testing.T) {
+	db := freshDB()
+	router := setupCartRouter(db)
+	user, token := seedTestUser(db, "cartprice@test.com", "customer", nil)
+	cat := seedCategory(db, "CartPriceCat")
+	prod := seedProduct(db, "Priced Product", cat.ID, 5.00)
+	franchise := seedFranchise(db, "Price Store", user.ID)
+	fp := seedFranchiseProduct(db, franchise.ID, prod.ID)
+	db.Model(&fp).Update("retail_price_override", 4.25)
+	db.Create(&models.CartItem{UserID: user.ID, ProductID: prod.ID, FranchiseID: &franchise.ID, Quantity: 2})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/cart", nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	items := parseResponseArray(w)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(items))
+	}
+	item := items[0].(map[string]interface{})
+	if item["unit_price"] != 4.25 || item["line_total"] != 8.5 {
+		t.Errorf("expected the store's price 4.25 x2 = 8.50, got %v / %v", item["unit_price"], item["line_total"])
+	}
+}
+
+func TestSwitchCartFranchiseRevalidatesItems(t *testing.T) {
+	db := freshDB()
+	router := setupCartRouter(db)
+	user, token := seedTestUser(db, "cartswitch@test.com", "customer", nil)
+	cat := seedCategory(db, "CartSwitchCat")
+	kept := seedProduct(db, "Kept Product", cat.ID, 2.00)
+	reduced := seedProduct(db, "Reduced Product", cat.ID, 3.00)
+	dropped := seedProduct(db, "Dropped Product", cat.ID, 4.00)
+	from := seedFranchise(db, "Switch From", user.ID)
+	to := seedFranchise(db, "Switch To", user.ID)
+	seedFranchiseProduct(db, to.ID, kept.ID)
+	low := seedFranchiseProduct(db, to.ID, reduced.ID)
+	db.Model(&low).Update("stock_quantity", 2)
+
+	for _, p := range []models.Product{kept, reduced, dropped} {
+		db.Create(&models.CartItem{UserID: user.ID, ProductID: p.ID, FranchiseID: &from.ID, Quantity: 5})
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/cart/franchise", map[string]string{"franchise_id": to.ID.String()}, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	resp := parseResponse(w)
+	removed := resp["removed"].([]interface{})
+	if len(removed) != 1 || removed[0].(map[string]interface{})["product_id"] != dropped.ID.String() {
+		t.Errorf("expected the unsold product to be removed, got %v", removed)
+	}
+	adjusted := resp["adjusted"].([]interface{})
+	if len(adjusted) != 1 || adjusted[0].(map[string]interface{})["quantity"] != float64(2) {
+		t.Errorf("expected the low-stock product to be reduced to 2, got %v", adjusted)
+	}
+
+	var items []models.CartItem
+	db.Where("user_id = ?", user.ID).Find(&items)
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items left, got %d", len(items))
+	}
+	for _, item := range items {
+		if item.FranchiseID == nil || *item.FranchiseID != to.ID {
+			t.Errorf("expected item %s to move to the new store", item.ProductID)
+		}
+	}
+}
+
+func TestCreateOrderUsesCartFranchise(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, token := seedTestUser(db, "cartorder@test.com", "customer", nil)
+	cat := seedCategory(db, "CartOrderCat")
+	prod := seedProduct(db, "Order Product", cat.ID, 20.00)
+	franchise := seedFranchise(db, "Cart Order Store", user.ID)
+	other := seedFranchise(db, "Other Order Store", user.ID)
+	seedFranchiseProduct(db, franchise.ID, prod.ID)
+	db.Create(&models.CartItem{UserID: user.ID, ProductID: prod.ID, FranchiseID: &franchise.ID, Quantity: 3})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+		"payment_method":   "cash",
+		"delivery_address": "1 Other St",
+		"franchise_id":     other.ID.String(),
+	}, token))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 checking out at another store, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{"payment_method": "cash", "delivery_address": "1 Cart St"}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if parseResponse(w)["franchise_id"] != franchise.ID.String() {
+		t.Errorf("expected the order to go to the cart's store, got %v", parseResponse(w)["franchise_id"])
+	}
 }
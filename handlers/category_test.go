@@ -483,17 +483,7 @@ func TestGetCategoriesDBError(t *testing.T) {
 	}
 
 	// Recreate the tables for subsequent tests
-	db.Exec(`CREATE TABLE IF NOT EXISTS "categories" (
-		"id" TEXT PRIMARY KEY,
-		"name" TEXT NOT NULL,
-		"icon" TEXT,
-		"description" TEXT,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		"deleted_at" DATETIME
-	)`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_categories_deleted_at ON "categories"("deleted_at")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_categories_name ON "categories"("name")`)
+	createSQLiteTables(db)
 }
 
 // TestDeleteCategoryDBErrorOnProductCount tests the error branch when counting products fails.
@@ -519,56 +509,7 @@ func TestDeleteCategoryDBErrorOnProductCount(t *testing.T) {
 	}
 
 	// Recreate the products table for subsequent tests
-	db.Exec(`CREATE TABLE IF NOT EXISTS "products" (
-		"id" TEXT PRIMARY KEY,
-		"sku" TEXT NOT NULL UNIQUE,
-		"item_name" TEXT NOT NULL,
-		"short_description" TEXT,
-		"long_description" TEXT,
-		"cost_price" REAL NOT NULL,
-		"retail_price" REAL NOT NULL,
-		"promotion_price" REAL,
-		"promotion_start" DATETIME,
-		"promotion_end" DATETIME,
-		"gross_margin" REAL DEFAULT 0,
-		"staff_discount" REAL DEFAULT 0,
-		"tax_rate" REAL DEFAULT 0,
-		"batch_number" TEXT,
-		"barcode" TEXT,
-		"stock_quantity" INTEGER DEFAULT 0,
-		"reorder_level" INTEGER DEFAULT 0,
-		"shelf_location" TEXT,
-		"weight_volume" REAL DEFAULT 0,
-		"unit_of_measure" TEXT,
-		"expiry_date" DATETIME,
-		"category_id" TEXT NOT NULL,
-		"subcategory_id" TEXT,
-		"brand" TEXT,
-		"supplier" TEXT,
-		"country_of_origin" TEXT,
-		"is_gluten_free" INTEGER DEFAULT 0,
-		"is_vegetarian" INTEGER DEFAULT 0,
-		"is_vegan" INTEGER DEFAULT 0,
-		"is_age_restricted" INTEGER DEFAULT 0,
-		"minimum_age" INTEGER,
-		"allergen_info" TEXT,
-		"storage_type" TEXT,
-		"is_own_brand" INTEGER DEFAULT 0,
-		"online_visible" INTEGER DEFAULT 1,
-		"status" TEXT DEFAULT 'active',
-		"notes" TEXT,
-		"pack_size" TEXT,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		"deleted_at" DATETIME,
-		CONSTRAINT fk_products_category FOREIGN KEY ("category_id") REFERENCES "categories"("id")
-	)`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_deleted_at ON "products"("deleted_at")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_item_name ON "products"("item_name")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_category_id ON "products"("category_id")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_subcategory_id ON "products"("subcategory_id")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_status ON "products"("status")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_stock_quantity ON "products"("stock_quantity")`)
+	createSQLiteTables(db)
 }
 
 // TestDeleteCategoryDBErrorOnSubcategoryCount tests the error branch when counting subcategories fails.
@@ -594,18 +535,5 @@ func TestDeleteCategoryDBErrorOnSubcategoryCount(t *testing.T) {
 	}
 
 	// Recreate the subcategories table for subsequent tests
-	db.Exec(`CREATE TABLE IF NOT EXISTS "subcategories" (
-		"id" TEXT PRIMARY KEY,
-		"name" TEXT NOT NULL,
-		"category_id" TEXT NOT NULL,
-		"icon" TEXT,
-		"description" TEXT,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		"deleted_at" DATETIME,
-		CONSTRAINT fk_subcategories_category FOREIGN KEY ("category_id") REFERENCES "categories"("id")
-	)`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_subcategories_deleted_at ON "subcategories"("deleted_at")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_subcategories_name ON "subcategories"("name")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_subcategories_category_id ON "subcategories"("category_id")`)
+	createSQLiteTables(db)
 }
@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"grabbi-backend/inventory"
+	"grabbi-backend/loyalty"
+	"grabbi-backend/models"
+	"grabbi-backend/pricing"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CheckoutHandler struct {
+	DB *gorm.DB
+}
+
+// checkoutError is a checkout validation failure carrying the HTTP status to
+// report, so quote and order creation reject bad input the same way.
+type checkoutError struct {
+	status  int
+	message string
+}
+
+func (e *checkoutError) Error() string { return e.message }
+
+// respondCheckoutError writes err as a JSON error, using its status when it is a
+// checkoutError and 500 with fallback otherwise.
+func respondCheckoutError(c *gin.Context, err error, fallback string) {
+	var ce *checkoutError
+	if errors.As(err, &ce) {
+		c.JSON(ce.status, gin.H{"error": ce.message})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+}
+
+// checkoutFailure returns err when it is a checkoutError, and otherwise a 500
+// checkoutError with fallback, for code that reports failures rather than
+// writing them.
+func checkoutFailure(err error, fallback string) error {
+	var ce *checkoutError
+	if errors.As(err, &ce) {
+		return ce
+	}
+	return &checkoutError{http.StatusInternalServerError, fallback}
+}
+
+// parseFulfilment validates a requested fulfilment type, defaulting to delivery.
+func parseFulfilment(value string) (models.FulfilmentType, error) {
+	switch models.FulfilmentType(value) {
+	case "", models.FulfilmentDelivery:
+		return models.FulfilmentDelivery, nil
+	case models.FulfilmentCollection:
+		return models.FulfilmentCollection, nil
+	}
+	return "", &checkoutError{http.StatusBadRequest, "fulfilment_type must be 'delivery' or 'collection'"}
+}
+
+// checkPaymentMethod validates how the customer asked to pay. Gift card
+// payment is decided at checkout rather than requested, so only card and cash
+// are accepted; the method may be left out for orders gift cards cover in full.
+func checkPaymentMethod(method string) error {
+	switch method {
+	case "", models.PaymentMethodCard, models.PaymentMethodCash:
+		return nil
+	}
+	return &checkoutError{http.StatusBadRequest, "payment_method must be 'card' or 'cash'"}
+}
+
+// resolveOrderFranchise returns the franchise serving an order: the one named
+// by franchiseID, else the nearest active franchise delivering to the customer's
+// coordinates. It returns nil when neither is given.
+func resolveOrderFranchise(db *gorm.DB, franchiseID string, lat, lng *float64) (*models.Franchise, error) {
+	if franchiseID != "" {
+		fID, err := uuid.Parse(franchiseID)
+		if err != nil {
+			return nil, &checkoutError{http.StatusBadRequest, "Invalid franchise_id"}
+		}
+		var f models.Franchise
+		if err := db.Where("id = ? AND is_active = ?", fID, true).First(&f).Error; err != nil {
+			return nil, &checkoutError{http.StatusNotFound, "Franchise not found"}
+		}
+		return &f, nil
+	}
+
+	if lat == nil || lng == nil {
+		return nil, nil
+	}
+
+	// Find nearest franchise
+	var franchises []models.Franchise
+	db.Where("is_active = ?", true).Find(&franchises)
+
+	nearest, _ := nearestDeliveringFranchise(franchises, *lat, *lng)
+	if nearest == nil {
+		return nil, &checkoutError{http.StatusBadRequest, "No franchise delivers to your location"}
+	}
+	return nearest, nil
+}
+
+// cartCheckoutFranchise returns the franchise to check a cart out at: the one
+// requested, else the store the cart is bound to when no location is given.
+func cartCheckoutFranchise(db *gorm.DB, userID interface{}, franchiseID string, lat, lng *float64) (string, error) {
+	if franchiseID != "" || (lat != nil && lng != nil) {
+		return franchiseID, nil
+	}
+	bound, _, err := boundCartFranchise(db, userID)
+	if err != nil || bound == nil {
+		return "", err
+	}
+	return bound.String(), nil
+}
+
+// checkCartFranchise rejects checking a cart out at a store other than the one
+// it was filled from, since its stock and prices were validated there.
+func checkCartFranchise(items []models.CartItem, franchise *models.Franchise) error {
+	if len(items) == 0 || items[0].FranchiseID == nil {
+		return nil
+	}
+	if franchise == nil || franchise.ID != *items[0].FranchiseID {
+		return &checkoutError{http.StatusConflict, "Your cart is from a different store. Switch stores before checking out."}
+	}
+	return nil
+}
+
+// checkPointsToRedeem checks a customer can spend the requested loyalty points.
+// How many the basket can take is capped later, when it is priced.
+func checkPointsToRedeem(db *gorm.DB, userID uuid.UUID, points int) error {
+	if points == 0 {
+		return nil
+	}
+	if points < 0 {
+		return &checkoutError{http.StatusBadRequest, "points_to_redeem cannot be negative"}
+	}
+	if minPoints := loyalty.RedemptionPolicy().MinPoints; points < minPoints {
+		return &checkoutError{http.StatusBadRequest, fmt.Sprintf("Redeem at least %d points", minPoints)}
+	}
+	var user models.User
+	if err := db.Select("loyalty_points").Where("id = ?", userID).First(&user).Error; err != nil {
+		return err
+	}
+	if user.LoyaltyPoints < points {
+		return &checkoutError{http.StatusBadRequest, fmt.Sprintf("Insufficient points. You have %d points.", user.LoyaltyPoints)}
+	}
+	return nil
+}
+
+// loyaltyEarning returns how a customer earns points on the lines at their
+// current tier and with the campaigns running on those products.
+func loyaltyEarning(db *gorm.DB, userID uuid.UUID, lines []pricing.Line) (loyalty.Earning, error) {
+	productIDs := make([]uuid.UUID, len(lines))
+	for i, line := range lines {
+		productIDs[i] = line.Product.ID
+	}
+	return loyalty.EarningFor(db, userID, productIDs, time.Now())
+}
+
+// cartPricingLines turns cart items with preloaded products into pricing lines.
+func cartPricingLines(items []models.CartItem) []pricing.Line {
+	lines := make([]pricing.Line, len(items))
+	for i, item := range items {
+		lines[i] = pricing.Line{Product: item.Product, Quantity: item.Quantity}
+	}
+	return lines
+}
+
+// Quote prices a basket exactly as CreateOrder would, without placing an order.
+// Items default to the caller's cart when none are given.
+func (h *CheckoutHandler) Quote(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		FulfilmentType string   `json:"fulfilment_type"`
+		FranchiseID    string   `json:"franchise_id"`
+		CustomerLat    *float64 `json:"customer_lat"`
+		CustomerLng    *float64 `json:"customer_lng"`
+		AddressID      string   `json:"address_id"`
+		CouponCode     string   `json:"coupon_code"`
+		PointsToRedeem int      `json:"points_to_redeem"`
+		Items          []struct {
+			ProductID uuid.UUID `json:"product_id" binding:"required"`
+			Quantity  int       `json:"quantity" binding:"required,min=1"`
+		} `json:"items" binding:"dive"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	fulfilment, err := parseFulfilment(req.FulfilmentType)
+	if err != nil {
+		respondCheckoutError(c, err, "Failed to quote checkout")
+		return
+	}
+
+	// A saved address is priced from the store that delivers to it
+	if fulfilment == models.FulfilmentDelivery && req.AddressID != "" {
+		address, err := orderAddress(h.DB, userID, req.AddressID, false)
+		if err != nil {
+			respondCheckoutError(c, err, "Failed to quote checkout")
+			return
+		}
+		req.CustomerLat = &address.Latitude
+		req.CustomerLng = &address.Longitude
+	}
+
+	franchiseRef := req.FranchiseID
+	if len(req.Items) == 0 {
+		franchiseRef, err = cartCheckoutFranchise(h.DB, userID, req.FranchiseID, req.CustomerLat, req.CustomerLng)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+			return
+		}
+	}
+
+	franchise, err := resolveOrderFranchise(h.DB, franchiseRef, req.CustomerLat, req.CustomerLng)
+	if err != nil {
+		respondCheckoutError(c, err, "Failed to quote checkout")
+		return
+	}
+
+	var lines []pricing.Line
+	if len(req.Items) > 0 {
+		for _, item := range req.Items {
+			var product models.Product
+			if err := h.DB.Where("id = ?", item.ProductID).First(&product).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Product not found: " + item.ProductID.String()})
+				return
+			}
+			lines = append(lines, pricing.Line{Product: product, Quantity: item.Quantity})
+		}
+	} else {
+		var cartItems []models.CartItem
+		if err := h.DB.Preload("Product").Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+			return
+		}
+		if len(cartItems) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+			return
+		}
+		if err := checkCartFranchise(cartItems, franchise); err != nil {
+			respondCheckoutError(c, err, "Failed to quote checkout")
+			return
+		}
+		lines = cartPricingLines(cartItems)
+	}
+
+	var coupon *models.Coupon
+	if req.CouponCode != "" {
+		coupon, err = redeemableCoupon(h.DB, req.CouponCode, userID.(uuid.UUID), franchise)
+		if err != nil {
+			respondCheckoutError(c, err, "Failed to apply coupon")
+			return
+		}
+	}
+
+	if err := checkPointsToRedeem(h.DB, userID.(uuid.UUID), req.PointsToRedeem); err != nil {
+		respondCheckoutError(c, err, "Failed to quote checkout")
+		return
+	}
+
+	earning, err := loyaltyEarning(h.DB, userID.(uuid.UUID), lines)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to quote checkout"})
+		return
+	}
+
+	quote, err := pricing.QuoteLines(h.DB, lines, pricing.Options{
+		Franchise: franchise, Fulfilment: fulfilment, Coupon: coupon, RedeemPoints: req.PointsToRedeem, Earning: earning,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to quote checkout"})
+		return
+	}
+	if coupon != nil {
+		if err := checkCouponBasket(coupon, quote); err != nil {
+			respondCheckoutError(c, err, "Failed to apply coupon")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, quote)
+}
+
+// ReserveStock holds the cart's stock at the checkout franchise while the
+// customer completes checkout. The hold expires after the reservation TTL and
+// is converted into the order's stock decrement when the order is placed.
+// Calling it again refreshes the hold for the current cart.
+func (h *CheckoutHandler) ReserveStock(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		FranchiseID string   `json:"franchise_id"`
+		CustomerLat *float64 `json:"customer_lat"`
+		CustomerLng *float64 `json:"customer_lng"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	franchiseRef, err := cartCheckoutFranchise(h.DB, userID, req.FranchiseID, req.CustomerLat, req.CustomerLng)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+	franchise, err := resolveOrderFranchise(h.DB, franchiseRef, req.CustomerLat, req.CustomerLng)
+	if err != nil {
+		respondCheckoutError(c, err, "Failed to reserve stock")
+		return
+	}
+
+	var cartItems []models.CartItem
+	if err := h.DB.Preload("Product").Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+	if len(cartItems) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+		return
+	}
+	if err := checkCartFranchise(cartItems, franchise); err != nil {
+		respondCheckoutError(c, err, "Failed to reserve stock")
+		return
+	}
+
+	var franchiseID *uuid.UUID
+	if franchise != nil {
+		franchiseID = &franchise.ID
+	}
+	items := make([]inventory.Item, len(cartItems))
+	names := make(map[uuid.UUID]string, len(cartItems))
+	for i, item := range cartItems {
+		items[i] = inventory.Item{ProductID: item.ProductID, Quantity: item.Quantity}
+		names[item.ProductID] = item.Product.ItemName
+	}
+
+	reservations, err := inventory.Reserve(h.DB, userID.(uuid.UUID), franchiseID, items, inventory.ReservationTTL())
+	if err != nil {
+		var stockErr *inventory.InsufficientStockError
+		if errors.As(err, &stockErr) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Insufficient stock for " + names[stockErr.ProductID]})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reserve stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reservations": reservations,
+		"expires_at":   reservations[0].ExpiresAt,
+	})
+}
+
+// ReleaseStock gives back the stock held for the caller's checkout, for when
+// the customer leaves checkout without ordering.
+func (h *CheckoutHandler) ReleaseStock(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	if err := inventory.Release(h.DB, userID.(uuid.UUID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stock released"})
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grabbi-backend/models"
+)
+
+func TestCheckoutQuoteMatchesPlacedOrder(t *testing.T) {
+	db := freshDB()
+	checkoutRouter := setupCheckoutRouter(db)
+	orderRouter := setupOrderRouter(db)
+	user, product, token := seedCartForPayment(t)
+	franchise := seedFranchise(db, "Quote Store", user.ID)
+	override := 8.0
+	fp := seedFranchiseProduct(db, franchise.ID, product.ID)
+	db.Model(&fp).Update("retail_price_override", override)
+
+	body := map[string]string{"franchise_id": franchise.ID.String(), "delivery_address": "1 Quote St", "payment_method": "cash"}
+
+	w := httptest.NewRecorder()
+	checkoutRouter.ServeHTTP(w, authRequest("POST", "/api/checkout/quote", body, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	quote := parseResponse(w)
+	if quote["subtotal"] != float64(24) || quote["delivery_fee"] != 4.99 || quote["points_earned"] != float64(24) {
+		t.Errorf("unexpected quote: %v", quote)
+	}
+	if lines, _ := quote["lines"].([]interface{}); len(lines) != 1 || lines[0].(map[string]interface{})["unit_price"] != override {
+		t.Errorf("expected one line at the franchise price, got %v", quote["lines"])
+	}
+
+	w = httptest.NewRecorder()
+	orderRouter.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if total := parseResponse(w)["total"]; total != quote["total"] {
+		t.Errorf("expected order total %v to match quote, got %v", quote["total"], total)
+	}
+}
+
+func TestCheckoutQuoteExplicitItems(t *testing.T) {
+	db := freshDB()
+	router := setupCheckoutRouter(db)
+	_, token := seedTestUser(db, "quoteitems@test.com", "customer", nil)
+	cat := seedCategory(db, "QuoteCat")
+	prod := seedProduct(db, "Quote Product", cat.ID, 12.50)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/checkout/quote", map[string]interface{}{
+		"fulfilment_type": "delivery",
+		"items":           []map[string]interface{}{{"product_id": prod.ID.String(), "quantity": 2}},
+	}, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	quote := parseResponse(w)
+	if quote["subtotal"] != float64(25) || quote["delivery_fee"] != float64(0) || quote["total"] != float64(25) {
+		t.Errorf("unexpected quote: %v", quote)
+	}
+}
+
+func TestCheckoutQuoteEmptyCart(t *testing.T) {
+	db := freshDB()
+	router := setupCheckoutRouter(db)
+	_, token := seedTestUser(db, "quoteempty@test.com", "customer", nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/checkout/quote", map[string]string{}, token))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCheckoutQuoteUnknownFranchise(t *testing.T) {
+	db := freshDB()
+	router := setupCheckoutRouter(db)
+	_, _, token := seedCartForPayment(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/checkout/quote", map[string]string{"franchise_id": "00000000-0000-0000-0000-000000000001"}, token))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReserveStockIsConvertedByOrder(t *testing.T) {
+	db := freshDB()
+	checkoutRouter := setupCheckoutRouter(db)
+	orderRouter := setupOrderRouter(db)
+	user, product, token := seedCartForPayment(t)
+
+	w := httptest.NewRecorder()
+	checkoutRouter.ServeHTTP(w, authRequest("POST", "/api/checkout/reserve", map[string]string{}, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var reloaded models.Product
+	db.First(&reloaded, "id = ?", product.ID)
+	if reloaded.StockQuantity != product.StockQuantity-3 {
+		t.Fatalf("expected 3 units held, got stock %d", reloaded.StockQuantity)
+	}
+
+	// The customer adds one more before placing the order
+	db.Model(&models.CartItem{}).Where("user_id = ?", user.ID).Update("quantity", 4)
+
+	w = httptest.NewRecorder()
+	orderRouter.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{"payment_method": "cash", "delivery_address": "1 Held St"}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	db.First(&reloaded, "id = ?", product.ID)
+	if reloaded.StockQuantity != product.StockQuantity-4 {
+		t.Errorf("expected stock to drop by the 4 ordered, got %d", reloaded.StockQuantity)
+	}
+	var count int64
+	db.Model(&models.StockReservation{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("expected the reservation to be used up, got %d", count)
+	}
+}
+
+func TestReserveStockConflictsWhenHeldElsewhere(t *testing.T) {
+	db := freshDB()
+	router := setupCheckoutRouter(db)
+	_, product, token := seedCartForPayment(t)
+	_, otherToken := seedTestUser(db, "holder@test.com", "customer", nil)
+	db.Model(&product).Update("stock_quantity", 4)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/checkout/reserve", map[string]string{}, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A second shopper with the same basket finds only one left
+	var other models.User
+	db.Where("email = ?", "holder@test.com").First(&other)
+	db.Create(&models.CartItem{UserID: other.ID, ProductID: product.ID, Quantity: 3})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/checkout/reserve", map[string]string{}, otherToken))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("DELETE", "/api/checkout/reserve", nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var reloaded models.Product
+	db.First(&reloaded, "id = ?", product.ID)
+	if reloaded.StockQuantity != 4 {
+		t.Errorf("expected released stock back to 4, got %d", reloaded.StockQuantity)
+	}
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"grabbi-backend/models"
+	"grabbi-backend/pricing"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CouponHandler manages discount codes. Admins manage every coupon; franchise
+// staff manage only their own store's coupons through the same handlers.
+type CouponHandler struct {
+	DB *gorm.DB
+}
+
+var errCouponExhausted = errors.New("coupon usage limit reached")
+
+// couponRequest is the body for creating or updating a coupon. On update only
+// the fields given are changed; product_ids and category_ids replace the
+// coupon's targets when present.
+type couponRequest struct {
+	Code         *string      `json:"code"`
+	Description  *string      `json:"description"`
+	Type         *string      `json:"type"`
+	Value        *float64     `json:"value"`
+	MinBasket    *float64     `json:"min_basket"`
+	MaxDiscount  *float64     `json:"max_discount"`
+	UsageLimit   *int         `json:"usage_limit"`
+	PerUserLimit *int         `json:"per_user_limit"`
+	StartsAt     *time.Time   `json:"starts_at"`
+	EndsAt       *time.Time   `json:"ends_at"`
+	FranchiseID  nullableUUID `json:"franchise_id"`
+	IsActive     *bool        `json:"is_active"`
+	ProductIDs   []uuid.UUID  `json:"product_ids"`
+	CategoryIDs  []uuid.UUID  `json:"category_ids"`
+}
+
+// nullableUUID is an optional ID in an update body. Set tells a field given as
+// null, which clears the value, apart from one left out, which keeps it.
+type nullableUUID struct {
+	Set   bool
+	Value *uuid.UUID
+}
+
+func (n *nullableUUID) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	n.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	var id uuid.UUID
+	if err := json.Unmarshal(data, &id); err != nil {
+		return err
+	}
+	n.Value = &id
+	return nil
+}
+
+// adminFranchiseScope checks the franchise_id an admin gives a coupon or
+// pricing rule, returning nil when it is cleared to make the discount global.
+func adminFranchiseScope(db *gorm.DB, id nullableUUID) (*uuid.UUID, error) {
+	if id.Value == nil {
+		return nil, nil
+	}
+	var franchise models.Franchise
+	if err := db.Where("id = ?", *id.Value).First(&franchise).Error; err != nil {
+		return nil, errors.New("Franchise not found")
+	}
+	return id.Value, nil
+}
+
+// normalizeCouponCode puts a code in the form coupons are stored in.
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// scopedCoupons limits a coupon query to what the caller may manage.
+func (h *CouponHandler) scopedCoupons(c *gin.Context) *gorm.DB {
+	query := h.DB.Model(&models.Coupon{})
+	if scope := portalFranchiseScope(c); scope != nil {
+		query = query.Where("franchise_id = ?", *scope)
+	}
+	return query
+}
+
+// applyCouponRequest copies the given fields onto coupon and checks the result
+// is a usable coupon.
+func applyCouponRequest(coupon *models.Coupon, req couponRequest) error {
+	if req.Code != nil {
+		coupon.Code = normalizeCouponCode(*req.Code)
+	}
+	if req.Description != nil {
+		coupon.Description = *req.Description
+	}
+	if req.Type != nil {
+		coupon.Type = models.CouponType(*req.Type)
+	}
+	if req.Value != nil {
+		coupon.Value = *req.Value
+	}
+	if req.MinBasket != nil {
+		coupon.MinBasket = *req.MinBasket
+	}
+	if req.MaxDiscount != nil {
+		coupon.MaxDiscount = req.MaxDiscount
+	}
+	if req.UsageLimit != nil {
+		coupon.UsageLimit = req.UsageLimit
+	}
+	if req.PerUserLimit != nil {
+		coupon.PerUserLimit = req.PerUserLimit
+	}
+	if req.StartsAt != nil {
+		coupon.StartsAt = req.StartsAt
+	}
+	if req.EndsAt != nil {
+		coupon.EndsAt = req.EndsAt
+	}
+	if req.IsActive != nil {
+		coupon.IsActive = *req.IsActive
+	}
+
+	if coupon.Code == "" {
+		return errors.New("code is required")
+	}
+	switch coupon.Type {
+	case models.CouponPercentage:
+		if coupon.Value <= 0 || coupon.Value > 100 {
+			return errors.New("value must be between 0 and 100 for percentage coupons")
+		}
+	case models.CouponFixed:
+		if coupon.Value <= 0 {
+			return errors.New("value must be greater than 0 for fixed coupons")
+		}
+	case models.CouponFreeDelivery:
+	default:
+		return errors.New("type must be 'percentage', 'fixed' or 'free_delivery'")
+	}
+	if coupon.MinBasket < 0 {
+		return errors.New("min_basket cannot be negative")
+	}
+	if coupon.MaxDiscount != nil && *coupon.MaxDiscount <= 0 {
+		return errors.New("max_discount must be greater than 0")
+	}
+	if (coupon.UsageLimit != nil && *coupon.UsageLimit < 1) || (coupon.PerUserLimit != nil && *coupon.PerUserLimit < 1) {
+		return errors.New("usage limits must be at least 1")
+	}
+	if coupon.StartsAt != nil && coupon.EndsAt != nil && !coupon.EndsAt.After(*coupon.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// couponTargets builds the targets for a request's product and category IDs.
+func couponTargets(couponID uuid.UUID, req couponRequest) []models.CouponTarget {
+	var targets []models.CouponTarget
+	for i := range req.ProductIDs {
+		targets = append(targets, models.CouponTarget{CouponID: couponID, ProductID: &req.ProductIDs[i]})
+	}
+	for i := range req.CategoryIDs {
+		targets = append(targets, models.CouponTarget{CouponID: couponID, CategoryID: &req.CategoryIDs[i]})
+	}
+	return targets
+}
+
+// couponCodeTaken reports whether another coupon, including a deleted one,
+// already uses the code.
+func couponCodeTaken(db *gorm.DB, code string, exceptID uuid.UUID) bool {
+	var count int64
+	db.Unscoped().Model(&models.Coupon{}).Where("code = ? AND id <> ?", code, exceptID).Count(&count)
+	return count > 0
+}
+
+func (h *CouponHandler) ListCoupons(c *gin.Context) {
+	query := h.scopedCoupons(c)
+	if franchiseID := c.Query("franchise_id"); franchiseID != "" && portalFranchiseScope(c) == nil {
+		query = query.Where("franchise_id = ?", franchiseID)
+	}
+
+	var coupons []models.Coupon
+	if err := query.Preload("Targets").Order("created_at DESC").Find(&coupons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupons)
+}
+
+func (h *CouponHandler) CreateCoupon(c *gin.Context) {
+	var req couponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	coupon := models.Coupon{ID: uuid.New(), IsActive: true}
+	if err := applyCouponRequest(&coupon, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Franchise coupons are always for the caller's own store
+	if scope := portalFranchiseScope(c); scope != nil {
+		coupon.FranchiseID = scope
+	} else {
+		scope, err := adminFranchiseScope(h.DB, req.FranchiseID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		coupon.FranchiseID = scope
+	}
+
+	if couponCodeTaken(h.DB, coupon.Code, coupon.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon code already exists"})
+		return
+	}
+
+	coupon.Targets = couponTargets(coupon.ID, req)
+	if err := h.DB.Create(&coupon).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, coupon)
+}
+
+func (h *CouponHandler) UpdateCoupon(c *gin.Context) {
+	var coupon models.Coupon
+	if err := h.scopedCoupons(c).Where("id = ?", c.Param("id")).First(&coupon).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+
+	var req couponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	if err := applyCouponRequest(&coupon, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Only admins move a coupon between stores, or make it global with null
+	if req.FranchiseID.Set && portalFranchiseScope(c) == nil {
+		scope, err := adminFranchiseScope(h.DB, req.FranchiseID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		coupon.FranchiseID = scope
+	}
+	if couponCodeTaken(h.DB, coupon.Code, coupon.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon code already exists"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Targets").Save(&coupon).Error; err != nil {
+			return err
+		}
+		if req.ProductIDs == nil && req.CategoryIDs == nil {
+			return nil
+		}
+		if err := tx.Where("coupon_id = ?", coupon.ID).Delete(&models.CouponTarget{}).Error; err != nil {
+			return err
+		}
+		if targets := couponTargets(coupon.ID, req); len(targets) > 0 {
+			return tx.Create(&targets).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update coupon"})
+		return
+	}
+
+	h.DB.Preload("Targets").First(&coupon, "id = ?", coupon.ID)
+	c.JSON(http.StatusOK, coupon)
+}
+
+func (h *CouponHandler) DeleteCoupon(c *gin.Context) {
+	var coupon models.Coupon
+	if err := h.scopedCoupons(c).Where("id = ?", c.Param("id")).First(&coupon).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+
+	if err := h.DB.Delete(&coupon).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete coupon"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon deleted"})
+}
+
+// redeemableCoupon looks up a code entered at checkout and checks the rules
+// that do not depend on the basket: validity window, store and usage limits.
+func redeemableCoupon(db *gorm.DB, code string, userID uuid.UUID, franchise *models.Franchise) (*models.Coupon, error) {
+	var coupon models.Coupon
+	if err := db.Preload("Targets").Where("code = ?", normalizeCouponCode(code)).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &checkoutError{http.StatusBadRequest, "Invalid coupon code"}
+		}
+		return nil, err
+	}
+
+	if !coupon.IsRedeemableAt(time.Now()) {
+		return nil, &checkoutError{http.StatusBadRequest, "Coupon is not valid at this time"}
+	}
+	if coupon.FranchiseID != nil && (franchise == nil || franchise.ID != *coupon.FranchiseID) {
+		return nil, &checkoutError{http.StatusBadRequest, "Coupon is not valid at this store"}
+	}
+	if coupon.UsageLimit != nil && coupon.UsedCount >= *coupon.UsageLimit {
+		return nil, &checkoutError{http.StatusBadRequest, "Coupon has reached its usage limit"}
+	}
+	if coupon.PerUserLimit != nil {
+		var used int64
+		if err := db.Model(&models.CouponRedemption{}).Where("coupon_id = ? AND user_id = ?", coupon.ID, userID).Count(&used).Error; err != nil {
+			return nil, err
+		}
+		if used >= int64(*coupon.PerUserLimit) {
+			return nil, &checkoutError{http.StatusBadRequest, "You have already used this coupon"}
+		}
+	}
+	return &coupon, nil
+}
+
+// checkCouponBasket checks the basket rules of a coupon against the priced
+// basket it was applied to.
+func checkCouponBasket(coupon *models.Coupon, quote *pricing.Quote) error {
+	if quote.Subtotal < coupon.MinBasket {
+		return &checkoutError{http.StatusBadRequest, fmt.Sprintf("Spend at least %.2f to use this coupon", coupon.MinBasket)}
+	}
+	if coupon.Type != models.CouponFreeDelivery && quote.CouponDiscount == 0 {
+		return &checkoutError{http.StatusBadRequest, "Coupon does not apply to any items in your basket"}
+	}
+	return nil
+}
+
+// redeemCoupon records the coupon's use by an order, and the discount it gave,
+// inside the order's transaction. The coupon row is locked and both usage
+// limits checked again, so concurrent checkouts cannot exceed either of them.
+func redeemCoupon(tx *gorm.DB, coupon *models.Coupon, order *models.Order, discount float64) error {
+	var locked models.Coupon
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", coupon.ID).First(&locked).Error; err != nil {
+		return err
+	}
+	if locked.UsageLimit != nil && locked.UsedCount >= *locked.UsageLimit {
+		return errCouponExhausted
+	}
+	if locked.PerUserLimit != nil {
+		var used int64
+		if err := tx.Model(&models.CouponRedemption{}).Where("coupon_id = ? AND user_id = ?", coupon.ID, order.UserID).Count(&used).Error; err != nil {
+			return err
+		}
+		if used >= int64(*locked.PerUserLimit) {
+			return errCouponExhausted
+		}
+	}
+
+	if err := tx.Model(&models.Coupon{}).Where("id = ?", coupon.ID).
+		UpdateColumn("used_count", gorm.Expr("used_count + 1")).Error; err != nil {
+		return err
+	}
+	return tx.Create(&models.CouponRedemption{
+		CouponID: coupon.ID,
+		UserID:   order.UserID,
+		OrderID:  order.ID,
+		Discount: discount,
+	}).Error
+}
+
+// releaseCoupon gives back the coupon use of a cancelled order.
+func releaseCoupon(db *gorm.DB, order models.Order) {
+	if order.CouponID == nil {
+		return
+	}
+	result := db.Where("order_id = ?", order.ID).Delete(&models.CouponRedemption{})
+	if result.RowsAffected > 0 {
+		db.Model(&models.Coupon{}).Where("id = ? AND used_count > 0", order.CouponID).
+			UpdateColumn("used_count", gorm.Expr("used_count - 1"))
+	}
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// seedCoupon creates an active coupon with the given code.
+func seedCoupon(db *gorm.DB, code string, couponType models.CouponType, value float64) models.Coupon {
+	coupon := models.Coupon{ID: uuid.New(), Code: code, Type: couponType, Value: value, IsActive: true}
+	db.Create(&coupon)
+	return coupon
+}
+
+func TestAdminCreateCoupon(t *testing.T) {
+	db := freshDB()
+	router := setupCouponRouter(db)
+	_, token := seedTestUser(db, "couponadmin@test.com", "admin", nil)
+	cat := seedCategory(db, "CouponCat")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/coupons", map[string]interface{}{
+		"code": " save10 ", "type": "percentage", "value": 10, "min_basket": 20, "usage_limit": 100,
+		"category_ids": []string{cat.ID.String()},
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	if resp["code"] != "SAVE10" {
+		t.Errorf("expected code to be normalised to SAVE10, got %v", resp["code"])
+	}
+	if targets, _ := resp["targets"].([]interface{}); len(targets) != 1 {
+		t.Errorf("expected 1 category target, got %v", resp["targets"])
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/coupons", map[string]interface{}{"code": "SAVE10", "type": "fixed", "value": 5}, token))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a duplicate code, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/coupons", map[string]interface{}{"code": "HALF", "type": "percentage", "value": 150}, token))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a percentage over 100, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminUpdatesCouponFranchise(t *testing.T) {
+	db := freshDB()
+	router := setupCouponRouter(db)
+	_, token := seedTestUser(db, "couponscope@test.com", "admin", nil)
+	owner, _ := seedTestUser(db, "couponscope-owner@test.com", "customer", nil)
+	franchise := seedFranchise(db, "Scoped Coupon Store", owner.ID)
+	coupon := seedCoupon(db, "SCOPED", models.CouponFixed, 5)
+	path := "/api/admin/coupons/" + coupon.ID.String()
+
+	update := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("PUT", path, body, token))
+		return w
+	}
+
+	if w := update(map[string]interface{}{"franchise_id": uuid.New().String()}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown franchise, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := update(map[string]interface{}{"franchise_id": franchise.ID.String()}); w.Code != http.StatusOK || parseResponse(w)["franchise_id"] != franchise.ID.String() {
+		t.Errorf("expected the coupon moved to the store, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := update(map[string]interface{}{"value": 6}); w.Code != http.StatusOK || parseResponse(w)["franchise_id"] != franchise.ID.String() {
+		t.Errorf("expected a body without franchise_id to keep the store, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := update(map[string]interface{}{"franchise_id": nil}); w.Code != http.StatusOK || parseResponse(w)["franchise_id"] != nil {
+		t.Errorf("expected null to make the coupon global, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFranchiseCouponsAreScopedToStore(t *testing.T) {
+	db := freshDB()
+	router := setupCouponRouter(db)
+	owner, _ := seedTestUser(db, "couponowner@test.com", "customer", nil)
+	franchise := seedFranchise(db, "Coupon Store", owner.ID)
+	other := seedFranchise(db, "Other Coupon Store", owner.ID)
+	_, token := seedFranchiseOwnerWithToken(db, franchise)
+
+	// A franchise_id in the body is ignored for franchise staff
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/franchise/coupons", map[string]interface{}{
+		"code": "STORE5", "type": "fixed", "value": 5, "franchise_id": other.ID.String(),
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if parseResponse(w)["franchise_id"] != franchise.ID.String() {
+		t.Errorf("expected the coupon to belong to the caller's store, got %v", parseResponse(w)["franchise_id"])
+	}
+
+	otherCoupon := seedCoupon(db, "OTHER5", models.CouponFixed, 5)
+	db.Model(&otherCoupon).Update("franchise_id", other.ID)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/franchise/coupons/"+otherCoupon.ID.String(), map[string]interface{}{"value": 50}, token))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 updating another store's coupon, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/franchise/coupons", nil, token))
+	if coupons := parseResponseArray(w); len(coupons) != 1 {
+		t.Errorf("expected only the store's own coupon, got %d", len(coupons))
+	}
+}
+
+func TestCreateOrderWithCoupon(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _, token := seedCartForPayment(t)
+	coupon := seedCoupon(db, "TENOFF", models.CouponPercentage, 10)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+		"payment_method":   "cash",
+		"delivery_address": "1 Coupon St", "coupon_code": "tenoff",
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// 3 x 10.00 less 10%, with free delivery over 20.00
+	resp := parseResponse(w)
+	if resp["discount"] != 3.0 || resp["total"] != 27.0 {
+		t.Errorf("expected discount 3.00 and total 27.00, got %v and %v", resp["discount"], resp["total"])
+	}
+	if resp["coupon_code"] != "TENOFF" {
+		t.Errorf("expected the coupon to be recorded, got %v", resp["coupon_code"])
+	}
+
+	var reloaded models.Coupon
+	db.First(&reloaded, "id = ?", coupon.ID)
+	if reloaded.UsedCount != 1 {
+		t.Errorf("expected 1 use, got %d", reloaded.UsedCount)
+	}
+	var redemptions int64
+	db.Model(&models.CouponRedemption{}).Where("user_id = ?", user.ID).Count(&redemptions)
+	if redemptions != 1 {
+		t.Errorf("expected 1 redemption, got %d", redemptions)
+	}
+}
+
+func TestCreateOrderCouponRules(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _, token := seedCartForPayment(t)
+	franchise := seedFranchise(db, "Coupon Rule Store", user.ID)
+
+	minBasket := seedCoupon(db, "BIGSHOP", models.CouponFixed, 5)
+	db.Model(&minBasket).Update("min_basket", 50)
+	storeOnly := seedCoupon(db, "STOREONLY", models.CouponFixed, 5)
+	db.Model(&storeOnly).Update("franchise_id", franchise.ID)
+	expired := seedCoupon(db, "EXPIRED", models.CouponFixed, 5)
+	db.Model(&expired).Update("ends_at", time.Now().Add(-time.Hour))
+	onceEach := seedCoupon(db, "ONCE", models.CouponFixed, 5)
+	db.Model(&onceEach).Update("per_user_limit", 1)
+	db.Create(&models.CouponRedemption{CouponID: onceEach.ID, UserID: user.ID, OrderID: uuid.New()})
+	otherCat := seedCategory(db, "Not In Basket")
+	targeted := seedCoupon(db, "OTHERCAT", models.CouponPercentage, 20)
+	db.Create(&models.CouponTarget{CouponID: targeted.ID, CategoryID: &otherCat.ID})
+
+	cases := map[string]string{
+		"NOPE":      "Invalid coupon code",
+		"BIGSHOP":   "Spend at least 50.00 to use this coupon",
+		"STOREONLY": "Coupon is not valid at this store",
+		"EXPIRED":   "Coupon is not valid at this time",
+		"ONCE":      "You have already used this coupon",
+		"OTHERCAT":  "Coupon does not apply to any items in your basket",
+	}
+	for code, expected := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+			"payment_method":   "cash",
+			"delivery_address": "1 Rule St", "coupon_code": code,
+		}, token))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", code, w.Code, w.Body.String())
+			continue
+		}
+		if msg := parseResponse(w)["error"]; msg != expected {
+			t.Errorf("%s: expected %q, got %v", code, expected, msg)
+		}
+	}
+}
+
+func TestRedeemCouponRechecksPerUserLimit(t *testing.T) {
+	db := freshDB()
+	user, _ := seedTestUser(db, "couponrace@test.com", "customer", nil)
+	coupon := seedCoupon(db, "RACE", models.CouponFixed, 5)
+	db.Model(&coupon).Update("per_user_limit", 1)
+
+	checked, err := redeemableCoupon(db, "RACE", user.ID, nil)
+	if err != nil {
+		t.Fatalf("expected the coupon to be redeemable, got %v", err)
+	}
+	// Another checkout by the same customer redeems it after the check
+	db.Create(&models.CouponRedemption{CouponID: coupon.ID, UserID: user.ID, OrderID: uuid.New()})
+
+	tx := db.Begin()
+	defer tx.Rollback()
+	if err := redeemCoupon(tx, checked, &models.Order{ID: uuid.New(), UserID: user.ID}, 5); err != errCouponExhausted {
+		t.Errorf("expected errCouponExhausted, got %v", err)
+	}
+}
+
+func TestCancelOrderReleasesCoupon(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	_, _, token := seedCartForPayment(t)
+	_, adminToken := seedTestUser(db, "couponcancel@test.com", "admin", nil)
+	coupon := seedCoupon(db, "COMEBACK", models.CouponFixed, 5)
+	db.Model(&coupon).Update("usage_limit", 1)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+		"payment_method":   "cash",
+		"delivery_address": "1 Cancel St", "coupon_code": "COMEBACK",
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	orderID := parseResponse(w)["id"].(string)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+orderID+"/status", map[string]string{"status": "cancelled"}, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var reloaded models.Coupon
+	db.First(&reloaded, "id = ?", coupon.ID)
+	if reloaded.UsedCount != 0 {
+		t.Errorf("expected the use to be given back, got %d", reloaded.UsedCount)
+	}
+}
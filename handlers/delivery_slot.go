@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	deliverySlotLength = time.Hour
+	// How many days ahead slots are offered
+	deliverySlotDays = 7
+	// Slots starting sooner than this can no longer be booked
+	deliverySlotLeadTime = 30 * time.Minute
+	// Used when a franchise has no slot capacity configured
+	defaultSlotCapacity = 10
+)
+
+var errDeliverySlotUnavailable = errors.New("delivery slot unavailable")
+
+// deliverySlotResponse is a slot together with its remaining capacity.
+type deliverySlotResponse struct {
+	models.DeliverySlot
+	Available int `json:"available"`
+}
+
+// ListDeliverySlots returns the bookable delivery slots for a franchise over the
+// coming week. When lat and lng are given, the address must be inside the
+// franchise's delivery radius.
+func (h *FranchiseHandler) ListDeliverySlots(c *gin.Context) {
+	var franchise models.Franchise
+	if err := h.DB.Preload("StoreHours").Where("id = ? AND is_active = ?", c.Param("id"), true).First(&franchise).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Franchise not found"})
+		return
+	}
+
+	latStr, lngStr := c.Query("lat"), c.Query("lng")
+	if latStr != "" || lngStr != "" {
+		lat, err := strconv.ParseFloat(latStr, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid latitude"})
+			return
+		}
+		lng, err := strconv.ParseFloat(lngStr, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid longitude"})
+			return
+		}
+		if !deliversTo(franchise, lat, lng) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This franchise does not deliver to your location"})
+			return
+		}
+	}
+
+	from := time.Now().Add(deliverySlotLeadTime)
+	if err := generateDeliverySlots(h.DB, franchise, from, deliverySlotDays); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate delivery slots"})
+		return
+	}
+
+	var slots []models.DeliverySlot
+	if err := h.DB.Where("franchise_id = ? AND starts_at >= ?", franchise.ID, from).
+		Order("starts_at ASC").Find(&slots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery slots"})
+		return
+	}
+
+	result := make([]deliverySlotResponse, len(slots))
+	for i := range slots {
+		result[i] = deliverySlotResponse{DeliverySlot: slots[i], Available: slots[i].Available()}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"slots": result})
+}
+
+// generateDeliverySlots creates the franchise's slots from its store hours for
+// the given number of days starting at from. Slots that already exist are left
+// untouched, so this is safe to call on every listing.
+func generateDeliverySlots(db *gorm.DB, franchise models.Franchise, from time.Time, days int) error {
+	hoursByDay := make(map[int]models.StoreHours)
+	for _, h := range franchise.StoreHours {
+		hoursByDay[h.DayOfWeek] = h
+	}
+
+	capacity := franchise.SlotCapacity
+	if capacity <= 0 {
+		capacity = defaultSlotCapacity
+	}
+
+	var slots []models.DeliverySlot
+	midnight := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for d := 0; d < days; d++ {
+		day := midnight.AddDate(0, 0, d)
+		hours, ok := hoursByDay[int(day.Weekday())]
+		if !ok || hours.IsClosed {
+			continue
+		}
+		openAt, err := clockTimeOn(day, hours.OpenTime)
+		if err != nil {
+			continue
+		}
+		closeAt, err := clockTimeOn(day, hours.CloseTime)
+		if err != nil {
+			continue
+		}
+
+		for start := openAt; !start.Add(deliverySlotLength).After(closeAt); start = start.Add(deliverySlotLength) {
+			if start.Before(from) {
+				continue
+			}
+			slots = append(slots, models.DeliverySlot{
+				FranchiseID: franchise.ID,
+				StartsAt:    start,
+				EndsAt:      start.Add(deliverySlotLength),
+				Capacity:    capacity,
+			})
+		}
+	}
+
+	if len(slots) == 0 {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&slots).Error
+}
+
+// clockTimeOn returns the "HH:MM" clock time on the given day.
+func clockTimeOn(day time.Time, clock string) (time.Time, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, day.Location()), nil
+}
+
+// reserveDeliverySlot books one place in a franchise's slot. The capacity check
+// and increment are a single conditional update, so concurrent checkouts cannot
+// overbook the slot.
+func reserveDeliverySlot(tx *gorm.DB, slotID, franchiseID uuid.UUID) error {
+	result := tx.Model(&models.DeliverySlot{}).
+		Where("id = ? AND franchise_id = ? AND reserved < capacity AND starts_at >= ?", slotID, franchiseID, time.Now().Add(deliverySlotLeadTime)).
+		UpdateColumn("reserved", gorm.Expr("reserved + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errDeliverySlotUnavailable
+	}
+	return nil
+}
+
+// releaseDeliverySlot frees the slot place held by a cancelled order.
+func releaseDeliverySlot(db *gorm.DB, order models.Order) {
+	if order.DeliverySlotID == nil {
+		return
+	}
+	db.Model(&models.DeliverySlot{}).Where("id = ? AND reserved > 0", order.DeliverySlotID).
+		UpdateColumn("reserved", gorm.Expr("reserved - 1"))
+}
+
+// applySlotCapacity sets the capacity of a franchise's upcoming slots after the
+// franchise changes it. Places already reserved are kept even if they now exceed
+// the new capacity.
+func applySlotCapacity(db *gorm.DB, franchiseID uuid.UUID, capacity int) {
+	db.Model(&models.DeliverySlot{}).Where("franchise_id = ? AND starts_at > ?", franchiseID, time.Now()).
+		UpdateColumn("capacity", capacity)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// seedDeliverySlot creates a slot for the franchise starting tomorrow.
+func seedDeliverySlot(db *gorm.DB, franchiseID uuid.UUID, capacity int) models.DeliverySlot {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+	slot := models.DeliverySlot{
+		ID:          uuid.New(),
+		FranchiseID: franchiseID,
+		StartsAt:    start,
+		EndsAt:      start.Add(time.Hour),
+		Capacity:    capacity,
+	}
+	db.Create(&slot)
+	return slot
+}
+
+func TestGenerateDeliverySlotsFollowsStoreHours(t *testing.T) {
+	db := freshDB()
+	owner, _ := seedTestUser(db, "slotgen@test.com", "customer", nil)
+	franchise := seedFranchise(db, "Slot Gen Store", owner.ID)
+	franchise.SlotCapacity = 4
+
+	// Open 09:00-12:00 on Mondays only
+	for day := 0; day < 7; day++ {
+		franchise.StoreHours = append(franchise.StoreHours, models.StoreHours{
+			DayOfWeek: day, OpenTime: "09:00", CloseTime: "12:00", IsClosed: day != int(time.Monday),
+		})
+	}
+	monday := time.Date(2030, time.January, 7, 0, 0, 0, 0, time.Local)
+
+	for i := 0; i < 2; i++ {
+		if err := generateDeliverySlots(db, franchise, monday, 7); err != nil {
+			t.Fatalf("failed to generate slots: %v", err)
+		}
+	}
+
+	var slots []models.DeliverySlot
+	db.Where("franchise_id = ?", franchise.ID).Order("starts_at ASC").Find(&slots)
+	if len(slots) != 3 {
+		t.Fatalf("expected 3 slots after generating twice, got %d", len(slots))
+	}
+	if slots[0].StartsAt.Hour() != 9 || slots[2].EndsAt.Hour() != 12 {
+		t.Errorf("expected slots from 09:00 to 12:00, got %v to %v", slots[0].StartsAt, slots[2].EndsAt)
+	}
+	if slots[0].Capacity != 4 {
+		t.Errorf("expected capacity 4, got %d", slots[0].Capacity)
+	}
+}
+
+func TestListDeliverySlots(t *testing.T) {
+	db := freshDB()
+	router := setupFranchiseRouter(db)
+	owner, _ := seedTestUser(db, "slotlist@test.com", "customer", nil)
+	franchise := seedFranchise(db, "Slot List Store", owner.ID)
+	seedStoreHours(db, franchise.ID)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, jsonRequest("GET", "/api/franchises/"+franchise.ID.String()+"/delivery-slots?lat=51.5074&lng=-0.1278", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	slots, ok := parseResponse(w)["slots"].([]interface{})
+	if !ok || len(slots) == 0 {
+		t.Fatalf("expected generated slots, got %v", parseResponse(w)["slots"])
+	}
+	first := slots[0].(map[string]interface{})
+	if first["available"] != float64(10) {
+		t.Errorf("expected 10 places available, got %v", first["available"])
+	}
+	startsAt, _ := time.Parse(time.RFC3339, first["starts_at"].(string))
+	if startsAt.Before(time.Now().Add(deliverySlotLeadTime)) {
+		t.Errorf("expected slots to start after the lead time, got %v", startsAt)
+	}
+}
+
+func TestListDeliverySlotsOutsideRadius(t *testing.T) {
+	db := freshDB()
+	router := setupFranchiseRouter(db)
+	owner, _ := seedTestUser(db, "slotfar@test.com", "customer", nil)
+	franchise := seedFranchise(db, "Slot Far Store", owner.ID)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, jsonRequest("GET", "/api/franchises/"+franchise.ID.String()+"/delivery-slots?lat=53.4808&lng=-2.2426", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestListDeliverySlotsRadiusInMiles(t *testing.T) {
+	db := freshDB()
+	router := setupFranchiseRouter(db)
+	owner, _ := seedTestUser(db, "slotmiles@test.com", "customer", nil)
+	franchise := seedFranchise(db, "Slot Miles Store", owner.ID)
+
+	// About 4.4 miles away: inside the 5 mile radius, though over 5 km
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, jsonRequest("GET", "/api/franchises/"+franchise.ID.String()+"/delivery-slots?lat=51.5704&lng=-0.1278", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateOrderReservesDeliverySlot(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _, token := seedCartForPayment(t)
+	franchise := seedFranchise(db, "Slot Order Store", user.ID)
+	slot := seedDeliverySlot(db, franchise.ID, 1)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+		"payment_method":   "cash",
+		"delivery_address": "1 Slot St",
+		"franchise_id":     franchise.ID.String(),
+		"slot_id":          slot.ID.String(),
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if parseResponse(w)["delivery_slot_id"] != slot.ID.String() {
+		t.Errorf("expected order to carry the slot, got %v", parseResponse(w)["delivery_slot_id"])
+	}
+
+	var reloaded models.DeliverySlot
+	db.First(&reloaded, "id = ?", slot.ID)
+	if reloaded.Reserved != 1 {
+		t.Errorf("expected 1 reserved place, got %d", reloaded.Reserved)
+	}
+}
+
+func TestCreateOrderFullDeliverySlotRejected(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, product, token := seedCartForPayment(t)
+	franchise := seedFranchise(db, "Full Slot Store", user.ID)
+	slot := seedDeliverySlot(db, franchise.ID, 1)
+	db.Model(&slot).Update("reserved", 1)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+		"payment_method":   "cash",
+		"delivery_address": "1 Full St",
+		"franchise_id":     franchise.ID.String(),
+		"slot_id":          slot.ID.String(),
+	}, token))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Nothing else in the checkout should have happened
+	var reloaded models.Product
+	db.First(&reloaded, "id = ?", product.ID)
+	if reloaded.StockQuantity != product.StockQuantity {
+		t.Errorf("expected stock %d to be untouched, got %d", product.StockQuantity, reloaded.StockQuantity)
+	}
+	var cartCount int64
+	db.Model(&models.CartItem{}).Where("user_id = ?", user.ID).Count(&cartCount)
+	if cartCount == 0 {
+		t.Error("expected cart to be kept")
+	}
+}
+
+func TestCancelOrderReleasesDeliverySlot(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _, token := seedCartForPayment(t)
+	_, adminToken := seedTestUser(db, "slotadmin@test.com", "admin", nil)
+	franchise := seedFranchise(db, "Release Slot Store", user.ID)
+	slot := seedDeliverySlot(db, franchise.ID, 2)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+		"payment_method":   "cash",
+		"delivery_address": "1 Release St",
+		"franchise_id":     franchise.ID.String(),
+		"slot_id":          slot.ID.String(),
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	orderID := parseResponse(w)["id"].(string)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+orderID+"/status", map[string]string{"status": "cancelled"}, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var reloaded models.DeliverySlot
+	db.First(&reloaded, "id = ?", slot.ID)
+	if reloaded.Reserved != 0 {
+		t.Errorf("expected the place to be released, got %d reserved", reloaded.Reserved)
+	}
+}
@@ -15,6 +15,8 @@ import (
 
 	"grabbi-backend/firebase"
 	"grabbi-backend/models"
+	"grabbi-backend/payments"
+	"grabbi-backend/pricing"
 	"grabbi-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -24,8 +26,9 @@ import (
 )
 
 type FranchiseHandler struct {
-	DB      *gorm.DB
-	Storage firebase.StorageClient
+	DB       *gorm.DB
+	Storage  firebase.StorageClient
+	Payments payments.PaymentProvider
 }
 
 // ========== Public Endpoints ==========
@@ -57,26 +60,42 @@ func (h *FranchiseHandler) GetNearestFranchise(c *gin.Context) {
 		return
 	}
 
+	nearest, nearestDistance := nearestDeliveringFranchise(franchises, lat, lng)
+	if nearest == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No franchise delivers to your location"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"franchise": nearest,
+		"distance":  nearestDistance,
+	})
+}
+
+// nearestDeliveringFranchise returns the closest of the franchises whose
+// delivery radius covers the location, with its distance in miles, or nil when
+// none deliver there.
+func nearestDeliveringFranchise(franchises []models.Franchise, lat, lng float64) (*models.Franchise, float64) {
 	var nearest *models.Franchise
 	var nearestDistance float64 = -1
 
 	for i := range franchises {
+		if !deliversTo(franchises[i], lat, lng) {
+			continue
+		}
 		dist := utils.Haversine(lat, lng, franchises[i].Latitude, franchises[i].Longitude)
-		if dist <= franchises[i].DeliveryRadius && (nearestDistance < 0 || dist < nearestDistance) {
+		if nearestDistance < 0 || dist < nearestDistance {
 			nearest = &franchises[i]
 			nearestDistance = dist
 		}
 	}
+	return nearest, nearestDistance
+}
 
-	if nearest == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No franchise delivers to your location"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"franchise": nearest,
-		"distance":  nearestDistance,
-	})
+// deliversTo reports whether a location is within the franchise's delivery
+// radius, which is in miles.
+func deliversTo(franchise models.Franchise, lat, lng float64) bool {
+	return utils.Haversine(lat, lng, franchise.Latitude, franchise.Longitude) <= franchise.DeliveryRadius
 }
 
 // StoreHoursResponse represents store hours for API response
@@ -376,14 +395,17 @@ func (h *FranchiseHandler) GetFranchiseProducts(c *gin.Context) {
 		FranchiseStock     int      `json:"franchise_stock"`
 		FranchisePrice     float64  `json:"franchise_price"`
 		PromoPrice         *float64 `json:"franchise_promo_price,omitempty"`
+		CurrentPrice       float64  `json:"current_price"`
 		ShelfLocation      string   `json:"franchise_shelf_location"`
 		FranchiseAvailable bool     `json:"franchise_available"`
 	}
 
+	now := time.Now()
 	var result []MergedProduct
 	for _, fp := range fps {
 		mp := MergedProduct{
 			Product:            fp.Product,
+			CurrentPrice:       pricing.EffectivePrice(fp.Product, &fp, now),
 			FranchiseStock:     fp.StockQuantity,
 			ShelfLocation:      fp.ShelfLocation,
 			FranchiseAvailable: fp.IsAvailable,
@@ -396,10 +418,11 @@ func (h *FranchiseHandler) GetFranchiseProducts(c *gin.Context) {
 			mp.FranchisePrice = fp.Product.RetailPrice
 		}
 
-		if fp.PromotionPriceOverride != nil {
-			mp.PromoPrice = fp.PromotionPriceOverride
-		} else if fp.Product.PromotionPrice != nil {
-			mp.PromoPrice = fp.Product.PromotionPrice
+		// Only a promotion running now is shown, and a store's own price replaces a
+		// catalogue promotion, as at checkout
+		if fp.IsPromotionActiveAt(now) || (fp.RetailPriceOverride == nil && fp.Product.IsPromotionActiveAt(now)) {
+			promoPrice := mp.CurrentPrice
+			mp.PromoPrice = &promoPrice
 		}
 
 		result = append(result, mp)
@@ -684,6 +707,7 @@ func (h *FranchiseHandler) UpdateFranchise(c *gin.Context) {
 		Phone           *string  `json:"phone"`
 		Email           *string  `json:"email"`
 		IsActive        *bool    `json:"is_active"`
+		SlotCapacity    *int     `json:"slot_capacity"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -727,11 +751,21 @@ func (h *FranchiseHandler) UpdateFranchise(c *gin.Context) {
 	if req.IsActive != nil {
 		franchise.IsActive = *req.IsActive
 	}
+	if req.SlotCapacity != nil {
+		if *req.SlotCapacity < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "slot_capacity must be at least 1"})
+			return
+		}
+		franchise.SlotCapacity = *req.SlotCapacity
+	}
 
 	if err := h.DB.Save(&franchise).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update franchise"})
 		return
 	}
+	if req.SlotCapacity != nil {
+		applySlotCapacity(h.DB, franchise.ID, franchise.SlotCapacity)
+	}
 
 	h.DB.Preload("Owner").Preload("StoreHours").First(&franchise, franchise.ID)
 	c.JSON(http.StatusOK, franchise)
@@ -824,6 +858,7 @@ func (h *FranchiseHandler) UpdateMyFranchise(c *gin.Context) {
 		DeliveryRadius  *float64 `json:"delivery_radius"`
 		DeliveryFee     *float64 `json:"delivery_fee"`
 		FreeDeliveryMin *float64 `json:"free_delivery_min"`
+		SlotCapacity    *int     `json:"slot_capacity"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -869,11 +904,21 @@ func (h *FranchiseHandler) UpdateMyFranchise(c *gin.Context) {
 	if req.FreeDeliveryMin != nil {
 		franchise.FreeDeliveryMin = *req.FreeDeliveryMin
 	}
+	if req.SlotCapacity != nil {
+		if *req.SlotCapacity < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "slot_capacity must be at least 1"})
+			return
+		}
+		franchise.SlotCapacity = *req.SlotCapacity
+	}
 
 	if err := h.DB.Save(&franchise).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update franchise"})
 		return
 	}
+	if req.SlotCapacity != nil {
+		applySlotCapacity(h.DB, franchise.ID, franchise.SlotCapacity)
+	}
 
 	h.DB.Preload("StoreHours").First(&franchise, franchise.ID)
 	c.JSON(http.StatusOK, franchise)
@@ -964,6 +1009,7 @@ func (h *FranchiseHandler) UpdateProductStock(c *gin.Context) {
 		return
 	}
 
+	wasInStock := listingInStock(fp)
 	if req.StockQuantity != nil {
 		fp.StockQuantity = *req.StockQuantity
 	}
@@ -981,6 +1027,9 @@ func (h *FranchiseHandler) UpdateProductStock(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update stock"})
 		return
 	}
+	if !wasInStock && listingInStock(fp) {
+		notifyBackInStock(h.DB, fp.ProductID, &fp.FranchiseID)
+	}
 
 	h.DB.Preload("Product").Preload("Product.Category").Preload("Product.Images", "deleted_at IS NULL").First(&fp, fp.ID)
 	c.JSON(http.StatusOK, fp)
@@ -1037,6 +1086,7 @@ func (h *FranchiseHandler) GetMyOrders(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
 		return
 	}
+	hidePickupCodes(orders)
 
 	c.JSON(http.StatusOK, orders)
 }
@@ -1052,7 +1102,9 @@ func (h *FranchiseHandler) UpdateOrderStatus(c *gin.Context) {
 	}
 
 	var req struct {
-		Status models.OrderStatus `json:"status" binding:"required"`
+		Status     models.OrderStatus `json:"status" binding:"required"`
+		Note       string             `json:"note"`
+		PickupCode string             `json:"pickup_code"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -1061,34 +1113,27 @@ func (h *FranchiseHandler) UpdateOrderStatus(c *gin.Context) {
 	}
 
 	// Validate state transition using the shared state machine
-	if !models.IsValidTransition(order.Status, req.Status) {
+	if !models.IsValidTransitionFor(order.FulfilmentType, order.Status, req.Status) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": fmt.Sprintf("Invalid status transition from '%s' to '%s'", order.Status, req.Status),
 		})
 		return
 	}
+	actorID, actorRole := statusActor(c)
+	if !models.CanTransition(actorRole, order.FulfilmentType, order.Status, req.Status) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("You cannot move an order to '%s'", req.Status)})
+		return
+	}
 
-	order.Status = req.Status
-	if err := h.DB.Save(&order).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+	// Collection is only confirmed once the store has checked the customer's code
+	if req.Status == models.OrderStatusCollected && !pickupCodeMatches(order, req.PickupCode) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid pickup code"})
 		return
 	}
 
-	// Restore stock on cancellation
-	if req.Status == models.OrderStatusCancelled {
-		var items []models.OrderItem
-		h.DB.Where("order_id = ?", order.ID).Find(&items)
-		for _, item := range items {
-			var fp models.FranchiseProduct
-			if err := h.DB.Where("franchise_id = ? AND product_id = ?", franchiseID, item.ProductID).First(&fp).Error; err == nil {
-				fp.StockQuantity += item.Quantity
-				h.DB.Save(&fp)
-			} else {
-				// Fallback to master product stock
-				h.DB.Model(&models.Product{}).Where("id = ?", item.ProductID).
-					UpdateColumn("stock_quantity", gorm.Expr("stock_quantity + ?", item.Quantity))
-			}
-		}
+	if err := applyStatusChange(h.DB, h.Payments, &order, req.Status, actorID, actorRole, req.Note); err != nil {
+		respondCheckoutError(c, err, "Failed to update order status")
+		return
 	}
 
 	h.DB.Preload("Items").Preload("Items.Product").Preload("User").First(&order, order.ID)
@@ -1097,10 +1142,25 @@ func (h *FranchiseHandler) UpdateOrderStatus(c *gin.Context) {
 	if order.User.Email != "" {
 		utils.SendOrderStatusUpdate(order.User.Email, order.User.Name, order.OrderNumber, string(req.Status))
 	}
+	order.PickupCode = ""
 
 	c.JSON(http.StatusOK, order)
 }
 
+// StreamMyOrders streams new orders and status changes for the caller's
+// franchise as server-sent events.
+func (h *FranchiseHandler) StreamMyOrders(c *gin.Context) {
+	franchiseID, _ := c.Get("franchise_id")
+	fID, _ := franchiseID.(uuid.UUID)
+
+	updates, unsubscribe := utils.OrderEvents.Subscribe(func(u utils.OrderUpdate) bool {
+		return u.FranchiseID != nil && *u.FranchiseID == fID
+	})
+	defer unsubscribe()
+
+	streamOrderUpdates(c, updates, nil)
+}
+
 func (h *FranchiseHandler) GetMyStaff(c *gin.Context) {
 	franchiseID, _ := c.Get("franchise_id")
 
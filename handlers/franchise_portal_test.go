@@ -1206,17 +1206,7 @@ func TestGetMyStaffDBError(t *testing.T) {
 	}
 
 	// Recreate the table for subsequent tests
-	db.Exec(`CREATE TABLE IF NOT EXISTS "franchise_staffs" (
-		"id" TEXT PRIMARY KEY,
-		"franchise_id" TEXT NOT NULL,
-		"user_id" TEXT NOT NULL UNIQUE,
-		"role" TEXT NOT NULL DEFAULT 'staff',
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		CONSTRAINT fk_franchise_staffs_franchise FOREIGN KEY ("franchise_id") REFERENCES "franchises"("id"),
-		CONSTRAINT fk_franchise_staffs_user FOREIGN KEY ("user_id") REFERENCES "users"("id")
-	)`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_franchise_staffs_franchise_id ON "franchise_staffs"("franchise_id")`)
+	createSQLiteTables(db)
 }
 
 // TestGetStoreHoursDBError tests the error path when the DB query fails.
@@ -1244,18 +1234,7 @@ func TestGetStoreHoursDBError(t *testing.T) {
 	}
 
 	// Recreate the table
-	db.Exec(`CREATE TABLE IF NOT EXISTS "store_hours" (
-		"id" TEXT PRIMARY KEY,
-		"franchise_id" TEXT NOT NULL,
-		"day_of_week" INTEGER NOT NULL,
-		"open_time" TEXT NOT NULL DEFAULT '09:00',
-		"close_time" TEXT NOT NULL DEFAULT '21:00',
-		"is_closed" INTEGER DEFAULT 0,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		CONSTRAINT fk_store_hours_franchise FOREIGN KEY ("franchise_id") REFERENCES "franchises"("id")
-	)`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_store_hours_franchise_id ON "store_hours"("franchise_id")`)
+	createSQLiteTables(db)
 }
 
 // TestGetMyPromotionsDBError tests the error path when the DB query fails.
@@ -1283,23 +1262,7 @@ func TestGetMyPromotionsDBError(t *testing.T) {
 	}
 
 	// Recreate the table
-	db.Exec(`CREATE TABLE IF NOT EXISTS "franchise_promotions" (
-		"id" TEXT PRIMARY KEY,
-		"franchise_id" TEXT NOT NULL,
-		"title" TEXT NOT NULL,
-		"description" TEXT,
-		"image" TEXT,
-		"product_url" TEXT,
-		"is_active" INTEGER DEFAULT 1,
-		"start_date" DATETIME,
-		"end_date" DATETIME,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		"deleted_at" DATETIME,
-		CONSTRAINT fk_franchise_promotions_franchise FOREIGN KEY ("franchise_id") REFERENCES "franchises"("id")
-	)`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_franchise_promotions_deleted_at ON "franchise_promotions"("deleted_at")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_franchise_promotions_franchise_id ON "franchise_promotions"("franchise_id")`)
+	createSQLiteTables(db)
 }
 
 // TestGetMyProductsDBError tests the error path when the DB query fails.
@@ -1327,24 +1290,7 @@ func TestGetMyProductsDBError(t *testing.T) {
 	}
 
 	// Recreate the table
-	db.Exec(`CREATE TABLE IF NOT EXISTS "franchise_products" (
-		"id" TEXT PRIMARY KEY,
-		"franchise_id" TEXT NOT NULL,
-		"product_id" TEXT NOT NULL,
-		"retail_price_override" REAL,
-		"promotion_price_override" REAL,
-		"promotion_start_override" DATETIME,
-		"promotion_end_override" DATETIME,
-		"stock_quantity" INTEGER DEFAULT 0,
-		"reorder_level" INTEGER DEFAULT 5,
-		"shelf_location" TEXT,
-		"is_available" INTEGER DEFAULT 1,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		CONSTRAINT fk_franchise_products_franchise FOREIGN KEY ("franchise_id") REFERENCES "franchises"("id"),
-		CONSTRAINT fk_franchise_products_product FOREIGN KEY ("product_id") REFERENCES "products"("id")
-	)`)
-	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_franchise_product ON "franchise_products"("franchise_id","product_id")`)
+	createSQLiteTables(db)
 }
 
 // TestGetMyOrdersDBError tests the error path when the DB query fails.
@@ -1373,42 +1319,7 @@ func TestGetMyOrdersDBError(t *testing.T) {
 	}
 
 	// Recreate the tables
-	db.Exec(`CREATE TABLE IF NOT EXISTS "orders" (
-		"id" TEXT PRIMARY KEY,
-		"user_id" TEXT NOT NULL,
-		"franchise_id" TEXT,
-		"order_number" TEXT NOT NULL UNIQUE,
-		"status" TEXT DEFAULT 'pending',
-		"subtotal" REAL NOT NULL,
-		"delivery_fee" REAL DEFAULT 0,
-		"total" REAL NOT NULL,
-		"delivery_address" TEXT,
-		"payment_method" TEXT,
-		"points_earned" INTEGER DEFAULT 0,
-		"customer_lat" REAL,
-		"customer_lng" REAL,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		"deleted_at" DATETIME,
-		CONSTRAINT fk_orders_user FOREIGN KEY ("user_id") REFERENCES "users"("id")
-	)`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_orders_deleted_at ON "orders"("deleted_at")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_orders_user_id ON "orders"("user_id")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_orders_franchise_id ON "orders"("franchise_id")`)
-	db.Exec(`CREATE TABLE IF NOT EXISTS "order_items" (
-		"id" TEXT PRIMARY KEY,
-		"order_id" TEXT NOT NULL,
-		"product_id" TEXT NOT NULL,
-		"image_url" TEXT,
-		"quantity" INTEGER NOT NULL,
-		"price" REAL NOT NULL,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		CONSTRAINT fk_order_items_order FOREIGN KEY ("order_id") REFERENCES "orders"("id"),
-		CONSTRAINT fk_order_items_product FOREIGN KEY ("product_id") REFERENCES "products"("id")
-	)`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON "order_items"("order_id")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_order_items_product_id ON "order_items"("product_id")`)
+	createSQLiteTables(db)
 }
 
 // Ensure all imported identifiers are used
@@ -602,6 +602,45 @@ func TestGetFranchiseProductsWithPriceOverrides(t *testing.T) {
 	}
 }
 
+func TestGetFranchiseProductsHidesExpiredPromotions(t *testing.T) {
+	db := freshDB()
+	router := setupFranchiseRouter(db)
+
+	owner, _ := seedTestUser(db, "owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Expired Promo Franchise", owner.ID)
+	cat := seedCategory(db, "TestCat")
+	prod := seedProduct(db, "ExpiredPromoProd", cat.ID, 10.00)
+	db.Model(&prod).Updates(map[string]interface{}{
+		"promotion_price": 7.00,
+		"promotion_start": time.Now().Add(-72 * time.Hour),
+		"promotion_end":   time.Now().Add(-48 * time.Hour),
+	})
+	fp := seedFranchiseProduct(db, franchise.ID, prod.ID)
+	db.Model(&fp).Updates(map[string]interface{}{
+		"promotion_price_override": 5.00,
+		"promotion_start_override": time.Now().Add(-48 * time.Hour),
+		"promotion_end_override":   time.Now().Add(-24 * time.Hour),
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/api/franchises/%s/products", franchise.ID), nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	result := parseResponseArray(w)
+	if len(result) != 1 {
+		t.Fatalf("expected 1 product, got %d", len(result))
+	}
+	p := result[0].(map[string]interface{})
+	if _, ok := p["franchise_promo_price"]; ok {
+		t.Errorf("expected no promo price once both promotions have ended, got %v", p["franchise_promo_price"])
+	}
+	if p["current_price"] != 10.0 {
+		t.Errorf("expected current_price 10.00, got %v", p["current_price"])
+	}
+}
+
 func TestGetFranchiseOrdersEmpty(t *testing.T) {
 	db := freshDB()
 	router := setupFranchiseRouter(db)
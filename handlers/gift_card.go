@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"grabbi-backend/giftcards"
+	"grabbi-backend/models"
+	"grabbi-backend/pricing"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GiftCardHandler issues and manages gift cards. Admins manage every card;
+// franchise staff issue and manage cards for their own store only.
+type GiftCardHandler struct {
+	DB *gorm.DB
+}
+
+// scopedGiftCards limits a gift card query to what the caller may manage.
+func (h *GiftCardHandler) scopedGiftCards(c *gin.Context) *gorm.DB {
+	query := h.DB.Model(&models.GiftCard{})
+	if scope := portalFranchiseScope(c); scope != nil {
+		query = query.Where("franchise_id = ?", *scope)
+	}
+	return query
+}
+
+func (h *GiftCardHandler) ListGiftCards(c *gin.Context) {
+	query := h.scopedGiftCards(c)
+	if franchiseID := c.Query("franchise_id"); franchiseID != "" && portalFranchiseScope(c) == nil {
+		query = query.Where("franchise_id = ?", franchiseID)
+	}
+
+	var cards []models.GiftCard
+	if err := query.Order("created_at DESC").Find(&cards).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch gift cards"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cards)
+}
+
+// CreateGiftCard issues a card. Without a code one is generated.
+func (h *GiftCardHandler) CreateGiftCard(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		Code        string     `json:"code"`
+		Amount      float64    `json:"amount" binding:"required,gt=0"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+		FranchiseID *uuid.UUID `json:"franchise_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	card := models.GiftCard{
+		Code:           req.Code,
+		InitialBalance: pricing.RoundMoney(req.Amount),
+		IssuedByID:     userID.(uuid.UUID),
+		ExpiresAt:      req.ExpiresAt,
+		IsActive:       true,
+	}
+
+	// Franchise cards are always for the caller's own store
+	if scope := portalFranchiseScope(c); scope != nil {
+		card.FranchiseID = scope
+	} else if req.FranchiseID != nil {
+		var franchise models.Franchise
+		if err := h.DB.Where("id = ?", *req.FranchiseID).First(&franchise).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Franchise not found"})
+			return
+		}
+		card.FranchiseID = req.FranchiseID
+	}
+
+	if err := giftcards.Issue(h.DB, &card); err != nil {
+		if errors.Is(err, giftcards.ErrDuplicateCode) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Gift card code already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue gift card"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, card)
+}
+
+// GetGiftCard returns a card with every movement of its balance.
+func (h *GiftCardHandler) GetGiftCard(c *gin.Context) {
+	var card models.GiftCard
+	if err := h.scopedGiftCards(c).Where("id = ?", c.Param("id")).First(&card).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+
+	var transactions []models.GiftCardTransaction
+	if err := h.DB.Where("gift_card_id = ?", card.ID).Order("created_at ASC").Find(&transactions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch gift card transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"gift_card":    card,
+		"transactions": transactions,
+	})
+}
+
+// UpdateGiftCard deactivates or reactivates a card or changes its expiry. The
+// balance only ever moves through transactions.
+func (h *GiftCardHandler) UpdateGiftCard(c *gin.Context) {
+	var card models.GiftCard
+	if err := h.scopedGiftCards(c).Where("id = ?", c.Param("id")).First(&card).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+
+	var req struct {
+		IsActive  *bool      `json:"is_active"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+	if req.ExpiresAt != nil {
+		updates["expires_at"] = *req.ExpiresAt
+	}
+	if len(updates) > 0 {
+		if err := h.DB.Model(&card).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update gift card"})
+			return
+		}
+	}
+
+	h.DB.First(&card, "id = ?", card.ID)
+	c.JSON(http.StatusOK, card)
+}
+
+// GetBalance lets anyone holding a card check what is left on it and how it
+// was spent.
+func (h *GiftCardHandler) GetBalance(c *gin.Context) {
+	card, err := giftcards.Lookup(h.DB, c.Param("code"))
+	if err != nil {
+		if errors.Is(err, giftcards.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch gift card"})
+		return
+	}
+
+	var transactions []models.GiftCardTransaction
+	if err := h.DB.Select("type", "amount", "balance_after", "created_at").
+		Where("gift_card_id = ?", card.ID).Order("created_at ASC").Find(&transactions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch gift card transactions"})
+		return
+	}
+	history := make([]gin.H, len(transactions))
+	for i, t := range transactions {
+		history[i] = gin.H{"type": t.Type, "amount": t.Amount, "balance_after": t.BalanceAfter, "created_at": t.CreatedAt}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":         card.Code,
+		"balance":      card.Balance,
+		"expires_at":   card.ExpiresAt,
+		"is_active":    card.IsActive,
+		"usable":       giftcards.CheckUsable(*card, card.FranchiseID, time.Now()) == nil,
+		"franchise_id": card.FranchiseID,
+		"transactions": history,
+	})
+}
+
+// usableGiftCards looks up the cards a customer wants to pay with and checks
+// each can be spent on an order at the franchise.
+func usableGiftCards(db *gorm.DB, codes []string, franchiseID *uuid.UUID) ([]models.GiftCard, error) {
+	seen := make(map[string]bool)
+	var cards []models.GiftCard
+	for _, code := range codes {
+		code = giftcards.NormaliseCode(code)
+		if seen[code] {
+			return nil, &checkoutError{http.StatusBadRequest, "Each gift card can only be applied once"}
+		}
+		seen[code] = true
+
+		card, err := giftcards.Lookup(db, code)
+		if errors.Is(err, giftcards.ErrNotFound) {
+			return nil, &checkoutError{http.StatusBadRequest, "Gift card not found"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := giftcards.CheckUsable(*card, franchiseID, time.Now()); err != nil {
+			return nil, &checkoutError{http.StatusBadRequest, "Gift card " + card.Code + ": " + err.Error()}
+		}
+		cards = append(cards, *card)
+	}
+	return cards, nil
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grabbi-backend/giftcards"
+	"grabbi-backend/models"
+	"grabbi-backend/payments"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// seedGiftCard issues a card worth amount, optionally tied to a franchise.
+func seedGiftCard(t *testing.T, db *gorm.DB, amount float64, franchiseID *uuid.UUID) models.GiftCard {
+	t.Helper()
+	card := models.GiftCard{InitialBalance: amount, IssuedByID: uuid.New(), FranchiseID: franchiseID, IsActive: true}
+	if err := giftcards.Issue(db, &card); err != nil {
+		t.Fatalf("failed to issue gift card: %v", err)
+	}
+	return card
+}
+
+func giftCardBalance(db *gorm.DB, id uuid.UUID) float64 {
+	var card models.GiftCard
+	db.First(&card, "id = ?", id)
+	return card.Balance
+}
+
+func TestCreateOrderSplitsGiftCardAndCard(t *testing.T) {
+	db := freshDB()
+	provider := payments.NewFakeProvider()
+	router := setupGiftCardRouter(db, provider)
+	_, _, token := seedCartForPayment(t)
+	card := seedGiftCard(t, db, 20, nil)
+
+	body := map[string]interface{}{
+		"delivery_address": "1 Card St",
+		"payment_method":   "card",
+		"payment_token":    "tok_visa",
+		"gift_card_codes":  []string{card.Code},
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	if resp["gift_card_amount"] != 20.0 || resp["status"] != "confirmed" {
+		t.Errorf("expected 20 on the gift card and a confirmed order, got %v / %v", resp["gift_card_amount"], resp["status"])
+	}
+
+	var payment models.Payment
+	if err := db.Where("order_id = ?", resp["id"]).First(&payment).Error; err != nil {
+		t.Fatalf("expected payment record: %v", err)
+	}
+	if payment.Amount != 10 {
+		t.Errorf("expected the card to be held for the remaining 10, got %v", payment.Amount)
+	}
+	if got := giftCardBalance(db, card.ID); got != 0 {
+		t.Errorf("expected gift card spent down to 0, got %v", got)
+	}
+
+	var entry models.GiftCardTransaction
+	if err := db.Where("gift_card_id = ? AND type = ?", card.ID, giftcards.TypeRedeemed).First(&entry).Error; err != nil {
+		t.Fatalf("expected a redeemed ledger entry: %v", err)
+	}
+	if entry.Amount != -20 || entry.BalanceAfter != 0 || entry.OrderID == nil || entry.OrderID.String() != resp["id"] {
+		t.Errorf("unexpected ledger entry %+v", entry)
+	}
+}
+
+func TestCreateOrderPaidInFullByGiftCards(t *testing.T) {
+	db := freshDB()
+	router := setupGiftCardRouter(db, payments.NewFakeProvider())
+	_, _, token := seedCartForPayment(t)
+	first := seedGiftCard(t, db, 12, nil)
+	second := seedGiftCard(t, db, 50, nil)
+
+	body := map[string]interface{}{
+		"delivery_address": "1 Card St",
+		"payment_method":   "card",
+		"gift_card_codes":  []string{first.Code, second.Code},
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	if resp["payment_method"] != models.PaymentMethodGiftCard || resp["status"] != "confirmed" {
+		t.Errorf("expected a confirmed gift card order, got %v / %v", resp["payment_method"], resp["status"])
+	}
+
+	var count int64
+	db.Model(&models.Payment{}).Where("order_id = ?", resp["id"]).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no card payment, got %d", count)
+	}
+	if giftCardBalance(db, first.ID) != 0 || giftCardBalance(db, second.ID) != 32 {
+		t.Errorf("expected balances 0 and 32, got %v and %v", giftCardBalance(db, first.ID), giftCardBalance(db, second.ID))
+	}
+}
+
+func TestCreateOrderRejectsUnusableGiftCard(t *testing.T) {
+	db := freshDB()
+	router := setupGiftCardRouter(db, payments.NewFakeProvider())
+	user, _, token := seedCartForPayment(t)
+	card := seedGiftCard(t, db, 20, nil)
+	db.Model(&models.GiftCard{}).Where("id = ?", card.ID).UpdateColumn("expires_at", time.Now().Add(-time.Hour))
+	owner, _ := seedTestUser(db, "giftstore@test.com", "franchise_owner", nil)
+	otherStore := seedFranchise(db, "Gift Store", owner.ID)
+	storeCard := seedGiftCard(t, db, 20, &otherStore.ID)
+
+	for _, code := range []string{card.Code, storeCard.Code, "NOSUCHCARD"} {
+		body := map[string]interface{}{
+			"delivery_address": "1 Card St",
+			"payment_method":   "card",
+			"payment_token":    "tok_visa",
+			"gift_card_codes":  []string{code},
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s, got %d: %s", code, w.Code, w.Body.String())
+		}
+	}
+
+	var count int64
+	db.Model(&models.Order{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no order placed, got %d", count)
+	}
+}
+
+func TestRefundReturnsGiftCardShare(t *testing.T) {
+	db := freshDB()
+	router := setupGiftCardRouter(db, payments.NewFakeProvider())
+	_, adminToken := seedTestUser(db, "giftadmin@test.com", "admin", nil)
+	_, _, token := seedCartForPayment(t)
+	card := seedGiftCard(t, db, 20, nil)
+
+	body := map[string]interface{}{
+		"delivery_address": "1 Card St",
+		"payment_method":   "card",
+		"payment_token":    "tok_visa",
+		"gift_card_codes":  []string{card.Code},
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	orderID := parseResponse(w)["id"].(string)
+	advanceOrder(t, router, orderID, adminToken, "preparing", "ready", "out_for_delivery", "delivered")
+
+	// The 10 paid by card goes back to the card, the rest to the gift card
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", map[string]interface{}{"amount": 25}, adminToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp := parseResponse(w); resp["gift_card_amount"] != 15.0 {
+		t.Errorf("expected 15 refunded to the gift card, got %v", resp["gift_card_amount"])
+	}
+	if got := giftCardBalance(db, card.ID); got != 15 {
+		t.Errorf("expected gift card balance 15, got %v", got)
+	}
+
+	var payment models.Payment
+	db.Where("order_id = ?", orderID).First(&payment)
+	if payment.RefundedAmount != 10 {
+		t.Errorf("expected 10 refunded to the card, got %v", payment.RefundedAmount)
+	}
+}
+
+func TestCancelOrderRestoresGiftCardBalance(t *testing.T) {
+	db := freshDB()
+	router := setupGiftCardRouter(db, payments.NewFakeProvider())
+	_, adminToken := seedTestUser(db, "giftcancel@test.com", "admin", nil)
+	_, _, token := seedCartForPayment(t)
+	card := seedGiftCard(t, db, 40, nil)
+
+	body := map[string]interface{}{
+		"delivery_address": "1 Card St",
+		"gift_card_codes":  []string{card.Code},
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	orderID := parseResponse(w)["id"].(string)
+	if got := giftCardBalance(db, card.ID); got != 10 {
+		t.Fatalf("expected 10 left after spending 30, got %v", got)
+	}
+
+	advanceOrder(t, router, orderID, adminToken, "cancelled")
+	if got := giftCardBalance(db, card.ID); got != 40 {
+		t.Errorf("expected full balance of 40 restored, got %v", got)
+	}
+}
+
+func TestGiftCardBalanceShowsLedger(t *testing.T) {
+	db := freshDB()
+	router := setupGiftCardRouter(db, payments.NewFakeProvider())
+	_, _, token := seedCartForPayment(t)
+	card := seedGiftCard(t, db, 50, nil)
+
+	body := map[string]interface{}{
+		"delivery_address": "1 Card St",
+		"gift_card_codes":  []string{card.Code},
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Codes can be typed in lower case with dashes
+	code := card.Code[:4] + "-" + card.Code[4:]
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, jsonRequest("GET", "/api/gift-cards/"+code+"/balance", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	if resp["balance"] != 20.0 || resp["usable"] != true {
+		t.Errorf("expected a usable balance of 20, got %v / %v", resp["balance"], resp["usable"])
+	}
+	transactions := resp["transactions"].([]interface{})
+	if len(transactions) != 2 {
+		t.Fatalf("expected issued and redeemed entries, got %d", len(transactions))
+	}
+	if spent := transactions[1].(map[string]interface{}); spent["type"] != "redeemed" || spent["amount"] != -30.0 {
+		t.Errorf("expected a redeemed entry of -30, got %v", spent)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, jsonRequest("GET", "/api/gift-cards/UNKNOWN/balance", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown code, got %d", w.Code)
+	}
+}
+
+func TestGiftCardPortalScopesToFranchise(t *testing.T) {
+	db := freshDB()
+	router := setupGiftCardRouter(db, nil)
+	_, adminToken := seedTestUser(db, "giftissuer@test.com", "admin", nil)
+	owner, _ := seedTestUser(db, "giftowner@test.com", "franchise_owner", nil)
+	store := seedFranchise(db, "Card Store", owner.ID)
+	_, ownerToken := seedFranchiseOwnerWithToken(db, store)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/gift-cards", map[string]interface{}{"amount": 30, "code": "ADMIN-CARD-1"}, adminToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	adminCard := parseResponse(w)
+	if adminCard["code"] != "ADMINCARD1" || adminCard["balance"] != 30.0 {
+		t.Errorf("expected ADMINCARD1 with 30, got %v with %v", adminCard["code"], adminCard["balance"])
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/gift-cards", map[string]interface{}{"amount": 5, "code": "admincard1"}, adminToken))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a duplicate code, got %d", w.Code)
+	}
+
+	// A franchise card is always tied to the issuing store
+	other := uuid.New()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/franchise/gift-cards", map[string]interface{}{"amount": 15, "franchise_id": other}, ownerToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp := parseResponse(w); resp["franchise_id"] != store.ID.String() {
+		t.Errorf("expected card for the owner's store, got %v", resp["franchise_id"])
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/franchise/gift-cards", nil, ownerToken))
+	if cards := parseResponseArray(w); len(cards) != 1 {
+		t.Errorf("expected only the store's card, got %d", len(cards))
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/franchise/gift-cards/"+adminCard["id"].(string), nil, ownerToken))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another store's card, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/gift-cards/"+adminCard["id"].(string), map[string]interface{}{"is_active": false}, adminToken))
+	if w.Code != http.StatusOK || parseResponse(w)["is_active"] != false {
+		t.Errorf("expected card deactivated, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"grabbi-backend/models"
+	"grabbi-backend/pricing"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GuestCartHandler serves the cart API to visitors who have not signed in. A
+// guest cart is identified by the signed token in the X-Cart-Token header; the
+// first item added creates the cart and returns its token in the same header.
+type GuestCartHandler struct {
+	DB *gorm.DB
+}
+
+// GuestCartTokenHeader carries a guest's cart token in requests and responses.
+const GuestCartTokenHeader = "X-Cart-Token"
+
+// guestCartTTL is how long a guest cart lives after it was last changed.
+const guestCartTTL = 30 * 24 * time.Hour
+
+// errGuestCartNotFound is returned for a missing, forged or expired cart token.
+var errGuestCartNotFound = errors.New("guest cart not found")
+
+// guestCartItemResponse is a guest cart item priced at the store the cart is bound to.
+type guestCartItemResponse struct {
+	models.GuestCartItem
+	UnitPrice float64 `json:"unit_price"`
+	LineTotal float64 `json:"line_total"`
+}
+
+// cartMergeResult reports what happened to a guest cart folded into a user's.
+type cartMergeResult struct {
+	Merged   int              `json:"merged"`
+	Removed  []cartAdjustment `json:"removed"`
+	Adjusted []cartAdjustment `json:"adjusted"`
+}
+
+// findGuestCart returns the live guest cart a token names.
+func findGuestCart(db *gorm.DB, token string) (*models.GuestCart, error) {
+	cartID, err := utils.ValidateCartToken(token)
+	if err != nil {
+		return nil, errGuestCartNotFound
+	}
+	var cart models.GuestCart
+	err = db.Where("id = ? AND expires_at > ?", cartID, time.Now()).First(&cart).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errGuestCartNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// requestGuestCart returns the cart named by the request's token, writing the
+// error response and returning false when there is none.
+func (h *GuestCartHandler) requestGuestCart(c *gin.Context) (*models.GuestCart, bool) {
+	cart, err := findGuestCart(h.DB, c.GetHeader(GuestCartTokenHeader))
+	if errors.Is(err, errGuestCartNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return nil, false
+	}
+	return cart, true
+}
+
+// touchGuestCart pushes back the cart's expiry after a change.
+func (h *GuestCartHandler) touchGuestCart(cart *models.GuestCart) {
+	cart.ExpiresAt = time.Now().Add(guestCartTTL)
+	h.DB.Model(cart).Update("expires_at", cart.ExpiresAt)
+}
+
+// SweepExpiredGuestCarts deletes the guest carts that expired before now,
+// along with their items, and returns how many carts were removed.
+func SweepExpiredGuestCarts(db *gorm.DB, now time.Time) (int64, error) {
+	var removed int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&models.GuestCart{}).Select("id").Where("expires_at <= ?", now)
+		if err := tx.Where("guest_cart_id IN (?)", expired).Delete(&models.GuestCartItem{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("expires_at <= ?", now).Delete(&models.GuestCart{})
+		removed = result.RowsAffected
+		return result.Error
+	})
+	return removed, err
+}
+
+// StartGuestCartSweeper deletes expired guest carts every interval until the
+// returned stop function is called.
+func StartGuestCartSweeper(db *gorm.DB, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				n, err := SweepExpiredGuestCarts(db, now)
+				if err != nil {
+					log.Printf("Failed to sweep guest carts: %v", err)
+				} else if n > 0 {
+					log.Printf("Deleted %d expired guest carts", n)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// boundGuestCartFranchise is boundCartFranchise for a guest cart.
+func boundGuestCartFranchise(db *gorm.DB, cartID uuid.UUID) (*uuid.UUID, bool, error) {
+	var item models.GuestCartItem
+	err := db.Where("guest_cart_id = ?", cartID).Order("created_at ASC").First(&item).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return item.FranchiseID, true, nil
+}
+
+// priceGuestCartItems prices each item at the store its cart is bound to.
+func priceGuestCartItems(db *gorm.DB, items []models.GuestCartItem) ([]guestCartItemResponse, error) {
+	result := make([]guestCartItemResponse, len(items))
+	if len(items) == 0 {
+		return result, nil
+	}
+	products := make([]models.Product, len(items))
+	for i, item := range items {
+		products[i] = item.Product
+	}
+	prices, err := cartUnitPrices(db, items[0].FranchiseID, products)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		result[i] = guestCartItemResponse{
+			GuestCartItem: item,
+			UnitPrice:     prices[i],
+			LineTotal:     pricing.RoundMoney(prices[i] * float64(item.Quantity)),
+		}
+	}
+	return result, nil
+}
+
+// respondGuestCartItem reloads a guest cart item with its product and writes it priced.
+func (h *GuestCartHandler) respondGuestCartItem(c *gin.Context, item models.GuestCartItem) {
+	h.DB.Preload("Product").Preload("Product.Category").Preload("Product.Images").First(&item, item.ID)
+	priced, err := priceGuestCartItems(h.DB, []models.GuestCartItem{item})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart item"})
+		return
+	}
+	c.JSON(http.StatusOK, priced[0])
+}
+
+// GetCart returns the guest's cart, or an empty cart when they have no token yet.
+func (h *GuestCartHandler) GetCart(c *gin.Context) {
+	if c.GetHeader(GuestCartTokenHeader) == "" {
+		c.JSON(http.StatusOK, []guestCartItemResponse{})
+		return
+	}
+	cart, ok := h.requestGuestCart(c)
+	if !ok {
+		return
+	}
+
+	var items []models.GuestCartItem
+	if err := h.DB.Preload("Product").Preload("Product.Category").Preload("Product.Images").
+		Where("guest_cart_id = ?", cart.ID).Order("created_at ASC").Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+	priced, err := priceGuestCartItems(h.DB, items)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	c.Header(GuestCartTokenHeader, utils.GenerateCartToken(cart.ID))
+	c.JSON(http.StatusOK, priced)
+}
+
+// AddToCart adds an item under the same store and stock rules as a signed-in
+// cart. A request without a live cart token starts a new cart.
+func (h *GuestCartHandler) AddToCart(c *gin.Context) {
+	var req struct {
+		ProductID   uuid.UUID  `json:"product_id" binding:"required"`
+		Quantity    int        `json:"quantity" binding:"required,min=1"`
+		FranchiseID *uuid.UUID `json:"franchise_id"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	var product models.Product
+	if err := h.DB.Where("id = ?", req.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	cart, err := findGuestCart(h.DB, c.GetHeader(GuestCartTokenHeader))
+	if err != nil && !errors.Is(err, errGuestCartNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	var boundFranchise *uuid.UUID
+	hasItems := false
+	if cart != nil {
+		boundFranchise, hasItems, err = boundGuestCartFranchise(h.DB, cart.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+			return
+		}
+	}
+	franchiseID, ok := cartAddFranchise(c, h.DB, boundFranchise, hasItems, req.FranchiseID)
+	if !ok {
+		return
+	}
+	stock, ok := cartStock(c, h.DB, franchiseID, product, req.Quantity)
+	if !ok {
+		return
+	}
+
+	if cart == nil {
+		cart = &models.GuestCart{ExpiresAt: time.Now().Add(guestCartTTL)}
+		if err := h.DB.Create(cart).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cart"})
+			return
+		}
+	}
+
+	var item models.GuestCartItem
+	err = h.DB.Where("guest_cart_id = ? AND product_id = ?", cart.ID, req.ProductID).First(&item).Error
+	if err == nil {
+		newQuantity := item.Quantity + req.Quantity
+		if newQuantity > stock {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Cannot add %d more items. Only %d available (you already have %d in cart).",
+					req.Quantity, stock-item.Quantity, item.Quantity),
+			})
+			return
+		}
+		item.Quantity = newQuantity
+		h.DB.Save(&item)
+	} else {
+		item = models.GuestCartItem{
+			GuestCartID: cart.ID,
+			ProductID:   req.ProductID,
+			FranchiseID: franchiseID,
+			Quantity:    req.Quantity,
+		}
+		if err := h.DB.Create(&item).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to cart"})
+			return
+		}
+	}
+	h.touchGuestCart(cart)
+
+	c.Header(GuestCartTokenHeader, utils.GenerateCartToken(cart.ID))
+	h.respondGuestCartItem(c, item)
+}
+
+func (h *GuestCartHandler) UpdateCartItem(c *gin.Context) {
+	cart, ok := h.requestGuestCart(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Quantity int `json:"quantity" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	var item models.GuestCartItem
+	if err := h.DB.Where("id = ? AND guest_cart_id = ?", c.Param("id"), cart.ID).First(&item).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	}
+
+	var product models.Product
+	h.DB.Where("id = ?", item.ProductID).First(&product)
+	if _, ok := cartStock(c, h.DB, item.FranchiseID, product, req.Quantity); !ok {
+		return
+	}
+
+	item.Quantity = req.Quantity
+	h.DB.Save(&item)
+	h.touchGuestCart(cart)
+
+	h.respondGuestCartItem(c, item)
+}
+
+func (h *GuestCartHandler) RemoveFromCart(c *gin.Context) {
+	cart, ok := h.requestGuestCart(c)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.Where("id = ? AND guest_cart_id = ?", c.Param("id"), cart.ID).Delete(&models.GuestCartItem{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove item from cart"})
+		return
+	}
+	h.touchGuestCart(cart)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item removed from cart"})
+}
+
+func (h *GuestCartHandler) ClearCart(c *gin.Context) {
+	cart, ok := h.requestGuestCart(c)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.Where("guest_cart_id = ?", cart.ID).Delete(&models.GuestCartItem{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cart"})
+		return
+	}
+	h.touchGuestCart(cart)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cart cleared"})
+}
+
+// mergeGuestCart folds the guest cart named by token into the user's cart, as
+// mergeCartLines does, and deletes it. The user's cart keeps its store when it
+// already has items; otherwise it takes the guest cart's. A missing or expired
+// guest cart merges nothing and returns nil.
+func mergeGuestCart(db *gorm.DB, userID uuid.UUID, token string) (*cartMergeResult, error) {
+	guest, err := findGuestCart(db, token)
+	if errors.Is(err, errGuestCartNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &cartMergeResult{Removed: []cartAdjustment{}, Adjusted: []cartAdjustment{}}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var guestItems []models.GuestCartItem
+		if err := tx.Preload("Product").Where("guest_cart_id = ?", guest.ID).Order("created_at ASC").Find(&guestItems).Error; err != nil {
+			return err
+		}
+
+		franchiseID, hasItems, err := boundCartFranchise(tx, userID)
+		if err != nil {
+			return err
+		}
+		if !hasItems && len(guestItems) > 0 {
+			franchiseID = guestItems[0].FranchiseID
+		}
+
+		lines := make([]cartLine, len(guestItems))
+		for i, g := range guestItems {
+			lines[i] = cartLine{Product: g.Product, Quantity: g.Quantity}
+		}
+		if err := mergeCartLines(tx, userID, franchiseID, lines, result); err != nil {
+			return err
+		}
+
+		if err := tx.Where("guest_cart_id = ?", guest.ID).Delete(&models.GuestCartItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(guest).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// mergeGuestCartOnSignIn merges a guest cart as a user signs in. Signing in
+// never fails on account of the cart, so errors are logged and dropped.
+func mergeGuestCartOnSignIn(db *gorm.DB, userID uuid.UUID, token string) *cartMergeResult {
+	if token == "" {
+		return nil
+	}
+	result, err := mergeGuestCart(db, userID, token)
+	if err != nil {
+		log.Printf("Failed to merge guest cart for user %s: %v", userID, err)
+		return nil
+	}
+	return result
+}
+
+// cartLine is a quantity of a product to put in a cart.
+type cartLine struct {
+	Product  models.Product
+	Quantity int
+}
+
+// mergeCartLines adds lines to the user's cart at franchiseID, recording the
+// outcome in result. Each line is re-validated against the store: lines it does
+// not sell or has no stock of are dropped, a product already in the cart has
+// the quantities added, and quantities are capped at stock.
+func mergeCartLines(tx *gorm.DB, userID uuid.UUID, franchiseID *uuid.UUID, lines []cartLine, result *cartMergeResult) error {
+	for _, line := range lines {
+		var existing models.CartItem
+		found := tx.Where("user_id = ? AND product_id = ?", userID, line.Product.ID).First(&existing).Error == nil
+		quantity := line.Quantity
+		if found {
+			quantity += existing.Quantity
+		}
+
+		stock, sold, err := availableStock(tx, franchiseID, line.Product)
+		if err != nil {
+			return err
+		}
+		change := cartAdjustment{ProductID: line.Product.ID, ProductName: line.Product.ItemName, PreviousQuantity: quantity}
+		if !sold || stock <= 0 {
+			change.PreviousQuantity = line.Quantity
+			result.Removed = append(result.Removed, change)
+			continue
+		}
+		if quantity > stock {
+			quantity = stock
+			change.Quantity = stock
+			result.Adjusted = append(result.Adjusted, change)
+		}
+
+		if found {
+			if err := tx.Model(&existing).Update("quantity", quantity).Error; err != nil {
+				return err
+			}
+		} else {
+			// Clear out a soft-deleted line so the unique index allows the new one
+			tx.Unscoped().Where("user_id = ? AND product_id = ?", userID, line.Product.ID).Delete(&models.CartItem{})
+			if err := tx.Create(&models.CartItem{
+				UserID:      userID,
+				ProductID:   line.Product.ID,
+				FranchiseID: franchiseID,
+				Quantity:    quantity,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		result.Merged++
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// guestRequest creates a JSON request carrying a guest cart token.
+func guestRequest(method, url string, body interface{}, cartToken string) *http.Request {
+	req := jsonRequest(method, url, body)
+	if cartToken != "" {
+		req.Header.Set(GuestCartTokenHeader, cartToken)
+	}
+	return req
+}
+
+// addGuestItem adds a product to a guest cart and returns the cart token from the response.
+func addGuestItem(t *testing.T, router *gin.Engine, cartToken string, productID, franchiseID uuid.UUID, quantity int) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, guestRequest("POST", "/api/guest-cart", map[string]interface{}{
+		"product_id": productID, "quantity": quantity, "franchise_id": franchiseID,
+	}, cartToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 adding to the guest cart, got %d: %s", w.Code, w.Body.String())
+	}
+	token := w.Header().Get(GuestCartTokenHeader)
+	if token == "" {
+		t.Fatal("expected a cart token in the response")
+	}
+	return token
+}
+
+func TestGuestCartAddUpdateRemove(t *testing.T) {
+	db := freshDB()
+	router := setupGuestCartRouter(db)
+	owner, _ := seedTestUser(db, "guestcart-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Guest Store", owner.ID)
+	cat := seedCategory(db, "Guest Cat")
+	milk := seedProduct(db, "Milk", cat.ID, 1.50)
+	seedFranchiseProduct(db, franchise.ID, milk.ID)
+
+	token := addGuestItem(t, router, "", milk.ID, franchise.ID, 2)
+	if again := addGuestItem(t, router, token, milk.ID, franchise.ID, 1); again != token {
+		t.Errorf("expected the same cart token, got a new one")
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, guestRequest("GET", "/api/guest-cart", nil, token))
+	items := parseResponseArray(w)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item, got %d: %s", len(items), w.Body.String())
+	}
+	item := items[0].(map[string]interface{})
+	if item["quantity"] != float64(3) || item["line_total"] != 4.5 {
+		t.Errorf("expected 3 at £4.50, got %v at %v", item["quantity"], item["line_total"])
+	}
+	itemID := item["id"].(string)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, guestRequest("PUT", "/api/guest-cart/"+itemID, map[string]interface{}{"quantity": 51}, token))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for more than the store has, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, guestRequest("PUT", "/api/guest-cart/"+itemID, map[string]interface{}{"quantity": 5}, token))
+	if w.Code != http.StatusOK || parseResponse(w)["quantity"] != float64(5) {
+		t.Errorf("expected quantity 5, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, guestRequest("DELETE", "/api/guest-cart/"+itemID, nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 removing the item, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, guestRequest("GET", "/api/guest-cart", nil, token))
+	if len(parseResponseArray(w)) != 0 {
+		t.Errorf("expected an empty cart, got %s", w.Body.String())
+	}
+}
+
+func TestGuestCartRejectsForgedToken(t *testing.T) {
+	db := freshDB()
+	router := setupGuestCartRouter(db)
+	owner, _ := seedTestUser(db, "guestforge-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Forge Store", owner.ID)
+	cat := seedCategory(db, "Forge Cat")
+	bread := seedProduct(db, "Bread", cat.ID, 2.00)
+	seedFranchiseProduct(db, franchise.ID, bread.ID)
+
+	token := addGuestItem(t, router, "", bread.ID, franchise.ID, 1)
+	forged := uuid.New().String() + token[len(uuid.Nil.String()):]
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, guestRequest("GET", "/api/guest-cart", nil, forged))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a forged token, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, guestRequest("DELETE", "/api/guest-cart", nil, forged))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 clearing with a forged token, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, jsonRequest("GET", "/api/guest-cart", nil))
+	if w.Code != http.StatusOK || len(parseResponseArray(w)) != 0 {
+		t.Errorf("expected an empty cart without a token, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLoginMergesGuestCartCappedAtStock(t *testing.T) {
+	db := freshDB()
+	router := setupGuestCartRouter(db)
+	owner, _ := seedTestUser(db, "guestmerge-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Merge Store", owner.ID)
+	cat := seedCategory(db, "Merge Cat")
+	eggs := seedProduct(db, "Eggs", cat.ID, 3.00)
+	tea := seedProduct(db, "Tea", cat.ID, 4.00)
+	fp := seedFranchiseProduct(db, franchise.ID, eggs.ID)
+	seedFranchiseProduct(db, franchise.ID, tea.ID)
+	db.Model(&fp).Update("stock_quantity", 6)
+
+	user, userToken := seedTestUser(db, "guestmerge@test.com", "customer", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/cart", map[string]interface{}{
+		"product_id": eggs.ID, "quantity": 4, "franchise_id": franchise.ID,
+	}, userToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 adding to the user cart, got %d: %s", w.Code, w.Body.String())
+	}
+
+	cartToken := addGuestItem(t, router, "", eggs.ID, franchise.ID, 5)
+	addGuestItem(t, router, cartToken, tea.ID, franchise.ID, 2)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, jsonRequest("POST", "/api/auth/login", map[string]interface{}{
+		"email": user.Email, "password": "password123", "cart_token": cartToken,
+	}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 logging in, got %d: %s", w.Code, w.Body.String())
+	}
+	merge, ok := parseResponse(w)["cart_merge"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected a cart_merge report, got %s", w.Body.String())
+	}
+	if merge["merged"] != float64(2) {
+		t.Errorf("expected 2 lines merged, got %v", merge["merged"])
+	}
+	adjusted := merge["adjusted"].([]interface{})
+	if len(adjusted) != 1 || adjusted[0].(map[string]interface{})["quantity"] != float64(6) {
+		t.Errorf("expected eggs capped at 6, got %v", adjusted)
+	}
+
+	quantities := map[uuid.UUID]int{}
+	var items []models.CartItem
+	db.Where("user_id = ?", user.ID).Find(&items)
+	for _, item := range items {
+		quantities[item.ProductID] = item.Quantity
+	}
+	if quantities[eggs.ID] != 6 || quantities[tea.ID] != 2 {
+		t.Errorf("expected 6 eggs and 2 tea, got %v", quantities)
+	}
+
+	var guestCarts int64
+	db.Model(&models.GuestCart{}).Count(&guestCarts)
+	if guestCarts != 0 {
+		t.Errorf("expected the guest cart to be deleted, found %d", guestCarts)
+	}
+}
+
+func TestRegisterMergeDropsItemsTheUserStoreDoesNotSell(t *testing.T) {
+	db := freshDB()
+	router := setupGuestCartRouter(db)
+	owner, _ := seedTestUser(db, "guestreg-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Register Store", owner.ID)
+	cat := seedCategory(db, "Register Cat")
+	jam := seedProduct(db, "Jam", cat.ID, 2.50)
+	fp := seedFranchiseProduct(db, franchise.ID, jam.ID)
+
+	cartToken := addGuestItem(t, router, "", jam.ID, franchise.ID, 2)
+	db.Model(&fp).Update("is_available", false)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, jsonRequest("POST", "/api/auth/register", map[string]interface{}{
+		"email": "guestreg@test.com", "password": "password123", "cart_token": cartToken,
+	}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 registering, got %d: %s", w.Code, w.Body.String())
+	}
+	merge := parseResponse(w)["cart_merge"].(map[string]interface{})
+	removed := merge["removed"].([]interface{})
+	if merge["merged"] != float64(0) || len(removed) != 1 {
+		t.Fatalf("expected the unavailable line to be removed, got %v", merge)
+	}
+
+	var count int64
+	db.Model(&models.CartItem{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no cart items, got %d", count)
+	}
+
+	// A spent or unknown token is ignored rather than failing sign-in
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, jsonRequest("POST", "/api/auth/login", map[string]interface{}{
+		"email": "guestreg@test.com", "password": "password123", "cart_token": cartToken,
+	}))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 logging in with a spent cart token, got %d", w.Code)
+	}
+	if _, ok := parseResponse(w)["cart_merge"]; ok {
+		t.Errorf("expected no cart_merge for a spent token, got %s", w.Body.String())
+	}
+}
+
+func TestLoginMergeKeepsTheUserCartStore(t *testing.T) {
+	db := freshDB()
+	router := setupGuestCartRouter(db)
+	owner, _ := seedTestUser(db, "guestconflict-owner@test.com", "franchise_owner", nil)
+	home := seedFranchise(db, "Home Store", owner.ID)
+	other := seedFranchise(db, "Other Store", owner.ID)
+	cat := seedCategory(db, "Conflict Cat")
+	rice := seedProduct(db, "Rice", cat.ID, 1.00)
+	oil := seedProduct(db, "Oil", cat.ID, 5.00)
+	seedFranchiseProduct(db, home.ID, rice.ID)
+	seedFranchiseProduct(db, other.ID, rice.ID)
+	seedFranchiseProduct(db, other.ID, oil.ID)
+
+	user, userToken := seedTestUser(db, "guestconflict@test.com", "customer", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/cart", map[string]interface{}{
+		"product_id": rice.ID, "quantity": 1, "franchise_id": home.ID,
+	}, userToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 adding to the user cart, got %d: %s", w.Code, w.Body.String())
+	}
+
+	cartToken := addGuestItem(t, router, "", oil.ID, other.ID, 1)
+	addGuestItem(t, router, cartToken, rice.ID, other.ID, 2)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, jsonRequest("POST", "/api/auth/login", map[string]interface{}{
+		"email": user.Email, "password": "password123", "cart_token": cartToken,
+	}))
+	merge := parseResponse(w)["cart_merge"].(map[string]interface{})
+	removed := merge["removed"].([]interface{})
+	if merge["merged"] != float64(1) || len(removed) != 1 || removed[0].(map[string]interface{})["product_name"] != "Oil" {
+		t.Fatalf("expected rice merged and oil removed, got %v", merge)
+	}
+
+	var items []models.CartItem
+	db.Where("user_id = ?", user.ID).Find(&items)
+	if len(items) != 1 || items[0].Quantity != 3 || items[0].FranchiseID == nil || *items[0].FranchiseID != home.ID {
+		t.Errorf("expected 3 rice at the home store, got %+v", items)
+	}
+}
+
+func TestSweepExpiredGuestCartsDeletesOnlyExpiredCarts(t *testing.T) {
+	db := freshDB()
+	cat := seedCategory(db, "Sweep Cat")
+	prod := seedProduct(db, "Sweep Product", cat.ID, 1.00)
+	now := time.Now()
+
+	expired := models.GuestCart{ExpiresAt: now.Add(-time.Hour)}
+	live := models.GuestCart{ExpiresAt: now.Add(time.Hour)}
+	for _, cart := range []*models.GuestCart{&expired, &live} {
+		db.Create(cart)
+		db.Create(&models.GuestCartItem{GuestCartID: cart.ID, ProductID: prod.ID, Quantity: 1})
+	}
+
+	n, err := SweepExpiredGuestCarts(db, now)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 cart swept, got %d, %v", n, err)
+	}
+	var carts, items int64
+	db.Model(&models.GuestCart{}).Count(&carts)
+	db.Model(&models.GuestCartItem{}).Where("guest_cart_id = ?", expired.ID).Count(&items)
+	if carts != 1 || items != 0 {
+		t.Errorf("expected only the live cart and none of the expired items to remain, got %d carts and %d items", carts, items)
+	}
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"grabbi-backend/loyalty"
+	"grabbi-backend/models"
+	"grabbi-backend/pricing"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LoyaltyHandler manages the loyalty programme: admins configure its tiers and
+// bonus campaigns, and customers see where they stand in it.
+type LoyaltyHandler struct {
+	DB *gorm.DB
+}
+
+// GetLoyaltyStatus returns the caller's points, tier and progress towards the
+// next tier.
+func (h *LoyaltyHandler) GetLoyaltyStatus(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var user models.User
+	if err := h.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	tiers, err := loyalty.Tiers(h.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty tiers"})
+		return
+	}
+	spend, err := loyalty.RollingSpend(h.DB, user.ID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty status"})
+		return
+	}
+	tier, next := loyalty.TierFor(tiers, spend)
+
+	resp := gin.H{
+		"loyalty_points":       user.LoyaltyPoints,
+		"rolling_spend":        pricing.RoundMoney(spend),
+		"tier":                 tier,
+		"multiplier":           loyalty.Earning{Tier: tier}.Multiplier(),
+		"points_expire_months": loyalty.PointsExpiryMonths(),
+	}
+	if next != nil {
+		resp["next_tier"] = gin.H{
+			"name":         next.Name,
+			"min_spend":    next.MinSpend,
+			"spend_needed": pricing.RoundMoney(next.MinSpend - spend),
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// loyaltyTierRequest is the body for creating or updating a tier. On update
+// only the fields given are changed.
+type loyaltyTierRequest struct {
+	Name       *string  `json:"name"`
+	MinSpend   *float64 `json:"min_spend"`
+	Multiplier *float64 `json:"multiplier"`
+}
+
+// applyLoyaltyTierRequest copies the given fields onto tier and checks them.
+func applyLoyaltyTierRequest(tier *models.LoyaltyTier, req loyaltyTierRequest) error {
+	if req.Name != nil {
+		tier.Name = *req.Name
+	}
+	if req.MinSpend != nil {
+		tier.MinSpend = *req.MinSpend
+	}
+	if req.Multiplier != nil {
+		tier.Multiplier = *req.Multiplier
+	}
+
+	if tier.Name == "" {
+		return errors.New("name is required")
+	}
+	if tier.MinSpend < 0 {
+		return errors.New("min_spend cannot be negative")
+	}
+	if tier.Multiplier <= 0 {
+		return errors.New("multiplier must be greater than 0")
+	}
+	return nil
+}
+
+// loyaltyTierTaken reports whether another tier already has the name.
+func loyaltyTierTaken(db *gorm.DB, name string, exceptID uuid.UUID) bool {
+	var count int64
+	db.Model(&models.LoyaltyTier{}).Where("name = ? AND id <> ?", name, exceptID).Count(&count)
+	return count > 0
+}
+
+func (h *LoyaltyHandler) ListTiers(c *gin.Context) {
+	tiers, err := loyalty.Tiers(h.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty tiers"})
+		return
+	}
+	c.JSON(http.StatusOK, tiers)
+}
+
+func (h *LoyaltyHandler) CreateTier(c *gin.Context) {
+	var req loyaltyTierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	tier := models.LoyaltyTier{ID: uuid.New(), Multiplier: 1}
+	if err := applyLoyaltyTierRequest(&tier, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if loyaltyTierTaken(h.DB, tier.Name, tier.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A tier with this name already exists"})
+		return
+	}
+
+	if err := h.DB.Create(&tier).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create loyalty tier"})
+		return
+	}
+	c.JSON(http.StatusCreated, tier)
+}
+
+func (h *LoyaltyHandler) UpdateTier(c *gin.Context) {
+	var tier models.LoyaltyTier
+	if err := h.DB.Where("id = ?", c.Param("id")).First(&tier).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loyalty tier not found"})
+		return
+	}
+
+	var req loyaltyTierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	if err := applyLoyaltyTierRequest(&tier, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if loyaltyTierTaken(h.DB, tier.Name, tier.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A tier with this name already exists"})
+		return
+	}
+
+	if err := h.DB.Save(&tier).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loyalty tier"})
+		return
+	}
+	c.JSON(http.StatusOK, tier)
+}
+
+func (h *LoyaltyHandler) DeleteTier(c *gin.Context) {
+	result := h.DB.Where("id = ?", c.Param("id")).Delete(&models.LoyaltyTier{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete loyalty tier"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loyalty tier not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Loyalty tier deleted"})
+}
+
+// loyaltyCampaignRequest is the body for creating or updating a bonus points
+// campaign. On update only the fields given are changed.
+type loyaltyCampaignRequest struct {
+	Name        *string    `json:"name"`
+	ProductID   *uuid.UUID `json:"product_id"`
+	BonusPoints *int       `json:"bonus_points"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	IsActive    *bool      `json:"is_active"`
+}
+
+// applyLoyaltyCampaignRequest copies the given fields onto campaign and checks
+// them, including that the product exists.
+func applyLoyaltyCampaignRequest(db *gorm.DB, campaign *models.LoyaltyCampaign, req loyaltyCampaignRequest) error {
+	if req.Name != nil {
+		campaign.Name = *req.Name
+	}
+	if req.ProductID != nil {
+		campaign.ProductID = *req.ProductID
+	}
+	if req.BonusPoints != nil {
+		campaign.BonusPoints = *req.BonusPoints
+	}
+	if req.StartsAt != nil {
+		campaign.StartsAt = req.StartsAt
+	}
+	if req.EndsAt != nil {
+		campaign.EndsAt = req.EndsAt
+	}
+	if req.IsActive != nil {
+		campaign.IsActive = *req.IsActive
+	}
+
+	if campaign.Name == "" {
+		return errors.New("name is required")
+	}
+	if campaign.BonusPoints < 1 {
+		return errors.New("bonus_points must be at least 1")
+	}
+	if campaign.StartsAt != nil && campaign.EndsAt != nil && !campaign.EndsAt.After(*campaign.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	var product models.Product
+	if err := db.Select("id").Where("id = ?", campaign.ProductID).First(&product).Error; err != nil {
+		return errors.New("Product not found")
+	}
+	return nil
+}
+
+func (h *LoyaltyHandler) ListCampaigns(c *gin.Context) {
+	var campaigns []models.LoyaltyCampaign
+	if err := h.DB.Order("created_at DESC").Find(&campaigns).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty campaigns"})
+		return
+	}
+	c.JSON(http.StatusOK, campaigns)
+}
+
+func (h *LoyaltyHandler) CreateCampaign(c *gin.Context) {
+	var req loyaltyCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	campaign := models.LoyaltyCampaign{IsActive: true}
+	if err := applyLoyaltyCampaignRequest(h.DB, &campaign, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.DB.Create(&campaign).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create loyalty campaign"})
+		return
+	}
+	c.JSON(http.StatusCreated, campaign)
+}
+
+func (h *LoyaltyHandler) UpdateCampaign(c *gin.Context) {
+	var campaign models.LoyaltyCampaign
+	if err := h.DB.Where("id = ?", c.Param("id")).First(&campaign).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loyalty campaign not found"})
+		return
+	}
+
+	var req loyaltyCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	if err := applyLoyaltyCampaignRequest(h.DB, &campaign, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.DB.Save(&campaign).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update loyalty campaign"})
+		return
+	}
+	c.JSON(http.StatusOK, campaign)
+}
+
+func (h *LoyaltyHandler) DeleteCampaign(c *gin.Context) {
+	var campaign models.LoyaltyCampaign
+	if err := h.DB.Where("id = ?", c.Param("id")).First(&campaign).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loyalty campaign not found"})
+		return
+	}
+
+	if err := h.DB.Delete(&campaign).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete loyalty campaign"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Loyalty campaign deleted"})
+}
+
+// ReconcilePoints compares every user's points balance with the sum of their
+// loyalty history and resets the balances that differ, or only reports them
+// with ?dry_run=true.
+func (h *LoyaltyHandler) ReconcilePoints(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+
+	found, err := loyalty.Reconcile(h.DB, dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile loyalty points"})
+		return
+	}
+	if found == nil {
+		found = []loyalty.Discrepancy{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dry_run":       dryRun,
+		"discrepancies": found,
+	})
+}
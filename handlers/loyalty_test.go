@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grabbi-backend/loyalty"
+	"grabbi-backend/models"
+)
+
+func TestCreateOrderRedeemsLoyaltyPoints(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _, token := seedCartForPayment(t)
+	db.Model(&user).UpdateColumn("loyalty_points", 2000)
+
+	// 3 x 10.00 can take at most half, 15.00 or 1500 points
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{
+		"payment_method":   "cash",
+		"delivery_address": "1 Points St", "points_to_redeem": 1800,
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	resp := parseResponse(w)
+	if resp["points_redeemed"] != 1500.0 || resp["points_discount"] != 15.0 {
+		t.Errorf("expected 1500 points worth 15.00, got %v worth %v", resp["points_redeemed"], resp["points_discount"])
+	}
+	if resp["total"] != 15.0 || resp["points_earned"] != 15.0 {
+		t.Errorf("expected total 15.00 earning 15 points, got %v and %v", resp["total"], resp["points_earned"])
+	}
+
+	var reloaded models.User
+	db.First(&reloaded, "id = ?", user.ID)
+	if reloaded.LoyaltyPoints != 2000-1500+15 {
+		t.Errorf("expected %d points left, got %d", 2000-1500+15, reloaded.LoyaltyPoints)
+	}
+	var redeemed int64
+	db.Model(&models.LoyaltyHistory{}).Where("user_id = ? AND type = ? AND points = ?", user.ID, loyalty.HistoryRedeemed, -1500).Count(&redeemed)
+	if redeemed != 1 {
+		t.Errorf("expected 1 redeemed history row, got %d", redeemed)
+	}
+}
+
+func TestCreateOrderRejectsPointsOverBalance(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _, token := seedCartForPayment(t)
+	db.Model(&user).UpdateColumn("loyalty_points", 150)
+
+	for _, points := range []int{50, 200} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{
+			"payment_method":   "cash",
+			"delivery_address": "1 Points St", "points_to_redeem": points,
+		}, token))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 redeeming %d points, got %d: %s", points, w.Code, w.Body.String())
+		}
+	}
+
+	var orders int64
+	db.Model(&models.Order{}).Where("user_id = ?", user.ID).Count(&orders)
+	if orders != 0 {
+		t.Errorf("expected no order to be placed, got %d", orders)
+	}
+}
+
+func TestCancelOrderRestoresRedeemedPoints(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _, token := seedCartForPayment(t)
+	_, adminToken := seedTestUser(db, "pointsadmin@test.com", "admin", nil)
+	db.Model(&user).UpdateColumn("loyalty_points", 500)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{
+		"payment_method":   "cash",
+		"delivery_address": "1 Points St", "points_to_redeem": 500,
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	orderID := parseResponse(w)["id"].(string)
+
+	var before models.User
+	db.First(&before, "id = ?", user.ID)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+orderID+"/status", map[string]string{"status": "cancelled"}, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The 500 redeemed come back and the 25 earned on the order go
+	var after models.User
+	db.First(&after, "id = ?", user.ID)
+	if before.LoyaltyPoints != 25 || after.LoyaltyPoints != 500 {
+		t.Errorf("expected 25 points before cancelling and 500 after, got %d and %d", before.LoyaltyPoints, after.LoyaltyPoints)
+	}
+	var restored int64
+	db.Model(&models.LoyaltyHistory{}).Where("order_id = ? AND type = ?", orderID, loyalty.HistoryRestored).Count(&restored)
+	if restored != 1 {
+		t.Errorf("expected 1 restored history row, got %d", restored)
+	}
+}
+
+func TestQuoteShowsPointsDiscount(t *testing.T) {
+	db := freshDB()
+	router := setupCheckoutRouter(db)
+	user, _, token := seedCartForPayment(t)
+	db.Model(&user).UpdateColumn("loyalty_points", 300)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/checkout/quote", map[string]interface{}{"points_to_redeem": 300}, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	if resp["points_discount"] != 3.0 || resp["total"] != 27.0 {
+		t.Errorf("expected 3.00 off for a total of 27.00, got %v and %v", resp["points_discount"], resp["total"])
+	}
+}
+
+func TestCreateOrderEarnsTierMultiplierAndCampaignBonus(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, prod, token := seedCartForPayment(t)
+	db.Create(&models.LoyaltyTier{Name: "Gold", MinSpend: 0, Multiplier: 2})
+	db.Create(&models.LoyaltyCampaign{Name: "Triple bonus", ProductID: prod.ID, BonusPoints: 5, IsActive: true})
+	ended := time.Now().Add(-time.Hour)
+	db.Create(&models.LoyaltyCampaign{Name: "Over", ProductID: prod.ID, BonusPoints: 100, IsActive: true, EndsAt: &ended})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{"payment_method": "cash", "delivery_address": "1 Tier St"}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// 30.00 at x2 is 60, plus 5 bonus on each of 3 units; the ended campaign adds nothing
+	resp := parseResponse(w)
+	if resp["points_earned"] != 75.0 {
+		t.Errorf("expected 75 points earned, got %v", resp["points_earned"])
+	}
+	var earned models.LoyaltyHistory
+	if err := db.Where("user_id = ? AND type = ?", user.ID, loyalty.HistoryEarned).First(&earned).Error; err != nil {
+		t.Fatalf("expected an earned history row: %v", err)
+	}
+	if earned.Points != 75 || earned.OrderID == nil {
+		t.Errorf("expected 75 points earned on the order, got %d (order %v)", earned.Points, earned.OrderID)
+	}
+}
+
+func TestGetLoyaltyStatusShowsTierProgress(t *testing.T) {
+	db := freshDB()
+	router := setupLoyaltyRouter(db)
+	user, token := seedTestUser(db, "tiered@test.com", "customer", nil)
+	db.Model(&user).UpdateColumn("loyalty_points", 120)
+	db.Create(&models.LoyaltyTier{Name: "Bronze", MinSpend: 0, Multiplier: 1})
+	db.Create(&models.LoyaltyTier{Name: "Silver", MinSpend: 50, Multiplier: 1.5})
+	db.Create(&models.Order{UserID: user.ID, OrderNumber: "ORD-TIER-1", Status: models.OrderStatusDelivered, Total: 20})
+	db.Create(&models.Order{UserID: user.ID, OrderNumber: "ORD-TIER-2", Status: models.OrderStatusCancelled, Total: 100})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/auth/loyalty", nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	if resp["loyalty_points"] != 120.0 || resp["rolling_spend"] != 20.0 || resp["multiplier"] != 1.0 {
+		t.Errorf("unexpected status: %v", resp)
+	}
+	if tier, _ := resp["tier"].(map[string]interface{}); tier == nil || tier["name"] != "Bronze" {
+		t.Errorf("expected Bronze tier, got %v", resp["tier"])
+	}
+	next, _ := resp["next_tier"].(map[string]interface{})
+	if next == nil || next["name"] != "Silver" || next["spend_needed"] != 30.0 {
+		t.Errorf("expected 30.00 more to reach Silver, got %v", resp["next_tier"])
+	}
+}
+
+func TestAdminManagesLoyaltyTiersAndCampaigns(t *testing.T) {
+	db := freshDB()
+	router := setupLoyaltyRouter(db)
+	_, adminToken := seedTestUser(db, "loyaltyadmin@test.com", "admin", nil)
+	_, customerToken := seedTestUser(db, "loyaltycust@test.com", "customer", nil)
+	cat := seedCategory(db, "Bonus")
+	prod := seedProduct(db, "Bonus Bread", cat.ID, 2.00)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/loyalty/tiers", map[string]interface{}{"name": "Gold", "min_spend": 500, "multiplier": 1.5}, customerToken))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a customer, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/loyalty/tiers", map[string]interface{}{"name": "Gold", "min_spend": 500, "multiplier": 1.5}, adminToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	tierID := parseResponse(w)["id"].(string)
+
+	for name, body := range map[string]map[string]interface{}{
+		"duplicate name":     {"name": "Gold", "min_spend": 100},
+		"zero multiplier":    {"name": "Zero", "multiplier": 0},
+		"negative min spend": {"name": "Neg", "min_spend": -1},
+		"missing name":       {"min_spend": 10},
+	} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("POST", "/api/admin/loyalty/tiers", body, adminToken))
+		if w.Code != http.StatusBadRequest && w.Code != http.StatusConflict {
+			t.Errorf("%s: expected rejection, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/loyalty/tiers/"+tierID, map[string]interface{}{"multiplier": 2}, adminToken))
+	if w.Code != http.StatusOK || parseResponse(w)["multiplier"] != 2.0 {
+		t.Errorf("expected multiplier updated to 2, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/loyalty/campaigns", map[string]interface{}{
+		"name": "Bread week", "product_id": prod.ID, "bonus_points": 10,
+		"starts_at": "2026-01-08T00:00:00Z", "ends_at": "2026-01-01T00:00:00Z",
+	}, adminToken))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for ends_at before starts_at, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/loyalty/campaigns", map[string]interface{}{
+		"name": "Bread week", "product_id": prod.ID, "bonus_points": 10,
+	}, adminToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	campaignID := parseResponse(w)["id"].(string)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("DELETE", "/api/admin/loyalty/campaigns/"+campaignID, nil, adminToken))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 deleting the campaign, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/admin/loyalty/campaigns", nil, adminToken))
+	if campaigns := parseResponseArray(w); len(campaigns) != 0 {
+		t.Errorf("expected no campaigns after delete, got %d", len(campaigns))
+	}
+}
+
+func TestCancelOrderReversesEarnedPoints(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _, token := seedCartForPayment(t)
+	_, adminToken := seedTestUser(db, "reverseadmin@test.com", "admin", nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{"payment_method": "cash", "delivery_address": "1 Earn St"}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	orderID := parseResponse(w)["id"].(string)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+orderID+"/status", map[string]string{"status": "cancelled"}, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var reloaded models.User
+	db.First(&reloaded, "id = ?", user.ID)
+	if reloaded.LoyaltyPoints != 0 {
+		t.Errorf("expected the 30 earned points reversed, got %d", reloaded.LoyaltyPoints)
+	}
+	var rows []models.LoyaltyHistory
+	db.Where("order_id = ?", orderID).Order("created_at ASC").Find(&rows)
+	if len(rows) != 2 || rows[0].Type != loyalty.HistoryEarned || rows[1].Type != loyalty.HistoryReversed || rows[1].Points != -30 {
+		t.Errorf("expected an earned row and a -30 reversed row, got %+v", rows)
+	}
+}
+
+func TestAdminReconcilesLoyaltyPoints(t *testing.T) {
+	db := freshDB()
+	router := setupLoyaltyRouter(db)
+	_, adminToken := seedTestUser(db, "reconcileadmin@test.com", "admin", nil)
+	user, _ := seedTestUser(db, "drifted@test.com", "customer", nil)
+	db.Model(&user).UpdateColumn("loyalty_points", 90)
+	loyalty.Post(db, loyalty.Entry{UserID: user.ID, Points: 60, Type: loyalty.HistoryEarned})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/loyalty/reconcile?dry_run=true", nil, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	found, _ := parseResponse(w)["discrepancies"].([]interface{})
+	if len(found) != 1 {
+		t.Fatalf("expected 1 discrepancy, got %v", found)
+	}
+	if d := found[0].(map[string]interface{}); d["balance"] != 150.0 || d["ledger"] != 60.0 {
+		t.Errorf("expected balance 150 against ledger 60, got %v", d)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/loyalty/reconcile", nil, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var reloaded models.User
+	db.First(&reloaded, "id = ?", user.ID)
+	if reloaded.LoyaltyPoints != 60 {
+		t.Errorf("expected the balance reset to the ledger's 60, got %d", reloaded.LoyaltyPoints)
+	}
+}
@@ -1,13 +1,23 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"net/http"
+	"strings"
 	"time"
 
 	"grabbi-backend/firebase"
+	"grabbi-backend/giftcards"
+	"grabbi-backend/inventory"
+	"grabbi-backend/loyalty"
 	"grabbi-backend/models"
+	"grabbi-backend/payments"
+	"grabbi-backend/pricing"
 	"grabbi-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -17,8 +27,33 @@ import (
 )
 
 type OrderHandler struct {
-	DB      *gorm.DB
-	Storage firebase.StorageClient
+	DB       *gorm.DB
+	Storage  firebase.StorageClient
+	Payments payments.PaymentProvider
+}
+
+// orderRequest is how an order is to be placed, from the checkout or from a
+// subscription's saved settings.
+type orderRequest struct {
+	FulfilmentType  string   `json:"fulfilment_type"`
+	DeliveryAddress string   `json:"delivery_address"`
+	AddressID       string   `json:"address_id"`
+	PaymentMethod   string   `json:"payment_method"`
+	PaymentToken    string   `json:"payment_token"`
+	FranchiseID     string   `json:"franchise_id"`
+	CustomerLat     *float64 `json:"customer_lat"`
+	CustomerLng     *float64 `json:"customer_lng"`
+	SlotID          string   `json:"slot_id"`
+	CouponCode      string   `json:"coupon_code"`
+	PointsToRedeem  int      `json:"points_to_redeem"`
+	GiftCardCodes   []string `json:"gift_card_codes"`
+
+	// What to do with each product if the store runs out while picking
+	Substitutions []substitutionRequest `json:"substitutions" binding:"omitempty,dive"`
+
+	// Set when a subscription places the order
+	SubscriptionID     *uuid.UUID `json:"-"`
+	SavedPaymentMethod string     `json:"-"`
 }
 
 func (h *OrderHandler) CreateOrder(c *gin.Context) {
@@ -28,69 +63,103 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		DeliveryAddress string   `json:"delivery_address" binding:"required"`
-		PaymentMethod   string   `json:"payment_method"`
-		FranchiseID     string   `json:"franchise_id"`
-		CustomerLat     *float64 `json:"customer_lat"`
-		CustomerLng     *float64 `json:"customer_lng"`
-	}
-
+	var req orderRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
 		return
 	}
 
-	// Determine franchise
-	var franchiseID *uuid.UUID
-	var franchise *models.Franchise
+	actorID, actorRole := statusActor(c)
+	order, err := h.placeOrder(userID.(uuid.UUID), req, nil, true, actorID, actorRole)
+	if err != nil {
+		respondCheckoutError(c, err, "Failed to create order")
+		return
+	}
+
+	c.JSON(http.StatusCreated, order)
+}
+
+// placeOrder prices, stocks and pays for an order. The basket is the user's
+// cart when fromCart is set: stock reserved when checkout began is used and the
+// cart is emptied. Otherwise basket is ordered and the cart is left alone.
+// Failures are checkoutErrors carrying the response to send.
+func (h *OrderHandler) placeOrder(userID uuid.UUID, req orderRequest, basket []models.CartItem, fromCart bool, actorID *uuid.UUID, actorRole string) (*models.Order, error) {
+	fulfilment, err := parseFulfilment(req.FulfilmentType)
+	if err != nil {
+		return nil, checkoutFailure(err, "Failed to create order")
+	}
+	if err := checkPaymentMethod(req.PaymentMethod); err != nil {
+		return nil, err
+	}
 
-	if req.FranchiseID != "" {
-		fID, err := uuid.Parse(req.FranchiseID)
+	// A saved address supplies the delivery address and the coordinates that
+	// choose the store. Without an address the customer's default is used.
+	var address *models.UserAddress
+	if fulfilment == models.FulfilmentDelivery {
+		address, err = orderAddress(h.DB, userID, req.AddressID, req.DeliveryAddress == "")
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid franchise_id"})
-			return
+			return nil, checkoutFailure(err, "Failed to create order")
 		}
-		var f models.Franchise
-		if err := h.DB.Where("id = ? AND is_active = ?", fID, true).First(&f).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Franchise not found"})
-			return
+		if address != nil {
+			req.DeliveryAddress = address.String()
+			req.CustomerLat = &address.Latitude
+			req.CustomerLng = &address.Longitude
 		}
-		franchiseID = &fID
-		franchise = &f
-	} else if req.CustomerLat != nil && req.CustomerLng != nil {
-		// Find nearest franchise
-		var franchises []models.Franchise
-		h.DB.Where("is_active = ?", true).Find(&franchises)
-
-		var nearest *models.Franchise
-		var nearestDist float64 = -1
-		for i := range franchises {
-			dist := utils.Haversine(*req.CustomerLat, *req.CustomerLng, franchises[i].Latitude, franchises[i].Longitude)
-			if dist <= franchises[i].DeliveryRadius && (nearestDist < 0 || dist < nearestDist) {
-				nearest = &franchises[i]
-				nearestDist = dist
-			}
+	}
+	if fulfilment == models.FulfilmentDelivery && req.DeliveryAddress == "" {
+		return nil, &checkoutError{http.StatusBadRequest, "delivery_address is required for delivery orders"}
+	}
+
+	// A cart bound to a store is checked out at that store unless told otherwise
+	franchiseRef := req.FranchiseID
+	if fromCart {
+		franchiseRef, err = cartCheckoutFranchise(h.DB, userID, req.FranchiseID, req.CustomerLat, req.CustomerLng)
+		if err != nil {
+			return nil, &checkoutError{http.StatusInternalServerError, "Failed to fetch cart"}
 		}
+	}
 
-		if nearest == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "No franchise delivers to your location"})
-			return
+	// Collection orders are picked up in store, so the store must be chosen explicitly
+	if fulfilment == models.FulfilmentCollection && franchiseRef == "" {
+		return nil, &checkoutError{http.StatusBadRequest, "franchise_id is required for collection orders"}
+	}
+
+	// Determine franchise
+	franchise, err := resolveOrderFranchise(h.DB, franchiseRef, req.CustomerLat, req.CustomerLng)
+	if err != nil {
+		return nil, checkoutFailure(err, "Failed to create order")
+	}
+	var franchiseID *uuid.UUID
+	if franchise != nil {
+		franchiseID = &franchise.ID
+	}
+
+	// Orders without a slot are delivered as soon as possible
+	var slotID *uuid.UUID
+	if req.SlotID != "" {
+		sID, err := uuid.Parse(req.SlotID)
+		if err != nil {
+			return nil, &checkoutError{http.StatusBadRequest, "Invalid slot_id"}
+		}
+		if franchiseID == nil {
+			return nil, &checkoutError{http.StatusBadRequest, "A franchise is required to book a delivery slot"}
 		}
-		franchiseID = &nearest.ID
-		franchise = nearest
+		slotID = &sID
 	}
 
 	// Get cart items with product data
-	var cartItems []models.CartItem
-	if err := h.DB.Preload("Product").Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
-		return
+	cartItems := basket
+	if fromCart {
+		if err := h.DB.Preload("Product").Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
+			return nil, &checkoutError{http.StatusInternalServerError, "Failed to fetch cart"}
+		}
 	}
 
 	if len(cartItems) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
-		return
+		return nil, &checkoutError{http.StatusBadRequest, "Cart is empty"}
+	}
+	if err := checkCartFranchise(cartItems, franchise); err != nil {
+		return nil, checkoutFailure(err, "Failed to create order")
 	}
 
 	// Batch query all primary images for products in the cart
@@ -107,93 +176,155 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		primaryImageMap[img.ProductID] = img.ImageURL
 	}
 
-	// Calculate totals
-	var subtotal float64
-	var orderItems []models.OrderItem
+	var coupon *models.Coupon
+	if req.CouponCode != "" {
+		coupon, err = redeemableCoupon(h.DB, req.CouponCode, userID, franchise)
+		if err != nil {
+			return nil, checkoutFailure(err, "Failed to apply coupon")
+		}
+	}
 
-	for _, item := range cartItems {
-		sourceImageURL := primaryImageMap[item.ProductID]
+	if err := checkPointsToRedeem(h.DB, userID, req.PointsToRedeem); err != nil {
+		return nil, checkoutFailure(err, "Failed to create order")
+	}
+
+	giftCards, err := usableGiftCards(h.DB, req.GiftCardCodes, franchiseID)
+	if err != nil {
+		return nil, checkoutFailure(err, "Failed to apply gift card")
+	}
 
-		currentPrice := item.Product.GetCurrentPrice()
+	lines := cartPricingLines(cartItems)
+	earning, err := loyaltyEarning(h.DB, userID, lines)
+	if err != nil {
+		return nil, &checkoutError{http.StatusInternalServerError, "Failed to price order"}
+	}
 
-		// If franchise specified, check for price overrides
-		if franchiseID != nil {
-			var fp models.FranchiseProduct
-			if err := h.DB.Where("franchise_id = ? AND product_id = ?", franchiseID, item.ProductID).First(&fp).Error; err == nil {
-				if fp.RetailPriceOverride != nil {
-					currentPrice = *fp.RetailPriceOverride
-				}
-				// Check franchise promo override
-				if fp.PromotionPriceOverride != nil {
-					currentPrice = *fp.PromotionPriceOverride
-				}
-			}
+	// Price the basket exactly as the checkout quote does
+	quote, err := pricing.QuoteLines(h.DB, lines, pricing.Options{
+		Franchise: franchise, Fulfilment: fulfilment, Coupon: coupon, RedeemPoints: req.PointsToRedeem, Earning: earning,
+	})
+	if err != nil {
+		return nil, &checkoutError{http.StatusInternalServerError, "Failed to price order"}
+	}
+	if coupon != nil {
+		if err := checkCouponBasket(coupon, quote); err != nil {
+			return nil, checkoutFailure(err, "Failed to apply coupon")
 		}
+	}
 
-		itemTotal := currentPrice * float64(item.Quantity)
-		subtotal += itemTotal
-
+	var orderItems []models.OrderItem
+	for _, line := range quote.Lines {
 		orderItems = append(orderItems, models.OrderItem{
 			ID:          uuid.Nil,
-			ProductID:   item.ProductID,
-			ImageURL:    sourceImageURL, // Will be updated after order is created
-			ProductName: item.Product.ItemName,
-			ProductSKU:  item.Product.SKU,
-			Quantity:    item.Quantity,
-			Price:       currentPrice,
+			ProductID:   line.ProductID,
+			ImageURL:    primaryImageMap[line.ProductID], // Will be updated after order is created
+			ProductName: line.ProductName,
+			ProductSKU:  line.ProductSKU,
+			Quantity:    line.Quantity,
+			Price:       line.UnitPrice,
+			Discount:    line.Discount,
 		})
 	}
-
-	// Calculate delivery fee from franchise settings or defaults
-	deliveryFee := 0.0
-	freeThreshold := 20.0
-	if franchise != nil {
-		if subtotal < franchise.FreeDeliveryMin {
-			deliveryFee = franchise.DeliveryFee
-		}
-		freeThreshold = franchise.FreeDeliveryMin
-	} else {
-		if subtotal < freeThreshold {
-			deliveryFee = 3.75
-		}
+	if err := applySubstitutionPreferences(h.DB, orderItems, req.Substitutions); err != nil {
+		return nil, checkoutFailure(err, "Failed to create order")
 	}
-	_ = freeThreshold
-
-	total := subtotal + deliveryFee
-	pointsEarned := int(subtotal)
+	pointsEarned := quote.PointsEarned
 
 	// Create order
 	order := models.Order{
 		ID:              uuid.New(),
-		UserID:          userID.(uuid.UUID),
+		UserID:          userID,
 		FranchiseID:     franchiseID,
 		Status:          models.OrderStatusPending,
-		Subtotal:        subtotal,
-		DeliveryFee:     deliveryFee,
-		Total:           total,
+		Subtotal:        quote.Subtotal,
+		Discount:        quote.Discount,
+		DeliveryFee:     quote.DeliveryFee,
+		Total:           quote.Total,
+		FulfilmentType:  fulfilment,
 		DeliveryAddress: req.DeliveryAddress,
 		PaymentMethod:   req.PaymentMethod,
 		PointsEarned:    pointsEarned,
+		PointsRedeemed:  quote.PointsRedeemed,
+		PointsDiscount:  quote.PointsDiscount,
 		CustomerLat:     req.CustomerLat,
 		CustomerLng:     req.CustomerLng,
+		DeliverySlotID:  slotID,
+		SubscriptionID:  req.SubscriptionID,
+	}
+	if address != nil {
+		order.AddressID = &address.ID
+		order.DeliveryNotes = address.DeliveryInstructions
+	}
+	if coupon != nil {
+		order.CouponID = &coupon.ID
+		order.CouponCode = coupon.Code
+	}
+	// Gift cards pay first; the card or cash covers whatever they leave
+	giftCardSpends, giftCardAmount := giftcards.Plan(giftCards, order.Total)
+	order.GiftCardAmount = giftCardAmount
+	if len(giftCardSpends) > 0 && order.AmountDue() <= 0 {
+		order.PaymentMethod = models.PaymentMethodGiftCard
+		order.Status = models.OrderStatusConfirmed
+	}
+	if order.AmountDue() > 0 && order.PaymentMethod == "" {
+		return nil, &checkoutError{http.StatusBadRequest, "payment_method is required"}
+	}
+	if h.Payments != nil && order.PaymentMethod == models.PaymentMethodCard && req.PaymentToken == "" && req.SavedPaymentMethod == "" {
+		return nil, &checkoutError{http.StatusBadRequest, "payment_token is required for card payments"}
+	}
+	if fulfilment == models.FulfilmentCollection {
+		code, err := generatePickupCode()
+		if err != nil {
+			return nil, &checkoutError{http.StatusInternalServerError, "Failed to generate pickup code"}
+		}
+		order.PickupCode = code
 	}
 
 	// Start transaction
 	tx := h.DB.Begin()
 
+	if slotID != nil {
+		if err := reserveDeliverySlot(tx, *slotID, *franchiseID); err != nil {
+			tx.Rollback()
+			if errors.Is(err, errDeliverySlotUnavailable) {
+				return nil, &checkoutError{http.StatusConflict, "Delivery slot is full or no longer available"}
+			}
+			return nil, &checkoutError{http.StatusInternalServerError, "Failed to reserve delivery slot"}
+		}
+	}
+
+	// Stock reserved when checkout began has already been taken
+	held := map[uuid.UUID]int{}
+	if fromCart {
+		held, err = inventory.Consume(tx, userID, franchiseID)
+		if err != nil {
+			tx.Rollback()
+			return nil, &checkoutError{http.StatusInternalServerError, "Failed to create order"}
+		}
+	}
+
 	// Update stock with row-level locking to prevent race conditions
 	for _, item := range cartItems {
+		quantity := item.Quantity - held[item.ProductID]
+		delete(held, item.ProductID)
+		if quantity <= 0 {
+			// The cart shrank after reserving; give back the surplus
+			if quantity < 0 {
+				restockProduct(tx, franchiseID, item.ProductID, -quantity)
+			}
+			continue
+		}
+
 		if franchiseID != nil {
 			var fp models.FranchiseProduct
 			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
 				Where("franchise_id = ? AND product_id = ?", franchiseID, item.ProductID).
 				First(&fp).Error; err == nil {
-				if fp.StockQuantity < item.Quantity {
+				if fp.StockQuantity < quantity {
 					tx.Rollback()
-					c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock for " + item.Product.ItemName})
-					return
+					return nil, &checkoutError{http.StatusBadRequest, "Insufficient stock for " + item.Product.ItemName}
 				}
-				fp.StockQuantity -= item.Quantity
+				fp.StockQuantity -= quantity
 				tx.Save(&fp)
 				continue
 			}
@@ -205,23 +336,50 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 			Where("id = ?", item.ProductID).
 			First(&product).Error; err != nil {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Product not found"})
-			return
+			return nil, &checkoutError{http.StatusInternalServerError, "Product not found"}
 		}
-		if product.StockQuantity < item.Quantity {
+		if product.StockQuantity < quantity {
 			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock for " + product.ItemName})
-			return
+			return nil, &checkoutError{http.StatusBadRequest, "Insufficient stock for " + product.ItemName}
 		}
-		product.StockQuantity -= item.Quantity
+		product.StockQuantity -= quantity
 		tx.Save(&product)
 	}
+	// Reserved products that were since removed from the cart
+	for productID, quantity := range held {
+		restockProduct(tx, franchiseID, productID, quantity)
+	}
 
 	// Create order
 	if err := tx.Create(&order).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
-		return
+		return nil, &checkoutError{http.StatusInternalServerError, "Failed to create order"}
+	}
+
+	if coupon != nil {
+		if err := redeemCoupon(tx, coupon, &order, quote.CouponDiscount); err != nil {
+			tx.Rollback()
+			if errors.Is(err, errCouponExhausted) {
+				return nil, &checkoutError{http.StatusConflict, "Coupon has reached its usage limit"}
+			}
+			return nil, &checkoutError{http.StatusInternalServerError, "Failed to apply coupon"}
+		}
+	}
+
+	if err := loyalty.Redeem(tx, order.UserID, order.PointsRedeemed, order); err != nil {
+		tx.Rollback()
+		if errors.Is(err, loyalty.ErrInsufficientPoints) {
+			return nil, &checkoutError{http.StatusConflict, "You no longer have enough loyalty points"}
+		}
+		return nil, &checkoutError{http.StatusInternalServerError, "Failed to redeem loyalty points"}
+	}
+
+	if err := giftcards.Redeem(tx, giftCardSpends, order); err != nil {
+		tx.Rollback()
+		if errors.Is(err, giftcards.ErrBalanceMoved) {
+			return nil, &checkoutError{http.StatusConflict, "A gift card balance changed, please try again"}
+		}
+		return nil, &checkoutError{http.StatusInternalServerError, "Failed to redeem gift card"}
 	}
 
 	// Create order items
@@ -230,25 +388,75 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		orderItems[i].ID = uuid.Nil
 	}
 
-	if err := tx.Omit("Product", "Order").CreateInBatches(&orderItems, 100).Error; err != nil {
+	if err := tx.Omit("Product", "Order", "Discounts").CreateInBatches(&orderItems, 100).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order items"})
-		return
+		return nil, &checkoutError{http.StatusInternalServerError, "Failed to create order items"}
+	}
+
+	// Itemise the deals and coupon taken off each item
+	var itemDiscounts []models.OrderItemDiscount
+	for i, line := range quote.Lines {
+		for _, d := range line.Discounts {
+			itemDiscounts = append(itemDiscounts, models.OrderItemDiscount{
+				OrderItemID:   orderItems[i].ID,
+				PricingRuleID: d.PricingRuleID,
+				CouponID:      d.CouponID,
+				Description:   d.Description,
+				Amount:        d.Amount,
+			})
+		}
+	}
+	if len(itemDiscounts) > 0 {
+		if err := tx.Create(&itemDiscounts).Error; err != nil {
+			tx.Rollback()
+			return nil, &checkoutError{http.StatusInternalServerError, "Failed to create order items"}
+		}
 	}
 
-	// Update user loyalty points
-	var user models.User
-	tx.Where("id = ?", userID).First(&user)
-	user.LoyaltyPoints += pointsEarned
-	tx.Save(&user)
+	// Authorize card payments before committing so a declined card leaves stock and cart untouched.
+	// The order is only confirmed once the provider has accepted the hold.
+	var payment *models.Payment
+	if h.Payments != nil && order.PaymentMethod == models.PaymentMethodCard && order.AmountDue() > 0 {
+		p, err := payments.AuthorizeOrder(tx, h.Payments, &order, req.PaymentToken, req.SavedPaymentMethod)
+		if err != nil {
+			tx.Rollback()
+			if errors.Is(err, payments.ErrDeclined) {
+				return nil, &checkoutError{http.StatusPaymentRequired, "Payment was declined"}
+			}
+			log.Printf("Payment authorization failed for order %s: %v", order.ID, err)
+			return nil, &checkoutError{http.StatusInternalServerError, "Failed to authorize payment"}
+		}
+		payment = p
+
+		order.Status = models.OrderStatusConfirmed
+		if err := tx.Model(&order).Update("status", order.Status).Error; err != nil {
+			tx.Rollback()
+			h.voidPayment(payment)
+			return nil, &checkoutError{http.StatusInternalServerError, "Failed to create order"}
+		}
+	}
+
+	if err := recordStatusEvent(tx, order.ID, "", order.Status, actorID, actorRole, ""); err != nil {
+		tx.Rollback()
+		h.voidPayment(payment)
+		return nil, &checkoutError{http.StatusInternalServerError, "Failed to create order"}
+	}
+
+	if err := loyalty.Earn(tx, order); err != nil {
+		tx.Rollback()
+		h.voidPayment(payment)
+		return nil, &checkoutError{http.StatusInternalServerError, "Failed to create order"}
+	}
 
 	// Clear cart
-	tx.Where("user_id = ?", userID).Delete(&models.CartItem{})
+	if fromCart {
+		tx.Where("user_id = ?", userID).Delete(&models.CartItem{})
+	}
 
 	// Commit transaction
 	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete order"})
-		return
+		h.voidPayment(payment)
+		return nil, &checkoutError{http.StatusInternalServerError, "Failed to complete order"}
 	}
 
 	// Copy product images to order-specific storage for permanent retention
@@ -276,12 +484,14 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	}
 
 	// Load order with relations
-	h.DB.Preload("Items").Preload("Items.Product").Preload("Items.Product.Category").Preload("Items.Product.Images").Preload("User").First(&order, order.ID)
+	h.DB.Preload("Items").Preload("Items.Discounts").Preload("Items.Product").Preload("Items.Product.Category").Preload("Items.Product.Images").Preload("User").Preload("Payments").Preload("DeliverySlot").First(&order, order.ID)
+
+	publishOrderUpdate(order, utils.OrderUpdateCreated)
 
 	// Send order confirmation email (non-blocking)
-	utils.SendOrderConfirmation(user.Email, user.Name, order.OrderNumber, order.Total)
+	utils.SendOrderConfirmation(order.User.Email, order.User.Name, order.OrderNumber, order.Total)
 
-	c.JSON(http.StatusCreated, order)
+	return &order, nil
 }
 
 func (h *OrderHandler) GetOrders(c *gin.Context) {
@@ -316,6 +526,9 @@ func (h *OrderHandler) GetOrders(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
 		return
 	}
+	if isFranchiseRole(roleStr) {
+		hidePickupCodes(orders)
+	}
 
 	c.JSON(http.StatusOK, orders)
 }
@@ -327,7 +540,7 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
 
 	var order models.Order
 	// Use Unscoped() for Product preloading to include soft-deleted products for historical order data
-	query := h.DB.Preload("Items").Preload("Items.Product", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).Preload("Items.Product.Category").Preload("Items.Product.Images").Preload("User")
+	query := h.DB.Preload("Items").Preload("Items.Discounts").Preload("Items.Product", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).Preload("Items.Product.Category").Preload("Items.Product.Images").Preload("User").Preload("Refunds.Items").Preload("DeliverySlot")
 
 	roleStr, _ := userRole.(string)
 
@@ -346,6 +559,9 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
+	if isFranchiseRole(roleStr) {
+		order.PickupCode = ""
+	}
 
 	c.JSON(http.StatusOK, order)
 }
@@ -355,7 +571,9 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 	userRole, _ := c.Get("user_role")
 
 	var req struct {
-		Status models.OrderStatus `json:"status" binding:"required"`
+		Status     models.OrderStatus `json:"status" binding:"required"`
+		Note       string             `json:"note"`
+		PickupCode string             `json:"pickup_code"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -380,36 +598,27 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 	}
 
 	// Validate state transition
-	if !models.IsValidTransition(order.Status, req.Status) {
+	if !models.IsValidTransitionFor(order.FulfilmentType, order.Status, req.Status) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": fmt.Sprintf("Invalid status transition from '%s' to '%s'", order.Status, req.Status),
 		})
 		return
 	}
+	actorID, actorRole := statusActor(c)
+	if !models.CanTransition(actorRole, order.FulfilmentType, order.Status, req.Status) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("You cannot move an order to '%s'", req.Status)})
+		return
+	}
 
-	order.Status = req.Status
-	if err := h.DB.Save(&order).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+	// Collection is only confirmed once the store has checked the customer's code
+	if req.Status == models.OrderStatusCollected && !pickupCodeMatches(order, req.PickupCode) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid pickup code"})
 		return
 	}
 
-	// Restore stock on cancellation
-	if req.Status == models.OrderStatusCancelled {
-		var items []models.OrderItem
-		h.DB.Where("order_id = ?", order.ID).Find(&items)
-		for _, item := range items {
-			if order.FranchiseID != nil {
-				var fp models.FranchiseProduct
-				if err := h.DB.Where("franchise_id = ? AND product_id = ?", order.FranchiseID, item.ProductID).First(&fp).Error; err == nil {
-					fp.StockQuantity += item.Quantity
-					h.DB.Save(&fp)
-					continue
-				}
-			}
-			// Fallback to master product stock
-			h.DB.Model(&models.Product{}).Where("id = ?", item.ProductID).
-				Update("stock_quantity", gorm.Expr("stock_quantity + ?", item.Quantity))
-		}
+	if err := applyStatusChange(h.DB, h.Payments, &order, req.Status, actorID, actorRole, req.Note); err != nil {
+		respondCheckoutError(c, err, "Failed to update order status")
+		return
 	}
 
 	// Use Unscoped() for Product preloading to include soft-deleted products for historical order data
@@ -419,12 +628,321 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 	if order.User.Email != "" {
 		utils.SendOrderStatusUpdate(order.User.Email, order.User.Name, order.OrderNumber, string(req.Status))
 	}
+	if isFranchiseRole(roleStr) {
+		order.PickupCode = ""
+	}
 
 	c.JSON(http.StatusOK, order)
 }
 
+// generatePickupCode returns a random six-digit code for a collection order.
+func generatePickupCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// pickupCodeMatches reports whether code is the order's pickup code.
+func pickupCodeMatches(order models.Order, code string) bool {
+	code = strings.TrimSpace(code)
+	return order.PickupCode != "" && subtle.ConstantTimeCompare([]byte(order.PickupCode), []byte(code)) == 1
+}
+
+// isFranchiseRole reports whether role belongs to store staff.
+func isFranchiseRole(role string) bool {
+	return role == "franchise_owner" || role == "franchise_staff"
+}
+
+// portalFranchiseScope returns the franchise a franchise-portal caller is
+// limited to, or nil for admins, for handlers shared by both portals.
+func portalFranchiseScope(c *gin.Context) *uuid.UUID {
+	role, _ := c.Get("user_role")
+	if roleStr, _ := role.(string); !isFranchiseRole(roleStr) {
+		return nil
+	}
+	franchiseID, _ := c.Get("franchise_id")
+	fID := franchiseID.(uuid.UUID)
+	return &fID
+}
+
+// hidePickupCodes blanks pickup codes before orders are shown to store staff,
+// who must get the code from the customer at handover.
+func hidePickupCodes(orders []models.Order) {
+	for i := range orders {
+		orders[i].PickupCode = ""
+	}
+}
+
+// applyStatusChange moves an order to status once the transition has been
+// checked against order.Status. The order row is locked and the change is
+// refused with 409 if the status has moved on since, so concurrent requests
+// cannot release or settle the same order twice. Payment is captured or
+// released first, so a failed provider call leaves the order where it was. The
+// change is then saved, added to the timeline and published, and a cancelled
+// order is released and a completed one rewarded.
+func applyStatusChange(db *gorm.DB, provider payments.PaymentProvider, order *models.Order, status models.OrderStatus, actorID *uuid.UUID, actorRole, note string) error {
+	fromStatus := order.Status
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var current models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", order.ID).First(&current).Error; err != nil {
+			return &checkoutError{http.StatusInternalServerError, "Failed to update order status"}
+		}
+		if current.Status != fromStatus {
+			return &checkoutError{http.StatusConflict, "The order has changed; reload it and try again"}
+		}
+
+		if err := payments.SettleForStatus(tx, provider, current.ID, status); err != nil {
+			log.Printf("Payment settlement failed for order %s: %v", current.ID, err)
+			return &checkoutError{http.StatusInternalServerError, "Failed to settle payment for order"}
+		}
+
+		// Only the status is written, so amendments saved since are kept
+		if err := tx.Model(&current).Update("status", status).Error; err != nil {
+			return &checkoutError{http.StatusInternalServerError, "Failed to update order status"}
+		}
+		current.Status = status
+
+		if err := recordStatusEvent(tx, current.ID, fromStatus, status, actorID, actorRole, note); err != nil {
+			log.Printf("Failed to record status event for order %s: %v", current.ID, err)
+		}
+
+		// Restore stock on cancellation
+		if status == models.OrderStatusCancelled {
+			releaseCancelledOrder(tx, current)
+		}
+		rewardCompletedOrder(tx, current)
+		*order = current
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	publishOrderUpdate(*order, utils.OrderUpdateStatusChanged)
+	return nil
+}
+
+// releaseCancelledOrder gives back everything a cancelled order was holding:
+// its stock, delivery slot place, coupon use, redeemed loyalty points and gift
+// card spend, and takes back the points it earned.
+func releaseCancelledOrder(db *gorm.DB, order models.Order) {
+	restoreOrderStock(db, order)
+	releaseDeliverySlot(db, order)
+	releaseCoupon(db, order)
+	if err := loyalty.RestoreRedeemed(db, order); err != nil {
+		log.Printf("Failed to restore loyalty points for order %s: %v", order.ID, err)
+	}
+	if _, err := loyalty.ReverseCancelled(db, order); err != nil {
+		log.Printf("Failed to reverse loyalty points for order %s: %v", order.ID, err)
+	}
+	if err := giftcards.Release(db, order); err != nil {
+		log.Printf("Failed to return gift card balance for order %s: %v", order.ID, err)
+	}
+}
+
+// voidPayment releases the card hold taken for an order that could not be
+// placed. A failed void is logged, as the hold stays on the customer's card
+// until the provider lets it lapse.
+func (h *OrderHandler) voidPayment(payment *models.Payment) {
+	if payment == nil {
+		return
+	}
+	if _, err := h.Payments.Void(payment.ProviderReference); err != nil {
+		log.Printf("CRITICAL: failed to void payment %s for order %s that was not placed: %v", payment.ID, payment.OrderID, err)
+	}
+}
+
+// restoreOrderStock returns the quantities of a cancelled order to the franchise
+// stock it was taken from, falling back to master product stock. Items that were
+// already refunded have been restocked and are skipped, as are units the store
+// could not pick and replaced with a substitute.
+func restoreOrderStock(db *gorm.DB, order models.Order) {
+	var items []models.OrderItem
+	db.Where("order_id = ?", order.ID).Find(&items)
+	for _, item := range items {
+		if quantity := item.Quantity - item.RefundedQuantity - item.SubstitutedQuantity; quantity > 0 {
+			restockProduct(db, order.FranchiseID, item.ProductID, quantity)
+		}
+	}
+}
+
+// restockProduct adds quantity back to the franchise's stock of a product, or to
+// the master product stock when the franchise does not carry it.
+func restockProduct(db *gorm.DB, franchiseID *uuid.UUID, productID uuid.UUID, quantity int) {
+	if franchiseID != nil {
+		var fp models.FranchiseProduct
+		if err := db.Where("franchise_id = ? AND product_id = ?", franchiseID, productID).First(&fp).Error; err == nil {
+			fp.StockQuantity += quantity
+			db.Save(&fp)
+			return
+		}
+	}
+	// Fallback to master product stock
+	db.Model(&models.Product{}).Where("id = ?", productID).
+		UpdateColumn("stock_quantity", gorm.Expr("stock_quantity + ?", quantity))
+}
+
+// statusActor returns the authenticated user and role making a status change.
+func statusActor(c *gin.Context) (*uuid.UUID, string) {
+	role, _ := c.Get("user_role")
+	roleStr, _ := role.(string)
+	if userID, ok := c.Get("user_id"); ok {
+		if id, ok := userID.(uuid.UUID); ok {
+			return &id, roleStr
+		}
+	}
+	return nil, roleStr
+}
+
+// recordStatusEvent appends a status change to the order's timeline.
+func recordStatusEvent(db *gorm.DB, orderID uuid.UUID, from, to models.OrderStatus, actorID *uuid.UUID, actorRole, note string) error {
+	event := models.OrderStatusEvent{
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   to,
+		ActorID:    actorID,
+		ActorRole:  actorRole,
+		Note:       note,
+	}
+	return db.Create(&event).Error
+}
+
+// orderAccessQuery selects order id as visible to the caller: admins see every
+// order, franchise roles their franchise's orders and customers their own.
+func orderAccessQuery(c *gin.Context, db *gorm.DB, id string) *gorm.DB {
+	userID, _ := c.Get("user_id")
+	userRole, _ := c.Get("user_role")
+	roleStr, _ := userRole.(string)
+
+	switch roleStr {
+	case "admin":
+		return db.Where("id = ?", id)
+	case "franchise_owner", "franchise_staff":
+		fID, _ := c.Get("franchise_id")
+		return db.Where("id = ? AND franchise_id = ?", id, fID)
+	default:
+		return db.Where("id = ? AND user_id = ?", id, userID)
+	}
+}
+
+// timelineEntry is a status event together with how long the order stayed in
+// the status it moved to. The duration is omitted for the current status.
+type timelineEntry struct {
+	models.OrderStatusEvent
+	DurationSeconds *int64 `json:"duration_seconds,omitempty"`
+}
+
+// GetOrderTimeline returns the status history of an order. Customers can only
+// see their own orders and franchise roles only their franchise's orders.
+func (h *OrderHandler) GetOrderTimeline(c *gin.Context) {
+	var order models.Order
+	if err := orderAccessQuery(c, h.DB, c.Param("id")).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	var events []models.OrderStatusEvent
+	if err := h.DB.Where("order_id = ?", order.ID).Order("created_at ASC").Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order timeline"})
+		return
+	}
+
+	timeline := make([]timelineEntry, len(events))
+	for i, event := range events {
+		timeline[i] = timelineEntry{OrderStatusEvent: event}
+		if i+1 < len(events) {
+			seconds := int64(events[i+1].CreatedAt.Sub(event.CreatedAt).Seconds())
+			timeline[i].DurationSeconds = &seconds
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_id": order.ID,
+		"status":   order.Status,
+		"events":   timeline,
+	})
+}
+
+// StreamOrderEvents streams live updates for a single order as server-sent events,
+// starting with a snapshot of its current status.
+func (h *OrderHandler) StreamOrderEvents(c *gin.Context) {
+	var order models.Order
+	if err := orderAccessQuery(c, h.DB, c.Param("id")).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	updates, unsubscribe := utils.OrderEvents.Subscribe(func(u utils.OrderUpdate) bool {
+		return u.OrderID == order.ID
+	})
+	defer unsubscribe()
+
+	snapshot := orderUpdate(order, utils.OrderUpdateSnapshot)
+	snapshot.At = order.UpdatedAt
+	streamOrderUpdates(c, updates, &snapshot)
+}
+
+// orderUpdate builds the broadcast form of an order.
+func orderUpdate(order models.Order, updateType string) utils.OrderUpdate {
+	return utils.OrderUpdate{
+		Type:        updateType,
+		OrderID:     order.ID,
+		OrderNumber: order.OrderNumber,
+		UserID:      order.UserID,
+		FranchiseID: order.FranchiseID,
+		Status:      string(order.Status),
+	}
+}
+
+// publishOrderUpdate notifies live subscribers about a change to an order.
+func publishOrderUpdate(order models.Order, updateType string) {
+	utils.OrderEvents.Publish(orderUpdate(order, updateType))
+}
+
+// sseHeartbeatInterval is how often an idle stream sends a comment line so
+// proxies and load balancers do not close the connection.
+const sseHeartbeatInterval = 25 * time.Second
+
+// streamOrderUpdates writes updates to the client as server-sent events until
+// the client disconnects or the broker shuts down.
+func streamOrderUpdates(c *gin.Context, updates <-chan utils.OrderUpdate, initial *utils.OrderUpdate) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if initial != nil {
+		c.SSEvent(initial.Type, initial)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			c.SSEvent(update.Type, update)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// GetOrderTransitions returns the status changes the caller's role may make.
 func (h *OrderHandler) GetOrderTransitions(c *gin.Context) {
-	c.JSON(http.StatusOK, models.AllowedTransitions)
+	_, role := statusActor(c)
+	c.JSON(http.StatusOK, models.RoleTransitions[role])
 }
 
 // GetAdminDashboard returns pre-computed dashboard stats for admin with optional franchise filter
@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"grabbi-backend/models"
+	"grabbi-backend/pricing"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CancelOrder lets a customer cancel their own order before the store starts
+// preparing it. The order is released and its payment voided or refunded as
+// when a store cancels it.
+func (h *OrderHandler) CancelOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// The body is optional
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	var order models.Order
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	actorID, actorRole := statusActor(c)
+	if !models.CanTransition(actorRole, order.FulfilmentType, order.Status, models.OrderStatusCancelled) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Orders can only be cancelled before the store starts preparing them"})
+		return
+	}
+
+	if err := applyStatusChange(h.DB, h.Payments, &order, models.OrderStatusCancelled, actorID, actorRole, req.Reason); err != nil {
+		respondCheckoutError(c, err, "Failed to cancel order")
+		return
+	}
+
+	// Use Unscoped() for Product preloading to include soft-deleted products for historical order data
+	h.DB.Preload("Items").Preload("Items.Product", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).Preload("Items.Product.Images").Preload("User").First(&order, order.ID)
+
+	// Send status update email (non-blocking)
+	if order.User.Email != "" {
+		utils.SendOrderStatusUpdate(order.User.Email, order.User.Name, order.OrderNumber, string(order.Status))
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
+// amendItemRequest sets the quantity of an order item a customer still wants.
+type amendItemRequest struct {
+	OrderItemID uuid.UUID `json:"order_item_id" binding:"required"`
+	Quantity    int       `json:"quantity" binding:"min=0"`
+}
+
+// AmendOrder lets a customer change their own order before the store starts
+// preparing it: lower item quantities, removing items at 0, or change the
+// delivery address. Items taken off are refunded and restocked as for any
+// refund. Quantities cannot go up, as that would need a new payment; the
+// customer places another order instead.
+func (h *OrderHandler) AmendOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Items           []amendItemRequest `json:"items" binding:"omitempty,dive"`
+		AddressID       string             `json:"address_id"`
+		DeliveryAddress string             `json:"delivery_address"`
+		CustomerLat     *float64           `json:"customer_lat"`
+		CustomerLng     *float64           `json:"customer_lng"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	changesAddress := req.AddressID != "" || req.DeliveryAddress != ""
+	if len(req.Items) == 0 && !changesAddress {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide items or an address to amend"})
+		return
+	}
+
+	tx := h.DB.Begin()
+
+	// Lock the order so an amendment cannot race the store starting to prepare it
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&order).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if !order.IsAmendable() {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Orders can only be amended before the store starts preparing them"})
+		return
+	}
+
+	if changesAddress {
+		if err := amendOrderAddress(tx, &order, userID, req.AddressID, req.DeliveryAddress, req.CustomerLat, req.CustomerLng); err != nil {
+			tx.Rollback()
+			respondCheckoutError(c, err, "Failed to amend order")
+			return
+		}
+	}
+
+	if len(req.Items) > 0 {
+		refund, err := amendedItemsRefund(tx, order, req.Items)
+		if err != nil {
+			tx.Rollback()
+			respondCheckoutError(c, err, "Failed to amend order")
+			return
+		}
+		if refund != nil {
+			refund.CreatedByID = userID.(uuid.UUID)
+			if err := recordRefund(tx, order, refund); err != nil {
+				tx.Rollback()
+				respondCheckoutError(c, err, "Failed to amend order")
+				return
+			}
+			unsettled, err := settleRefund(tx, h.Payments, &order, refund)
+			if err != nil {
+				tx.Rollback()
+				respondCheckoutError(c, err, "Failed to amend order")
+				return
+			}
+			if unsettled > 0 {
+				log.Printf("Refund %s for order %s has %.2f to be returned outside the payment provider", refund.ID, order.ID, unsettled)
+			}
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("CRITICAL: amendment to order %s may have refunded payment but was not recorded: %v", order.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to amend order"})
+		return
+	}
+
+	publishOrderUpdate(order, utils.OrderUpdateAmended)
+
+	// Use Unscoped() for Product preloading to include soft-deleted products for historical order data
+	h.DB.Preload("Items").Preload("Items.Product", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).Preload("Items.Product.Images").Preload("Refunds.Items").First(&order, order.ID)
+	c.JSON(http.StatusOK, order)
+}
+
+// amendOrderAddress points a delivery order at a saved address or a new
+// free-text one, checking the order's store delivers there when the
+// coordinates are known.
+func amendOrderAddress(tx *gorm.DB, order *models.Order, userID interface{}, addressID, deliveryAddress string, lat, lng *float64) error {
+	if order.FulfilmentType != models.FulfilmentDelivery {
+		return &checkoutError{http.StatusBadRequest, "Collection orders have no delivery address"}
+	}
+
+	if addressID != "" {
+		address, err := orderAddress(tx, userID, addressID, false)
+		if err != nil {
+			return err
+		}
+		lat, lng = &address.Latitude, &address.Longitude
+		order.AddressID = &address.ID
+		order.DeliveryAddress = address.String()
+		order.DeliveryNotes = address.DeliveryInstructions
+	} else {
+		order.AddressID = nil
+		order.DeliveryAddress = deliveryAddress
+		order.DeliveryNotes = ""
+	}
+	order.CustomerLat, order.CustomerLng = lat, lng
+
+	if order.FranchiseID != nil && lat != nil && lng != nil {
+		var franchise models.Franchise
+		if err := tx.Where("id = ?", *order.FranchiseID).First(&franchise).Error; err != nil {
+			return err
+		}
+		if !deliversTo(franchise, *lat, *lng) {
+			return &checkoutError{http.StatusBadRequest, "This franchise does not deliver to your location"}
+		}
+	}
+
+	return tx.Model(order).Updates(map[string]interface{}{
+		"address_id":       order.AddressID,
+		"delivery_address": order.DeliveryAddress,
+		"delivery_notes":   order.DeliveryNotes,
+		"customer_lat":     order.CustomerLat,
+		"customer_lng":     order.CustomerLng,
+	}).Error
+}
+
+// amendedItemsRefund builds the refund for the units taken off an order by an
+// amendment, less what the smaller basket now costs in delivery and coupon
+// discount. It returns nil when no quantity goes down.
+func amendedItemsRefund(tx *gorm.DB, order models.Order, amendments []amendItemRequest) (*models.Refund, error) {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	kept := make(map[uuid.UUID]int, len(items))
+	itemsByID := make(map[uuid.UUID]models.OrderItem, len(items))
+	for _, item := range items {
+		kept[item.ID] = item.Quantity - item.RefundedQuantity
+		itemsByID[item.ID] = item
+	}
+
+	refund := &models.Refund{OrderID: order.ID, Reason: "Amended by customer"}
+	seen := make(map[uuid.UUID]bool, len(amendments))
+	for _, amendment := range amendments {
+		item, ok := itemsByID[amendment.OrderItemID]
+		if !ok {
+			return nil, &checkoutError{http.StatusBadRequest, "Order item not found"}
+		}
+		if seen[item.ID] {
+			return nil, &checkoutError{http.StatusBadRequest, "Each order item can only be amended once"}
+		}
+		seen[item.ID] = true
+		current := item.Quantity - item.RefundedQuantity
+		if amendment.Quantity > current {
+			return nil, &checkoutError{http.StatusBadRequest,
+				fmt.Sprintf("Quantities can only be lowered; place another order for more %s", item.ProductName)}
+		}
+		if amendment.Quantity == current {
+			continue
+		}
+		removed := current - amendment.Quantity
+		amount := itemPaidAmount(item, removed)
+		refund.Items = append(refund.Items, models.RefundItem{
+			OrderItemID: item.ID,
+			ProductID:   item.ProductID,
+			Quantity:    removed,
+			Amount:      amount,
+		})
+		refund.Amount += amount
+		kept[item.ID] = amendment.Quantity
+	}
+
+	remaining := 0
+	for _, quantity := range kept {
+		remaining += quantity
+	}
+	if remaining == 0 {
+		return nil, &checkoutError{http.StatusBadRequest, "Cancel the order instead of removing every item"}
+	}
+	if len(refund.Items) == 0 {
+		return nil, nil
+	}
+
+	charges, notes, err := amendedOrderCharges(tx, order, items, kept)
+	if err != nil {
+		return nil, err
+	}
+	if charges > refund.Amount+0.001 {
+		return nil, &checkoutError{http.StatusBadRequest,
+			"Removing these items costs more in delivery and coupon discount than it refunds; keep more items or cancel the order"}
+	}
+	if len(notes) > 0 {
+		refund.Reason += ": " + strings.Join(notes, "; ")
+	}
+	refund.Amount = pricing.RoundMoney(refund.Amount - charges)
+	return refund, nil
+}
+
+// amendedOrderCharges works out what an amended basket loses by dropping below
+// a threshold the order was placed over: free delivery, or a coupon's
+// min_basket, in which case the coupon discount on the items kept is
+// withdrawn. The order is updated to match, so a later amendment does not
+// charge the same again. It returns the amount to keep back from the refund
+// and a note for each charge.
+func amendedOrderCharges(tx *gorm.DB, order models.Order, items []models.OrderItem, kept map[uuid.UUID]int) (float64, []string, error) {
+	var subtotal float64
+	for _, item := range items {
+		subtotal += item.Price * float64(kept[item.ID])
+	}
+	subtotal = pricing.RoundMoney(subtotal)
+
+	var charges float64
+	var notes []string
+
+	var coupon *models.Coupon
+	if order.CouponID != nil {
+		var c models.Coupon
+		if err := tx.Unscoped().Where("id = ?", *order.CouponID).First(&c).Error; err != nil {
+			return 0, nil, err
+		}
+		coupon = &c
+	}
+	couponLost := coupon != nil && subtotal < coupon.MinBasket
+	if couponLost {
+		withdrawn, err := withdrawCouponDiscount(tx, coupon.ID, items, kept)
+		if err != nil {
+			return 0, nil, err
+		}
+		if withdrawn > 0 {
+			charges += withdrawn
+			notes = append(notes, fmt.Sprintf("coupon %s no longer applies (%.2f)", coupon.Code, withdrawn))
+		}
+	}
+
+	if order.FulfilmentType == models.FulfilmentDelivery {
+		opts := pricing.Options{Fulfilment: order.FulfilmentType}
+		if order.FranchiseID != nil {
+			var franchise models.Franchise
+			if err := tx.Unscoped().Where("id = ?", *order.FranchiseID).First(&franchise).Error; err != nil {
+				return 0, nil, err
+			}
+			opts.Franchise = &franchise
+		}
+		fee, _ := pricing.DeliveryFee(subtotal, opts)
+		if coupon != nil && coupon.Type == models.CouponFreeDelivery && !couponLost {
+			fee = 0
+		}
+		if extra := pricing.RoundMoney(fee - order.DeliveryFee); extra > 0 {
+			if err := tx.Model(&models.Order{}).Where("id = ?", order.ID).UpdateColumn("delivery_fee", fee).Error; err != nil {
+				return 0, nil, err
+			}
+			charges += extra
+			notes = append(notes, fmt.Sprintf("delivery fee of %.2f now applies", extra))
+		}
+	}
+
+	return pricing.RoundMoney(charges), notes, nil
+}
+
+// withdrawCouponDiscount removes a coupon's discount from the order's items and
+// returns the part of it given on the units still kept, which the customer
+// now pays. Units refunded later are then refunded at the undiscounted price.
+func withdrawCouponDiscount(tx *gorm.DB, couponID uuid.UUID, items []models.OrderItem, kept map[uuid.UUID]int) (float64, error) {
+	itemsByID := make(map[uuid.UUID]models.OrderItem, len(items))
+	itemIDs := make([]uuid.UUID, 0, len(items))
+	for _, item := range items {
+		itemsByID[item.ID] = item
+		itemIDs = append(itemIDs, item.ID)
+	}
+
+	var discounts []models.OrderItemDiscount
+	if err := tx.Where("order_item_id IN ? AND coupon_id = ?", itemIDs, couponID).Find(&discounts).Error; err != nil {
+		return 0, err
+	}
+	if len(discounts) == 0 {
+		return 0, nil
+	}
+
+	var withdrawn float64
+	for _, discount := range discounts {
+		item := itemsByID[discount.OrderItemID]
+		withdrawn += discount.Amount * float64(kept[item.ID]) / float64(item.Quantity)
+		if err := tx.Model(&models.OrderItem{}).Where("id = ?", item.ID).
+			UpdateColumn("discount", gorm.Expr("discount - ?", discount.Amount)).Error; err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Delete(&discounts).Error; err != nil {
+		return 0, err
+	}
+	return pricing.RoundMoney(withdrawn), nil
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grabbi-backend/models"
+)
+
+func TestCustomerCancelsOrderOnlyBeforePreparing(t *testing.T) {
+	db := freshDB()
+	router := setupOrderAmendRouter(db, nil)
+	owner, _ := seedTestUser(db, "cancel-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Cancel Store", owner.ID)
+	_, ownerToken := seedFranchiseOwnerWithToken(db, franchise)
+	cat := seedCategory(db, "Cancel Cat")
+	bread := seedProduct(db, "Bread", cat.ID, 2.00)
+	seedFranchiseProduct(db, franchise.ID, bread.ID)
+	_, token := seedTestUser(db, "cancel@test.com", "customer", nil)
+	_, otherToken := seedTestUser(db, "cancel-other@test.com", "customer", nil)
+
+	addToCart(t, router, token, bread.ID, franchise.ID, 2)
+	orderID := placeCollectionOrder(t, router, token, franchise.ID)["id"].(string)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders/"+orderID+"/cancel", nil, otherToken))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 cancelling someone else's order, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders/"+orderID+"/cancel", map[string]string{"reason": "Ordered by mistake"}, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if status := parseResponse(w)["status"]; status != "cancelled" {
+		t.Errorf("expected cancelled, got %v", status)
+	}
+	var stock models.FranchiseProduct
+	db.Where("franchise_id = ? AND product_id = ?", franchise.ID, bread.ID).First(&stock)
+	if stock.StockQuantity != 50 {
+		t.Errorf("expected stock restored to 50, got %d", stock.StockQuantity)
+	}
+	var event models.OrderStatusEvent
+	db.Where("order_id = ? AND to_status = ?", orderID, models.OrderStatusCancelled).First(&event)
+	if event.ActorRole != "customer" || event.Note != "Ordered by mistake" {
+		t.Errorf("expected the customer's cancellation on the timeline, got %+v", event)
+	}
+
+	// Once the store is preparing an order only the store can cancel it
+	addToCart(t, router, token, bread.ID, franchise.ID, 1)
+	orderID = placeCollectionOrder(t, router, token, franchise.ID)["id"].(string)
+	startPicking(t, router, orderID, ownerToken)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders/"+orderID+"/cancel", nil, token))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 cancelling while preparing, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/orders/transitions", nil, token))
+	transitions := parseResponse(w)
+	if len(transitions) != 2 || transitions["preparing"] != nil {
+		t.Errorf("expected customers to see only their cancellations, got %v", transitions)
+	}
+}
+
+func TestCancellingTwiceReleasesOrderOnce(t *testing.T) {
+	db := freshDB()
+	router := setupOrderAmendRouter(db, nil)
+	owner, _ := seedTestUser(db, "twice-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Twice Store", owner.ID)
+	cat := seedCategory(db, "Twice Cat")
+	milk := seedProduct(db, "Milk", cat.ID, 1.20)
+	seedFranchiseProduct(db, franchise.ID, milk.ID)
+	slot := seedDeliverySlot(db, franchise.ID, 2)
+	_, token := seedTestUser(db, "twice@test.com", "customer", nil)
+
+	placeSlotOrder := func(quantity int) string {
+		addToCart(t, router, token, milk.ID, franchise.ID, quantity)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+			"payment_method": "cash", "delivery_address": "1 Twice St",
+			"franchise_id": franchise.ID.String(), "slot_id": slot.ID.String(),
+		}, token))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		return parseResponse(w)["id"].(string)
+	}
+	placeSlotOrder(3)
+	orderID := placeSlotOrder(2)
+
+	// A second request that read the order before the first cancelled it
+	var stale models.Order
+	db.First(&stale, "id = ?", orderID)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders/"+orderID+"/cancel", nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders/"+orderID+"/cancel", nil, token))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 cancelling again, got %d: %s", w.Code, w.Body.String())
+	}
+	err := applyStatusChange(db, nil, &stale, models.OrderStatusCancelled, nil, "customer", "")
+	var ce *checkoutError
+	if !errors.As(err, &ce) || ce.status != http.StatusConflict {
+		t.Errorf("expected 409 cancelling from a stale read, got %v", err)
+	}
+
+	var stock models.FranchiseProduct
+	db.Where("franchise_id = ? AND product_id = ?", franchise.ID, milk.ID).First(&stock)
+	if stock.StockQuantity != 47 {
+		t.Errorf("expected stock restored once to 47, got %d", stock.StockQuantity)
+	}
+	var reloaded models.DeliverySlot
+	db.First(&reloaded, "id = ?", slot.ID)
+	if reloaded.Reserved != 1 {
+		t.Errorf("expected the other order to keep its place, got %d reserved", reloaded.Reserved)
+	}
+}
+
+func TestCustomerAmendsOrderItems(t *testing.T) {
+	db := freshDB()
+	router := setupOrderAmendRouter(db, nil)
+	owner, _ := seedTestUser(db, "amend-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Amend Store", owner.ID)
+	_, ownerToken := seedFranchiseOwnerWithToken(db, franchise)
+	cat := seedCategory(db, "Amend Cat")
+	eggs := seedProduct(db, "Eggs", cat.ID, 3.00)
+	jam := seedProduct(db, "Jam", cat.ID, 4.00)
+	seedFranchiseProduct(db, franchise.ID, eggs.ID)
+	seedFranchiseProduct(db, franchise.ID, jam.ID)
+	_, token := seedTestUser(db, "amend@test.com", "customer", nil)
+
+	addToCart(t, router, token, eggs.ID, franchise.ID, 3)
+	addToCart(t, router, token, jam.ID, franchise.ID, 1)
+	orderID := placeCollectionOrder(t, router, token, franchise.ID)["id"].(string)
+
+	var eggItem, jamItem models.OrderItem
+	db.Where("order_id = ? AND product_id = ?", orderID, eggs.ID).First(&eggItem)
+	db.Where("order_id = ? AND product_id = ?", orderID, jam.ID).First(&jamItem)
+	amend := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("PUT", "/api/orders/"+orderID, body, token))
+		return w
+	}
+
+	for name, body := range map[string]map[string]interface{}{
+		"nothing to amend": {},
+		"a higher quantity": {"items": []map[string]interface{}{
+			{"order_item_id": eggItem.ID, "quantity": 4},
+		}},
+		"every item removed": {"items": []map[string]interface{}{
+			{"order_item_id": eggItem.ID, "quantity": 0},
+			{"order_item_id": jamItem.ID, "quantity": 0},
+		}},
+		"an address on a collection order": {"delivery_address": "1 New St"},
+	} {
+		if w := amend(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	w := amend(map[string]interface{}{"items": []map[string]interface{}{
+		{"order_item_id": eggItem.ID, "quantity": 1},
+		{"order_item_id": jamItem.ID, "quantity": 1},
+	}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	refunds := parseResponse(w)["refunds"].([]interface{})
+	if len(refunds) != 1 || refunds[0].(map[string]interface{})["amount"] != 6.0 {
+		t.Errorf("expected one 6.00 refund for two eggs, got %v", refunds)
+	}
+
+	db.First(&eggItem, "id = ?", eggItem.ID)
+	if eggItem.RefundedQuantity != 2 {
+		t.Errorf("expected 2 eggs taken off, got %d", eggItem.RefundedQuantity)
+	}
+	var order models.Order
+	db.First(&order, "id = ?", orderID)
+	if order.RefundedAmount != 6.0 {
+		t.Errorf("expected 6.00 refunded on the order, got %.2f", order.RefundedAmount)
+	}
+	var stock models.FranchiseProduct
+	db.Where("franchise_id = ? AND product_id = ?", franchise.ID, eggs.ID).First(&stock)
+	if stock.StockQuantity != 49 {
+		t.Errorf("expected the removed eggs restocked, got %d", stock.StockQuantity)
+	}
+
+	startPicking(t, router, orderID, ownerToken)
+	w = amend(map[string]interface{}{"items": []map[string]interface{}{
+		{"order_item_id": jamItem.ID, "quantity": 0},
+	}})
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 amending while preparing, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAmendingBelowThresholdsChargesDeliveryAndCoupon(t *testing.T) {
+	db := freshDB()
+	router := setupOrderAmendRouter(db, nil)
+	owner, _ := seedTestUser(db, "threshold-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Threshold Store", owner.ID)
+	db.Model(&franchise).Update("free_delivery_min", 51.0)
+	cat := seedCategory(db, "Threshold Cat")
+	wine := seedProduct(db, "Wine", cat.ID, 10.00)
+	bread := seedProduct(db, "Bread", cat.ID, 1.00)
+	seedFranchiseProduct(db, franchise.ID, wine.ID)
+	seedFranchiseProduct(db, franchise.ID, bread.ID)
+	coupon := seedCoupon(db, "FIVEOFF", models.CouponFixed, 5)
+	db.Model(&coupon).Update("min_basket", 45.0)
+	_, token := seedTestUser(db, "threshold@test.com", "customer", nil)
+
+	// 51.00 of goods clears free delivery and the coupon's minimum
+	addToCart(t, router, token, wine.ID, franchise.ID, 5)
+	addToCart(t, router, token, bread.ID, franchise.ID, 1)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+		"payment_method": "cash", "franchise_id": franchise.ID.String(),
+		"delivery_address": "1 Threshold St", "coupon_code": "FIVEOFF",
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	placed := parseResponse(w)
+	if placed["delivery_fee"] != 0.0 || placed["total"] != 46.0 {
+		t.Fatalf("expected free delivery and 5.00 off, got %v", placed)
+	}
+	orderID := placed["id"].(string)
+
+	var wineItem, breadItem models.OrderItem
+	db.Where("order_id = ? AND product_id = ?", orderID, wine.ID).First(&wineItem)
+	db.Where("order_id = ? AND product_id = ?", orderID, bread.ID).First(&breadItem)
+	amend := func(items ...map[string]interface{}) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("PUT", "/api/orders/"+orderID, map[string]interface{}{"items": items}, token))
+		return w
+	}
+
+	// The 0.90 paid for the bread does not cover the delivery fee it would cost
+	if w := amend(map[string]interface{}{"order_item_id": breadItem.ID, "quantity": 0}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 when the fee outweighs the refund, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Two wines refund 18.04 paid, less the 3.04 coupon discount on what is
+	// kept and the 4.99 delivery fee
+	w = amend(map[string]interface{}{"order_item_id": wineItem.ID, "quantity": 3})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	refunds := parseResponse(w)["refunds"].([]interface{})
+	if len(refunds) != 1 || refunds[0].(map[string]interface{})["amount"] != 10.01 {
+		t.Errorf("expected one 10.01 refund, got %v", refunds)
+	}
+	var order models.Order
+	db.First(&order, "id = ?", orderID)
+	if order.DeliveryFee != 4.99 || order.RefundedAmount != 10.01 {
+		t.Errorf("expected the delivery fee charged and 10.01 refunded, got fee %.2f refunded %.2f", order.DeliveryFee, order.RefundedAmount)
+	}
+	var couponDiscounts int64
+	db.Model(&models.OrderItemDiscount{}).Where("coupon_id = ?", coupon.ID).Count(&couponDiscounts)
+	if couponDiscounts != 0 {
+		t.Errorf("expected the coupon discount withdrawn, got %d lines", couponDiscounts)
+	}
+
+	// Nothing is charged twice: the bread now refunds at full price
+	w = amend(map[string]interface{}{"order_item_id": breadItem.ID, "quantity": 0})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	db.First(&order, "id = ?", orderID)
+	if order.RefundedAmount != 11.01 {
+		t.Errorf("expected 1.00 more refunded for the bread, got %.2f in total", order.RefundedAmount)
+	}
+}
+
+func TestAmendAddressUsesCheckoutDeliveryRadius(t *testing.T) {
+	db := freshDB()
+	router := setupOrderAmendRouter(db, nil)
+	owner, _ := seedTestUser(db, "radius-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Radius Store", owner.ID)
+	cat := seedCategory(db, "Radius Cat")
+	tea := seedProduct(db, "Tea", cat.ID, 3.00)
+	seedFranchiseProduct(db, franchise.ID, tea.ID)
+	_, token := seedTestUser(db, "radius@test.com", "customer", nil)
+
+	addToCart(t, router, token, tea.ID, franchise.ID, 1)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+		"payment_method": "cash", "franchise_id": franchise.ID.String(), "delivery_address": "1 Radius St",
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	orderID := parseResponse(w)["id"].(string)
+
+	// About 4.4 miles away: inside the 5 mile radius, though over 5 km
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/orders/"+orderID, map[string]interface{}{
+		"delivery_address": "2 Radius St", "customer_lat": 51.5704, "customer_lng": -0.1278,
+	}, token))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 for an address within the radius, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/orders/"+orderID, map[string]interface{}{
+		"delivery_address": "1 Far St", "customer_lat": 53.4808, "customer_lng": -2.2426,
+	}, token))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an address outside the radius, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"grabbi-backend/middleware"
 	"grabbi-backend/models"
+	"grabbi-backend/payments"
 
 	"github.com/google/uuid"
 )
@@ -296,6 +303,7 @@ func TestCreateOrderInvalidFranchiseID(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req := authRequest("POST", "/api/orders", map[string]interface{}{
+		"payment_method":   "cash",
 		"delivery_address": "123 Test St",
 		"franchise_id":     "not-a-uuid",
 	}, token)
@@ -319,6 +327,7 @@ func TestCreateOrderFranchiseNotFound(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req := authRequest("POST", "/api/orders", map[string]interface{}{
+		"payment_method":   "cash",
 		"delivery_address": "123 Test St",
 		"franchise_id":     uuid.New().String(),
 	}, token)
@@ -344,6 +353,7 @@ func TestCreateOrderWithCoordinates(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req := authRequest("POST", "/api/orders", map[string]interface{}{
+		"payment_method":   "cash",
 		"delivery_address": "123 Test St",
 		"customer_lat":     51.5074,
 		"customer_lng":     -0.1278,
@@ -371,6 +381,7 @@ func TestCreateOrderNoFranchiseInRange(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req := authRequest("POST", "/api/orders", map[string]interface{}{
+		"payment_method":   "cash",
 		"delivery_address": "Far Away Place",
 		"customer_lat":     35.6762, // Tokyo
 		"customer_lng":     139.6503,
@@ -396,6 +407,7 @@ func TestCreateOrderInsufficientStock(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req := authRequest("POST", "/api/orders", map[string]interface{}{
+		"payment_method":   "cash",
 		"delivery_address": "123 Test St",
 	}, token)
 	router.ServeHTTP(w, req)
@@ -664,6 +676,7 @@ func TestCreateOrderWithFranchisePriceOverride(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req := authRequest("POST", "/api/orders", map[string]interface{}{
+		"payment_method":   "cash",
 		"delivery_address": "123 Test St",
 		"franchise_id":     franchise.ID.String(),
 	}, token)
@@ -682,3 +695,655 @@ func TestCreateOrderWithFranchisePriceOverride(t *testing.T) {
 		t.Errorf("expected subtotal 7.50 (overridden price), got %v", subtotal)
 	}
 }
+
+func TestCreateOrderIgnoresExpiredFranchisePromotion(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+
+	user, token := seedTestUser(db, "expiredpromo@test.com", "customer", nil)
+	owner, _ := seedTestUser(db, "expiredpromoowner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "ExpiredPromoFranch", owner.ID)
+	cat := seedCategory(db, "ExpiredPromoCat")
+	prod := seedProduct(db, "ExpiredPromoProd", cat.ID, 10.00)
+
+	fp := seedFranchiseProduct(db, franchise.ID, prod.ID)
+	db.Model(&fp).Updates(map[string]interface{}{
+		"promotion_price_override": 5.00,
+		"promotion_start_override": time.Now().Add(-48 * time.Hour),
+		"promotion_end_override":   time.Now().Add(-24 * time.Hour),
+	})
+	db.Create(&models.CartItem{ID: uuid.New(), UserID: user.ID, ProductID: prod.ID, FranchiseID: &franchise.ID, Quantity: 2})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{
+		"payment_method":   "cash",
+		"delivery_address": "123 Test St",
+		"franchise_id":     franchise.ID.String(),
+	}, token))
+	if w.Code != 201 {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if subtotal := parseResponse(w)["subtotal"]; subtotal != 20.0 {
+		t.Errorf("expected the retail price once the promotion has ended, got subtotal %v", subtotal)
+	}
+}
+
+// ==================== Payment Tests ====================
+
+// seedCartForPayment seeds a customer with one product in their cart and returns the token.
+func seedCartForPayment(t *testing.T) (models.User, models.Product, string) {
+	t.Helper()
+	db := testDB
+	user, token := seedTestUser(db, "payer-"+uuid.New().String()[:8]+"@test.com", "customer", nil)
+	cat := seedCategory(db, "PayCat")
+	prod := seedProduct(db, "Pay Product", cat.ID, 10.00)
+	db.Create(&models.CartItem{ID: uuid.New(), UserID: user.ID, ProductID: prod.ID, Quantity: 3})
+	return user, prod, token
+}
+
+func TestCreateOrderAuthorizesPaymentAndConfirms(t *testing.T) {
+	db := freshDB()
+	provider := payments.NewFakeProvider()
+	router := setupOrderRouterWithPayments(db, provider)
+	_, _, token := seedCartForPayment(t)
+
+	body := map[string]interface{}{
+		"delivery_address": "1 Card St",
+		"payment_method":   "card",
+		"payment_token":    "tok_visa",
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	if resp["status"] != "confirmed" {
+		t.Errorf("expected status 'confirmed', got %v", resp["status"])
+	}
+
+	var payment models.Payment
+	if err := db.Where("order_id = ?", resp["id"]).First(&payment).Error; err != nil {
+		t.Fatalf("expected payment record: %v", err)
+	}
+	if payment.Status != models.PaymentStatusAuthorized {
+		t.Errorf("expected authorized payment, got %s", payment.Status)
+	}
+	if payment.Amount != resp["total"] {
+		t.Errorf("expected payment amount %v, got %v", resp["total"], payment.Amount)
+	}
+	if payment.Provider != "fake" || payment.ProviderReference == "" {
+		t.Errorf("expected provider details, got %q/%q", payment.Provider, payment.ProviderReference)
+	}
+}
+
+func TestCreateOrderDeclinedPaymentLeavesCartAndStock(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouterWithPayments(db, payments.NewFakeProvider())
+	user, prod, token := seedCartForPayment(t)
+
+	body := map[string]interface{}{
+		"delivery_address": "1 Card St",
+		"payment_method":   "card",
+		"payment_token":    payments.DeclineToken,
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var orderCount, cartCount int64
+	db.Model(&models.Order{}).Where("user_id = ?", user.ID).Count(&orderCount)
+	db.Model(&models.CartItem{}).Where("user_id = ?", user.ID).Count(&cartCount)
+	if orderCount != 0 {
+		t.Errorf("expected no order to be created, got %d", orderCount)
+	}
+	if cartCount != 1 {
+		t.Errorf("expected cart to be kept, got %d items", cartCount)
+	}
+
+	var reloaded models.Product
+	db.First(&reloaded, "id = ?", prod.ID)
+	if reloaded.StockQuantity != 100 {
+		t.Errorf("expected stock to be untouched at 100, got %d", reloaded.StockQuantity)
+	}
+}
+
+func TestCreateOrderRejectsUnknownPaymentMethodOrMissingToken(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouterWithPayments(db, payments.NewFakeProvider())
+	user, _, token := seedCartForPayment(t)
+
+	for name, body := range map[string]map[string]interface{}{
+		"no method":          {"delivery_address": "1 Card St"},
+		"misspelled method":  {"delivery_address": "1 Card St", "payment_method": "crad", "payment_token": "tok_visa"},
+		"card without token": {"delivery_address": "1 Card St", "payment_method": "card"},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	var orderCount int64
+	db.Model(&models.Order{}).Where("user_id = ?", user.ID).Count(&orderCount)
+	if orderCount != 0 {
+		t.Errorf("expected no order to be created, got %d", orderCount)
+	}
+}
+
+func TestCreateOrderCashSkipsPaymentProvider(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouterWithPayments(db, payments.NewFakeProvider())
+	_, _, token := seedCartForPayment(t)
+
+	body := map[string]interface{}{
+		"delivery_address": "1 Cash St",
+		"payment_method":   "cash",
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	if resp["status"] != "pending" {
+		t.Errorf("expected cash order to stay 'pending', got %v", resp["status"])
+	}
+
+	var paymentCount int64
+	db.Model(&models.Payment{}).Where("order_id = ?", resp["id"]).Count(&paymentCount)
+	if paymentCount != 0 {
+		t.Errorf("expected no payment records for cash order, got %d", paymentCount)
+	}
+}
+
+// placePaidOrder creates a card order through the API and returns its ID.
+func placePaidOrder(t *testing.T, router http.Handler, token string) string {
+	t.Helper()
+	body := map[string]interface{}{
+		"delivery_address": "1 Card St",
+		"payment_method":   "card",
+		"payment_token":    "tok_visa",
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", body, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to place order: %d %s", w.Code, w.Body.String())
+	}
+	return parseResponse(w)["id"].(string)
+}
+
+func TestCancelOrderVoidsAuthorizedPayment(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouterWithPayments(db, payments.NewFakeProvider())
+	_, _, token := seedCartForPayment(t)
+	_, adminToken := seedTestUser(db, "payadmin@test.com", "admin", nil)
+
+	orderID := placePaidOrder(t, router, token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+orderID+"/status", map[string]string{"status": "cancelled"}, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var payment models.Payment
+	db.Where("order_id = ?", orderID).First(&payment)
+	if payment.Status != models.PaymentStatusVoided {
+		t.Errorf("expected payment to be voided, got %s", payment.Status)
+	}
+}
+
+func TestOutForDeliveryCapturesAndCancelRefunds(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouterWithPayments(db, payments.NewFakeProvider())
+	_, _, token := seedCartForPayment(t)
+	_, adminToken := seedTestUser(db, "payadmin@test.com", "admin", nil)
+
+	orderID := placePaidOrder(t, router, token)
+
+	for _, status := range []string{"preparing", "ready", "out_for_delivery"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+orderID+"/status", map[string]string{"status": status}, adminToken))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d: %s", status, w.Code, w.Body.String())
+		}
+	}
+
+	var payment models.Payment
+	db.Where("order_id = ?", orderID).First(&payment)
+	if payment.Status != models.PaymentStatusCaptured {
+		t.Fatalf("expected payment to be captured, got %s", payment.Status)
+	}
+	if payment.CapturedAmount != payment.Amount {
+		t.Errorf("expected captured amount %.2f, got %.2f", payment.Amount, payment.CapturedAmount)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+orderID+"/status", map[string]string{"status": "cancelled"}, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	db.Where("order_id = ?", orderID).First(&payment)
+	if payment.Status != models.PaymentStatusRefunded {
+		t.Errorf("expected payment to be refunded, got %s", payment.Status)
+	}
+	if payment.RefundedAmount != payment.Amount {
+		t.Errorf("expected refunded amount %.2f, got %.2f", payment.Amount, payment.RefundedAmount)
+	}
+}
+
+// ==================== Timeline Tests ====================
+
+func TestUpdateOrderStatusRecordsTimeline(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	_, _, token := seedCartForPayment(t)
+	admin, adminToken := seedTestUser(db, "timelineadmin@test.com", "admin", nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{"payment_method": "cash", "delivery_address": "1 Time St"}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to place order: %d %s", w.Code, w.Body.String())
+	}
+	orderID := parseResponse(w)["id"].(string)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+orderID+"/status", map[string]string{"status": "confirmed", "note": "Checked stock"}, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/orders/"+orderID+"/timeline", nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	resp := parseResponse(w)
+	events, ok := resp["events"].([]interface{})
+	if !ok || len(events) != 2 {
+		t.Fatalf("expected 2 timeline events, got %v", resp["events"])
+	}
+
+	placed := events[0].(map[string]interface{})
+	if placed["from_status"] != "" || placed["to_status"] != "pending" || placed["actor_role"] != "customer" {
+		t.Errorf("unexpected placement event: %v", placed)
+	}
+	if _, ok := placed["duration_seconds"]; !ok {
+		t.Error("expected duration for completed stage")
+	}
+
+	confirmed := events[1].(map[string]interface{})
+	if confirmed["from_status"] != "pending" || confirmed["to_status"] != "confirmed" {
+		t.Errorf("unexpected transition event: %v", confirmed)
+	}
+	if confirmed["actor_id"] != admin.ID.String() || confirmed["actor_role"] != "admin" {
+		t.Errorf("expected admin actor, got %v / %v", confirmed["actor_id"], confirmed["actor_role"])
+	}
+	if confirmed["note"] != "Checked stock" {
+		t.Errorf("expected note, got %v", confirmed["note"])
+	}
+	if _, ok := confirmed["duration_seconds"]; ok {
+		t.Error("expected no duration for the current status")
+	}
+}
+
+func TestOrderTimelineHiddenFromOtherCustomers(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+
+	user, _ := seedTestUser(db, "owner@test.com", "customer", nil)
+	_, otherToken := seedTestUser(db, "other@test.com", "customer", nil)
+	cat := seedCategory(db, "TimelineCat")
+	prod := seedProduct(db, "Timeline Product", cat.ID, 5.00)
+	franchise := seedFranchise(db, "Timeline Store", user.ID)
+	order := seedOrder(db, user.ID, franchise.ID, prod.ID)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/orders/"+order.ID.String()+"/timeline", nil, otherToken))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFranchiseStatusUpdateRecordsTimeline(t *testing.T) {
+	db := freshDB()
+	portal := setupFranchisePortalRouter(db)
+	router := setupOrderRouter(db)
+
+	customer, _ := seedTestUser(db, "cust@test.com", "customer", nil)
+	cat := seedCategory(db, "TimelineCat")
+	prod := seedProduct(db, "Timeline Product", cat.ID, 5.00)
+	franchise := seedFranchise(db, "Timeline Store", customer.ID)
+	order := seedOrder(db, customer.ID, franchise.ID, prod.ID)
+	owner, ownerToken := seedFranchiseOwnerWithToken(db, franchise)
+
+	w := httptest.NewRecorder()
+	portal.ServeHTTP(w, authRequest("PUT", "/api/franchise/orders/"+order.ID.String()+"/status", map[string]string{"status": "confirmed"}, ownerToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/orders/"+order.ID.String()+"/timeline", nil, ownerToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	events := parseResponse(w)["events"].([]interface{})
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	event := events[0].(map[string]interface{})
+	if event["actor_id"] != owner.ID.String() || event["actor_role"] != "franchise_owner" {
+		t.Errorf("expected franchise owner actor, got %v / %v", event["actor_id"], event["actor_role"])
+	}
+}
+
+// openEventStream connects to a server-sent events endpoint on a live test
+// server. The subscription is registered once the response headers arrive.
+func openEventStream(t *testing.T, serverURL, path, token string) (*bufio.Reader, func()) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	req, _ := http.NewRequestWithContext(ctx, "GET", serverURL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		cancel()
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("expected text/event-stream, got %q", ct)
+	}
+	return bufio.NewReader(resp.Body), func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+// nextSSEEvent reads the next named event from the stream, skipping heartbeats.
+func nextSSEEvent(t *testing.T, r *bufio.Reader) (string, map[string]interface{}) {
+	t.Helper()
+	var name, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended before an event arrived: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		case line == "" && name != "":
+			var payload map[string]interface{}
+			if err := json.Unmarshal([]byte(data), &payload); err != nil {
+				t.Fatalf("invalid event data %q: %v", data, err)
+			}
+			return name, payload
+		}
+	}
+}
+
+func TestStreamOrderEventsSendsSnapshotThenUpdates(t *testing.T) {
+	db := freshDB()
+	server := httptest.NewServer(setupOrderRouter(db))
+	defer server.Close()
+
+	user, token := seedTestUser(db, "streamer@test.com", "customer", nil)
+	_, adminToken := seedTestUser(db, "streamadmin@test.com", "admin", nil)
+	cat := seedCategory(db, "StreamCat")
+	prod := seedProduct(db, "Stream Product", cat.ID, 5.00)
+	franchise := seedFranchise(db, "Stream Store", user.ID)
+	order := seedOrder(db, user.ID, franchise.ID, prod.ID)
+
+	stream, closeStream := openEventStream(t, server.URL, "/api/orders/"+order.ID.String()+"/events", token)
+	defer closeStream()
+
+	name, payload := nextSSEEvent(t, stream)
+	if name != "order.snapshot" || payload["status"] != "pending" {
+		t.Fatalf("expected pending snapshot, got %s %v", name, payload)
+	}
+
+	w := httptest.NewRecorder()
+	setupOrderRouter(db).ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+order.ID.String()+"/status", map[string]string{"status": "confirmed"}, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	name, payload = nextSSEEvent(t, stream)
+	if name != "order.status_changed" {
+		t.Errorf("expected order.status_changed, got %s", name)
+	}
+	if payload["status"] != "confirmed" || payload["order_id"] != order.ID.String() {
+		t.Errorf("unexpected update payload: %v", payload)
+	}
+}
+
+func TestStreamOrderEventsHiddenFromOtherCustomers(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+
+	user, _ := seedTestUser(db, "streamowner@test.com", "customer", nil)
+	_, otherToken := seedTestUser(db, "streamother@test.com", "customer", nil)
+	cat := seedCategory(db, "StreamHiddenCat")
+	prod := seedProduct(db, "Stream Hidden Product", cat.ID, 5.00)
+	franchise := seedFranchise(db, "Stream Hidden Store", user.ID)
+	order := seedOrder(db, user.ID, franchise.ID, prod.ID)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/orders/"+order.ID.String()+"/events", nil, otherToken))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFranchiseOrderStreamOnlyReceivesOwnStore(t *testing.T) {
+	db := freshDB()
+	server := httptest.NewServer(setupOrderRouter(db))
+	defer server.Close()
+
+	customer, _ := seedTestUser(db, "streamcustomer@test.com", "customer", nil)
+	_, adminToken := seedTestUser(db, "franchisestreamadmin@test.com", "admin", nil)
+	cat := seedCategory(db, "FranchiseStreamCat")
+	prod := seedProduct(db, "Franchise Stream Product", cat.ID, 5.00)
+	ownStore := seedFranchise(db, "Own Stream Store", customer.ID)
+	otherStore := seedFranchise(db, "Other Stream Store", customer.ID)
+	_, ownerToken := seedFranchiseOwnerWithToken(db, ownStore)
+
+	otherOrder := seedOrder(db, customer.ID, otherStore.ID, prod.ID)
+	ownOrder := seedOrder(db, customer.ID, ownStore.ID, prod.ID)
+
+	stream, closeStream := openEventStream(t, server.URL, "/api/franchise/orders/stream", ownerToken)
+	defer closeStream()
+
+	// The other store's update is published first, so it would be read first if leaked
+	for _, order := range []models.Order{otherOrder, ownOrder} {
+		w := httptest.NewRecorder()
+		setupOrderRouter(db).ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+order.ID.String()+"/status", map[string]string{"status": "confirmed"}, adminToken))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	_, payload := nextSSEEvent(t, stream)
+	if payload["order_id"] != ownOrder.ID.String() {
+		t.Errorf("expected update for own store's order %s, got %v", ownOrder.ID, payload["order_id"])
+	}
+}
+
+func TestCreateOrderRetryWithIdempotencyKeyReplaysOrder(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _, token := seedCartForPayment(t)
+
+	place := func() *httptest.ResponseRecorder {
+		req := authRequest("POST", "/api/orders", map[string]string{"payment_method": "cash", "delivery_address": "1 Retry Rd"}, token)
+		req.Header.Set(middleware.IdempotencyKeyHeader, "checkout-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := place()
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", first.Code, first.Body.String())
+	}
+	// The cart is empty now, so a retry that ran again would fail
+	second := place()
+	if second.Code != http.StatusCreated {
+		t.Fatalf("expected replayed 201, got %d: %s", second.Code, second.Body.String())
+	}
+	if parseResponse(second)["id"] != parseResponse(first)["id"] {
+		t.Errorf("expected the same order to be returned")
+	}
+
+	var count int64
+	db.Model(&models.Order{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("expected 1 order, got %d", count)
+	}
+}
+
+// placeCollectionOrder checks out the cart for collection from the franchise.
+func placeCollectionOrder(t *testing.T, router http.Handler, token string, franchiseID uuid.UUID) map[string]interface{} {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+		"payment_method":  "cash",
+		"fulfilment_type": "collection",
+		"franchise_id":    franchiseID.String(),
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to place collection order: %d %s", w.Code, w.Body.String())
+	}
+	return parseResponse(w)
+}
+
+func TestCreateCollectionOrderSkipsDeliveryFee(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _, token := seedCartForPayment(t)
+	franchise := seedFranchise(db, "Collect Store", user.ID)
+
+	resp := placeCollectionOrder(t, router, token, franchise.ID)
+
+	if resp["fulfilment_type"] != "collection" {
+		t.Errorf("expected collection order, got %v", resp["fulfilment_type"])
+	}
+	if resp["delivery_fee"] != float64(0) || resp["total"] != float64(30) {
+		t.Errorf("expected no delivery fee and total 30, got fee %v total %v", resp["delivery_fee"], resp["total"])
+	}
+	code, _ := resp["pickup_code"].(string)
+	if len(code) != 6 {
+		t.Errorf("expected a six-digit pickup code, got %q", code)
+	}
+}
+
+func TestCreateCollectionOrderRequiresFranchise(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	_, _, token := seedCartForPayment(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{"payment_method": "cash", "fulfilment_type": "collection"}, token))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateOrderRejectsUnknownFulfilmentType(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	_, _, token := seedCartForPayment(t)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{
+		"payment_method":   "cash",
+		"fulfilment_type":  "drone",
+		"delivery_address": "1 Sky Rd",
+	}, token))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCollectionOrderHandoverRequiresPickupCode(t *testing.T) {
+	db := freshDB()
+	orderRouter := setupOrderRouter(db)
+	portalRouter := setupFranchisePortalRouter(db)
+	user, _, token := seedCartForPayment(t)
+	franchise := seedFranchise(db, "Handover Store", user.ID)
+	_, ownerToken := seedFranchiseOwnerWithToken(db, franchise)
+
+	resp := placeCollectionOrder(t, orderRouter, token, franchise.ID)
+	orderID := resp["id"].(string)
+	pickupCode := resp["pickup_code"].(string)
+
+	setStatus := func(body map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		portalRouter.ServeHTTP(w, authRequest("PUT", "/api/franchise/orders/"+orderID+"/status", body, ownerToken))
+		return w
+	}
+
+	for _, status := range []string{"confirmed", "preparing"} {
+		if w := setStatus(map[string]string{"status": status}); w.Code != http.StatusOK {
+			t.Fatalf("failed to move to %s: %d %s", status, w.Code, w.Body.String())
+		}
+	}
+	if w := setStatus(map[string]string{"status": "ready"}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected delivery path to be rejected, got %d", w.Code)
+	}
+	w := setStatus(map[string]string{"status": "ready_for_collection"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if parseResponse(w)["pickup_code"] != nil {
+		t.Error("expected pickup code to be hidden from store staff")
+	}
+
+	if w := setStatus(map[string]string{"status": "collected", "pickup_code": "000000x"}); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a wrong code, got %d", w.Code)
+	}
+	if w := setStatus(map[string]string{"status": "collected", "pickup_code": pickupCode}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var order models.Order
+	db.First(&order, "id = ?", orderID)
+	if order.Status != models.OrderStatusCollected {
+		t.Errorf("expected collected, got %s", order.Status)
+	}
+}
+
+func TestDeliveryOrderCannotUseCollectionPath(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	user, _ := seedTestUser(db, "deliverypath@test.com", "customer", nil)
+	_, adminToken := seedTestUser(db, "deliverypathadmin@test.com", "admin", nil)
+	cat := seedCategory(db, "DeliveryPathCat")
+	prod := seedProduct(db, "Delivery Path Product", cat.ID, 5.00)
+	franchise := seedFranchise(db, "Delivery Path Store", user.ID)
+	order := seedOrder(db, user.ID, franchise.ID, prod.ID)
+	db.Model(&order).Update("status", models.OrderStatusPreparing)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+order.ID.String()+"/status", map[string]string{"status": "ready_for_collection"}, adminToken))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
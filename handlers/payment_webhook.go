@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"grabbi-backend/models"
+	"grabbi-backend/payments"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PaymentWebhookHandler struct {
+	DB     *gorm.DB
+	Secret string
+}
+
+// HandlePaymentWebhook receives asynchronous notifications from the payment provider.
+// Every event is stored by its ID so redeliveries are acknowledged without being
+// applied twice, and order status only moves along models.AllowedTransitions.
+func (h *PaymentWebhookHandler) HandlePaymentWebhook(c *gin.Context) {
+	if h.Secret == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment webhooks are not configured"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	timestamp := c.GetHeader(payments.TimestampHeader)
+	signature := c.GetHeader(payments.SignatureHeader)
+	if err := payments.VerifyWebhook(h.Secret, timestamp, signature, body, time.Now()); err != nil {
+		log.Printf("Rejected payment webhook: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+
+	var event payments.WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.ID == "" || event.Type == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+		return
+	}
+
+	tx := h.DB.Begin()
+
+	var seen int64
+	tx.Model(&models.PaymentEvent{}).Where("event_id = ?", event.ID).Count(&seen)
+	if seen > 0 {
+		tx.Rollback()
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+		return
+	}
+
+	record := models.PaymentEvent{
+		EventID:           event.ID,
+		Type:              event.Type,
+		ProviderReference: event.Data.Reference,
+		Payload:           string(body),
+	}
+
+	outcome, changed, err := applyPaymentEvent(tx, event, &record)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Failed to process payment webhook %s: %v", event.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
+		return
+	}
+	record.Outcome = outcome
+
+	if err := tx.Create(&record).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record webhook"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record webhook"})
+		return
+	}
+
+	if changed != nil {
+		publishOrderUpdate(*changed, utils.OrderUpdateStatusChanged)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": outcome})
+}
+
+// applyPaymentEvent updates the payment and its order for a verified event and
+// returns the outcome to record, along with the order if its status changed.
+// Events for unknown payments, unknown types or transitions the order can no
+// longer make are ignored rather than rejected, so the provider stops
+// redelivering them.
+func applyPaymentEvent(tx *gorm.DB, event payments.WebhookEvent, record *models.PaymentEvent) (string, *models.Order, error) {
+	var payment models.Payment
+	if err := tx.Where("provider_reference = ?", event.Data.Reference).First(&payment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.PaymentEventIgnored, nil, nil
+		}
+		return "", nil, err
+	}
+	record.OrderID = &payment.OrderID
+
+	var order models.Order
+	if err := tx.Where("id = ?", payment.OrderID).First(&order).Error; err != nil {
+		return "", nil, err
+	}
+	fromStatus := order.Status
+	var changed *models.Order
+
+	switch event.Type {
+	case payments.EventPaymentSucceeded:
+		if !models.IsValidTransition(order.Status, models.OrderStatusConfirmed) {
+			return models.PaymentEventIgnored, nil, nil
+		}
+		if err := tx.Model(&order).Update("status", models.OrderStatusConfirmed).Error; err != nil {
+			return "", nil, err
+		}
+		if err := recordStatusEvent(tx, order.ID, fromStatus, models.OrderStatusConfirmed, nil, "system", "Payment succeeded"); err != nil {
+			return "", nil, err
+		}
+		changed = &order
+
+	case payments.EventPaymentFailed:
+		payment.Status = models.PaymentStatusFailed
+		payment.FailureReason = event.Data.Reason
+		if err := tx.Save(&payment).Error; err != nil {
+			return "", nil, err
+		}
+		// The money never arrived, so the order cannot go ahead
+		if models.IsValidTransition(order.Status, models.OrderStatusCancelled) {
+			if err := tx.Model(&order).Update("status", models.OrderStatusCancelled).Error; err != nil {
+				return "", nil, err
+			}
+			if err := recordStatusEvent(tx, order.ID, fromStatus, models.OrderStatusCancelled, nil, "system", "Payment failed"); err != nil {
+				return "", nil, err
+			}
+			releaseCancelledOrder(tx, order)
+			changed = &order
+		}
+
+	case payments.EventPaymentDisputed:
+		// Disputes are resolved by staff; the order itself is left where it is
+		payment.Status = models.PaymentStatusDisputed
+		if err := tx.Save(&payment).Error; err != nil {
+			return "", nil, err
+		}
+		log.Printf("Payment %s for order %s disputed: %s", payment.ID, order.ID, event.Data.Reason)
+
+	default:
+		return models.PaymentEventIgnored, nil, nil
+	}
+
+	return models.PaymentEventProcessed, changed, nil
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"grabbi-backend/models"
+	"grabbi-backend/payments"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// signedWebhookRequest builds a webhook request signed with testWebhookSecret.
+func signedWebhookRequest(event map[string]interface{}) *http.Request {
+	body, _ := json.Marshal(event)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest("POST", "/api/webhooks/payments", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(payments.TimestampHeader, ts)
+	req.Header.Set(payments.SignatureHeader, payments.SignWebhook(testWebhookSecret, ts, body))
+	return req
+}
+
+func webhookEvent(id, eventType, reference string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   id,
+		"type": eventType,
+		"data": map[string]interface{}{"reference": reference, "reason": "insufficient_funds"},
+	}
+}
+
+// seedOrderWithPayment creates an order in the given status with one authorized card payment.
+func seedOrderWithPayment(db *gorm.DB, status models.OrderStatus) (models.Order, models.Payment, models.Product) {
+	user, _ := seedTestUser(db, "hook-"+uuid.New().String()[:8]+"@test.com", "customer", nil)
+	cat := seedCategory(db, "HookCat")
+	prod := seedProduct(db, "Hook Product", cat.ID, 10.00)
+	franchise := seedFranchise(db, "Hook Store", user.ID)
+
+	order := seedOrder(db, user.ID, franchise.ID, prod.ID)
+	db.Model(&order).Update("status", status)
+
+	payment := models.Payment{
+		ID:                uuid.New(),
+		OrderID:           order.ID,
+		Provider:          "fake",
+		ProviderReference: "fake_" + uuid.New().String(),
+		Method:            models.PaymentMethodCard,
+		Amount:            order.Total,
+		Currency:          "GBP",
+		Status:            models.PaymentStatusAuthorized,
+	}
+	db.Create(&payment)
+	return order, payment, prod
+}
+
+func TestPaymentWebhookSucceededConfirmsPendingOrder(t *testing.T) {
+	db := freshDB()
+	router := setupPaymentWebhookRouter(db, testWebhookSecret)
+	order, payment, _ := seedOrderWithPayment(db, models.OrderStatusPending)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedWebhookRequest(webhookEvent("evt_ok", payments.EventPaymentSucceeded, payment.ProviderReference)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp := parseResponse(w); resp["status"] != models.PaymentEventProcessed {
+		t.Errorf("expected 'processed', got %v", resp["status"])
+	}
+
+	var reloaded models.Order
+	db.First(&reloaded, "id = ?", order.ID)
+	if reloaded.Status != models.OrderStatusConfirmed {
+		t.Errorf("expected order to be confirmed, got %s", reloaded.Status)
+	}
+
+	var event models.PaymentEvent
+	if err := db.Where("event_id = ?", "evt_ok").First(&event).Error; err != nil {
+		t.Fatalf("expected event to be stored: %v", err)
+	}
+	if event.OrderID == nil || *event.OrderID != order.ID {
+		t.Errorf("expected event linked to order %s, got %v", order.ID, event.OrderID)
+	}
+}
+
+func TestPaymentWebhookFailedCancelsOrderAndRestoresStock(t *testing.T) {
+	db := freshDB()
+	router := setupPaymentWebhookRouter(db, testWebhookSecret)
+	order, payment, prod := seedOrderWithPayment(db, models.OrderStatusConfirmed)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedWebhookRequest(webhookEvent("evt_fail", payments.EventPaymentFailed, payment.ProviderReference)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var reloadedOrder models.Order
+	db.First(&reloadedOrder, "id = ?", order.ID)
+	if reloadedOrder.Status != models.OrderStatusCancelled {
+		t.Errorf("expected order to be cancelled, got %s", reloadedOrder.Status)
+	}
+
+	var reloadedPayment models.Payment
+	db.First(&reloadedPayment, "id = ?", payment.ID)
+	if reloadedPayment.Status != models.PaymentStatusFailed {
+		t.Errorf("expected payment to be failed, got %s", reloadedPayment.Status)
+	}
+	if reloadedPayment.FailureReason != "insufficient_funds" {
+		t.Errorf("expected failure reason to be recorded, got %q", reloadedPayment.FailureReason)
+	}
+
+	var reloadedProduct models.Product
+	db.First(&reloadedProduct, "id = ?", prod.ID)
+	if reloadedProduct.StockQuantity != prod.StockQuantity+1 {
+		t.Errorf("expected stock restored to %d, got %d", prod.StockQuantity+1, reloadedProduct.StockQuantity)
+	}
+}
+
+func TestPaymentWebhookDisputedLeavesOrderStatus(t *testing.T) {
+	db := freshDB()
+	router := setupPaymentWebhookRouter(db, testWebhookSecret)
+	order, payment, _ := seedOrderWithPayment(db, models.OrderStatusDelivered)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedWebhookRequest(webhookEvent("evt_dispute", payments.EventPaymentDisputed, payment.ProviderReference)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var reloadedPayment models.Payment
+	db.First(&reloadedPayment, "id = ?", payment.ID)
+	if reloadedPayment.Status != models.PaymentStatusDisputed {
+		t.Errorf("expected payment to be disputed, got %s", reloadedPayment.Status)
+	}
+
+	var reloadedOrder models.Order
+	db.First(&reloadedOrder, "id = ?", order.ID)
+	if reloadedOrder.Status != models.OrderStatusDelivered {
+		t.Errorf("expected order to stay delivered, got %s", reloadedOrder.Status)
+	}
+}
+
+func TestPaymentWebhookInvalidTransitionIsIgnored(t *testing.T) {
+	db := freshDB()
+	router := setupPaymentWebhookRouter(db, testWebhookSecret)
+	order, payment, _ := seedOrderWithPayment(db, models.OrderStatusDelivered)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedWebhookRequest(webhookEvent("evt_late", payments.EventPaymentSucceeded, payment.ProviderReference)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp := parseResponse(w); resp["status"] != models.PaymentEventIgnored {
+		t.Errorf("expected 'ignored', got %v", resp["status"])
+	}
+
+	var reloaded models.Order
+	db.First(&reloaded, "id = ?", order.ID)
+	if reloaded.Status != models.OrderStatusDelivered {
+		t.Errorf("expected order to stay delivered, got %s", reloaded.Status)
+	}
+}
+
+func TestPaymentWebhookDuplicateEventProcessedOnce(t *testing.T) {
+	db := freshDB()
+	router := setupPaymentWebhookRouter(db, testWebhookSecret)
+	_, payment, prod := seedOrderWithPayment(db, models.OrderStatusConfirmed)
+	event := webhookEvent("evt_dup", payments.EventPaymentFailed, payment.ProviderReference)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedWebhookRequest(event))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, signedWebhookRequest(event))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for redelivery, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp := parseResponse(w); resp["status"] != "duplicate" {
+		t.Errorf("expected 'duplicate', got %v", resp["status"])
+	}
+
+	var eventCount int64
+	db.Model(&models.PaymentEvent{}).Where("event_id = ?", "evt_dup").Count(&eventCount)
+	if eventCount != 1 {
+		t.Errorf("expected 1 stored event, got %d", eventCount)
+	}
+
+	var reloadedProduct models.Product
+	db.First(&reloadedProduct, "id = ?", prod.ID)
+	if reloadedProduct.StockQuantity != prod.StockQuantity+1 {
+		t.Errorf("expected stock restored once to %d, got %d", prod.StockQuantity+1, reloadedProduct.StockQuantity)
+	}
+}
+
+func TestPaymentWebhookUnknownReferenceIsIgnored(t *testing.T) {
+	db := freshDB()
+	router := setupPaymentWebhookRouter(db, testWebhookSecret)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedWebhookRequest(webhookEvent("evt_unknown", payments.EventPaymentSucceeded, "fake_missing")))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp := parseResponse(w); resp["status"] != models.PaymentEventIgnored {
+		t.Errorf("expected 'ignored', got %v", resp["status"])
+	}
+}
+
+func TestPaymentWebhookRejectsBadSignature(t *testing.T) {
+	db := freshDB()
+	router := setupPaymentWebhookRouter(db, testWebhookSecret)
+	order, payment, _ := seedOrderWithPayment(db, models.OrderStatusPending)
+
+	req := signedWebhookRequest(webhookEvent("evt_forged", payments.EventPaymentSucceeded, payment.ProviderReference))
+	req.Header.Set(payments.SignatureHeader, "deadbeef")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var reloaded models.Order
+	db.First(&reloaded, "id = ?", order.ID)
+	if reloaded.Status != models.OrderStatusPending {
+		t.Errorf("expected order to stay pending, got %s", reloaded.Status)
+	}
+}
+
+func TestPaymentWebhookRejectsReplayedRequest(t *testing.T) {
+	db := freshDB()
+	router := setupPaymentWebhookRouter(db, testWebhookSecret)
+
+	body := []byte(`{"id":"evt_old","type":"payment.succeeded","data":{"reference":"fake_x"}}`)
+	ts := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	req := httptest.NewRequest("POST", "/api/webhooks/payments", bytes.NewReader(body))
+	req.Header.Set(payments.TimestampHeader, ts)
+	req.Header.Set(payments.SignatureHeader, payments.SignWebhook(testWebhookSecret, ts, body))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPaymentWebhookNotConfigured(t *testing.T) {
+	db := freshDB()
+	router := setupPaymentWebhookRouter(db, "")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedWebhookRequest(webhookEvent("evt_x", payments.EventPaymentSucceeded, "fake_x")))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"grabbi-backend/models"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PricingRuleHandler manages basket deals. Admins manage every rule; franchise
+// staff manage only their own store's rules through the same handlers.
+type PricingRuleHandler struct {
+	DB *gorm.DB
+}
+
+// pricingRuleRequest is the body for creating or updating a pricing rule. On
+// update only the fields given are changed; giving one of product_id,
+// category_id or subcategory_id retargets the rule.
+type pricingRuleRequest struct {
+	Name          *string      `json:"name"`
+	Type          *string      `json:"type"`
+	ProductID     *uuid.UUID   `json:"product_id"`
+	CategoryID    *uuid.UUID   `json:"category_id"`
+	SubcategoryID *uuid.UUID   `json:"subcategory_id"`
+	Quantity      *int         `json:"quantity"`
+	FreeQuantity  *int         `json:"free_quantity"`
+	BundlePrice   *float64     `json:"bundle_price"`
+	Priority      *int         `json:"priority"`
+	StartsAt      *time.Time   `json:"starts_at"`
+	EndsAt        *time.Time   `json:"ends_at"`
+	FranchiseID   nullableUUID `json:"franchise_id"`
+	IsActive      *bool        `json:"is_active"`
+}
+
+// scopedPricingRules limits a pricing rule query to what the caller may manage.
+func (h *PricingRuleHandler) scopedPricingRules(c *gin.Context) *gorm.DB {
+	query := h.DB.Model(&models.PricingRule{})
+	if scope := portalFranchiseScope(c); scope != nil {
+		query = query.Where("franchise_id = ?", *scope)
+	}
+	return query
+}
+
+// applyPricingRuleRequest copies the given fields onto rule and checks the
+// result is a usable deal.
+func applyPricingRuleRequest(rule *models.PricingRule, req pricingRuleRequest) error {
+	if req.Name != nil {
+		rule.Name = *req.Name
+	}
+	if req.Type != nil {
+		rule.Type = models.PricingRuleType(*req.Type)
+	}
+	if req.ProductID != nil || req.CategoryID != nil || req.SubcategoryID != nil {
+		rule.ProductID, rule.CategoryID, rule.SubcategoryID = req.ProductID, req.CategoryID, req.SubcategoryID
+	}
+	if req.Quantity != nil {
+		rule.Quantity = *req.Quantity
+	}
+	if req.FreeQuantity != nil {
+		rule.FreeQuantity = *req.FreeQuantity
+	}
+	if req.BundlePrice != nil {
+		rule.BundlePrice = *req.BundlePrice
+	}
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+	if req.StartsAt != nil {
+		rule.StartsAt = req.StartsAt
+	}
+	if req.EndsAt != nil {
+		rule.EndsAt = req.EndsAt
+	}
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+
+	if rule.Name == "" {
+		return errors.New("name is required")
+	}
+	targets := 0
+	for _, id := range []*uuid.UUID{rule.ProductID, rule.CategoryID, rule.SubcategoryID} {
+		if id != nil {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return errors.New("exactly one of product_id, category_id or subcategory_id is required")
+	}
+	switch rule.Type {
+	case models.PricingRuleMultiBuy, models.PricingRuleMixAndMatch:
+		if rule.Type == models.PricingRuleMixAndMatch && rule.ProductID != nil {
+			return errors.New("mix_and_match rules need a category_id or subcategory_id")
+		}
+		if rule.Quantity < 2 {
+			return errors.New("quantity must be at least 2")
+		}
+		if rule.BundlePrice <= 0 {
+			return errors.New("bundle_price must be greater than 0")
+		}
+	case models.PricingRuleBOGO:
+		if rule.Quantity < 1 {
+			return errors.New("quantity must be at least 1")
+		}
+		if rule.FreeQuantity < 1 {
+			return errors.New("free_quantity must be at least 1")
+		}
+	default:
+		return errors.New("type must be 'multi_buy', 'bogo' or 'mix_and_match'")
+	}
+	if rule.StartsAt != nil && rule.EndsAt != nil && !rule.EndsAt.After(*rule.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+func (h *PricingRuleHandler) ListPricingRules(c *gin.Context) {
+	query := h.scopedPricingRules(c)
+	if franchiseID := c.Query("franchise_id"); franchiseID != "" && portalFranchiseScope(c) == nil {
+		query = query.Where("franchise_id = ?", franchiseID)
+	}
+
+	var rules []models.PricingRule
+	if err := query.Order("priority DESC, created_at DESC").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pricing rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *PricingRuleHandler) CreatePricingRule(c *gin.Context) {
+	var req pricingRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	rule := models.PricingRule{IsActive: true}
+	if err := applyPricingRuleRequest(&rule, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Franchise rules are always for the caller's own store
+	if scope := portalFranchiseScope(c); scope != nil {
+		rule.FranchiseID = scope
+	} else {
+		scope, err := adminFranchiseScope(h.DB, req.FranchiseID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		rule.FranchiseID = scope
+	}
+
+	if err := h.DB.Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create pricing rule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (h *PricingRuleHandler) UpdatePricingRule(c *gin.Context) {
+	var rule models.PricingRule
+	if err := h.scopedPricingRules(c).Where("id = ?", c.Param("id")).First(&rule).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pricing rule not found"})
+		return
+	}
+
+	var req pricingRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	if err := applyPricingRuleRequest(&rule, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Only admins move a rule between stores, or make it global with null
+	if req.FranchiseID.Set && portalFranchiseScope(c) == nil {
+		scope, err := adminFranchiseScope(h.DB, req.FranchiseID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		rule.FranchiseID = scope
+	}
+
+	if err := h.DB.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pricing rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *PricingRuleHandler) DeletePricingRule(c *gin.Context) {
+	var rule models.PricingRule
+	if err := h.scopedPricingRules(c).Where("id = ?", c.Param("id")).First(&rule).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pricing rule not found"})
+		return
+	}
+
+	if err := h.DB.Delete(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pricing rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Pricing rule deleted"})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+)
+
+func TestAdminCreatePricingRule(t *testing.T) {
+	db := freshDB()
+	router := setupPricingRuleRouter(db)
+	_, token := seedTestUser(db, "ruleadmin@test.com", "admin", nil)
+	cat := seedCategory(db, "RuleCat")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/pricing-rules", map[string]interface{}{
+		"name": "Any 3 for £5", "type": "mix_and_match", "category_id": cat.ID.String(), "quantity": 3, "bundle_price": 5,
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	ruleID := parseResponse(w)["id"].(string)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/pricing-rules", map[string]interface{}{
+		"name": "Broken", "type": "bogo", "category_id": cat.ID.String(), "quantity": 1,
+	}, token))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a BOGO without free_quantity, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/admin/pricing-rules/"+ruleID, map[string]interface{}{"is_active": false}, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if parseResponse(w)["is_active"] != false {
+		t.Errorf("expected the rule to be deactivated, got %v", parseResponse(w)["is_active"])
+	}
+
+	// Admins scope a rule to an existing store, and clear the scope with null
+	owner, _ := seedTestUser(db, "ruleadmin-owner@test.com", "customer", nil)
+	franchise := seedFranchise(db, "Rule Scope Store", owner.ID)
+	for _, step := range []struct {
+		franchiseID interface{}
+		status      int
+		want        interface{}
+	}{
+		{uuid.New().String(), http.StatusBadRequest, nil},
+		{franchise.ID.String(), http.StatusOK, franchise.ID.String()},
+		{nil, http.StatusOK, nil},
+	} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("PUT", "/api/admin/pricing-rules/"+ruleID, map[string]interface{}{"franchise_id": step.franchiseID}, token))
+		if w.Code != step.status {
+			t.Errorf("franchise_id %v: expected %d, got %d: %s", step.franchiseID, step.status, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code == http.StatusOK && parseResponse(w)["franchise_id"] != step.want {
+			t.Errorf("franchise_id %v: expected %v on the rule, got %v", step.franchiseID, step.want, parseResponse(w)["franchise_id"])
+		}
+	}
+}
+
+func TestFranchisePricingRulesAreScopedToStore(t *testing.T) {
+	db := freshDB()
+	router := setupPricingRuleRouter(db)
+	owner, _ := seedTestUser(db, "ruleowner@test.com", "customer", nil)
+	franchise := seedFranchise(db, "Rule Store", owner.ID)
+	other := seedFranchise(db, "Other Rule Store", owner.ID)
+	_, token := seedFranchiseOwnerWithToken(db, franchise)
+	cat := seedCategory(db, "StoreRuleCat")
+	product := seedProduct(db, "Rule Product", cat.ID, 2)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/franchise/pricing-rules", map[string]interface{}{
+		"name": "BOGOF", "type": "bogo", "product_id": product.ID.String(), "quantity": 1, "free_quantity": 1,
+		"franchise_id": other.ID.String(),
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if parseResponse(w)["franchise_id"] != franchise.ID.String() {
+		t.Errorf("expected the rule to belong to the caller's store, got %v", parseResponse(w)["franchise_id"])
+	}
+
+	otherRule := models.PricingRule{Name: "Other", Type: models.PricingRuleBOGO, ProductID: &product.ID, Quantity: 1, FreeQuantity: 1, FranchiseID: &other.ID, IsActive: true}
+	db.Create(&otherRule)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("DELETE", "/api/franchise/pricing-rules/"+otherRule.ID.String(), nil, token))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another store's rule, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/franchise/pricing-rules", nil, token))
+	if rules := parseResponseArray(w); len(rules) != 1 {
+		t.Errorf("expected only the store's own rule, got %d", len(rules))
+	}
+}
+
+func TestCreateOrderItemisesPricingRuleDiscounts(t *testing.T) {
+	db := freshDB()
+	router := setupOrderRouter(db)
+	_, product, token := seedCartForPayment(t)
+	rule := models.PricingRule{Name: "Buy one get one free", Type: models.PricingRuleBOGO, ProductID: &product.ID, Quantity: 1, FreeQuantity: 1, IsActive: true}
+	db.Create(&rule)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]string{"payment_method": "cash", "delivery_address": "1 Deal St"}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// 3 x 10.00 with one free, and free delivery over 20.00
+	resp := parseResponse(w)
+	if resp["discount"] != 10.0 || resp["total"] != 20.0 {
+		t.Errorf("expected discount 10.00 and total 20.00, got %v and %v", resp["discount"], resp["total"])
+	}
+	item := resp["items"].([]interface{})[0].(map[string]interface{})
+	if item["discount"] != 10.0 {
+		t.Errorf("expected the item to carry the discount, got %v", item["discount"])
+	}
+	discounts, _ := item["discounts"].([]interface{})
+	if len(discounts) != 1 || discounts[0].(map[string]interface{})["pricing_rule_id"] != rule.ID.String() {
+		t.Errorf("expected the deal to be itemised on the item, got %v", item["discounts"])
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"grabbi-backend/firebase"
 	"grabbi-backend/models"
+	"grabbi-backend/pricing"
 	"grabbi-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -35,6 +36,13 @@ func generateSKU(db *gorm.DB) (string, error) {
 	return sku, nil
 }
 
+// franchiseProductListing is a product as a franchise sells it, with the
+// franchise's overrides merged in and the price it sells for right now.
+type franchiseProductListing struct {
+	models.Product
+	CurrentPrice float64 `json:"current_price"`
+}
+
 func (h *ProductHandler) GetProducts(c *gin.Context) {
 	// If franchise_id provided, return products with franchise overrides merged
 	if franchiseID := c.Query("franchise_id"); franchiseID != "" {
@@ -57,21 +65,25 @@ func (h *ProductHandler) GetProducts(c *gin.Context) {
 		}
 
 		// Return products with overrides applied
-		var products []models.Product
+		now := time.Now()
+		var products []franchiseProductListing
 		for _, fp := range fps {
 			p := fp.Product
+			price := pricing.EffectivePrice(p, &fp, now)
 			if fp.RetailPriceOverride != nil {
 				p.RetailPrice = *fp.RetailPriceOverride
+				// The store's own price replaces a catalogue promotion
+				p.PromotionPrice, p.PromotionStart, p.PromotionEnd = nil, nil, nil
 			}
-			if fp.PromotionPriceOverride != nil {
-				p.PromotionPrice = fp.PromotionPriceOverride
+			if fp.IsPromotionActiveAt(now) {
+				p.PromotionPrice, p.PromotionStart, p.PromotionEnd = fp.PromotionPriceOverride, fp.PromotionStartOverride, fp.PromotionEndOverride
 			}
 			p.StockQuantity = fp.StockQuantity
 			p.ReorderLevel = fp.ReorderLevel
 			if fp.ShelfLocation != "" {
 				p.ShelfLocation = fp.ShelfLocation
 			}
-			products = append(products, p)
+			products = append(products, franchiseProductListing{Product: p, CurrentPrice: price})
 		}
 		c.JSON(http.StatusOK, products)
 		return
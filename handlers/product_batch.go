@@ -431,6 +431,9 @@ func (h *ProductHandler) processBatchImport(job *dtos.BatchJob, products []dtos.
 		}
 	}
 
+	// Listings the import adds, whose stock may bring wishlisted products back
+	var stocked []franchiseAssoc
+
 	// Create new franchise associations (or restore soft-deleted ones)
 	if len(franchiseProductsToCreate) > 0 {
 		var fpRecords []models.FranchiseProduct
@@ -482,6 +485,7 @@ func (h *ProductHandler) processBatchImport(job *dtos.BatchJob, products []dtos.
 					log.Printf("Error restoring franchise product association %s: %v", fpID, err)
 				} else {
 					log.Printf("Restored soft-deleted franchise product association: Product %s -> Franchise %s", fa.productID, fa.franchiseID)
+					stocked = append(stocked, fa)
 					// Count as update for progress tracking
 					utils.Store.AddUpdated(job.ID)
 				}
@@ -494,6 +498,14 @@ func (h *ProductHandler) processBatchImport(job *dtos.BatchJob, products []dtos.
 				log.Printf("Error creating franchise product associations: %v", err)
 			} else {
 				log.Printf("Created %d new franchise product associations", len(fpRecords))
+				for _, fp := range fpRecords {
+					stocked = append(stocked, franchiseAssoc{
+						productID:     fp.ProductID,
+						franchiseID:   fp.FranchiseID,
+						stockQuantity: fp.StockQuantity,
+						isAvailable:   fp.IsAvailable,
+					})
+				}
 				// Count each new franchise association as an update for progress tracking
 				for range fpRecords {
 					utils.Store.AddUpdated(job.ID)
@@ -506,15 +518,38 @@ func (h *ProductHandler) processBatchImport(job *dtos.BatchJob, products []dtos.
 		}
 	}
 
+	for _, fa := range stocked {
+		if fa.isAvailable && fa.stockQuantity > 0 {
+			notifyBackInStock(h.DB, fa.productID, &fa.franchiseID)
+		}
+	}
+
 	utils.Store.UpdateJob(job.ID, func(j *dtos.BatchJob) {
 		j.Progress = 87
 	})
 
 	if len(productsToUpdate) > 0 {
+		// Note which products are out of stock before the update, to catch restocks
+		updatedIDs := make([]uuid.UUID, len(productsToUpdate))
+		for i, p := range productsToUpdate {
+			updatedIDs[i] = p.ID
+		}
+		var soldOutIDs []uuid.UUID
+		h.DB.Model(&models.Product{}).Where("id IN ? AND stock_quantity <= 0", updatedIDs).Pluck("id", &soldOutIDs)
+		soldOut := make(map[uuid.UUID]bool, len(soldOutIDs))
+		for _, id := range soldOutIDs {
+			soldOut[id] = true
+		}
+
 		if err := h.DB.Save(&productsToUpdate).Error; err != nil {
 			log.Printf("Error bulk updating products: %v", err)
 		} else {
 			log.Printf("Bulk updated %d products", len(productsToUpdate))
+			for _, p := range productsToUpdate {
+				if soldOut[p.ID] && p.StockQuantity > 0 {
+					notifyBackInStock(h.DB, p.ID, nil)
+				}
+			}
 		}
 	}
 
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"grabbi-backend/middleware"
 	"grabbi-backend/models"
@@ -648,6 +649,46 @@ func TestGetProductsWithFranchiseIDOverrides(t *testing.T) {
 	}
 }
 
+func TestGetProductsWithFranchiseIDHonoursPromotionWindow(t *testing.T) {
+	db := freshDB()
+	router := setupProductRouter(db)
+
+	owner, _ := seedTestUser(db, "promowindow@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "WindowStore", owner.ID)
+	cat := seedCategory(db, "WindowCat")
+	running := seedProduct(db, "Running Promo", cat.ID, 10.00)
+	ended := seedProduct(db, "Ended Promo", cat.ID, 10.00)
+	runningFP := seedFranchiseProduct(db, franchise.ID, running.ID)
+	endedFP := seedFranchiseProduct(db, franchise.ID, ended.ID)
+	db.Model(&runningFP).Updates(map[string]interface{}{
+		"promotion_price_override": 6.00,
+		"promotion_end_override":   time.Now().Add(24 * time.Hour),
+	})
+	db.Model(&endedFP).Updates(map[string]interface{}{
+		"retail_price_override":    9.00,
+		"promotion_price_override": 6.00,
+		"promotion_end_override":   time.Now().Add(-time.Hour),
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/api/products?franchise_id=%s", franchise.ID), nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	prices := make(map[string]map[string]interface{})
+	for _, item := range parseResponseArray(w) {
+		p := item.(map[string]interface{})
+		prices[p["item_name"].(string)] = p
+	}
+	if prices["Running Promo"]["current_price"] != 6.0 || prices["Running Promo"]["promotion_price"] != 6.0 {
+		t.Errorf("expected the running promotion at 6.00, got %v", prices["Running Promo"])
+	}
+	if prices["Ended Promo"]["current_price"] != 9.0 || prices["Ended Promo"]["promotion_price"] != nil {
+		t.Errorf("expected the ended promotion to fall back to 9.00, got %v", prices["Ended Promo"])
+	}
+}
+
 func TestCreateProductWithPromoFields(t *testing.T) {
 	db := freshDB()
 	router := setupProductRouter(db)
@@ -1468,54 +1509,5 @@ func TestGetProductsExportDBError(t *testing.T) {
 	}
 
 	// Recreate the products table for subsequent tests
-	db.Exec(`CREATE TABLE IF NOT EXISTS "products" (
-		"id" TEXT PRIMARY KEY,
-		"sku" TEXT NOT NULL UNIQUE,
-		"item_name" TEXT NOT NULL,
-		"short_description" TEXT,
-		"long_description" TEXT,
-		"cost_price" REAL NOT NULL,
-		"retail_price" REAL NOT NULL,
-		"promotion_price" REAL,
-		"promotion_start" DATETIME,
-		"promotion_end" DATETIME,
-		"gross_margin" REAL DEFAULT 0,
-		"staff_discount" REAL DEFAULT 0,
-		"tax_rate" REAL DEFAULT 0,
-		"batch_number" TEXT,
-		"barcode" TEXT,
-		"stock_quantity" INTEGER DEFAULT 0,
-		"reorder_level" INTEGER DEFAULT 0,
-		"shelf_location" TEXT,
-		"weight_volume" REAL DEFAULT 0,
-		"unit_of_measure" TEXT,
-		"expiry_date" DATETIME,
-		"category_id" TEXT NOT NULL,
-		"subcategory_id" TEXT,
-		"brand" TEXT,
-		"supplier" TEXT,
-		"country_of_origin" TEXT,
-		"is_gluten_free" INTEGER DEFAULT 0,
-		"is_vegetarian" INTEGER DEFAULT 0,
-		"is_vegan" INTEGER DEFAULT 0,
-		"is_age_restricted" INTEGER DEFAULT 0,
-		"minimum_age" INTEGER,
-		"allergen_info" TEXT,
-		"storage_type" TEXT,
-		"is_own_brand" INTEGER DEFAULT 0,
-		"online_visible" INTEGER DEFAULT 1,
-		"status" TEXT DEFAULT 'active',
-		"notes" TEXT,
-		"pack_size" TEXT,
-		"created_at" DATETIME,
-		"updated_at" DATETIME,
-		"deleted_at" DATETIME,
-		CONSTRAINT fk_products_category FOREIGN KEY ("category_id") REFERENCES "categories"("id")
-	)`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_deleted_at ON "products"("deleted_at")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_item_name ON "products"("item_name")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_category_id ON "products"("category_id")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_subcategory_id ON "products"("subcategory_id")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_status ON "products"("status")`)
-	db.Exec(`CREATE INDEX IF NOT EXISTS idx_products_stock_quantity ON "products"("stock_quantity")`)
+	createSQLiteTables(db)
 }
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"grabbi-backend/loyalty"
+	"grabbi-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ReferralHandler shows customers their referral code and admins how the
+// referral programme is doing.
+type ReferralHandler struct {
+	DB *gorm.DB
+}
+
+// GetMyReferral returns the caller's referral code, what each side of a
+// referral earns, and how the caller's referrals stand.
+func (h *ReferralHandler) GetMyReferral(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var user models.User
+	if err := h.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	code, err := loyalty.EnsureReferralCode(h.DB, &user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create referral code"})
+		return
+	}
+	summary, err := loyalty.SummariseReferrals(h.DB, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referrals"})
+		return
+	}
+	referrerPoints, refereePoints := loyalty.ReferralRewards()
+
+	c.JSON(http.StatusOK, gin.H{
+		"referral_code":   code,
+		"referrer_points": referrerPoints,
+		"referee_points":  refereePoints,
+		"referrals":       summary,
+	})
+}
+
+// ListReferrals is the admin referral report: every referral with both
+// customers, optionally filtered by status, and totals across all of them.
+func (h *ReferralHandler) ListReferrals(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	query := h.DB.Model(&models.Referral{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	customer := func(db *gorm.DB) *gorm.DB { return db.Unscoped().Select("id", "email", "name") }
+	var referrals []models.Referral
+	if err := query.Preload("Referrer", customer).Preload("Referee", customer).
+		Order("created_at DESC").Offset(offset).Limit(limit).Find(&referrals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referrals"})
+		return
+	}
+
+	var summary struct {
+		Pending       int64 `json:"pending"`
+		Rewarded      int64 `json:"rewarded"`
+		Rejected      int64 `json:"rejected"`
+		PointsAwarded int   `json:"points_awarded"`
+	}
+	h.DB.Model(&models.Referral{}).Select(`
+		COALESCE(SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END), 0) AS pending,
+		COALESCE(SUM(CASE WHEN status = 'rewarded' THEN 1 ELSE 0 END), 0) AS rewarded,
+		COALESCE(SUM(CASE WHEN status = 'rejected' THEN 1 ELSE 0 END), 0) AS rejected,
+		COALESCE(SUM(referrer_points + referee_points), 0) AS points_awarded`).Scan(&summary)
+
+	c.JSON(http.StatusOK, gin.H{
+		"referrals": referrals,
+		"summary":   summary,
+		"total":     total,
+		"page":      page,
+		"limit":     limit,
+		"pages":     int(math.Ceil(float64(total) / float64(limit))),
+	})
+}
+
+// rewardCompletedOrder pays out the customer's referral when their order has
+// been delivered or collected.
+func rewardCompletedOrder(db *gorm.DB, order models.Order) {
+	if order.Status != models.OrderStatusDelivered && order.Status != models.OrderStatusCollected {
+		return
+	}
+	if _, err := loyalty.RewardReferral(db, order); err != nil {
+		log.Printf("Failed to reward referral for order %s: %v", order.ID, err)
+	}
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grabbi-backend/loyalty"
+	"grabbi-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// seedReferrer creates a customer and returns them with their referral code.
+func seedReferrer(t *testing.T, db *gorm.DB, router *gin.Engine, email string) (models.User, string) {
+	t.Helper()
+	user, token := seedTestUser(db, email, "customer", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/auth/referral", nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 fetching referral code, got %d: %s", w.Code, w.Body.String())
+	}
+	return user, parseResponse(w)["referral_code"].(string)
+}
+
+// registerReferee signs up a customer with a referral code.
+func registerReferee(t *testing.T, router *gin.Engine, email, code string) uuid.UUID {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/auth/register", map[string]string{
+		"email": email, "password": "password123", "name": "Friend", "referral_code": code,
+	}, ""))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 registering %s, got %d: %s", email, w.Code, w.Body.String())
+	}
+	user := parseResponse(w)["user"].(map[string]interface{})
+	if user["referral_code"] == nil {
+		t.Errorf("expected %s to get a referral code of their own", email)
+	}
+	return uuid.MustParse(user["id"].(string))
+}
+
+// deliverOrder places an out-for-delivery order for the user and marks it delivered.
+func deliverOrder(t *testing.T, db *gorm.DB, userID uuid.UUID, address string) {
+	t.Helper()
+	order := models.Order{
+		UserID:          userID,
+		OrderNumber:     "ORD-" + uuid.New().String()[:8],
+		Status:          models.OrderStatusOutForDelivery,
+		DeliveryAddress: address,
+		Total:           20,
+	}
+	db.Create(&order)
+	_, adminToken := seedTestUser(db, "deliver-"+uuid.New().String()[:8]+"@test.com", "admin", nil)
+
+	w := httptest.NewRecorder()
+	setupOrderRouter(db).ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+order.ID.String()+"/status", map[string]string{"status": "delivered"}, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 delivering order, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func pointsOf(db *gorm.DB, userID uuid.UUID) int {
+	var user models.User
+	db.First(&user, "id = ?", userID)
+	return user.LoyaltyPoints
+}
+
+func TestReferralRewardedOnFirstDeliveredOrder(t *testing.T) {
+	db := freshDB()
+	router := setupReferralRouter(db)
+	referrer, code := seedReferrer(t, db, router, "inviter@test.com")
+	refereeID := registerReferee(t, router, "friend@gmail.com", code)
+
+	var referral models.Referral
+	if err := db.Where("referee_id = ?", refereeID).First(&referral).Error; err != nil {
+		t.Fatalf("expected a referral to be recorded: %v", err)
+	}
+	if referral.Status != models.ReferralStatusPending || referral.ReferrerID != referrer.ID {
+		t.Fatalf("expected a pending referral from the inviter, got %+v", referral)
+	}
+
+	deliverOrder(t, db, refereeID, "2 Friend Rd")
+	deliverOrder(t, db, refereeID, "2 Friend Rd")
+
+	if got := pointsOf(db, referrer.ID); got != loyalty.DefaultReferrerPoints {
+		t.Errorf("expected the referrer to get %d points once, got %d", loyalty.DefaultReferrerPoints, got)
+	}
+	if got := pointsOf(db, refereeID); got != loyalty.DefaultRefereePoints {
+		t.Errorf("expected the referee to get %d points once, got %d", loyalty.DefaultRefereePoints, got)
+	}
+	db.First(&referral, "id = ?", referral.ID)
+	if referral.Status != models.ReferralStatusRewarded || referral.OrderID == nil || referral.RewardedAt == nil {
+		t.Errorf("expected the referral rewarded against the order, got %+v", referral)
+	}
+}
+
+func TestRegisterRejectsUnknownReferralCode(t *testing.T) {
+	db := freshDB()
+	router := setupReferralRouter(db)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/auth/register", map[string]string{
+		"email": "nocode@gmail.com", "password": "password123", "referral_code": "NOTACODE",
+	}, ""))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	var count int64
+	db.Model(&models.User{}).Where("email = ?", "nocode@gmail.com").Count(&count)
+	if count != 0 {
+		t.Error("expected no account to be created")
+	}
+}
+
+func TestReferralFraudGuards(t *testing.T) {
+	db := freshDB()
+	router := setupReferralRouter(db)
+
+	tests := []struct {
+		name, referrer, referee, reason string
+		sharedAddress                   bool
+	}{
+		{"self referral", "jo@example.org", "Jo+2@example.org", loyalty.ReferralSelf, false},
+		{"same email domain", "ann@acme.co", "bob@acme.co", loyalty.ReferralSameDomain, false},
+		{"same address", "cat@mail.test", "dan@gmail.com", loyalty.ReferralSameAddress, true},
+	}
+	for _, tt := range tests {
+		referrer, code := seedReferrer(t, db, router, tt.referrer)
+		refereeID := registerReferee(t, router, tt.referee, code)
+		if tt.sharedAddress {
+			db.Create(&models.Order{UserID: referrer.ID, OrderNumber: "ORD-" + uuid.New().String()[:8], Status: models.OrderStatusDelivered, DeliveryAddress: "1 High St, Leeds"})
+		}
+		deliverOrder(t, db, refereeID, "1 high st leeds")
+
+		var referral models.Referral
+		db.Where("referee_id = ?", refereeID).First(&referral)
+		if referral.Status != models.ReferralStatusRejected || referral.RejectReason != tt.reason {
+			t.Errorf("%s: expected rejection for %s, got %s %q", tt.name, tt.reason, referral.Status, referral.RejectReason)
+		}
+		if pointsOf(db, referrer.ID) != 0 || pointsOf(db, refereeID) != 0 {
+			t.Errorf("%s: expected no points awarded", tt.name)
+		}
+	}
+}
+
+func TestAdminReferralReport(t *testing.T) {
+	db := freshDB()
+	router := setupReferralRouter(db)
+	_, adminToken := seedTestUser(db, "referraladmin@test.com", "admin", nil)
+	_, code := seedReferrer(t, db, router, "host@test.com")
+	rewardedID := registerReferee(t, router, "one@gmail.com", code)
+	registerReferee(t, router, "two@gmail.com", code)
+	registerReferee(t, router, "three@test.com", code)
+	deliverOrder(t, db, rewardedID, "9 Other Rd")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/admin/referrals", nil, adminToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	summary := resp["summary"].(map[string]interface{})
+	if summary["pending"] != 1.0 || summary["rewarded"] != 1.0 || summary["rejected"] != 1.0 {
+		t.Errorf("expected one referral in each status, got %v", summary)
+	}
+	if summary["points_awarded"] != float64(loyalty.DefaultReferrerPoints+loyalty.DefaultRefereePoints) {
+		t.Errorf("expected both rewards counted, got %v", summary["points_awarded"])
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/admin/referrals?status=rewarded", nil, adminToken))
+	referrals := parseResponse(w)["referrals"].([]interface{})
+	if len(referrals) != 1 {
+		t.Fatalf("expected 1 rewarded referral, got %d", len(referrals))
+	}
+	referee := referrals[0].(map[string]interface{})["referee"].(map[string]interface{})
+	if referee["email"] != "one@gmail.com" {
+		t.Errorf("expected the referee's email in the report, got %v", referee["email"])
+	}
+}
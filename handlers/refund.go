@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"grabbi-backend/giftcards"
+	"grabbi-backend/loyalty"
+	"grabbi-backend/models"
+	"grabbi-backend/payments"
+	"grabbi-backend/pricing"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RefundHandler struct {
+	DB       *gorm.DB
+	Payments payments.PaymentProvider
+}
+
+// CreateRefund refunds selected order items or a plain amount. Refunded items are
+// restocked and the loyalty points earned on them are clawed back; amount-only
+// refunds (goodwill, delivery fee) move money but leave stock and points alone.
+func (h *RefundHandler) CreateRefund(c *gin.Context) {
+	id := c.Param("id")
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		Items []struct {
+			OrderItemID string `json:"order_item_id" binding:"required"`
+			Quantity    int    `json:"quantity" binding:"required,min=1"`
+		} `json:"items" binding:"omitempty,dive"`
+		Amount float64 `json:"amount" binding:"omitempty,gt=0"`
+		Reason string  `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+
+	if (len(req.Items) == 0) == (req.Amount == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either items or an amount to refund"})
+		return
+	}
+
+	tx := h.DB.Begin()
+
+	// Lock the order so concurrent refunds cannot both pass the remaining-amount check
+	var order models.Order
+	if err := h.scopedOrderQuery(c, tx, id).Clauses(clause.Locking{Strength: "UPDATE"}).First(&order).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	if order.Status == models.OrderStatusCancelled {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cancelled orders are refunded in full automatically"})
+		return
+	}
+
+	refund := models.Refund{
+		OrderID:     order.ID,
+		Reason:      req.Reason,
+		CreatedByID: userID.(uuid.UUID),
+	}
+
+	if len(req.Items) > 0 {
+		var orderItems []models.OrderItem
+		tx.Where("order_id = ?", order.ID).Find(&orderItems)
+		itemsByID := make(map[uuid.UUID]*models.OrderItem, len(orderItems))
+		for i := range orderItems {
+			itemsByID[orderItems[i].ID] = &orderItems[i]
+		}
+
+		requested := make(map[uuid.UUID]int)
+		for _, ri := range req.Items {
+			itemID, err := uuid.Parse(ri.OrderItemID)
+			if err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order_item_id"})
+				return
+			}
+			item, ok := itemsByID[itemID]
+			if !ok {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Order item not found"})
+				return
+			}
+			requested[itemID] += ri.Quantity
+			if refundable := item.Quantity - item.RefundedQuantity - item.SubstitutedQuantity; requested[itemID] > refundable {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("Only %d of %s can be refunded", refundable, item.ProductName),
+				})
+				return
+			}
+
+			amount := itemPaidAmount(*item, ri.Quantity)
+			refund.Items = append(refund.Items, models.RefundItem{
+				OrderItemID: item.ID,
+				ProductID:   item.ProductID,
+				Quantity:    ri.Quantity,
+				Amount:      amount,
+			})
+			refund.Amount += amount
+		}
+		refund.Amount = pricing.RoundMoney(refund.Amount)
+	} else {
+		refund.Amount = pricing.RoundMoney(req.Amount)
+	}
+
+	if remaining := pricing.RoundMoney(order.Total - order.RefundedAmount); refund.Amount > remaining {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Refund exceeds the %.2f remaining on this order", remaining),
+		})
+		return
+	}
+
+	if err := recordRefund(tx, order, &refund); err != nil {
+		tx.Rollback()
+		respondCheckoutError(c, err, "Failed to create refund")
+		return
+	}
+
+	unsettled, err := settleRefund(tx, h.Payments, &order, &refund)
+	if err != nil {
+		tx.Rollback()
+		respondCheckoutError(c, err, "Failed to create refund")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("CRITICAL: refund of %.2f for order %s sent to provider but not recorded: %v", refund.Amount, order.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete refund"})
+		return
+	}
+
+	if unsettled > 0 {
+		log.Printf("Refund %s for order %s has %.2f to be returned outside the payment provider", refund.ID, order.ID, unsettled)
+	}
+
+	c.JSON(http.StatusCreated, refund)
+}
+
+// GetRefunds lists the refunds issued against an order.
+func (h *RefundHandler) GetRefunds(c *gin.Context) {
+	var order models.Order
+	if err := h.scopedOrderQuery(c, h.DB, c.Param("id")).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	var refunds []models.Refund
+	if err := h.DB.Preload("Items").Where("order_id = ?", order.ID).Order("created_at DESC").Find(&refunds).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch refunds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, refunds)
+}
+
+// scopedOrderQuery restricts franchise roles to their own franchise's orders.
+func (h *RefundHandler) scopedOrderQuery(c *gin.Context, db *gorm.DB, id string) *gorm.DB {
+	query := db.Where("id = ?", id)
+	userRole, _ := c.Get("user_role")
+	roleStr, _ := userRole.(string)
+	if roleStr == "franchise_owner" || roleStr == "franchise_staff" {
+		fID, _ := c.Get("franchise_id")
+		query = query.Where("franchise_id = ?", fID)
+	}
+	return query
+}
+
+// itemPaidAmount is what quantity units of an order item were paid for: their
+// share of the line after discounts.
+func itemPaidAmount(item models.OrderItem, quantity int) float64 {
+	return pricing.RoundMoney((item.Price*float64(item.Quantity) - item.Discount) * float64(quantity) / float64(item.Quantity))
+}
+
+// recordRefund saves a refund, returns its items to stock and claws back the
+// loyalty points earned on them. Failures are checkoutErrors carrying the
+// response to send.
+func recordRefund(tx *gorm.DB, order models.Order, refund *models.Refund) error {
+	if err := tx.Create(refund).Error; err != nil {
+		return &checkoutError{http.StatusInternalServerError, "Failed to create refund"}
+	}
+
+	for _, ri := range refund.Items {
+		tx.Model(&models.OrderItem{}).Where("id = ?", ri.OrderItemID).
+			UpdateColumn("refunded_quantity", gorm.Expr("refunded_quantity + ?", ri.Quantity))
+		restockProduct(tx, order.FranchiseID, ri.ProductID, ri.Quantity)
+	}
+
+	if len(refund.Items) > 0 {
+		points, err := reverseRefundedPoints(tx, order, refund.ID)
+		if err != nil {
+			return &checkoutError{http.StatusInternalServerError, "Failed to reverse loyalty points"}
+		}
+		refund.PointsReversed = points
+	}
+	return nil
+}
+
+// reverseRefundedPoints claws back the loyalty points earned on refunded goods
+// and records them on the refund. Substituted units count as refunded and the
+// substitutes picked for them as bought, so a cheaper substitute reverses the
+// points on the difference.
+func reverseRefundedPoints(tx *gorm.DB, order models.Order, refundID uuid.UUID) (int, error) {
+	var refundedGoods float64
+	tx.Model(&models.OrderItem{}).Where("order_id = ?", order.ID).
+		Select("COALESCE(SUM(price * (refunded_quantity + substituted_quantity) - " +
+			"CASE WHEN substitute_for_id IS NULL THEN 0 ELSE price * quantity END), 0)").Scan(&refundedGoods)
+
+	points, err := loyalty.ReverseRefunded(tx, order, refundedGoods)
+	if err != nil || points == 0 {
+		return 0, err
+	}
+
+	if err := tx.Model(&models.Refund{}).Where("id = ?", refundID).UpdateColumn("points_reversed", points).Error; err != nil {
+		return 0, err
+	}
+	return points, nil
+}
+
+// settleRefund adds a recorded refund to the order's refunded amount and
+// returns the money: to the payment provider first, then to the gift cards the
+// order spent. It returns what neither could take back. Failures are
+// checkoutErrors carrying the response to send.
+func settleRefund(tx *gorm.DB, provider payments.PaymentProvider, order *models.Order, refund *models.Refund) (float64, error) {
+	order.RefundedAmount = pricing.RoundMoney(order.RefundedAmount + refund.Amount)
+	if err := tx.Model(order).UpdateColumn("refunded_amount", order.RefundedAmount).Error; err != nil {
+		return 0, &checkoutError{http.StatusInternalServerError, "Failed to create refund"}
+	}
+
+	// Move the money last so any failure above leaves the provider untouched
+	unsettled, err := payments.RefundOrder(tx, provider, order.ID, refund.Amount)
+	if err != nil {
+		log.Printf("Payment refund failed for order %s: %v", order.ID, err)
+		return 0, &checkoutError{http.StatusInternalServerError, "Failed to refund payment"}
+	}
+
+	// What the provider could not return goes back to the gift cards the order spent
+	if unsettled > 0 && order.GiftCardAmount > 0 {
+		returned, err := giftcards.Refund(tx, *order, unsettled)
+		if err == nil {
+			refund.GiftCardAmount = returned
+			err = tx.Model(refund).UpdateColumn("gift_card_amount", returned).Error
+		}
+		if err != nil {
+			log.Printf("CRITICAL: gift card refund failed for order %s after the payment refund: %v", order.ID, err)
+			return 0, &checkoutError{http.StatusInternalServerError, "Failed to refund gift card"}
+		}
+		unsettled = pricing.RoundMoney(unsettled - returned)
+	}
+	return unsettled, nil
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grabbi-backend/models"
+	"grabbi-backend/payments"
+
+	"github.com/google/uuid"
+)
+
+// placeRefundableOrder places a card order for 3 x 10.00 and returns its ID and single item.
+func placeRefundableOrder(t *testing.T, router http.Handler) (models.User, models.Product, string, models.OrderItem) {
+	t.Helper()
+	user, prod, token := seedCartForPayment(t)
+	orderID := placePaidOrder(t, router, token)
+
+	var item models.OrderItem
+	testDB.Where("order_id = ?", orderID).First(&item)
+	return user, prod, orderID, item
+}
+
+func advanceOrder(t *testing.T, router http.Handler, orderID, adminToken string, statuses ...string) {
+	t.Helper()
+	for _, status := range statuses {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("PUT", "/api/admin/orders/"+orderID+"/status", map[string]string{"status": status}, adminToken))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d: %s", status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRefundItemsRestocksAndReversesPoints(t *testing.T) {
+	db := freshDB()
+	router := setupRefundRouter(db, payments.NewFakeProvider())
+	_, adminToken := seedTestUser(db, "refundadmin@test.com", "admin", nil)
+	user, prod, orderID, item := placeRefundableOrder(t, router)
+	advanceOrder(t, router, orderID, adminToken, "preparing", "ready", "out_for_delivery", "delivered")
+
+	body := map[string]interface{}{
+		"items":  []map[string]interface{}{{"order_item_id": item.ID.String(), "quantity": 2}},
+		"reason": "Damaged in transit",
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", body, adminToken))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+	if resp["amount"] != 20.0 {
+		t.Errorf("expected refund amount 20, got %v", resp["amount"])
+	}
+	if resp["points_reversed"] != 20.0 {
+		t.Errorf("expected 20 points reversed, got %v", resp["points_reversed"])
+	}
+
+	var reloadedProduct models.Product
+	db.First(&reloadedProduct, "id = ?", prod.ID)
+	if reloadedProduct.StockQuantity != 99 {
+		t.Errorf("expected stock 99 after restocking 2 of 3, got %d", reloadedProduct.StockQuantity)
+	}
+
+	var reloadedItem models.OrderItem
+	db.First(&reloadedItem, "id = ?", item.ID)
+	if reloadedItem.RefundedQuantity != 2 {
+		t.Errorf("expected refunded quantity 2, got %d", reloadedItem.RefundedQuantity)
+	}
+
+	var reloadedUser models.User
+	db.First(&reloadedUser, "id = ?", user.ID)
+	if reloadedUser.LoyaltyPoints != 10 {
+		t.Errorf("expected 10 loyalty points left, got %d", reloadedUser.LoyaltyPoints)
+	}
+
+	var history models.LoyaltyHistory
+	if err := db.Where("user_id = ? AND type = ?", user.ID, "reversed").First(&history).Error; err != nil {
+		t.Fatalf("expected reversal history entry: %v", err)
+	}
+	if history.Points != -20 {
+		t.Errorf("expected -20 points in history, got %d", history.Points)
+	}
+
+	var payment models.Payment
+	db.Where("order_id = ?", orderID).First(&payment)
+	if payment.Status != models.PaymentStatusPartiallyRefunded || payment.RefundedAmount != 20 {
+		t.Errorf("expected partially refunded payment of 20, got %s / %.2f", payment.Status, payment.RefundedAmount)
+	}
+
+	var order models.Order
+	db.First(&order, "id = ?", orderID)
+	if order.RefundedAmount != 20 {
+		t.Errorf("expected order refunded amount 20, got %.2f", order.RefundedAmount)
+	}
+}
+
+func TestRefundBeforeCaptureReducesHold(t *testing.T) {
+	db := freshDB()
+	router := setupRefundRouter(db, payments.NewFakeProvider())
+	_, adminToken := seedTestUser(db, "refundadmin@test.com", "admin", nil)
+	_, _, orderID, item := placeRefundableOrder(t, router)
+
+	body := map[string]interface{}{
+		"items": []map[string]interface{}{{"order_item_id": item.ID.String(), "quantity": 1}},
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", body, adminToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var payment models.Payment
+	db.Where("order_id = ?", orderID).First(&payment)
+	if payment.Status != models.PaymentStatusAuthorized || payment.Amount != 20 {
+		t.Fatalf("expected authorized hold reduced to 20, got %s / %.2f", payment.Status, payment.Amount)
+	}
+
+	// Only the reduced amount is captured when the order leaves the store
+	advanceOrder(t, router, orderID, adminToken, "preparing", "ready", "out_for_delivery")
+	db.Where("order_id = ?", orderID).First(&payment)
+	if payment.CapturedAmount != 20 {
+		t.Errorf("expected 20 captured, got %.2f", payment.CapturedAmount)
+	}
+}
+
+func TestRefundFullAmountBeforeCaptureVoids(t *testing.T) {
+	db := freshDB()
+	router := setupRefundRouter(db, payments.NewFakeProvider())
+	_, adminToken := seedTestUser(db, "refundadmin@test.com", "admin", nil)
+	_, _, orderID, _ := placeRefundableOrder(t, router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", map[string]interface{}{"amount": 30}, adminToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var payment models.Payment
+	db.Where("order_id = ?", orderID).First(&payment)
+	if payment.Status != models.PaymentStatusVoided {
+		t.Errorf("expected payment to be voided, got %s", payment.Status)
+	}
+}
+
+func TestRefundAmountLeavesStockAndPoints(t *testing.T) {
+	db := freshDB()
+	router := setupRefundRouter(db, payments.NewFakeProvider())
+	_, adminToken := seedTestUser(db, "refundadmin@test.com", "admin", nil)
+	user, prod, orderID, _ := placeRefundableOrder(t, router)
+	advanceOrder(t, router, orderID, adminToken, "preparing", "ready", "out_for_delivery")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", map[string]interface{}{"amount": 5, "reason": "Late delivery"}, adminToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var reloadedProduct models.Product
+	db.First(&reloadedProduct, "id = ?", prod.ID)
+	if reloadedProduct.StockQuantity != 97 {
+		t.Errorf("expected stock untouched at 97, got %d", reloadedProduct.StockQuantity)
+	}
+
+	var reloadedUser models.User
+	db.First(&reloadedUser, "id = ?", user.ID)
+	if reloadedUser.LoyaltyPoints != 30 {
+		t.Errorf("expected points untouched at 30, got %d", reloadedUser.LoyaltyPoints)
+	}
+}
+
+func TestRefundRejectsOverRefund(t *testing.T) {
+	db := freshDB()
+	router := setupRefundRouter(db, payments.NewFakeProvider())
+	_, adminToken := seedTestUser(db, "refundadmin@test.com", "admin", nil)
+	_, _, orderID, item := placeRefundableOrder(t, router)
+
+	tooMany := map[string]interface{}{
+		"items": []map[string]interface{}{
+			{"order_item_id": item.ID.String(), "quantity": 2},
+			{"order_item_id": item.ID.String(), "quantity": 2},
+		},
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", tooMany, adminToken))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for too many items, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", map[string]interface{}{"amount": 25}, adminToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", map[string]interface{}{"amount": 10}, adminToken))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 when exceeding order total, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRefundRequiresItemsOrAmount(t *testing.T) {
+	db := freshDB()
+	router := setupRefundRouter(db, payments.NewFakeProvider())
+	_, adminToken := seedTestUser(db, "refundadmin@test.com", "admin", nil)
+	_, _, orderID, item := placeRefundableOrder(t, router)
+
+	for _, body := range []map[string]interface{}{
+		{},
+		{"amount": 5, "items": []map[string]interface{}{{"order_item_id": item.ID.String(), "quantity": 1}}},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", body, adminToken))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %v, got %d: %s", body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRefundCancelledOrderRejected(t *testing.T) {
+	db := freshDB()
+	router := setupRefundRouter(db, payments.NewFakeProvider())
+	_, adminToken := seedTestUser(db, "refundadmin@test.com", "admin", nil)
+	_, _, orderID, _ := placeRefundableOrder(t, router)
+	advanceOrder(t, router, orderID, adminToken, "cancelled")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", map[string]interface{}{"amount": 5}, adminToken))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCancelAfterPartialRefundRestocksRemainder(t *testing.T) {
+	db := freshDB()
+	router := setupRefundRouter(db, payments.NewFakeProvider())
+	_, adminToken := seedTestUser(db, "refundadmin@test.com", "admin", nil)
+	_, prod, orderID, item := placeRefundableOrder(t, router)
+
+	body := map[string]interface{}{
+		"items": []map[string]interface{}{{"order_item_id": item.ID.String(), "quantity": 1}},
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/admin/orders/"+orderID+"/refunds", body, adminToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	advanceOrder(t, router, orderID, adminToken, "cancelled")
+
+	var reloadedProduct models.Product
+	db.First(&reloadedProduct, "id = ?", prod.ID)
+	if reloadedProduct.StockQuantity != 100 {
+		t.Errorf("expected stock back at 100 without double counting, got %d", reloadedProduct.StockQuantity)
+	}
+}
+
+func TestFranchiseCannotRefundOtherFranchiseOrder(t *testing.T) {
+	db := freshDB()
+	router := setupRefundRouter(db, payments.NewFakeProvider())
+
+	customer, _ := seedTestUser(db, "cust@test.com", "customer", nil)
+	cat := seedCategory(db, "RefundCat")
+	prod := seedProduct(db, "Refund Product", cat.ID, 10.00)
+	ownFranchise := seedFranchise(db, "Own Store", customer.ID)
+	otherFranchise := seedFranchise(db, "Other Store", customer.ID)
+	order := seedOrder(db, customer.ID, otherFranchise.ID, prod.ID)
+	_, ownerToken := seedFranchiseOwnerWithToken(db, ownFranchise)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/franchise/orders/"+order.ID.String()+"/refunds", map[string]interface{}{"amount": 1}, ownerToken))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d: %s", w.Code, w.Body.String())
+	}
+
+	ownOrder := seedOrder(db, customer.ID, ownFranchise.ID, prod.ID)
+	body := map[string]interface{}{
+		"items": []map[string]interface{}{{"order_item_id": ownOrder.Items[0].ID.String(), "quantity": 1}},
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/franchise/orders/"+ownOrder.ID.String()+"/refunds", body, ownerToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for own order, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var refund models.Refund
+	db.Preload("Items").Where("order_id = ?", ownOrder.ID).First(&refund)
+	if refund.ID == uuid.Nil || len(refund.Items) != 1 {
+		t.Errorf("expected refund with one item, got %+v", refund)
+	}
+}
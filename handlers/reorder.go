@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"grabbi-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// substituteCandidates is how many near-priced products are checked for stock
+// when looking for a substitute.
+const substituteCandidates = 10
+
+// reorderSubstitution reports a product no longer sold that was replaced.
+type reorderSubstitution struct {
+	ProductID      uuid.UUID `json:"product_id"`
+	ProductName    string    `json:"product_name"`
+	SubstituteID   uuid.UUID `json:"substitute_id"`
+	SubstituteName string    `json:"substitute_name"`
+	Quantity       int       `json:"quantity"`
+}
+
+// reorderPriceChange reports a product whose price has moved since it was ordered.
+type reorderPriceChange struct {
+	ProductID     uuid.UUID `json:"product_id"`
+	ProductName   string    `json:"product_name"`
+	PreviousPrice float64   `json:"previous_price"`
+	Price         float64   `json:"price"`
+}
+
+// findSubstitute returns the in-stock product closest in price to product from
+// the same subcategory, or category when it has none, at the store a cart is
+// bound to. It returns nil when there is nothing suitable.
+func findSubstitute(db *gorm.DB, franchiseID *uuid.UUID, product models.Product) (*models.Product, error) {
+	query := db.Where("id <> ? AND status = ? AND online_visible = ?", product.ID, "active", true)
+	if product.SubcategoryID != nil {
+		query = query.Where("subcategory_id = ?", *product.SubcategoryID)
+	} else {
+		query = query.Where("category_id = ?", product.CategoryID)
+	}
+	var candidates []models.Product
+	closest := clause.OrderBy{Expression: clause.Expr{SQL: "ABS(retail_price - ?)", Vars: []interface{}{product.RetailPrice}}}
+	if err := query.Clauses(closest).Limit(substituteCandidates).Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		stock, sold, err := availableStock(db, franchiseID, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if sold && stock > 0 {
+			return &candidate, nil
+		}
+	}
+	return nil, nil
+}
+
+// currentProduct returns the product to buy in place of productID: the product
+// itself, or a substitute when it has since been deleted from the catalogue.
+// It returns nil when the product is gone and nothing can stand in for it.
+func currentProduct(db *gorm.DB, franchiseID *uuid.UUID, productID uuid.UUID) (product *models.Product, substituted bool, err error) {
+	var p models.Product
+	err = db.Unscoped().Where("id = ?", productID).First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !p.DeletedAt.Valid {
+		return &p, false, nil
+	}
+	substitute, err := findSubstitute(db, franchiseID, p)
+	if err != nil || substitute == nil {
+		return nil, false, err
+	}
+	return substitute, true, nil
+}
+
+// Reorder puts a past order's items back in the cart. Items are re-validated as
+// when merging a guest cart, products deleted since are swapped for a
+// substitute, and any change from the price paid is reported.
+func (h *CartHandler) Reorder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var order models.Order
+	if err := h.DB.Preload("Items").Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	// The order's items join the cart at its store
+	boundFranchise, hasItems, err := boundCartFranchise(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+	franchiseID, ok := cartAddFranchise(c, h.DB, boundFranchise, hasItems, order.FranchiseID)
+	if !ok {
+		return
+	}
+
+	result := &cartMergeResult{Removed: []cartAdjustment{}, Adjusted: []cartAdjustment{}}
+	substituted := []reorderSubstitution{}
+	var lines []cartLine
+	paid := make(map[uuid.UUID]float64)
+	for _, item := range order.Items {
+		product, isSubstitute, err := currentProduct(h.DB, franchiseID, item.ProductID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder"})
+			return
+		}
+		if product == nil {
+			result.Removed = append(result.Removed, cartAdjustment{
+				ProductID: item.ProductID, ProductName: item.ProductName, PreviousQuantity: item.Quantity,
+			})
+			continue
+		}
+		if isSubstitute {
+			substituted = append(substituted, reorderSubstitution{
+				ProductID: item.ProductID, ProductName: item.ProductName,
+				SubstituteID: product.ID, SubstituteName: product.ItemName, Quantity: item.Quantity,
+			})
+		} else {
+			paid[product.ID] = item.Price
+		}
+		lines = append(lines, cartLine{Product: *product, Quantity: item.Quantity})
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		return mergeCartLines(tx, userID.(uuid.UUID), franchiseID, lines, result)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder"})
+		return
+	}
+
+	cart, err := h.loadCart(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	// The cart is priced today; report lines that cost something else last time
+	priceChanges := []reorderPriceChange{}
+	for _, item := range cart {
+		if price, ok := paid[item.ProductID]; ok && price != item.UnitPrice {
+			priceChanges = append(priceChanges, reorderPriceChange{
+				ProductID: item.ProductID, ProductName: item.Product.ItemName,
+				PreviousPrice: price, Price: item.UnitPrice,
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"cart":          cart,
+		"merged":        result.Merged,
+		"removed":       result.Removed,
+		"adjusted":      result.Adjusted,
+		"substituted":   substituted,
+		"price_changes": priceChanges,
+	})
+}
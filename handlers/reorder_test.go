@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// addToCart puts a product from the franchise in the user's cart.
+func addToCart(t *testing.T, router http.Handler, token string, productID, franchiseID uuid.UUID, quantity int) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/cart", map[string]interface{}{
+		"product_id": productID, "quantity": quantity, "franchise_id": franchiseID,
+	}, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 adding to cart, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReorderRebuildsCartWithSubstitutesAndCurrentPrices(t *testing.T) {
+	db := freshDB()
+	router := setupSubscriptionRouter(db, nil)
+	owner, _ := seedTestUser(db, "reorder-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Reorder Store", owner.ID)
+	cat := seedCategory(db, "Reorder Cat")
+	butter := seedProduct(db, "Butter", cat.ID, 2.00)
+	cheese := seedProduct(db, "Cheese", cat.ID, 4.00)
+	yoghurt := seedProduct(db, "Yoghurt", cat.ID, 1.50)
+	seedFranchiseProduct(db, franchise.ID, butter.ID)
+	seedFranchiseProduct(db, franchise.ID, cheese.ID)
+	yoghurtListing := seedFranchiseProduct(db, franchise.ID, yoghurt.ID)
+	_, token := seedTestUser(db, "reorder@test.com", "customer", nil)
+
+	addToCart(t, router, token, butter.ID, franchise.ID, 2)
+	addToCart(t, router, token, cheese.ID, franchise.ID, 1)
+	addToCart(t, router, token, yoghurt.ID, franchise.ID, 4)
+	order := placeCollectionOrder(t, router, token, franchise.ID)
+
+	// Since then butter was discontinued, cheese went up and yoghurt ran low
+	spread := seedProduct(db, "Spread", cat.ID, 2.10)
+	seedFranchiseProduct(db, franchise.ID, spread.ID)
+	db.Delete(&butter)
+	db.Model(&cheese).Update("retail_price", 4.50)
+	db.Model(&yoghurtListing).Update("stock_quantity", 3)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders/"+order["id"].(string)+"/reorder", nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := parseResponse(w)
+
+	substituted := resp["substituted"].([]interface{})
+	if len(substituted) != 1 || substituted[0].(map[string]interface{})["substitute_name"] != "Spread" {
+		t.Errorf("expected butter swapped for spread, got %v", substituted)
+	}
+	priceChanges := resp["price_changes"].([]interface{})
+	if len(priceChanges) != 1 || priceChanges[0].(map[string]interface{})["price"] != 4.5 {
+		t.Errorf("expected cheese's new price reported, got %v", priceChanges)
+	}
+	adjusted := resp["adjusted"].([]interface{})
+	if len(adjusted) != 1 || adjusted[0].(map[string]interface{})["quantity"] != float64(3) {
+		t.Errorf("expected yoghurt capped at 3, got %v", adjusted)
+	}
+
+	quantities := map[uuid.UUID]int{}
+	var items []models.CartItem
+	db.Where("user_id IN (SELECT id FROM users WHERE email = ?)", "reorder@test.com").Find(&items)
+	for _, item := range items {
+		quantities[item.ProductID] = item.Quantity
+	}
+	if len(items) != 3 || quantities[spread.ID] != 2 || quantities[cheese.ID] != 1 || quantities[yoghurt.ID] != 3 {
+		t.Errorf("expected 2 spread, 1 cheese and 3 yoghurt, got %v", quantities)
+	}
+}
+
+func TestReorderRejectsCartFromAnotherStore(t *testing.T) {
+	db := freshDB()
+	router := setupSubscriptionRouter(db, nil)
+	owner, _ := seedTestUser(db, "reorder-conflict-owner@test.com", "franchise_owner", nil)
+	first := seedFranchise(db, "First Reorder Store", owner.ID)
+	second := seedFranchise(db, "Second Reorder Store", owner.ID)
+	cat := seedCategory(db, "Reorder Conflict Cat")
+	soup := seedProduct(db, "Soup", cat.ID, 1.00)
+	seedFranchiseProduct(db, first.ID, soup.ID)
+	seedFranchiseProduct(db, second.ID, soup.ID)
+	_, token := seedTestUser(db, "reorder-conflict@test.com", "customer", nil)
+
+	addToCart(t, router, token, soup.ID, first.ID, 1)
+	order := placeCollectionOrder(t, router, token, first.ID)
+	addToCart(t, router, token, soup.ID, second.ID, 1)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders/"+order["id"].(string)+"/reorder", nil, token))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	_, otherToken := seedTestUser(db, "reorder-other@test.com", "customer", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders/"+order["id"].(string)+"/reorder", nil, otherToken))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another customer's order, got %d", w.Code)
+	}
+}
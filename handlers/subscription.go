@@ -0,0 +1,505 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"grabbi-backend/models"
+	"grabbi-backend/payments"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SubscriptionHandler manages recurring orders. The orders themselves are
+// placed by PlaceDueSubscriptions, through the same path as CreateOrder.
+type SubscriptionHandler struct {
+	DB       *gorm.DB
+	Payments payments.PaymentProvider
+}
+
+// subscriptionItemRequest is a basket line in a subscription request.
+type subscriptionItemRequest struct {
+	ProductID uuid.UUID `json:"product_id" binding:"required"`
+	Quantity  int       `json:"quantity" binding:"required,min=1"`
+}
+
+// subscriptionRequest carries the fields a customer can set on a subscription.
+// Pointer fields are left unchanged when nil.
+type subscriptionRequest struct {
+	Frequency      *string                   `json:"frequency"`
+	FranchiseID    *uuid.UUID                `json:"franchise_id"`
+	FulfilmentType *string                   `json:"fulfilment_type"`
+	AddressID      *uuid.UUID                `json:"address_id"`
+	PaymentMethod  *string                   `json:"payment_method"`
+	PaymentToken   *string                   `json:"payment_token"`
+	NextOrderAt    *time.Time                `json:"next_order_at"`
+	Items          []subscriptionItemRequest `json:"items" binding:"dive"`
+}
+
+// applySubscriptionRequest validates req and copies it onto sub, returning the
+// basket to save when the request replaces it. A payment token is one-time, so
+// it is exchanged with the provider for a saved method that every scheduled
+// order can charge.
+func applySubscriptionRequest(db *gorm.DB, provider payments.PaymentProvider, sub *models.Subscription, req subscriptionRequest) ([]models.SubscriptionItem, error) {
+	if req.Frequency != nil {
+		frequency := models.SubscriptionFrequency(*req.Frequency)
+		if frequency.Days() == 0 {
+			return nil, errors.New("frequency must be weekly or fortnightly")
+		}
+		sub.Frequency = frequency
+	}
+	if req.FulfilmentType != nil {
+		fulfilment, err := parseFulfilment(*req.FulfilmentType)
+		if err != nil {
+			return nil, errors.New("fulfilment_type must be delivery or collection")
+		}
+		sub.FulfilmentType = fulfilment
+	}
+	if req.FranchiseID != nil {
+		var franchise models.Franchise
+		if err := db.Where("id = ? AND is_active = ?", *req.FranchiseID, true).First(&franchise).Error; err != nil {
+			return nil, errors.New("franchise not found")
+		}
+		sub.FranchiseID = req.FranchiseID
+	}
+	if req.AddressID != nil {
+		var address models.UserAddress
+		if err := db.Where("id = ? AND user_id = ?", *req.AddressID, sub.UserID).First(&address).Error; err != nil {
+			return nil, errors.New("address not found")
+		}
+		sub.AddressID = req.AddressID
+	}
+	if req.PaymentMethod != nil {
+		if *req.PaymentMethod == "" {
+			return nil, errors.New("payment_method must be 'card' or 'cash'")
+		}
+		if err := checkPaymentMethod(*req.PaymentMethod); err != nil {
+			return nil, err
+		}
+		sub.PaymentMethod = *req.PaymentMethod
+	}
+	if req.NextOrderAt != nil {
+		if !req.NextOrderAt.After(time.Now()) {
+			return nil, errors.New("next_order_at must be in the future")
+		}
+		sub.NextOrderAt = *req.NextOrderAt
+	}
+
+	if sub.FulfilmentType == models.FulfilmentCollection && sub.FranchiseID == nil {
+		return nil, errors.New("franchise_id is required for collection subscriptions")
+	}
+	// Without an address_id scheduled deliveries go to the default address
+	if sub.FulfilmentType == models.FulfilmentDelivery && sub.AddressID == nil {
+		address, err := orderAddress(db, sub.UserID, "", true)
+		if err != nil || address == nil {
+			return nil, errors.New("a saved delivery address is required for delivery subscriptions")
+		}
+	}
+	newToken := req.PaymentToken != nil && *req.PaymentToken != ""
+	if sub.PaymentMethod == models.PaymentMethodCard && sub.SavedMethod == "" && !newToken {
+		return nil, errors.New("payment_token is required to pay by card")
+	}
+
+	items, err := subscriptionItems(db, req.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	// Saved last so a request rejected above leaves nothing at the provider
+	if sub.PaymentMethod == models.PaymentMethodCard && newToken {
+		if provider == nil {
+			return nil, errors.New("card payments are not available")
+		}
+		method, err := provider.SavePaymentMethod(*req.PaymentToken)
+		if err != nil {
+			log.Printf("Failed to save payment method for subscription %s: %v", sub.ID, err)
+			return nil, errors.New("payment_token was not accepted")
+		}
+		sub.SavedMethod = method
+	}
+	return items, nil
+}
+
+// subscriptionItems checks a requested basket, returning nil when the request
+// leaves the basket as it is.
+func subscriptionItems(db *gorm.DB, reqItems []subscriptionItemRequest) ([]models.SubscriptionItem, error) {
+	if reqItems == nil {
+		return nil, nil
+	}
+	if len(reqItems) == 0 {
+		return nil, errors.New("a subscription needs at least one item")
+	}
+	items := make([]models.SubscriptionItem, 0, len(reqItems))
+	seen := make(map[uuid.UUID]bool)
+	for _, item := range reqItems {
+		if seen[item.ProductID] {
+			return nil, errors.New("each product can appear only once")
+		}
+		seen[item.ProductID] = true
+		var product models.Product
+		if err := db.Where("id = ?", item.ProductID).First(&product).Error; err != nil {
+			return nil, fmt.Errorf("product %s not found", item.ProductID)
+		}
+		items = append(items, models.SubscriptionItem{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	return items, nil
+}
+
+// loadSubscription returns the user's subscription with its basket.
+func (h *SubscriptionHandler) loadSubscription(c *gin.Context, userID interface{}) (*models.Subscription, bool) {
+	var sub models.Subscription
+	if err := h.DB.Preload("Items.Product").Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&sub).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return nil, false
+	}
+	return &sub, true
+}
+
+func (h *SubscriptionHandler) ListSubscriptions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var subs []models.Subscription
+	if err := h.DB.Preload("Items.Product").Where("user_id = ? AND status <> ?", userID, models.SubscriptionCancelled).
+		Order("next_order_at ASC").Find(&subs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, subs)
+}
+
+// CreateSubscription saves a basket to be ordered every week or fortnight. The
+// basket is given as items, or copied from one of the customer's orders with
+// from_order_id, which also supplies the store, fulfilment and address. The
+// first order is placed one period from now unless next_order_at says otherwise.
+func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		subscriptionRequest
+		FromOrderID *uuid.UUID `json:"from_order_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	if req.Frequency == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "frequency is required"})
+		return
+	}
+
+	sub := models.Subscription{
+		UserID:         userID.(uuid.UUID),
+		Status:         models.SubscriptionActive,
+		FulfilmentType: models.FulfilmentDelivery,
+		PaymentMethod:  models.PaymentMethodCard,
+	}
+	if req.FromOrderID != nil {
+		var order models.Order
+		if err := h.DB.Preload("Items").Where("id = ? AND user_id = ?", *req.FromOrderID, userID).First(&order).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		sub.FranchiseID = order.FranchiseID
+		sub.FulfilmentType = order.FulfilmentType
+		sub.AddressID = order.AddressID
+		if req.Items == nil {
+			for _, item := range order.Items {
+				req.Items = append(req.Items, subscriptionItemRequest{ProductID: item.ProductID, Quantity: item.Quantity})
+			}
+		}
+	}
+	if req.Items == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "items or from_order_id is required"})
+		return
+	}
+
+	items, err := applySubscriptionRequest(h.DB, h.Payments, &sub, req.subscriptionRequest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.NextOrderAt == nil {
+		sub.NextOrderAt = time.Now().AddDate(0, 0, sub.Frequency.Days())
+	}
+	sub.Items = items
+
+	if err := h.DB.Create(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription"})
+		return
+	}
+
+	h.DB.Preload("Items.Product").First(&sub, sub.ID)
+	c.JSON(http.StatusCreated, sub)
+}
+
+func (h *SubscriptionHandler) GetSubscription(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sub, ok := h.loadSubscription(c, userID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+// UpdateSubscription changes a subscription's settings, or replaces its basket
+// when items are given.
+func (h *SubscriptionHandler) UpdateSubscription(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sub, ok := h.loadSubscription(c, userID)
+	if !ok {
+		return
+	}
+	if sub.Status == models.SubscriptionCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Subscription has been cancelled"})
+		return
+	}
+
+	var req subscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	items, err := applySubscriptionRequest(h.DB, h.Payments, sub, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Items").Save(sub).Error; err != nil {
+			return err
+		}
+		if items == nil {
+			return nil
+		}
+		if err := tx.Where("subscription_id = ?", sub.ID).Delete(&models.SubscriptionItem{}).Error; err != nil {
+			return err
+		}
+		for i := range items {
+			items[i].SubscriptionID = sub.ID
+		}
+		return tx.Create(&items).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update subscription"})
+		return
+	}
+
+	h.DB.Preload("Items.Product").First(sub, sub.ID)
+	c.JSON(http.StatusOK, sub)
+}
+
+// setSubscriptionSchedule saves a status and next order time for an active or
+// paused subscription and writes the result.
+func (h *SubscriptionHandler) setSubscriptionSchedule(c *gin.Context, sub *models.Subscription, status models.SubscriptionStatus, next time.Time) {
+	sub.Status = status
+	sub.NextOrderAt = next
+	if err := h.DB.Model(sub).Updates(map[string]interface{}{"status": status, "next_order_at": next}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update subscription"})
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+// SkipSubscription skips the next scheduled order.
+func (h *SubscriptionHandler) SkipSubscription(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sub, ok := h.loadSubscription(c, userID)
+	if !ok {
+		return
+	}
+	if sub.Status != models.SubscriptionActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only an active subscription can skip an order"})
+		return
+	}
+	h.setSubscriptionSchedule(c, sub, sub.Status, sub.NextOrderAt.AddDate(0, 0, sub.Frequency.Days()))
+}
+
+// PauseSubscription stops orders until the subscription is resumed.
+func (h *SubscriptionHandler) PauseSubscription(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sub, ok := h.loadSubscription(c, userID)
+	if !ok {
+		return
+	}
+	if sub.Status != models.SubscriptionActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only an active subscription can be paused"})
+		return
+	}
+	h.setSubscriptionSchedule(c, sub, models.SubscriptionPaused, sub.NextOrderAt)
+}
+
+// ResumeSubscription restarts a paused subscription on its usual day, skipping
+// any orders that fell due while it was paused.
+func (h *SubscriptionHandler) ResumeSubscription(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sub, ok := h.loadSubscription(c, userID)
+	if !ok {
+		return
+	}
+	if sub.Status != models.SubscriptionPaused {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only a paused subscription can be resumed"})
+		return
+	}
+	h.setSubscriptionSchedule(c, sub, models.SubscriptionActive, nextSubscriptionOrder(sub.NextOrderAt, sub.Frequency, time.Now()))
+}
+
+func (h *SubscriptionHandler) CancelSubscription(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sub, ok := h.loadSubscription(c, userID)
+	if !ok {
+		return
+	}
+	if err := h.DB.Model(sub).Update("status", models.SubscriptionCancelled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel subscription"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Subscription cancelled"})
+}
+
+// nextSubscriptionOrder returns the first scheduled time after now, stepping on
+// from at by the subscription's frequency.
+func nextSubscriptionOrder(at time.Time, frequency models.SubscriptionFrequency, now time.Time) time.Time {
+	for !at.After(now) {
+		at = at.AddDate(0, 0, frequency.Days())
+	}
+	return at
+}
+
+// subscriptionBasket builds the basket for a subscription's next order from
+// what can be bought today: deleted products are swapped for a substitute,
+// products the store has stopped selling or run out of are left out, and
+// quantities are cut to stock.
+func subscriptionBasket(db *gorm.DB, sub models.Subscription) ([]models.CartItem, error) {
+	var basket []models.CartItem
+	for _, item := range sub.Items {
+		product, _, err := currentProduct(db, sub.FranchiseID, item.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		if product == nil {
+			continue
+		}
+		stock, sold, err := availableStock(db, sub.FranchiseID, *product)
+		if err != nil {
+			return nil, err
+		}
+		if !sold || stock <= 0 {
+			continue
+		}
+		basket = append(basket, models.CartItem{
+			UserID:      sub.UserID,
+			ProductID:   product.ID,
+			Product:     *product,
+			FranchiseID: sub.FranchiseID,
+			Quantity:    min(item.Quantity, stock),
+		})
+	}
+	return basket, nil
+}
+
+// placeSubscriptionOrder places one order for a subscription with its saved
+// settings. The customer's cart is left alone.
+func (h *OrderHandler) placeSubscriptionOrder(sub models.Subscription) (*models.Order, error) {
+	basket, err := subscriptionBasket(h.DB, sub)
+	if err != nil {
+		return nil, err
+	}
+	if len(basket) == 0 {
+		return nil, errors.New("nothing in the subscription is available")
+	}
+
+	req := orderRequest{
+		FulfilmentType:     string(sub.FulfilmentType),
+		PaymentMethod:      sub.PaymentMethod,
+		SubscriptionID:     &sub.ID,
+		SavedPaymentMethod: sub.SavedMethod,
+	}
+	if sub.FranchiseID != nil {
+		req.FranchiseID = sub.FranchiseID.String()
+	}
+	if sub.AddressID != nil {
+		req.AddressID = sub.AddressID.String()
+	}
+	return h.placeOrder(sub.UserID, req, basket, false, nil, "system")
+}
+
+// PlaceDueSubscriptions places an order for every active subscription due by
+// now and moves each on to its next date. A subscription is claimed by moving
+// its date before ordering, so concurrent runs never order it twice. When an
+// order cannot be placed the reason is kept in last_error and the subscription
+// waits for its next date. It returns the number of orders placed.
+func PlaceDueSubscriptions(orders *OrderHandler, now time.Time) (int, error) {
+	var due []models.Subscription
+	if err := orders.DB.Preload("Items").Where("status = ? AND next_order_at <= ?", models.SubscriptionActive, now).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	placed := 0
+	for _, sub := range due {
+		next := nextSubscriptionOrder(sub.NextOrderAt, sub.Frequency, now)
+		claim := orders.DB.Model(&models.Subscription{}).
+			Where("id = ? AND status = ? AND next_order_at = ?", sub.ID, models.SubscriptionActive, sub.NextOrderAt).
+			Update("next_order_at", next)
+		if claim.Error != nil {
+			return placed, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		updates := map[string]interface{}{"last_error": ""}
+		order, err := orders.placeSubscriptionOrder(sub)
+		if err != nil {
+			log.Printf("Failed to place subscription order for %s: %v", sub.ID, err)
+			updates["last_error"] = err.Error()
+		} else {
+			updates["last_order_id"] = order.ID
+			placed++
+		}
+		if err := orders.DB.Model(&models.Subscription{}).Where("id = ?", sub.ID).Updates(updates).Error; err != nil {
+			log.Printf("Failed to record subscription order for %s: %v", sub.ID, err)
+		}
+	}
+	return placed, nil
+}
+
+// StartSubscriptionJob places due subscription orders every interval until the
+// returned stop function is called.
+func StartSubscriptionJob(orders *OrderHandler, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				n, err := PlaceDueSubscriptions(orders, now)
+				if err != nil {
+					log.Printf("Failed to place subscription orders: %v", err)
+				} else if n > 0 {
+					log.Printf("Placed %d subscription orders", n)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grabbi-backend/models"
+	"grabbi-backend/payments"
+)
+
+func TestSubscriptionFromOrderSkipPauseResume(t *testing.T) {
+	db := freshDB()
+	router := setupSubscriptionRouter(db, nil)
+	owner, _ := seedTestUser(db, "sub-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Subscription Store", owner.ID)
+	cat := seedCategory(db, "Subscription Cat")
+	milk := seedProduct(db, "Milk", cat.ID, 1.10)
+	seedFranchiseProduct(db, franchise.ID, milk.ID)
+	customer, token := seedTestUser(db, "sub@test.com", "customer", nil)
+
+	addToCart(t, router, token, milk.ID, franchise.ID, 2)
+	order := placeCollectionOrder(t, router, token, franchise.ID)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/subscriptions", map[string]interface{}{
+		"frequency": "weekly", "from_order_id": order["id"], "payment_method": "cash",
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var sub models.Subscription
+	db.Preload("Items").First(&sub)
+	if sub.FulfilmentType != models.FulfilmentCollection || len(sub.Items) != 1 || sub.Items[0].Quantity != 2 {
+		t.Fatalf("expected 2 milk for collection copied from the order, got %+v", sub)
+	}
+	if days := time.Until(sub.NextOrderAt).Hours() / 24; days < 6.9 || days > 7.1 {
+		t.Errorf("expected the first order in a week, got %.1f days", days)
+	}
+	path := "/api/subscriptions/" + sub.ID.String()
+
+	// Switching to delivery needs somewhere to deliver to
+	toDelivery := map[string]interface{}{"fulfilment_type": "delivery"}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", path, toDelivery, token))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 switching to delivery without an address, got %d: %s", w.Code, w.Body.String())
+	}
+	seedAddress(db, customer.ID, "1 Subscription St", 51.5074, -0.1278, true)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", path, toDelivery, token))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 switching to delivery with a default address, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", path+"/skip", nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 skipping, got %d: %s", w.Code, w.Body.String())
+	}
+	var skipped models.Subscription
+	db.First(&skipped, "id = ?", sub.ID)
+	if gap := skipped.NextOrderAt.Sub(sub.NextOrderAt); gap != 7*24*time.Hour {
+		t.Errorf("expected skipping to move the order on a week, moved %v", gap)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", path+"/resume", nil, token))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 resuming an active subscription, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", path+"/pause", nil, token))
+	if w.Code != http.StatusOK || parseResponse(w)["status"] != "paused" {
+		t.Fatalf("expected 200 pausing, got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", path+"/skip", nil, token))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 skipping while paused, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", path+"/resume", nil, token))
+	if w.Code != http.StatusOK || parseResponse(w)["status"] != "active" {
+		t.Errorf("expected 200 resuming, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("DELETE", path, nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 cancelling, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/subscriptions", nil, token))
+	if subs := parseResponseArray(w); len(subs) != 0 {
+		t.Errorf("expected a cancelled subscription to be hidden, got %d", len(subs))
+	}
+}
+
+func TestPlaceDueSubscriptionsOrdersOncePerPeriod(t *testing.T) {
+	db := freshDB()
+	router := setupSubscriptionRouter(db, nil)
+	owner, _ := seedTestUser(db, "due-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Due Store", owner.ID)
+	cat := seedCategory(db, "Due Cat")
+	eggs := seedProduct(db, "Eggs", cat.ID, 2.40)
+	bread := seedProduct(db, "Bread", cat.ID, 1.30)
+	seedFranchiseProduct(db, franchise.ID, eggs.ID)
+	seedFranchiseProduct(db, franchise.ID, bread.ID)
+	customer, token := seedTestUser(db, "due@test.com", "customer", nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/subscriptions", map[string]interface{}{
+		"frequency": "fortnightly", "franchise_id": franchise.ID, "fulfilment_type": "collection",
+		"payment_method": "cash", "items": []map[string]interface{}{{"product_id": eggs.ID, "quantity": 1}},
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	subID := parseResponse(w)["id"].(string)
+	db.Model(&models.Subscription{}).Where("id = ?", subID).Update("next_order_at", time.Now().Add(-time.Hour))
+
+	// Something in the cart stays there when the subscription orders
+	addToCart(t, router, token, bread.ID, franchise.ID, 1)
+
+	orders := &OrderHandler{DB: db, Storage: newMockStorage()}
+	placed, err := PlaceDueSubscriptions(orders, time.Now())
+	if err != nil || placed != 1 {
+		t.Fatalf("expected 1 order placed, got %d (%v)", placed, err)
+	}
+	var order models.Order
+	db.Preload("Items").Where("user_id = ?", customer.ID).First(&order)
+	if order.SubscriptionID == nil || order.SubscriptionID.String() != subID || len(order.Items) != 1 || order.Items[0].ProductID != eggs.ID {
+		t.Errorf("expected an order of eggs from the subscription, got %+v", order)
+	}
+	var cartLines int64
+	db.Model(&models.CartItem{}).Where("user_id = ?", customer.ID).Count(&cartLines)
+	if cartLines != 1 {
+		t.Errorf("expected the cart to be left alone, got %d lines", cartLines)
+	}
+	var sub models.Subscription
+	db.First(&sub, "id = ?", subID)
+	if sub.LastOrderID == nil || *sub.LastOrderID != order.ID || !sub.NextOrderAt.After(time.Now().AddDate(0, 0, 13)) {
+		t.Errorf("expected the order recorded and the next one a fortnight on, got %+v", sub)
+	}
+
+	if placed, _ := PlaceDueSubscriptions(orders, time.Now()); placed != 0 {
+		t.Errorf("expected nothing due on a second run, got %d", placed)
+	}
+
+	// When nothing can be ordered the reason is kept and the schedule moves on
+	db.Model(&models.FranchiseProduct{}).Where("product_id = ?", eggs.ID).Update("stock_quantity", 0)
+	db.Model(&models.Subscription{}).Where("id = ?", subID).Update("next_order_at", time.Now().Add(-time.Hour))
+	if placed, _ := PlaceDueSubscriptions(orders, time.Now()); placed != 0 {
+		t.Errorf("expected no order without stock, got %d", placed)
+	}
+	db.First(&sub, "id = ?", subID)
+	if sub.LastError == "" || !sub.NextOrderAt.After(time.Now()) {
+		t.Errorf("expected last_error set and the schedule advanced, got %+v", sub)
+	}
+}
+
+func TestCardSubscriptionChargesSavedMethod(t *testing.T) {
+	db := freshDB()
+	provider := payments.NewFakeProvider()
+	router := setupSubscriptionRouter(db, provider)
+	owner, _ := seedTestUser(db, "card-sub-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Card Subscription Store", owner.ID)
+	cat := seedCategory(db, "Card Subscription Cat")
+	eggs := seedProduct(db, "Eggs", cat.ID, 3.00)
+	seedFranchiseProduct(db, franchise.ID, eggs.ID)
+	customer, token := seedTestUser(db, "card-sub@test.com", "customer", nil)
+
+	subscribe := func(extra map[string]interface{}) *httptest.ResponseRecorder {
+		body := map[string]interface{}{
+			"frequency": "weekly", "franchise_id": franchise.ID, "fulfilment_type": "collection",
+			"items": []map[string]interface{}{{"product_id": eggs.ID, "quantity": 1}},
+		}
+		for k, v := range extra {
+			body[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("POST", "/api/subscriptions", body, token))
+		return w
+	}
+
+	for name, extra := range map[string]map[string]interface{}{
+		"an unknown payment method": {"payment_method": "bitcoin", "payment_token": "tok_visa"},
+		"a gift card":               {"payment_method": "gift_card"},
+		"a card without a token":    {"payment_method": "card"},
+		"a declined card":           {"payment_method": "card", "payment_token": payments.DeclineToken},
+	} {
+		if w := subscribe(extra); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	w := subscribe(map[string]interface{}{"payment_method": "card", "payment_token": "tok_visa"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	subID := parseResponse(w)["id"].(string)
+	var sub models.Subscription
+	db.First(&sub, "id = ?", subID)
+	if sub.SavedMethod == "" || sub.SavedMethod == "tok_visa" {
+		t.Fatalf("expected a saved method in place of the one-time token, got %q", sub.SavedMethod)
+	}
+
+	// Every scheduled order is authorized against the saved method
+	orders := &OrderHandler{DB: db, Storage: newMockStorage(), Payments: provider}
+	for run := 0; run < 2; run++ {
+		db.Model(&models.Subscription{}).Where("id = ?", subID).Update("next_order_at", time.Now().Add(-time.Hour))
+		if placed, err := PlaceDueSubscriptions(orders, time.Now()); err != nil || placed != 1 {
+			t.Fatalf("run %d: expected 1 order placed, got %d (%v)", run, placed, err)
+		}
+	}
+	var authorized int64
+	db.Model(&models.Payment{}).Joins("JOIN orders ON orders.id = payments.order_id").
+		Where("orders.user_id = ? AND payments.status = ?", customer.ID, models.PaymentStatusAuthorized).Count(&authorized)
+	if authorized != 2 {
+		t.Errorf("expected both scheduled orders authorized, got %d", authorized)
+	}
+}
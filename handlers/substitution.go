@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"grabbi-backend/models"
+	"grabbi-backend/pricing"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// substitutionRequest is the customer's choice at checkout for one product in
+// the basket. Products without one accept any similar substitute.
+type substitutionRequest struct {
+	ProductID    uuid.UUID                     `json:"product_id" binding:"required"`
+	Preference   models.SubstitutionPreference `json:"preference" binding:"required"`
+	SubstituteID *uuid.UUID                    `json:"substitute_id"`
+}
+
+// applySubstitutionPreferences records the customer's substitution choices on
+// the items of a new order.
+func applySubstitutionPreferences(db *gorm.DB, items []models.OrderItem, prefs []substitutionRequest) error {
+	for i := range items {
+		items[i].SubstitutionPreference = models.SubstitutionAllow
+	}
+
+	for _, pref := range prefs {
+		switch pref.Preference {
+		case models.SubstitutionAllow, models.SubstitutionNone:
+			if pref.SubstituteID != nil {
+				return &checkoutError{http.StatusBadRequest, "substitute_id is only used with a specific preference"}
+			}
+		case models.SubstitutionSpecific:
+			if pref.SubstituteID == nil {
+				return &checkoutError{http.StatusBadRequest, "substitute_id is required for a specific substitute"}
+			}
+			if *pref.SubstituteID == pref.ProductID {
+				return &checkoutError{http.StatusBadRequest, "A product cannot substitute for itself"}
+			}
+			var count int64
+			if err := db.Model(&models.Product{}).Where("id = ?", *pref.SubstituteID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return &checkoutError{http.StatusBadRequest, "Substitute product not found"}
+			}
+		default:
+			return &checkoutError{http.StatusBadRequest, "preference must be 'allow', 'none' or 'specific'"}
+		}
+
+		found := false
+		for i := range items {
+			if items[i].ProductID == pref.ProductID {
+				items[i].SubstitutionPreference = pref.Preference
+				items[i].PreferredSubstituteID = pref.SubstituteID
+				found = true
+			}
+		}
+		if !found {
+			return &checkoutError{http.StatusBadRequest, "substitutions name a product that is not in the basket"}
+		}
+	}
+	return nil
+}
+
+// SubstituteOrderItem replaces units of an item the store has run out of with
+// another product while the order is being prepared. The substitute is the
+// requested substitute_product_id, the customer's chosen product, or the
+// nearest-priced product in stock from the same subcategory. The customer never
+// pays more than they paid for the original; a cheaper substitute refunds the
+// difference.
+func (h *FranchiseHandler) SubstituteOrderItem(c *gin.Context) {
+	var req struct {
+		SubstituteProductID *uuid.UUID `json:"substitute_product_id"`
+		Quantity            int        `json:"quantity" binding:"omitempty,min=1"`
+	}
+	// The body is optional
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	h.changeOrderItem(c, models.OrderItemSubstituted, req.SubstituteProductID, req.Quantity)
+}
+
+// ShortPickOrderItem leaves units of an item out of an order being prepared and
+// refunds what was paid for them.
+func (h *FranchiseHandler) ShortPickOrderItem(c *gin.Context) {
+	var req struct {
+		Quantity int `json:"quantity" binding:"omitempty,min=1"`
+	}
+	// The body is optional
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	h.changeOrderItem(c, models.OrderItemShortPicked, nil, req.Quantity)
+}
+
+// changeOrderItem substitutes or short picks quantity units of an order item,
+// all of what is left of it when quantity is 0, refunds any difference and
+// records the change for the customer.
+func (h *FranchiseHandler) changeOrderItem(c *gin.Context, changeType models.OrderItemChangeType, substituteID *uuid.UUID, quantity int) {
+	franchiseID, _ := c.Get("franchise_id")
+	actorID, _ := statusActor(c)
+
+	tx := h.DB.Begin()
+
+	// Lock the order so concurrent changes and refunds see each other's amounts
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND franchise_id = ?", c.Param("id"), franchiseID).First(&order).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if order.Status != models.OrderStatusPreparing {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Items can only be changed while the order is being prepared"})
+		return
+	}
+
+	var item models.OrderItem
+	if err := tx.Where("id = ? AND order_id = ?", c.Param("item_id"), order.ID).First(&item).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order item not found"})
+		return
+	}
+	if item.SubstituteForID != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A substitute cannot be changed"})
+		return
+	}
+
+	open := item.Quantity - item.RefundedQuantity - item.SubstitutedQuantity
+	if quantity == 0 {
+		quantity = open
+	}
+	if quantity == 0 || quantity > open {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Only %d of %s can be changed", open, item.ProductName)})
+		return
+	}
+
+	paid := itemPaidAmount(item, quantity)
+	change := models.OrderItemChange{
+		OrderID:       order.ID,
+		OrderItemID:   item.ID,
+		Type:          changeType,
+		ProductName:   item.ProductName,
+		Quantity:      quantity,
+		PreviousPrice: pricing.RoundMoney(paid / float64(quantity)),
+		ActorID:       actorID,
+	}
+	refund := models.Refund{OrderID: order.ID}
+	if actorID != nil {
+		refund.CreatedByID = *actorID
+	}
+
+	if changeType == models.OrderItemShortPicked {
+		// The units were never there, so nothing goes back into stock
+		if err := tx.Model(&item).UpdateColumn("refunded_quantity", gorm.Expr("refunded_quantity + ?", quantity)).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change order item"})
+			return
+		}
+		refund.Amount = paid
+		refund.Reason = item.ProductName + " was out of stock"
+		refund.Items = []models.RefundItem{{OrderItemID: item.ID, ProductID: item.ProductID, Quantity: quantity, Amount: paid}}
+	} else {
+		substitute, err := orderItemSubstitute(tx, order, item, substituteID)
+		if err != nil {
+			tx.Rollback()
+			respondCheckoutError(c, err, "Failed to find a substitute")
+			return
+		}
+		subItem, err := substituteOrderItem(tx, order, item, *substitute, quantity, change.PreviousPrice)
+		if err != nil {
+			tx.Rollback()
+			respondCheckoutError(c, err, "Failed to change order item")
+			return
+		}
+		change.SubstituteItemID = &subItem.ID
+		change.SubstituteName = subItem.ProductName
+		change.Price = subItem.Price
+		refund.Amount = pricing.RoundMoney(paid - subItem.Price*float64(quantity))
+		refund.Reason = "Substituted " + item.ProductName + " with " + subItem.ProductName
+	}
+
+	unsettled := 0.0
+	if refund.Amount > 0 {
+		if err := tx.Create(&refund).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refund"})
+			return
+		}
+		if _, err := reverseRefundedPoints(tx, order, refund.ID); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reverse loyalty points"})
+			return
+		}
+		var err error
+		if unsettled, err = settleRefund(tx, h.Payments, &order, &refund); err != nil {
+			tx.Rollback()
+			respondCheckoutError(c, err, "Failed to create refund")
+			return
+		}
+		change.RefundID = &refund.ID
+		change.RefundAmount = refund.Amount
+	}
+
+	if err := tx.Create(&change).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change order item"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("CRITICAL: change to order %s refunded %.2f but was not recorded: %v", order.ID, refund.Amount, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change order item"})
+		return
+	}
+	if unsettled > 0 {
+		log.Printf("Refund %s for order %s has %.2f to be returned outside the payment provider", refund.ID, order.ID, unsettled)
+	}
+
+	publishOrderUpdate(order, utils.OrderUpdateItemsChanged)
+	c.JSON(http.StatusCreated, change)
+}
+
+// orderItemSubstitute picks the product to substitute for an order item,
+// respecting the customer's preference. substituteID is the store's choice, if
+// it made one.
+func orderItemSubstitute(tx *gorm.DB, order models.Order, item models.OrderItem, substituteID *uuid.UUID) (*models.Product, error) {
+	switch item.SubstitutionPreference {
+	case models.SubstitutionNone:
+		return nil, &checkoutError{http.StatusConflict, "The customer does not accept substitutes for " + item.ProductName}
+	case models.SubstitutionSpecific:
+		if item.PreferredSubstituteID == nil {
+			break
+		}
+		if substituteID != nil && *substituteID != *item.PreferredSubstituteID {
+			return nil, &checkoutError{http.StatusConflict, "The customer only accepts their chosen substitute for " + item.ProductName}
+		}
+		substituteID = item.PreferredSubstituteID
+	}
+
+	if substituteID == nil {
+		var original models.Product
+		if err := tx.Unscoped().Where("id = ?", item.ProductID).First(&original).Error; err != nil {
+			return nil, err
+		}
+		substitute, err := findSubstitute(tx, order.FranchiseID, original)
+		if err != nil {
+			return nil, err
+		}
+		if substitute == nil {
+			return nil, &checkoutError{http.StatusNotFound, "No substitute in stock for " + item.ProductName}
+		}
+		return substitute, nil
+	}
+
+	if *substituteID == item.ProductID {
+		return nil, &checkoutError{http.StatusBadRequest, "A product cannot substitute for itself"}
+	}
+	var substitute models.Product
+	if err := tx.Where("id = ? AND status = ?", *substituteID, "active").First(&substitute).Error; err != nil {
+		return nil, &checkoutError{http.StatusNotFound, "Substitute product not found"}
+	}
+	return &substitute, nil
+}
+
+// substituteOrderItem adds a line for quantity units of substitute in place of
+// item and takes them from stock. Each unit is charged at the store's current
+// price, capped at paidEach.
+func substituteOrderItem(tx *gorm.DB, order models.Order, item models.OrderItem, substitute models.Product, quantity int, paidEach float64) (*models.OrderItem, error) {
+	stock, sold, err := availableStock(tx, order.FranchiseID, substitute)
+	if err != nil {
+		return nil, err
+	}
+	if !sold || stock < quantity {
+		return nil, &checkoutError{http.StatusBadRequest, "Insufficient stock for " + substitute.ItemName}
+	}
+	prices, err := cartUnitPrices(tx, order.FranchiseID, []models.Product{substitute})
+	if err != nil {
+		return nil, err
+	}
+
+	var image models.ProductImage
+	tx.Where("product_id = ? AND is_primary = ?", substitute.ID, true).Limit(1).Find(&image)
+
+	line := models.OrderItem{
+		OrderID:                order.ID,
+		ProductID:              substitute.ID,
+		ImageURL:               image.ImageURL,
+		ProductName:            substitute.ItemName,
+		ProductSKU:             substitute.SKU,
+		Quantity:               quantity,
+		Price:                  min(prices[0], paidEach),
+		SubstitutionPreference: models.SubstitutionNone,
+		SubstituteForID:        &item.ID,
+	}
+	if err := tx.Omit("Product", "Order", "Discounts").Create(&line).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&item).UpdateColumn("substituted_quantity", gorm.Expr("substituted_quantity + ?", quantity)).Error; err != nil {
+		return nil, err
+	}
+	restockProduct(tx, order.FranchiseID, substitute.ID, -quantity)
+	return &line, nil
+}
+
+// GetOrderChanges summarises the items substituted or left out of an order and
+// what was refunded for them. Customers see their own orders and franchise roles
+// their franchise's orders.
+func (h *OrderHandler) GetOrderChanges(c *gin.Context) {
+	var order models.Order
+	if err := orderAccessQuery(c, h.DB, c.Param("id")).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	var changes []models.OrderItemChange
+	if err := h.DB.Where("order_id = ?", order.ID).Order("created_at ASC").Find(&changes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order changes"})
+		return
+	}
+
+	substituted, shortPicked, refunded := 0, 0, 0.0
+	for _, change := range changes {
+		if change.Type == models.OrderItemSubstituted {
+			substituted += change.Quantity
+		} else {
+			shortPicked += change.Quantity
+		}
+		refunded += change.RefundAmount
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_id":     order.ID,
+		"changes":      changes,
+		"substituted":  substituted,
+		"short_picked": shortPicked,
+		"refunded":     pricing.RoundMoney(refunded),
+	})
+}
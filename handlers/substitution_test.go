@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// startPicking moves an order from pending to preparing as the store.
+func startPicking(t *testing.T, router http.Handler, orderID, ownerToken string) {
+	t.Helper()
+	for _, status := range []string{"confirmed", "preparing"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("PUT", "/api/franchise/orders/"+orderID+"/status", map[string]string{"status": status}, ownerToken))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d: %s", status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPickerSubstitutionsFollowPreferencesAndRefund(t *testing.T) {
+	db := freshDB()
+	router := setupSubstitutionRouter(db, nil)
+	owner, _ := seedTestUser(db, "pick-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Pick Store", owner.ID)
+	_, ownerToken := seedFranchiseOwnerWithToken(db, franchise)
+	cat := seedCategory(db, "Pick Cat")
+	tea := seedProduct(db, "Tea", cat.ID, 3.00)
+	greenTea := seedProduct(db, "Green Tea", cat.ID, 2.50)
+	coffee := seedProduct(db, "Coffee", cat.ID, 5.00)
+	decaf := seedProduct(db, "Decaf", cat.ID, 4.00)
+	milk := seedProduct(db, "Milk", cat.ID, 1.00)
+	for _, p := range []models.Product{tea, greenTea, coffee, decaf, milk} {
+		seedFranchiseProduct(db, franchise.ID, p.ID)
+	}
+	_, token := seedTestUser(db, "pick@test.com", "customer", nil)
+
+	addToCart(t, router, token, tea.ID, franchise.ID, 2)
+	addToCart(t, router, token, coffee.ID, franchise.ID, 1)
+	addToCart(t, router, token, milk.ID, franchise.ID, 3)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{
+		"payment_method":  "cash",
+		"fulfilment_type": "collection", "franchise_id": franchise.ID,
+		"substitutions": []map[string]interface{}{
+			{"product_id": coffee.ID, "preference": "specific", "substitute_id": decaf.ID},
+			{"product_id": milk.ID, "preference": "none"},
+		},
+	}, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	orderID := parseResponse(w)["id"].(string)
+
+	items := map[uuid.UUID]models.OrderItem{}
+	var orderItems []models.OrderItem
+	db.Where("order_id = ?", orderID).Find(&orderItems)
+	for _, item := range orderItems {
+		items[item.ProductID] = item
+	}
+	if items[tea.ID].SubstitutionPreference != models.SubstitutionAllow || items[milk.ID].SubstitutionPreference != models.SubstitutionNone {
+		t.Errorf("expected tea to allow and milk to refuse substitutes, got %q and %q",
+			items[tea.ID].SubstitutionPreference, items[milk.ID].SubstitutionPreference)
+	}
+	itemPath := func(productID uuid.UUID, action string) string {
+		return "/api/franchise/orders/" + orderID + "/items/" + items[productID].ID.String() + "/" + action
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", itemPath(tea.ID, "substitute"), nil, ownerToken))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 before picking starts, got %d", w.Code)
+	}
+	startPicking(t, router, orderID, ownerToken)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", itemPath(milk.ID, "substitute"), nil, ownerToken))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 substituting milk, got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", itemPath(coffee.ID, "substitute"), map[string]interface{}{"substitute_product_id": greenTea.ID}, ownerToken))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a substitute the customer did not choose, got %d", w.Code)
+	}
+
+	// Coffee becomes the customer's choice of decaf, a pound cheaper
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", itemPath(coffee.ID, "substitute"), nil, ownerToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if change := parseResponse(w); change["substitute_name"] != "Decaf" || change["refund_amount"] != 1.0 {
+		t.Errorf("expected decaf with 1.00 refunded, got %v", change)
+	}
+
+	// One tea becomes the nearest-priced alternative
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", itemPath(tea.ID, "substitute"), map[string]interface{}{"quantity": 1}, ownerToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if change := parseResponse(w); change["substitute_name"] != "Green Tea" || change["refund_amount"] != 0.5 {
+		t.Errorf("expected green tea with 0.50 refunded, got %v", change)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", itemPath(milk.ID, "short-pick"), map[string]interface{}{"quantity": 2}, ownerToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", itemPath(milk.ID, "short-pick"), map[string]interface{}{"quantity": 2}, ownerToken))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 short picking more than is left, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/orders/"+orderID+"/changes", nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	summary := parseResponse(w)
+	if len(summary["changes"].([]interface{})) != 3 || summary["substituted"] != 2.0 || summary["short_picked"] != 2.0 || summary["refunded"] != 3.5 {
+		t.Errorf("expected 2 substituted, 2 short picked and 3.50 refunded, got %v", summary)
+	}
+
+	var order models.Order
+	db.First(&order, "id = ?", orderID)
+	if order.RefundedAmount != 3.5 {
+		t.Errorf("expected 3.50 refunded on the order, got %.2f", order.RefundedAmount)
+	}
+	var milkItem models.OrderItem
+	db.First(&milkItem, "id = ?", items[milk.ID].ID)
+	if milkItem.RefundedQuantity != 2 {
+		t.Errorf("expected 2 milk refunded, got %d", milkItem.RefundedQuantity)
+	}
+	var decafLine models.OrderItem
+	db.Where("order_id = ? AND product_id = ?", orderID, decaf.ID).First(&decafLine)
+	if decafLine.SubstituteForID == nil || *decafLine.SubstituteForID != items[coffee.ID].ID || decafLine.Price != 4.0 {
+		t.Errorf("expected a decaf line at 4.00 in place of coffee, got %+v", decafLine)
+	}
+	var decafStock models.FranchiseProduct
+	db.Where("franchise_id = ? AND product_id = ?", franchise.ID, decaf.ID).First(&decafStock)
+	if decafStock.StockQuantity != 49 {
+		t.Errorf("expected the substitute taken from stock, got %d", decafStock.StockQuantity)
+	}
+}
+
+func TestCheckoutRejectsInvalidSubstitutionPreferences(t *testing.T) {
+	db := freshDB()
+	router := setupSubstitutionRouter(db, nil)
+	owner, _ := seedTestUser(db, "pref-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Pref Store", owner.ID)
+	cat := seedCategory(db, "Pref Cat")
+	rice := seedProduct(db, "Rice", cat.ID, 2.00)
+	beans := seedProduct(db, "Beans", cat.ID, 1.00)
+	seedFranchiseProduct(db, franchise.ID, rice.ID)
+	_, token := seedTestUser(db, "pref@test.com", "customer", nil)
+	addToCart(t, router, token, rice.ID, franchise.ID, 1)
+
+	for name, pref := range map[string]map[string]interface{}{
+		"specific without a product": {"product_id": rice.ID, "preference": "specific"},
+		"unknown preference":         {"product_id": rice.ID, "preference": "maybe"},
+		"product not in the basket":  {"product_id": beans.ID, "preference": "none"},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, authRequest("POST", "/api/orders", map[string]interface{}{
+			"payment_method":  "cash",
+			"fulfilment_type": "collection", "franchise_id": franchise.ID,
+			"substitutions": []map[string]interface{}{pref},
+		}, token))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+}
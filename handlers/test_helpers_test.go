@@ -14,6 +14,7 @@ import (
 
 	"grabbi-backend/middleware"
 	"grabbi-backend/models"
+	"grabbi-backend/payments"
 	"grabbi-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -53,6 +54,31 @@ func TestMain(m *testing.M) {
 // freshDB returns a clean database for each test by deleting all rows.
 func freshDB() *gorm.DB {
 	// Delete in correct order to respect foreign keys
+	testDB.Exec("DELETE FROM idempotency_records")
+	testDB.Exec("DELETE FROM delivery_slots")
+	testDB.Exec("DELETE FROM stock_reservations")
+	testDB.Exec("DELETE FROM coupon_redemptions")
+	testDB.Exec("DELETE FROM coupon_targets")
+	testDB.Exec("DELETE FROM coupons")
+	testDB.Exec("DELETE FROM pricing_rules")
+	testDB.Exec("DELETE FROM order_item_discounts")
+	testDB.Exec("DELETE FROM loyalty_tiers")
+	testDB.Exec("DELETE FROM loyalty_campaigns")
+	testDB.Exec("DELETE FROM referrals")
+	testDB.Exec("DELETE FROM gift_card_transactions")
+	testDB.Exec("DELETE FROM gift_cards")
+	testDB.Exec("DELETE FROM user_addresses")
+	testDB.Exec("DELETE FROM guest_cart_items")
+	testDB.Exec("DELETE FROM guest_carts")
+	testDB.Exec("DELETE FROM wishlist_items")
+	testDB.Exec("DELETE FROM subscription_items")
+	testDB.Exec("DELETE FROM subscriptions")
+	testDB.Exec("DELETE FROM order_item_changes")
+	testDB.Exec("DELETE FROM order_status_events")
+	testDB.Exec("DELETE FROM refund_items")
+	testDB.Exec("DELETE FROM refunds")
+	testDB.Exec("DELETE FROM payment_events")
+	testDB.Exec("DELETE FROM payments")
 	testDB.Exec("DELETE FROM order_items")
 	testDB.Exec("DELETE FROM orders")
 	testDB.Exec("DELETE FROM cart_items")
@@ -85,6 +111,7 @@ func createSQLiteTables(db *gorm.DB) error {
 			"role" TEXT DEFAULT 'customer',
 			"franchise_id" TEXT,
 			"loyalty_points" INTEGER DEFAULT 0,
+			"referral_code" TEXT UNIQUE,
 			"phone" TEXT,
 			"is_blocked" INTEGER DEFAULT 0,
 			"created_at" DATETIME,
@@ -163,6 +190,7 @@ func createSQLiteTables(db *gorm.DB) error {
 			"created_at" DATETIME,
 			"updated_at" DATETIME,
 			"deleted_at" DATETIME,
+			"deleted_by" TEXT,
 			CONSTRAINT fk_products_category FOREIGN KEY ("category_id") REFERENCES "categories"("id")
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_products_deleted_at ON "products"("deleted_at")`,
@@ -225,6 +253,7 @@ func createSQLiteTables(db *gorm.DB) error {
 			"delivery_radius" REAL DEFAULT 5,
 			"delivery_fee" REAL DEFAULT 4.99,
 			"free_delivery_min" REAL DEFAULT 50,
+			"slot_capacity" INTEGER DEFAULT 10,
 			"phone" TEXT,
 			"email" TEXT,
 			"is_active" INTEGER DEFAULT 1,
@@ -262,6 +291,7 @@ func createSQLiteTables(db *gorm.DB) error {
 			"is_available" INTEGER DEFAULT 1,
 			"created_at" DATETIME,
 			"updated_at" DATETIME,
+			"deleted_at" DATETIME,
 			CONSTRAINT fk_franchise_products_franchise FOREIGN KEY ("franchise_id") REFERENCES "franchises"("id"),
 			CONSTRAINT fk_franchise_products_product FOREIGN KEY ("product_id") REFERENCES "products"("id")
 		)`,
@@ -322,11 +352,24 @@ func createSQLiteTables(db *gorm.DB) error {
 			"subtotal" REAL NOT NULL,
 			"delivery_fee" REAL DEFAULT 0,
 			"total" REAL NOT NULL,
+			"fulfilment_type" TEXT DEFAULT 'delivery',
 			"delivery_address" TEXT,
+			"address_id" TEXT,
+			"delivery_notes" TEXT,
+			"pickup_code" TEXT,
 			"payment_method" TEXT,
 			"points_earned" INTEGER DEFAULT 0,
+			"points_redeemed" INTEGER DEFAULT 0,
+			"points_discount" REAL DEFAULT 0,
 			"customer_lat" REAL,
 			"customer_lng" REAL,
+			"refunded_amount" REAL DEFAULT 0,
+			"delivery_slot_id" TEXT,
+			"discount" REAL DEFAULT 0,
+			"coupon_id" TEXT,
+			"coupon_code" TEXT,
+			"subscription_id" TEXT,
+			"gift_card_amount" REAL DEFAULT 0,
 			"created_at" DATETIME,
 			"updated_at" DATETIME,
 			"deleted_at" DATETIME,
@@ -345,6 +388,12 @@ func createSQLiteTables(db *gorm.DB) error {
 			"product_sku" TEXT,
 			"quantity" INTEGER NOT NULL,
 			"price" REAL NOT NULL,
+			"discount" REAL DEFAULT 0,
+			"refunded_quantity" INTEGER DEFAULT 0,
+			"substitution_preference" TEXT DEFAULT 'allow',
+			"preferred_substitute_id" TEXT,
+			"substituted_quantity" INTEGER DEFAULT 0,
+			"substitute_for_id" TEXT,
 			"created_at" DATETIME,
 			"updated_at" DATETIME,
 			CONSTRAINT fk_order_items_order FOREIGN KEY ("order_id") REFERENCES "orders"("id"),
@@ -352,6 +401,41 @@ func createSQLiteTables(db *gorm.DB) error {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON "order_items"("order_id")`,
 		`CREATE INDEX IF NOT EXISTS idx_order_items_product_id ON "order_items"("product_id")`,
+		`CREATE INDEX IF NOT EXISTS idx_order_items_substitute_for_id ON "order_items"("substitute_for_id")`,
+
+		`CREATE TABLE IF NOT EXISTS "order_item_changes" (
+			"id" TEXT PRIMARY KEY,
+			"order_id" TEXT NOT NULL,
+			"order_item_id" TEXT NOT NULL,
+			"type" TEXT NOT NULL,
+			"product_name" TEXT,
+			"quantity" INTEGER NOT NULL,
+			"substitute_item_id" TEXT,
+			"substitute_name" TEXT,
+			"previous_price" REAL,
+			"price" REAL,
+			"refund_id" TEXT,
+			"refund_amount" REAL DEFAULT 0,
+			"actor_id" TEXT,
+			"created_at" DATETIME,
+			CONSTRAINT fk_order_item_changes_order FOREIGN KEY ("order_id") REFERENCES "orders"("id")
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_order_item_changes_order_id ON "order_item_changes"("order_id")`,
+		`CREATE INDEX IF NOT EXISTS idx_order_item_changes_order_item_id ON "order_item_changes"("order_item_id")`,
+
+		`CREATE TABLE IF NOT EXISTS "order_status_events" (
+			"id" TEXT PRIMARY KEY,
+			"order_id" TEXT NOT NULL,
+			"from_status" TEXT,
+			"to_status" TEXT NOT NULL,
+			"actor_id" TEXT,
+			"actor_role" TEXT,
+			"note" TEXT,
+			"created_at" DATETIME,
+			CONSTRAINT fk_order_status_events_order FOREIGN KEY ("order_id") REFERENCES "orders"("id")
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_order_status_events_order_id ON "order_status_events"("order_id")`,
+		`CREATE INDEX IF NOT EXISTS idx_order_status_events_created_at ON "order_status_events"("created_at")`,
 
 		`CREATE TABLE IF NOT EXISTS "password_reset_tokens" (
 			"id" TEXT PRIMARY KEY,
@@ -386,6 +470,292 @@ func createSQLiteTables(db *gorm.DB) error {
 			CONSTRAINT fk_refresh_tokens_user FOREIGN KEY ("user_id") REFERENCES "users"("id")
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON "refresh_tokens"("user_id")`,
+
+		`CREATE TABLE IF NOT EXISTS "payments" (
+			"id" TEXT PRIMARY KEY,
+			"order_id" TEXT NOT NULL,
+			"provider" TEXT NOT NULL,
+			"provider_reference" TEXT,
+			"method" TEXT,
+			"amount" REAL NOT NULL,
+			"captured_amount" REAL DEFAULT 0,
+			"refunded_amount" REAL DEFAULT 0,
+			"currency" TEXT DEFAULT 'GBP',
+			"status" TEXT NOT NULL,
+			"failure_reason" TEXT,
+			"created_at" DATETIME,
+			"updated_at" DATETIME,
+			CONSTRAINT fk_payments_order FOREIGN KEY ("order_id") REFERENCES "orders"("id")
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_order_id ON "payments"("order_id")`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_provider_reference ON "payments"("provider_reference")`,
+
+		`CREATE TABLE IF NOT EXISTS "payment_events" (
+			"id" TEXT PRIMARY KEY,
+			"event_id" TEXT NOT NULL,
+			"type" TEXT NOT NULL,
+			"provider_reference" TEXT,
+			"order_id" TEXT,
+			"payload" TEXT,
+			"outcome" TEXT,
+			"created_at" DATETIME
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_events_event_id ON "payment_events"("event_id")`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_events_provider_reference ON "payment_events"("provider_reference")`,
+		`CREATE INDEX IF NOT EXISTS idx_payment_events_order_id ON "payment_events"("order_id")`,
+
+		`CREATE TABLE IF NOT EXISTS "refunds" (
+			"id" TEXT PRIMARY KEY,
+			"order_id" TEXT NOT NULL,
+			"amount" REAL NOT NULL,
+			"reason" TEXT,
+			"points_reversed" INTEGER DEFAULT 0,
+			"gift_card_amount" REAL DEFAULT 0,
+			"created_by_id" TEXT NOT NULL,
+			"created_at" DATETIME,
+			CONSTRAINT fk_refunds_order FOREIGN KEY ("order_id") REFERENCES "orders"("id")
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON "refunds"("order_id")`,
+
+		`CREATE TABLE IF NOT EXISTS "refund_items" (
+			"id" TEXT PRIMARY KEY,
+			"refund_id" TEXT NOT NULL,
+			"order_item_id" TEXT NOT NULL,
+			"product_id" TEXT NOT NULL,
+			"quantity" INTEGER NOT NULL,
+			"amount" REAL NOT NULL,
+			CONSTRAINT fk_refund_items_refund FOREIGN KEY ("refund_id") REFERENCES "refunds"("id")
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_refund_items_refund_id ON "refund_items"("refund_id")`,
+		`CREATE INDEX IF NOT EXISTS idx_refund_items_order_item_id ON "refund_items"("order_item_id")`,
+
+		`CREATE TABLE IF NOT EXISTS "idempotency_records" (
+			"id" TEXT PRIMARY KEY,
+			"user_id" TEXT NOT NULL,
+			"idempotency_key" TEXT NOT NULL,
+			"method" TEXT NOT NULL,
+			"path" TEXT NOT NULL,
+			"request_hash" TEXT NOT NULL,
+			"status_code" INTEGER DEFAULT 0,
+			"content_type" TEXT,
+			"response_body" TEXT,
+			"completed_at" DATETIME,
+			"created_at" DATETIME
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_user_key ON "idempotency_records"("user_id", "idempotency_key")`,
+
+		`CREATE TABLE IF NOT EXISTS "delivery_slots" (
+			"id" TEXT PRIMARY KEY,
+			"franchise_id" TEXT NOT NULL,
+			"starts_at" DATETIME NOT NULL,
+			"ends_at" DATETIME NOT NULL,
+			"capacity" INTEGER NOT NULL,
+			"reserved" INTEGER NOT NULL DEFAULT 0,
+			"created_at" DATETIME,
+			"updated_at" DATETIME
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_delivery_slot_franchise_start ON "delivery_slots"("franchise_id", "starts_at")`,
+		`CREATE TABLE IF NOT EXISTS "stock_reservations" (
+			"id" TEXT PRIMARY KEY,
+			"user_id" TEXT NOT NULL,
+			"product_id" TEXT NOT NULL,
+			"franchise_id" TEXT,
+			"franchise_product_id" TEXT,
+			"quantity" INTEGER NOT NULL,
+			"expires_at" DATETIME NOT NULL,
+			"created_at" DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS "coupons" (
+			"id" TEXT PRIMARY KEY,
+			"code" TEXT NOT NULL UNIQUE,
+			"description" TEXT,
+			"type" TEXT NOT NULL,
+			"value" REAL DEFAULT 0,
+			"min_basket" REAL DEFAULT 0,
+			"max_discount" REAL,
+			"usage_limit" INTEGER,
+			"per_user_limit" INTEGER,
+			"used_count" INTEGER DEFAULT 0,
+			"starts_at" DATETIME,
+			"ends_at" DATETIME,
+			"franchise_id" TEXT,
+			"is_active" NUMERIC DEFAULT 1,
+			"created_at" DATETIME,
+			"updated_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS "coupon_targets" (
+			"id" TEXT PRIMARY KEY,
+			"coupon_id" TEXT NOT NULL,
+			"product_id" TEXT,
+			"category_id" TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS "coupon_redemptions" (
+			"id" TEXT PRIMARY KEY,
+			"coupon_id" TEXT NOT NULL,
+			"user_id" TEXT NOT NULL,
+			"order_id" TEXT NOT NULL UNIQUE,
+			"discount" REAL,
+			"created_at" DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS "pricing_rules" (
+			"id" TEXT PRIMARY KEY,
+			"name" TEXT NOT NULL,
+			"type" TEXT NOT NULL,
+			"product_id" TEXT,
+			"category_id" TEXT,
+			"subcategory_id" TEXT,
+			"quantity" INTEGER NOT NULL,
+			"free_quantity" INTEGER DEFAULT 0,
+			"bundle_price" REAL DEFAULT 0,
+			"franchise_id" TEXT,
+			"priority" INTEGER DEFAULT 0,
+			"is_active" NUMERIC DEFAULT 1,
+			"starts_at" DATETIME,
+			"ends_at" DATETIME,
+			"created_at" DATETIME,
+			"updated_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS "referrals" (
+			"id" TEXT PRIMARY KEY,
+			"referrer_id" TEXT NOT NULL,
+			"referee_id" TEXT NOT NULL UNIQUE,
+			"code" TEXT NOT NULL,
+			"status" TEXT NOT NULL DEFAULT 'pending',
+			"reject_reason" TEXT,
+			"order_id" TEXT,
+			"referrer_points" INTEGER DEFAULT 0,
+			"referee_points" INTEGER DEFAULT 0,
+			"rewarded_at" DATETIME,
+			"created_at" DATETIME,
+			"updated_at" DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS "gift_cards" (
+			"id" TEXT PRIMARY KEY,
+			"code" TEXT NOT NULL UNIQUE,
+			"initial_balance" REAL NOT NULL,
+			"balance" REAL NOT NULL,
+			"franchise_id" TEXT,
+			"issued_by_id" TEXT NOT NULL,
+			"expires_at" DATETIME,
+			"is_active" BOOLEAN DEFAULT 1,
+			"created_at" DATETIME,
+			"updated_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS "gift_card_transactions" (
+			"id" TEXT PRIMARY KEY,
+			"gift_card_id" TEXT NOT NULL,
+			"order_id" TEXT,
+			"type" TEXT NOT NULL,
+			"amount" REAL NOT NULL,
+			"balance_after" REAL NOT NULL,
+			"description" TEXT,
+			"created_at" DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_gift_card_transactions_gift_card_id ON "gift_card_transactions"("gift_card_id")`,
+		`CREATE TABLE IF NOT EXISTS "user_addresses" (
+			"id" TEXT PRIMARY KEY,
+			"user_id" TEXT NOT NULL,
+			"label" TEXT,
+			"line1" TEXT NOT NULL,
+			"line2" TEXT,
+			"city" TEXT,
+			"postcode" TEXT NOT NULL,
+			"latitude" REAL NOT NULL,
+			"longitude" REAL NOT NULL,
+			"is_default" BOOLEAN DEFAULT 0,
+			"delivery_instructions" TEXT,
+			"created_at" DATETIME,
+			"updated_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_addresses_user_id ON "user_addresses"("user_id")`,
+		`CREATE TABLE IF NOT EXISTS "guest_carts" (
+			"id" TEXT PRIMARY KEY,
+			"expires_at" DATETIME NOT NULL,
+			"created_at" DATETIME,
+			"updated_at" DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_guest_carts_expires_at ON "guest_carts"("expires_at")`,
+		`CREATE TABLE IF NOT EXISTS "guest_cart_items" (
+			"id" TEXT PRIMARY KEY,
+			"guest_cart_id" TEXT NOT NULL,
+			"product_id" TEXT NOT NULL,
+			"franchise_id" TEXT,
+			"quantity" INTEGER DEFAULT 1,
+			"created_at" DATETIME,
+			"updated_at" DATETIME,
+			CONSTRAINT fk_guest_carts_items FOREIGN KEY ("guest_cart_id") REFERENCES "guest_carts"("id") ON DELETE CASCADE
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_guest_cart_product ON "guest_cart_items"("guest_cart_id","product_id")`,
+		`CREATE TABLE IF NOT EXISTS "wishlist_items" (
+			"id" TEXT PRIMARY KEY,
+			"user_id" TEXT NOT NULL,
+			"product_id" TEXT NOT NULL,
+			"franchise_id" TEXT,
+			"quantity" INTEGER DEFAULT 1,
+			"notified_at" DATETIME,
+			"created_at" DATETIME,
+			"updated_at" DATETIME
+		)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_wishlist_user_product ON "wishlist_items"("user_id","product_id")`,
+		`CREATE TABLE IF NOT EXISTS "subscriptions" (
+			"id" TEXT PRIMARY KEY,
+			"user_id" TEXT NOT NULL,
+			"franchise_id" TEXT,
+			"frequency" TEXT NOT NULL,
+			"status" TEXT DEFAULT 'active',
+			"fulfilment_type" TEXT DEFAULT 'delivery',
+			"address_id" TEXT,
+			"payment_method" TEXT,
+			"saved_method" TEXT,
+			"next_order_at" DATETIME NOT NULL,
+			"last_order_id" TEXT,
+			"last_error" TEXT,
+			"created_at" DATETIME,
+			"updated_at" DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_subscriptions_next_order_at ON "subscriptions"("next_order_at")`,
+		`CREATE TABLE IF NOT EXISTS "subscription_items" (
+			"id" TEXT PRIMARY KEY,
+			"subscription_id" TEXT NOT NULL,
+			"product_id" TEXT NOT NULL,
+			"quantity" INTEGER NOT NULL,
+			"created_at" DATETIME,
+			"updated_at" DATETIME,
+			CONSTRAINT fk_subscriptions_items FOREIGN KEY ("subscription_id") REFERENCES "subscriptions"("id") ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS "loyalty_tiers" (
+			"id" TEXT PRIMARY KEY,
+			"name" TEXT NOT NULL UNIQUE,
+			"min_spend" REAL NOT NULL DEFAULT 0,
+			"multiplier" REAL NOT NULL DEFAULT 1,
+			"created_at" DATETIME,
+			"updated_at" DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS "loyalty_campaigns" (
+			"id" TEXT PRIMARY KEY,
+			"name" TEXT NOT NULL,
+			"product_id" TEXT NOT NULL,
+			"bonus_points" INTEGER NOT NULL,
+			"starts_at" DATETIME,
+			"ends_at" DATETIME,
+			"is_active" NUMERIC DEFAULT 1,
+			"created_at" DATETIME,
+			"updated_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS "order_item_discounts" (
+			"id" TEXT PRIMARY KEY,
+			"order_item_id" TEXT NOT NULL,
+			"pricing_rule_id" TEXT,
+			"coupon_id" TEXT,
+			"description" TEXT,
+			"amount" REAL NOT NULL,
+			"created_at" DATETIME
+		)`,
 	}
 
 	for _, sql := range tables {
@@ -625,19 +995,372 @@ func setupProductRouter(db *gorm.DB) *gin.Engine {
 func setupOrderRouter(db *gorm.DB) *gin.Engine {
 	r := gin.New()
 	orderHandler := &OrderHandler{DB: db, Storage: newMockStorage()}
+	franchiseHandler := &FranchiseHandler{DB: db}
 
 	api := r.Group("/api")
 
 	protected := api.Group("")
 	protected.Use(middleware.AuthMiddleware())
-	protected.POST("/orders", orderHandler.CreateOrder)
+	protected.POST("/orders", middleware.Idempotency(db), orderHandler.CreateOrder)
 	protected.GET("/orders", orderHandler.GetOrders)
 	protected.GET("/orders/:id", orderHandler.GetOrder)
+	protected.GET("/orders/:id/timeline", orderHandler.GetOrderTimeline)
+	protected.GET("/orders/:id/events", orderHandler.StreamOrderEvents)
+
+	admin := api.Group("/admin")
+	admin.Use(middleware.AuthMiddleware())
+	admin.Use(middleware.AdminMiddleware())
+	admin.PUT("/orders/:id/status", orderHandler.UpdateOrderStatus)
+
+	franchise := api.Group("/franchise")
+	franchise.Use(middleware.AuthMiddleware())
+	franchise.Use(middleware.FranchiseMiddleware())
+	franchise.GET("/orders/stream", franchiseHandler.StreamMyOrders)
+
+	return r
+}
+
+// setupOrderRouterWithPayments sets up order routes backed by the given payment provider.
+func setupOrderRouterWithPayments(db *gorm.DB, provider payments.PaymentProvider) *gin.Engine {
+	r := gin.New()
+	orderHandler := &OrderHandler{DB: db, Storage: newMockStorage(), Payments: provider}
+
+	api := r.Group("/api")
+
+	protected := api.Group("")
+	protected.Use(middleware.AuthMiddleware())
+	protected.POST("/orders", orderHandler.CreateOrder)
+	protected.GET("/orders/:id", orderHandler.GetOrder)
+
+	admin := api.Group("/admin")
+	admin.Use(middleware.AuthMiddleware())
+	admin.Use(middleware.AdminMiddleware())
+	admin.PUT("/orders/:id/status", orderHandler.UpdateOrderStatus)
+
+	return r
+}
+
+// setupPaymentWebhookRouter sets up the payment webhook route signed with the given secret.
+func setupPaymentWebhookRouter(db *gorm.DB, secret string) *gin.Engine {
+	r := gin.New()
+	webhookHandler := &PaymentWebhookHandler{DB: db, Secret: secret}
+	r.POST("/api/webhooks/payments", webhookHandler.HandlePaymentWebhook)
+	return r
+}
+
+// setupRefundRouter sets up admin and franchise refund routes backed by the given payment provider.
+func setupRefundRouter(db *gorm.DB, provider payments.PaymentProvider) *gin.Engine {
+	r := gin.New()
+	orderHandler := &OrderHandler{DB: db, Storage: newMockStorage(), Payments: provider}
+	refundHandler := &RefundHandler{DB: db, Payments: provider}
+
+	api := r.Group("/api")
+
+	protected := api.Group("")
+	protected.Use(middleware.AuthMiddleware())
+	protected.POST("/orders", orderHandler.CreateOrder)
+
+	admin := api.Group("/admin")
+	admin.Use(middleware.AuthMiddleware())
+	admin.Use(middleware.AdminMiddleware())
+	admin.PUT("/orders/:id/status", orderHandler.UpdateOrderStatus)
+	admin.POST("/orders/:id/refunds", middleware.Idempotency(db), refundHandler.CreateRefund)
+	admin.GET("/orders/:id/refunds", refundHandler.GetRefunds)
+
+	franchise := api.Group("/franchise")
+	franchise.Use(middleware.AuthMiddleware())
+	franchise.Use(middleware.FranchiseMiddleware())
+	franchise.POST("/orders/:id/refunds", middleware.Idempotency(db), refundHandler.CreateRefund)
+
+	return r
+}
+
+// setupCheckoutRouter sets up routes for checkout handler tests.
+func setupCheckoutRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	checkoutHandler := &CheckoutHandler{DB: db}
+
+	protected := r.Group("/api")
+	protected.Use(middleware.AuthMiddleware())
+	protected.POST("/checkout/quote", checkoutHandler.Quote)
+	protected.POST("/checkout/reserve", checkoutHandler.ReserveStock)
+	protected.DELETE("/checkout/reserve", checkoutHandler.ReleaseStock)
+
+	return r
+}
+
+// setupCouponRouter sets up the admin and franchise portal coupon routes.
+func setupCouponRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	couponHandler := &CouponHandler{DB: db}
+
+	api := r.Group("/api")
+	admin := api.Group("/admin")
+	admin.Use(middleware.AuthMiddleware())
+	admin.Use(middleware.AdminMiddleware())
+	admin.GET("/coupons", couponHandler.ListCoupons)
+	admin.POST("/coupons", couponHandler.CreateCoupon)
+	admin.PUT("/coupons/:id", couponHandler.UpdateCoupon)
+	admin.DELETE("/coupons/:id", couponHandler.DeleteCoupon)
+
+	franchise := api.Group("/franchise")
+	franchise.Use(middleware.AuthMiddleware())
+	franchise.Use(middleware.FranchiseMiddleware())
+	franchise.GET("/coupons", couponHandler.ListCoupons)
+	franchise.POST("/coupons", couponHandler.CreateCoupon)
+	franchise.PUT("/coupons/:id", couponHandler.UpdateCoupon)
+	franchise.DELETE("/coupons/:id", couponHandler.DeleteCoupon)
+
+	return r
+}
+
+// setupPricingRuleRouter sets up the admin and franchise portal pricing rule routes.
+func setupPricingRuleRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	pricingRuleHandler := &PricingRuleHandler{DB: db}
+
+	api := r.Group("/api")
+	admin := api.Group("/admin")
+	admin.Use(middleware.AuthMiddleware())
+	admin.Use(middleware.AdminMiddleware())
+	admin.GET("/pricing-rules", pricingRuleHandler.ListPricingRules)
+	admin.POST("/pricing-rules", pricingRuleHandler.CreatePricingRule)
+	admin.PUT("/pricing-rules/:id", pricingRuleHandler.UpdatePricingRule)
+	admin.DELETE("/pricing-rules/:id", pricingRuleHandler.DeletePricingRule)
+
+	franchise := api.Group("/franchise")
+	franchise.Use(middleware.AuthMiddleware())
+	franchise.Use(middleware.FranchiseMiddleware())
+	franchise.GET("/pricing-rules", pricingRuleHandler.ListPricingRules)
+	franchise.POST("/pricing-rules", pricingRuleHandler.CreatePricingRule)
+	franchise.PUT("/pricing-rules/:id", pricingRuleHandler.UpdatePricingRule)
+	franchise.DELETE("/pricing-rules/:id", pricingRuleHandler.DeletePricingRule)
+
+	return r
+}
+
+// setupLoyaltyRouter sets up routes for loyalty programme tests.
+func setupLoyaltyRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	loyaltyHandler := &LoyaltyHandler{DB: db}
+
+	api := r.Group("/api")
+	protected := api.Group("")
+	protected.Use(middleware.AuthMiddleware())
+	protected.GET("/auth/loyalty", loyaltyHandler.GetLoyaltyStatus)
+
+	admin := api.Group("/admin")
+	admin.Use(middleware.AuthMiddleware())
+	admin.Use(middleware.AdminMiddleware())
+	admin.GET("/loyalty/tiers", loyaltyHandler.ListTiers)
+	admin.POST("/loyalty/tiers", loyaltyHandler.CreateTier)
+	admin.PUT("/loyalty/tiers/:id", loyaltyHandler.UpdateTier)
+	admin.DELETE("/loyalty/tiers/:id", loyaltyHandler.DeleteTier)
+	admin.GET("/loyalty/campaigns", loyaltyHandler.ListCampaigns)
+	admin.POST("/loyalty/campaigns", loyaltyHandler.CreateCampaign)
+	admin.PUT("/loyalty/campaigns/:id", loyaltyHandler.UpdateCampaign)
+	admin.DELETE("/loyalty/campaigns/:id", loyaltyHandler.DeleteCampaign)
+	admin.POST("/loyalty/reconcile", loyaltyHandler.ReconcilePoints)
+
+	return r
+}
+
+// setupGiftCardRouter sets up the gift card portal and balance routes alongside
+// ordering, cancelling and refunding, so spends can be followed through.
+func setupGiftCardRouter(db *gorm.DB, provider payments.PaymentProvider) *gin.Engine {
+	r := gin.New()
+	giftCardHandler := &GiftCardHandler{DB: db}
+	orderHandler := &OrderHandler{DB: db, Storage: newMockStorage(), Payments: provider}
+	refundHandler := &RefundHandler{DB: db, Payments: provider}
+
+	api := r.Group("/api")
+	api.GET("/gift-cards/:code/balance", giftCardHandler.GetBalance)
+
+	protected := api.Group("")
+	protected.Use(middleware.AuthMiddleware())
+	protected.POST("/orders", orderHandler.CreateOrder)
 
 	admin := api.Group("/admin")
 	admin.Use(middleware.AuthMiddleware())
 	admin.Use(middleware.AdminMiddleware())
+	admin.GET("/gift-cards", giftCardHandler.ListGiftCards)
+	admin.POST("/gift-cards", giftCardHandler.CreateGiftCard)
+	admin.GET("/gift-cards/:id", giftCardHandler.GetGiftCard)
+	admin.PUT("/gift-cards/:id", giftCardHandler.UpdateGiftCard)
 	admin.PUT("/orders/:id/status", orderHandler.UpdateOrderStatus)
+	admin.POST("/orders/:id/refunds", refundHandler.CreateRefund)
+
+	franchise := api.Group("/franchise")
+	franchise.Use(middleware.AuthMiddleware())
+	franchise.Use(middleware.FranchiseMiddleware())
+	franchise.GET("/gift-cards", giftCardHandler.ListGiftCards)
+	franchise.POST("/gift-cards", giftCardHandler.CreateGiftCard)
+	franchise.GET("/gift-cards/:id", giftCardHandler.GetGiftCard)
+
+	return r
+}
+
+// setupAddressRouter sets up the address book routes with order creation and
+// the checkout quote, which can both take a saved address.
+func setupAddressRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	addressHandler := &AddressHandler{DB: db}
+	orderHandler := &OrderHandler{DB: db, Storage: newMockStorage()}
+	checkoutHandler := &CheckoutHandler{DB: db}
+
+	protected := r.Group("/api")
+	protected.Use(middleware.AuthMiddleware())
+	protected.GET("/auth/addresses", addressHandler.ListAddresses)
+	protected.POST("/auth/addresses", addressHandler.CreateAddress)
+	protected.PUT("/auth/addresses/:id", addressHandler.UpdateAddress)
+	protected.DELETE("/auth/addresses/:id", addressHandler.DeleteAddress)
+	protected.POST("/orders", orderHandler.CreateOrder)
+	protected.POST("/checkout/quote", checkoutHandler.Quote)
+
+	return r
+}
+
+// setupGuestCartRouter sets up the guest cart routes alongside login,
+// registration and the signed-in cart a guest cart is merged into.
+func setupGuestCartRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	guestCartHandler := &GuestCartHandler{DB: db}
+	authHandler := &AuthHandler{DB: db}
+	cartHandler := &CartHandler{DB: db}
+
+	api := r.Group("/api")
+	api.POST("/auth/register", authHandler.Register)
+	api.POST("/auth/login", authHandler.Login)
+	api.GET("/guest-cart", guestCartHandler.GetCart)
+	api.POST("/guest-cart", guestCartHandler.AddToCart)
+	api.PUT("/guest-cart/:id", guestCartHandler.UpdateCartItem)
+	api.DELETE("/guest-cart/:id", guestCartHandler.RemoveFromCart)
+	api.DELETE("/guest-cart", guestCartHandler.ClearCart)
+
+	protected := api.Group("")
+	protected.Use(middleware.AuthMiddleware())
+	protected.GET("/cart", cartHandler.GetCart)
+	protected.POST("/cart", cartHandler.AddToCart)
+
+	return r
+}
+
+// setupWishlistRouter sets up the wishlist and cart routes, and the franchise
+// stock update that sends back-in-stock notifications.
+func setupWishlistRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	wishlistHandler := &WishlistHandler{DB: db}
+	cartHandler := &CartHandler{DB: db}
+	franchiseHandler := &FranchiseHandler{DB: db, Storage: newMockStorage()}
+
+	protected := r.Group("/api")
+	protected.Use(middleware.AuthMiddleware())
+	protected.GET("/wishlist", wishlistHandler.GetWishlist)
+	protected.POST("/wishlist", wishlistHandler.AddToWishlist)
+	protected.DELETE("/wishlist/:id", wishlistHandler.RemoveFromWishlist)
+	protected.POST("/wishlist/:id/move-to-cart", cartHandler.MoveToCart)
+	protected.GET("/cart", cartHandler.GetCart)
+	protected.POST("/cart", cartHandler.AddToCart)
+	protected.POST("/cart/:id/save-for-later", cartHandler.SaveForLater)
+
+	franchise := r.Group("/api/franchise")
+	franchise.Use(middleware.AuthMiddleware())
+	franchise.Use(middleware.FranchiseMiddleware())
+	franchise.PUT("/products/:id/stock", franchiseHandler.UpdateProductStock)
+
+	return r
+}
+
+// setupSubscriptionRouter sets up reordering, subscriptions and the routes to
+// place an order and fill a cart, for recurring order tests.
+func setupSubscriptionRouter(db *gorm.DB, provider payments.PaymentProvider) *gin.Engine {
+	r := gin.New()
+	cartHandler := &CartHandler{DB: db}
+	orderHandler := &OrderHandler{DB: db, Storage: newMockStorage(), Payments: provider}
+	subscriptionHandler := &SubscriptionHandler{DB: db, Payments: provider}
+
+	protected := r.Group("/api")
+	protected.Use(middleware.AuthMiddleware())
+	protected.GET("/cart", cartHandler.GetCart)
+	protected.POST("/cart", cartHandler.AddToCart)
+	protected.POST("/orders", orderHandler.CreateOrder)
+	protected.POST("/orders/:id/reorder", cartHandler.Reorder)
+	protected.GET("/subscriptions", subscriptionHandler.ListSubscriptions)
+	protected.POST("/subscriptions", subscriptionHandler.CreateSubscription)
+	protected.PUT("/subscriptions/:id", subscriptionHandler.UpdateSubscription)
+	protected.POST("/subscriptions/:id/skip", subscriptionHandler.SkipSubscription)
+	protected.POST("/subscriptions/:id/pause", subscriptionHandler.PauseSubscription)
+	protected.POST("/subscriptions/:id/resume", subscriptionHandler.ResumeSubscription)
+	protected.DELETE("/subscriptions/:id", subscriptionHandler.CancelSubscription)
+
+	return r
+}
+
+// setupSubstitutionRouter sets up ordering, franchise picking and order change
+// routes for substitution tests.
+func setupSubstitutionRouter(db *gorm.DB, provider payments.PaymentProvider) *gin.Engine {
+	r := gin.New()
+	cartHandler := &CartHandler{DB: db}
+	orderHandler := &OrderHandler{DB: db, Storage: newMockStorage(), Payments: provider}
+	franchiseHandler := &FranchiseHandler{DB: db, Payments: provider}
+
+	protected := r.Group("/api")
+	protected.Use(middleware.AuthMiddleware())
+	protected.POST("/cart", cartHandler.AddToCart)
+	protected.POST("/orders", orderHandler.CreateOrder)
+	protected.GET("/orders/:id/changes", orderHandler.GetOrderChanges)
+
+	franchise := r.Group("/api/franchise")
+	franchise.Use(middleware.AuthMiddleware())
+	franchise.Use(middleware.FranchiseMiddleware())
+	franchise.PUT("/orders/:id/status", franchiseHandler.UpdateOrderStatus)
+	franchise.POST("/orders/:id/items/:item_id/substitute", franchiseHandler.SubstituteOrderItem)
+	franchise.POST("/orders/:id/items/:item_id/short-pick", franchiseHandler.ShortPickOrderItem)
+
+	return r
+}
+
+// setupOrderAmendRouter sets up customer cancellation and amendment routes
+// alongside checkout and the store's status updates.
+func setupOrderAmendRouter(db *gorm.DB, provider payments.PaymentProvider) *gin.Engine {
+	r := gin.New()
+	cartHandler := &CartHandler{DB: db}
+	orderHandler := &OrderHandler{DB: db, Storage: newMockStorage(), Payments: provider}
+	franchiseHandler := &FranchiseHandler{DB: db, Payments: provider}
+
+	protected := r.Group("/api")
+	protected.Use(middleware.AuthMiddleware())
+	protected.POST("/cart", cartHandler.AddToCart)
+	protected.POST("/orders", orderHandler.CreateOrder)
+	protected.PUT("/orders/:id", orderHandler.AmendOrder)
+	protected.POST("/orders/:id/cancel", orderHandler.CancelOrder)
+	protected.GET("/orders/transitions", orderHandler.GetOrderTransitions)
+
+	franchise := r.Group("/api/franchise")
+	franchise.Use(middleware.AuthMiddleware())
+	franchise.Use(middleware.FranchiseMiddleware())
+	franchise.PUT("/orders/:id/status", franchiseHandler.UpdateOrderStatus)
+
+	return r
+}
+
+// setupReferralRouter sets up registration and referral routes for referral tests.
+func setupReferralRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	authHandler := &AuthHandler{DB: db}
+	referralHandler := &ReferralHandler{DB: db}
+
+	api := r.Group("/api")
+	api.POST("/auth/register", authHandler.Register)
+
+	protected := api.Group("")
+	protected.Use(middleware.AuthMiddleware())
+	protected.GET("/auth/referral", referralHandler.GetMyReferral)
+
+	admin := api.Group("/admin")
+	admin.Use(middleware.AuthMiddleware())
+	admin.Use(middleware.AdminMiddleware())
+	admin.GET("/referrals", referralHandler.ListReferrals)
 
 	return r
 }
@@ -651,10 +1374,11 @@ func setupCartRouter(db *gorm.DB) *gin.Engine {
 	protected := api.Group("")
 	protected.Use(middleware.AuthMiddleware())
 	protected.GET("/cart", cartHandler.GetCart)
-	protected.POST("/cart", cartHandler.AddToCart)
-	protected.PUT("/cart/:id", cartHandler.UpdateCartItem)
-	protected.DELETE("/cart/:id", cartHandler.RemoveFromCart)
-	protected.DELETE("/cart", cartHandler.ClearCart)
+	protected.POST("/cart", middleware.Idempotency(db), cartHandler.AddToCart)
+	protected.PUT("/cart/:id", middleware.Idempotency(db), cartHandler.UpdateCartItem)
+	protected.PUT("/cart/franchise", middleware.Idempotency(db), cartHandler.SwitchFranchise)
+	protected.DELETE("/cart/:id", middleware.Idempotency(db), cartHandler.RemoveFromCart)
+	protected.DELETE("/cart", middleware.Idempotency(db), cartHandler.ClearCart)
 
 	return r
 }
@@ -671,6 +1395,7 @@ func setupFranchiseRouter(db *gorm.DB) *gin.Engine {
 	api.GET("/franchises/:id", franchiseHandler.GetFranchise)
 	api.GET("/franchises/:id/products", franchiseHandler.GetFranchiseProducts)
 	api.GET("/franchises/:id/promotions", franchiseHandler.GetFranchisePromotions)
+	api.GET("/franchises/:id/delivery-slots", franchiseHandler.ListDeliverySlots)
 
 	// Admin routes
 	admin := api.Group("/admin")
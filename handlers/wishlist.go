@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"grabbi-backend/models"
+	"grabbi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WishlistHandler manages the products customers park for later. Cart lines
+// move in and out of the wishlist through CartHandler.SaveForLater and
+// CartHandler.MoveToCart.
+type WishlistHandler struct {
+	DB *gorm.DB
+}
+
+// wishlistItemResponse is a wishlist item with whether it can be bought now
+// from the store it was saved from.
+type wishlistItemResponse struct {
+	models.WishlistItem
+	InStock bool `json:"in_stock"`
+}
+
+func (h *WishlistHandler) GetWishlist(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var items []models.WishlistItem
+	if err := h.DB.Preload("Product").Preload("Product.Category").Preload("Product.Images").
+		Where("user_id = ?", userID).Order("created_at DESC").Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlist"})
+		return
+	}
+
+	result := make([]wishlistItemResponse, len(items))
+	for i, item := range items {
+		stock, sold, err := availableStock(h.DB, item.FranchiseID, item.Product)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlist"})
+			return
+		}
+		result[i] = wishlistItemResponse{WishlistItem: item, InStock: sold && stock > 0}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// AddToWishlist saves a product, optionally from a particular store. Out of
+// stock products can be saved; that is what the back-in-stock notification is for.
+func (h *WishlistHandler) AddToWishlist(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		ProductID   uuid.UUID  `json:"product_id" binding:"required"`
+		Quantity    int        `json:"quantity" binding:"omitempty,min=1"`
+		FranchiseID *uuid.UUID `json:"franchise_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": utils.SanitizeValidationError(err)})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	var product models.Product
+	if err := h.DB.Where("id = ?", req.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if req.FranchiseID != nil {
+		var franchise models.Franchise
+		if err := h.DB.Where("id = ? AND is_active = ?", *req.FranchiseID, true).First(&franchise).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Franchise not found"})
+			return
+		}
+	}
+
+	var existing models.WishlistItem
+	if err := h.DB.Where("user_id = ? AND product_id = ?", userID, req.ProductID).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Product is already in your wishlist"})
+		return
+	}
+
+	item := models.WishlistItem{
+		UserID:      userID.(uuid.UUID),
+		ProductID:   req.ProductID,
+		FranchiseID: req.FranchiseID,
+		Quantity:    req.Quantity,
+	}
+	if err := h.DB.Create(&item).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to wishlist"})
+		return
+	}
+
+	h.DB.Preload("Product").Preload("Product.Images").First(&item, item.ID)
+	c.JSON(http.StatusCreated, item)
+}
+
+func (h *WishlistHandler) RemoveFromWishlist(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&models.WishlistItem{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove item from wishlist"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist item not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item removed from wishlist"})
+}
+
+// listingInStock reports whether a store listing can be bought from.
+func listingInStock(fp models.FranchiseProduct) bool {
+	return fp.IsAvailable && fp.DeletedAt == nil && fp.StockQuantity > 0
+}
+
+// notifyBackInStock is the back-in-stock hook, called when a product's stock
+// goes from none to some: at a store when franchiseID is set, otherwise in the
+// master catalogue. Customers who saved the product from there are emailed and
+// the item is stamped with when. Failures are logged, never returned, so a
+// stock update is not undone by a notification problem.
+func notifyBackInStock(db *gorm.DB, productID uuid.UUID, franchiseID *uuid.UUID) {
+	query := db.Preload("User").Preload("Product").Where("product_id = ?", productID)
+	if franchiseID == nil {
+		query = query.Where("franchise_id IS NULL")
+	} else {
+		query = query.Where("franchise_id = ?", *franchiseID)
+	}
+	var items []models.WishlistItem
+	if err := query.Find(&items).Error; err != nil {
+		log.Printf("Failed to find wishlist items for restocked product %s: %v", productID, err)
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	storeName := ""
+	if franchiseID != nil {
+		var franchise models.Franchise
+		if err := db.Where("id = ?", *franchiseID).First(&franchise).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Failed to load franchise %s for back in stock notifications: %v", *franchiseID, err)
+		}
+		storeName = franchise.Name
+	}
+
+	ids := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+		utils.SendBackInStockEmail(item.User.Email, item.User.Name, item.Product.ItemName, storeName)
+	}
+	if err := db.Model(&models.WishlistItem{}).Where("id IN ?", ids).Update("notified_at", time.Now()).Error; err != nil {
+		log.Printf("Failed to record back in stock notifications for product %s: %v", productID, err)
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grabbi-backend/models"
+)
+
+func TestWishlistAddListRemove(t *testing.T) {
+	db := freshDB()
+	router := setupWishlistRouter(db)
+	owner, _ := seedTestUser(db, "wishlist-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Wishlist Store", owner.ID)
+	cat := seedCategory(db, "Wishlist Cat")
+	coffee := seedProduct(db, "Coffee", cat.ID, 6.00)
+	fp := seedFranchiseProduct(db, franchise.ID, coffee.ID)
+	db.Model(&fp).Update("stock_quantity", 0)
+	_, token := seedTestUser(db, "wishlist@test.com", "customer", nil)
+
+	body := map[string]interface{}{"product_id": coffee.ID, "franchise_id": franchise.ID}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/wishlist", body, token))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	itemID := parseResponse(w)["id"].(string)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/wishlist", body, token))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a duplicate, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/wishlist", nil, token))
+	items := parseResponseArray(w)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(items))
+	}
+	item := items[0].(map[string]interface{})
+	if item["in_stock"] != false || item["quantity"] != float64(1) {
+		t.Errorf("expected 1 out-of-stock item, got in_stock %v quantity %v", item["in_stock"], item["quantity"])
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("DELETE", "/api/wishlist/"+itemID, nil, token))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 removing, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("DELETE", "/api/wishlist/"+itemID, nil, token))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 removing again, got %d", w.Code)
+	}
+}
+
+func TestSaveForLaterAndMoveBackToCart(t *testing.T) {
+	db := freshDB()
+	router := setupWishlistRouter(db)
+	owner, _ := seedTestUser(db, "later-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Later Store", owner.ID)
+	cat := seedCategory(db, "Later Cat")
+	pasta := seedProduct(db, "Pasta", cat.ID, 1.20)
+	fp := seedFranchiseProduct(db, franchise.ID, pasta.ID)
+	_, token := seedTestUser(db, "later@test.com", "customer", nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/cart", map[string]interface{}{
+		"product_id": pasta.ID, "quantity": 3, "franchise_id": franchise.ID,
+	}, token))
+	cartItemID := parseResponse(w)["id"].(string)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/cart/"+cartItemID+"/save-for-later", nil, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 saving for later, got %d: %s", w.Code, w.Body.String())
+	}
+	saved := parseResponse(w)
+	if saved["quantity"] != float64(3) || saved["franchise_id"] != franchise.ID.String() {
+		t.Errorf("expected 3 saved from the store, got %v from %v", saved["quantity"], saved["franchise_id"])
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("GET", "/api/cart", nil, token))
+	if len(parseResponseArray(w)) != 0 {
+		t.Errorf("expected an empty cart, got %s", w.Body.String())
+	}
+
+	// Stock fell below the saved quantity, so moving it back is refused
+	db.Model(&fp).Update("stock_quantity", 2)
+	wishlistID := saved["id"].(string)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/wishlist/"+wishlistID+"/move-to-cart", nil, token))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for insufficient stock, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("POST", "/api/wishlist/"+wishlistID+"/move-to-cart", map[string]interface{}{"quantity": 2}, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 moving to cart, got %d: %s", w.Code, w.Body.String())
+	}
+	if moved := parseResponse(w); moved["quantity"] != float64(2) || moved["line_total"] != 2.4 {
+		t.Errorf("expected 2 at £2.40, got %v at %v", moved["quantity"], moved["line_total"])
+	}
+
+	var remaining int64
+	db.Model(&models.WishlistItem{}).Count(&remaining)
+	if remaining != 0 {
+		t.Errorf("expected the wishlist to be empty, got %d", remaining)
+	}
+}
+
+func TestRestockNotifiesWishlistedCustomers(t *testing.T) {
+	db := freshDB()
+	router := setupWishlistRouter(db)
+	owner, _ := seedTestUser(db, "restock-owner@test.com", "franchise_owner", nil)
+	franchise := seedFranchise(db, "Restock Store", owner.ID)
+	other := seedFranchise(db, "Other Restock Store", owner.ID)
+	_, ownerToken := seedFranchiseOwnerWithToken(db, franchise)
+	cat := seedCategory(db, "Restock Cat")
+	flour := seedProduct(db, "Flour", cat.ID, 1.80)
+	fp := seedFranchiseProduct(db, franchise.ID, flour.ID)
+	db.Model(&fp).Update("stock_quantity", 0)
+
+	here, _ := seedTestUser(db, "restock-here@test.com", "customer", nil)
+	elsewhere, _ := seedTestUser(db, "restock-elsewhere@test.com", "customer", nil)
+	db.Create(&models.WishlistItem{UserID: here.ID, ProductID: flour.ID, FranchiseID: &franchise.ID, Quantity: 1})
+	db.Create(&models.WishlistItem{UserID: elsewhere.ID, ProductID: flour.ID, FranchiseID: &other.ID, Quantity: 1})
+
+	notified := func(userID interface{}) bool {
+		var item models.WishlistItem
+		db.Where("user_id = ?", userID).First(&item)
+		return item.NotifiedAt != nil
+	}
+
+	// A change that leaves it out of stock notifies nobody
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/franchise/products/"+flour.ID.String()+"/stock", map[string]interface{}{"reorder_level": 3}, ownerToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if notified(here.ID) {
+		t.Error("expected no notification while out of stock")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, authRequest("PUT", "/api/franchise/products/"+flour.ID.String()+"/stock", map[string]interface{}{"stock_quantity": 12}, ownerToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !notified(here.ID) {
+		t.Error("expected the customer who saved it from this store to be notified")
+	}
+	if notified(elsewhere.ID) {
+		t.Error("expected no notification for a wishlist at another store")
+	}
+}
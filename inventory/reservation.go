@@ -0,0 +1,244 @@
+// Package inventory holds stock for customers while they check out, so an item
+// that was available when checkout began is still there when the order is
+// placed.
+package inventory
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DefaultReservationTTL is how long stock is held when STOCK_RESERVATION_TTL
+// is not set.
+const DefaultReservationTTL = 10 * time.Minute
+
+// InsufficientStockError reports the product that could not be reserved.
+type InsufficientStockError struct {
+	ProductID uuid.UUID
+}
+
+func (e *InsufficientStockError) Error() string {
+	return "insufficient stock for product " + e.ProductID.String()
+}
+
+// Item is a quantity of a product to reserve.
+type Item struct {
+	ProductID uuid.UUID
+	Quantity  int
+}
+
+// ReservationTTL returns how long reservations last, read from the
+// STOCK_RESERVATION_TTL environment variable as a Go duration (e.g. "15m").
+func ReservationTTL() time.Duration {
+	if value := os.Getenv("STOCK_RESERVATION_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("Invalid STOCK_RESERVATION_TTL %q, using %s", value, DefaultReservationTTL)
+	}
+	return DefaultReservationTTL
+}
+
+// Reserve holds stock for the user's items at the franchise, or against master
+// stock when franchiseID is nil or the franchise does not list a product, the
+// same stock order creation takes from. Any
+// reservations the user already holds are released first, so starting checkout
+// again refreshes the hold. Nothing is reserved unless every item can be.
+func Reserve(db *gorm.DB, userID uuid.UUID, franchiseID *uuid.UUID, items []Item, ttl time.Duration) ([]models.StockReservation, error) {
+	var reservations []models.StockReservation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := releaseAll(tx, userID); err != nil {
+			return err
+		}
+
+		expiresAt := time.Now().Add(ttl)
+		for _, item := range items {
+			reservation := models.StockReservation{
+				UserID:      userID,
+				ProductID:   item.ProductID,
+				FranchiseID: franchiseID,
+				Quantity:    item.Quantity,
+				ExpiresAt:   expiresAt,
+			}
+			if franchiseID != nil {
+				var fp models.FranchiseProduct
+				if err := tx.Where("franchise_id = ? AND product_id = ?", franchiseID, item.ProductID).First(&fp).Error; err == nil {
+					reservation.FranchiseProductID = &fp.ID
+				} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return err
+				}
+			}
+
+			taken, err := takeStock(tx, reservation)
+			if err != nil {
+				return err
+			}
+			if !taken {
+				return &InsufficientStockError{ProductID: item.ProductID}
+			}
+			if err := tx.Create(&reservation).Error; err != nil {
+				return err
+			}
+			reservations = append(reservations, reservation)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// Release gives back all stock held for the user.
+func Release(db *gorm.DB, userID uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return releaseAll(tx, userID)
+	})
+}
+
+// Consume turns the user's reservations into the stock decrement for an order
+// being placed in tx, returning the quantity already taken per product. Held
+// stock the order does not cover is for the caller to reconcile; reservations
+// made at a different franchise are released here.
+func Consume(tx *gorm.DB, userID uuid.UUID, franchiseID *uuid.UUID) (map[uuid.UUID]int, error) {
+	var reservations []models.StockReservation
+	if err := tx.Where("user_id = ?", userID).Find(&reservations).Error; err != nil {
+		return nil, err
+	}
+
+	held := make(map[uuid.UUID]int)
+	for _, r := range reservations {
+		claimed, err := claim(tx, r)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			continue
+		}
+		if !sameFranchise(r.FranchiseID, franchiseID) {
+			if err := returnStock(tx, r); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		held[r.ProductID] += r.Quantity
+	}
+	return held, nil
+}
+
+// SweepExpired releases reservations that expired before now and returns how
+// many were released.
+func SweepExpired(db *gorm.DB, now time.Time) (int, error) {
+	var expired []models.StockReservation
+	if err := db.Where("expires_at < ?", now).Find(&expired).Error; err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, r := range expired {
+		var claimed bool
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			claimed, err = claim(tx, r)
+			if err != nil || !claimed {
+				return err
+			}
+			return returnStock(tx, r)
+		})
+		if err != nil {
+			return released, err
+		}
+		if claimed {
+			released++
+		}
+	}
+	return released, nil
+}
+
+// StartSweeper releases expired reservations every interval until the returned
+// stop function is called.
+func StartSweeper(db *gorm.DB, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				n, err := SweepExpired(db, now)
+				if err != nil {
+					log.Printf("Failed to sweep stock reservations: %v", err)
+				} else if n > 0 {
+					log.Printf("Released %d expired stock reservations", n)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// releaseAll gives back every reservation the user holds.
+func releaseAll(tx *gorm.DB, userID uuid.UUID) error {
+	var reservations []models.StockReservation
+	if err := tx.Where("user_id = ?", userID).Find(&reservations).Error; err != nil {
+		return err
+	}
+	for _, r := range reservations {
+		claimed, err := claim(tx, r)
+		if err != nil {
+			return err
+		}
+		if claimed {
+			if err := returnStock(tx, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// claim deletes a reservation, reporting whether this caller removed it. Only
+// the caller that claims a reservation may return or keep its stock, so the
+// sweeper and a checkout racing for the same reservation cannot both act on it.
+func claim(tx *gorm.DB, r models.StockReservation) (bool, error) {
+	result := tx.Where("id = ?", r.ID).Delete(&models.StockReservation{})
+	return result.RowsAffected == 1, result.Error
+}
+
+// takeStock removes the reserved quantity from the stock row the reservation
+// holds against. The check and decrement are one conditional update, so
+// concurrent checkouts cannot take the same units.
+func takeStock(tx *gorm.DB, r models.StockReservation) (bool, error) {
+	query := tx.Model(&models.Product{}).Where("id = ?", r.ProductID)
+	if r.FranchiseProductID != nil {
+		query = tx.Model(&models.FranchiseProduct{}).Where("id = ? AND is_available = ?", *r.FranchiseProductID, true)
+	}
+	result := query.Where("stock_quantity >= ?", r.Quantity).
+		UpdateColumn("stock_quantity", gorm.Expr("stock_quantity - ?", r.Quantity))
+	return result.RowsAffected == 1, result.Error
+}
+
+// returnStock adds a released reservation's quantity back to its stock row.
+func returnStock(tx *gorm.DB, r models.StockReservation) error {
+	query := tx.Model(&models.Product{}).Where("id = ?", r.ProductID)
+	if r.FranchiseProductID != nil {
+		query = tx.Model(&models.FranchiseProduct{}).Where("id = ?", *r.FranchiseProductID)
+	}
+	return query.UpdateColumn("stock_quantity", gorm.Expr("stock_quantity + ?", r.Quantity)).Error
+}
+
+func sameFranchise(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
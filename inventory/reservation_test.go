@@ -0,0 +1,199 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupInventoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", uuid.New())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// Only the stock columns are needed; raw DDL because the models' uuid
+	// defaults are PostgreSQL-specific
+	for _, sql := range []string{
+		`CREATE TABLE "products" (
+			"id" TEXT PRIMARY KEY,
+			"stock_quantity" INTEGER DEFAULT 0,
+			"updated_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+		`CREATE TABLE "franchise_products" (
+			"id" TEXT PRIMARY KEY,
+			"franchise_id" TEXT NOT NULL,
+			"product_id" TEXT NOT NULL,
+			"stock_quantity" INTEGER DEFAULT 0,
+			"is_available" NUMERIC DEFAULT 1,
+			"updated_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+		`CREATE TABLE "stock_reservations" (
+			"id" TEXT PRIMARY KEY,
+			"user_id" TEXT NOT NULL,
+			"product_id" TEXT NOT NULL,
+			"franchise_id" TEXT,
+			"franchise_product_id" TEXT,
+			"quantity" INTEGER NOT NULL,
+			"expires_at" DATETIME NOT NULL,
+			"created_at" DATETIME
+		)`,
+	} {
+		if err := db.Exec(sql).Error; err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	return db
+}
+
+func seedStock(t *testing.T, db *gorm.DB, stock int) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	if err := db.Exec(`INSERT INTO products (id, stock_quantity) VALUES (?, ?)`, id, stock).Error; err != nil {
+		t.Fatalf("failed to seed product: %v", err)
+	}
+	return id
+}
+
+func seedFranchiseStock(t *testing.T, db *gorm.DB, franchiseID, productID uuid.UUID, stock int) {
+	t.Helper()
+	if err := db.Exec(`INSERT INTO franchise_products (id, franchise_id, product_id, stock_quantity) VALUES (?, ?, ?, ?)`,
+		uuid.New(), franchiseID, productID, stock).Error; err != nil {
+		t.Fatalf("failed to seed franchise product: %v", err)
+	}
+}
+
+// stockOf returns a product's master stock, or its franchise stock when table
+// is franchise_products.
+func stockOf(db *gorm.DB, table string, productID uuid.UUID) int {
+	column := "id"
+	if table == "franchise_products" {
+		column = "product_id"
+	}
+	var stock int
+	db.Raw(`SELECT stock_quantity FROM `+table+` WHERE `+column+` = ?`, productID).Scan(&stock)
+	return stock
+}
+
+func TestReserveHoldsFranchiseStock(t *testing.T) {
+	db := setupInventoryDB(t)
+	franchiseID := uuid.New()
+	productID := seedStock(t, db, 100)
+	seedFranchiseStock(t, db, franchiseID, productID, 5)
+
+	first, second := uuid.New(), uuid.New()
+	if _, err := Reserve(db, first, &franchiseID, []Item{{ProductID: productID, Quantity: 3}}, time.Minute); err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	if got := stockOf(db, "franchise_products", productID); got != 2 {
+		t.Errorf("expected franchise stock 2 after reserving 3, got %d", got)
+	}
+	if got := stockOf(db, "products", productID); got != 100 {
+		t.Errorf("expected master stock untouched, got %d", got)
+	}
+
+	_, err := Reserve(db, second, &franchiseID, []Item{{ProductID: productID, Quantity: 3}}, time.Minute)
+	var stockErr *InsufficientStockError
+	if !errors.As(err, &stockErr) || stockErr.ProductID != productID {
+		t.Fatalf("expected insufficient stock for the product, got %v", err)
+	}
+	var count int64
+	db.Model(&models.StockReservation{}).Where("user_id = ?", second).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no reservation for the second customer, got %d", count)
+	}
+}
+
+func TestReserveAgainReplacesHold(t *testing.T) {
+	db := setupInventoryDB(t)
+	productID := seedStock(t, db, 10)
+	userID := uuid.New()
+
+	for _, quantity := range []int{4, 6} {
+		if _, err := Reserve(db, userID, nil, []Item{{ProductID: productID, Quantity: quantity}}, time.Minute); err != nil {
+			t.Fatalf("failed to reserve %d: %v", quantity, err)
+		}
+	}
+	if got := stockOf(db, "products", productID); got != 4 {
+		t.Errorf("expected only the latest hold of 6 to be taken, got stock %d", got)
+	}
+
+	if err := Release(db, userID); err != nil {
+		t.Fatalf("failed to release: %v", err)
+	}
+	if got := stockOf(db, "products", productID); got != 10 {
+		t.Errorf("expected all stock back after release, got %d", got)
+	}
+}
+
+func TestSweepExpiredReturnsStock(t *testing.T) {
+	db := setupInventoryDB(t)
+	expiring := seedStock(t, db, 10)
+	live := seedStock(t, db, 10)
+
+	if _, err := Reserve(db, uuid.New(), nil, []Item{{ProductID: expiring, Quantity: 2}}, time.Minute); err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	if _, err := Reserve(db, uuid.New(), nil, []Item{{ProductID: live, Quantity: 2}}, time.Hour); err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+
+	released, err := SweepExpired(db, time.Now().Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("failed to sweep: %v", err)
+	}
+	if released != 1 {
+		t.Errorf("expected 1 reservation released, got %d", released)
+	}
+	if got := stockOf(db, "products", expiring); got != 10 {
+		t.Errorf("expected expired stock back, got %d", got)
+	}
+	if got := stockOf(db, "products", live); got != 8 {
+		t.Errorf("expected live hold kept, got %d", got)
+	}
+}
+
+func TestConsumeKeepsMatchingHoldsAndReleasesOthers(t *testing.T) {
+	db := setupInventoryDB(t)
+	franchiseID := uuid.New()
+	productID := seedStock(t, db, 10)
+	userID := uuid.New()
+
+	if _, err := Reserve(db, userID, nil, []Item{{ProductID: productID, Quantity: 3}}, time.Minute); err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+
+	// Ordering from a franchise gives back a hold taken from master stock
+	held, err := Consume(db, userID, &franchiseID)
+	if err != nil {
+		t.Fatalf("failed to consume: %v", err)
+	}
+	if len(held) != 0 || stockOf(db, "products", productID) != 10 {
+		t.Errorf("expected the mismatched hold to be released, got held %v stock %d", held, stockOf(db, "products", productID))
+	}
+
+	if _, err := Reserve(db, userID, nil, []Item{{ProductID: productID, Quantity: 3}}, time.Minute); err != nil {
+		t.Fatalf("failed to reserve: %v", err)
+	}
+	held, err = Consume(db, userID, nil)
+	if err != nil {
+		t.Fatalf("failed to consume: %v", err)
+	}
+	if held[productID] != 3 || stockOf(db, "products", productID) != 7 {
+		t.Errorf("expected 3 held and kept out of stock, got held %v stock %d", held, stockOf(db, "products", productID))
+	}
+
+	// A consumed reservation cannot be swept
+	if released, _ := SweepExpired(db, time.Now().Add(time.Hour)); released != 0 {
+		t.Errorf("expected nothing left to sweep, got %d", released)
+	}
+}
@@ -0,0 +1,108 @@
+package loyalty
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DefaultPointsExpiryMonths is how long points last when
+// LOYALTY_POINTS_EXPIRY_MONTHS is not set.
+const DefaultPointsExpiryMonths = 12
+
+// PointsExpiryMonths returns how many months points last before they expire.
+func PointsExpiryMonths() int {
+	return int(envFloat("LOYALTY_POINTS_EXPIRY_MONTHS", DefaultPointsExpiryMonths))
+}
+
+// pointsPosition is a user's points movements relative to the expiry cutoff.
+type pointsPosition struct {
+	UserID uuid.UUID
+	// Points credited before the cutoff
+	Credited int
+	// Points taken away at any time, expiry included
+	Debited int
+}
+
+// ExpirePoints expires points credited before now minus months that have not
+// been spent since. Spending uses the oldest points first, so a user's expired
+// amount is what they were credited before the cutoff less everything they
+// have spent, had reversed or had expired. Each expiry is written as an
+// "expired" history row, which also makes running it again a no-op. It returns
+// the number of users whose points expired.
+func ExpirePoints(db *gorm.DB, now time.Time, months int) (int, error) {
+	cutoff := now.AddDate(0, -months, 0)
+
+	var positions []pointsPosition
+	err := db.Model(&models.LoyaltyHistory{}).
+		Select(`user_id,
+			COALESCE(SUM(CASE WHEN points > 0 AND created_at < ? THEN points ELSE 0 END), 0) AS credited,
+			COALESCE(SUM(CASE WHEN points < 0 THEN -points ELSE 0 END), 0) AS debited`, cutoff).
+		Group("user_id").
+		Having("COALESCE(SUM(CASE WHEN points > 0 AND created_at < ? THEN points ELSE 0 END), 0) > 0", cutoff).
+		Scan(&positions).Error
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, p := range positions {
+		points := p.Credited - p.Debited
+		if points <= 0 {
+			continue
+		}
+		done, err := expireUserPoints(db, p.UserID, points, months)
+		if err != nil {
+			return expired, err
+		}
+		if done {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// expireUserPoints takes up to points off a user's balance, never below zero.
+func expireUserPoints(db *gorm.DB, userID uuid.UUID, points, months int) (bool, error) {
+	var taken int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		taken, err = Take(tx, userID, points, HistoryExpired, fmt.Sprintf("%d points expired after %d months", points, months), nil)
+		return err
+	})
+	if errors.Is(err, ErrInsufficientPoints) {
+		// Spent since the balance was read; the next run picks up what is left
+		return false, nil
+	}
+	return taken > 0, err
+}
+
+// StartExpiryJob expires old points every interval until the returned stop
+// function is called.
+func StartExpiryJob(db *gorm.DB, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				n, err := ExpirePoints(db, now, PointsExpiryMonths())
+				if err != nil {
+					log.Printf("Failed to expire loyalty points: %v", err)
+				} else if n > 0 {
+					log.Printf("Expired loyalty points for %d users", n)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,195 @@
+package loyalty
+
+import (
+	"fmt"
+	"math"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The ledger is the loyalty_histories table. Every change to a user's points is
+// a row in it, and User.LoyaltyPoints is the running total of those rows. The
+// functions in this file are the only writers of that balance.
+
+// Entry is one movement of a user's points: positive credits, negative debits.
+type Entry struct {
+	UserID      uuid.UUID
+	Points      int
+	Type        string
+	Description string
+	OrderID     *uuid.UUID
+}
+
+// Post records the entry and moves the balance by its points. The balance
+// check and update are one conditional statement, so a debit the balance
+// cannot cover fails with ErrInsufficientPoints even under concurrent posts.
+// Pass the surrounding transaction so the row and the balance commit together.
+func Post(tx *gorm.DB, e Entry) error {
+	if e.Points == 0 {
+		return nil
+	}
+	query := tx.Model(&models.User{}).Where("id = ?", e.UserID)
+	if e.Points < 0 {
+		query = query.Where("loyalty_points >= ?", -e.Points)
+	}
+	result := query.UpdateColumn("loyalty_points", gorm.Expr("loyalty_points + ?", e.Points))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if e.Points < 0 {
+			return ErrInsufficientPoints
+		}
+		return gorm.ErrRecordNotFound
+	}
+	return tx.Create(&models.LoyaltyHistory{
+		UserID:      e.UserID,
+		Points:      e.Points,
+		Type:        e.Type,
+		Description: e.Description,
+		OrderID:     e.OrderID,
+	}).Error
+}
+
+// Take debits up to points without taking the balance below zero, for points
+// that are clawed back rather than spent. It returns how many were taken. Call
+// it inside a transaction.
+func Take(tx *gorm.DB, userID uuid.UUID, points int, kind, description string, orderID *uuid.UUID) (int, error) {
+	var user models.User
+	if err := tx.Select("id", "loyalty_points").Where("id = ?", userID).First(&user).Error; err != nil {
+		return 0, err
+	}
+	if points > user.LoyaltyPoints {
+		points = user.LoyaltyPoints
+	}
+	if points <= 0 {
+		return 0, nil
+	}
+	err := Post(tx, Entry{UserID: userID, Points: -points, Type: kind, Description: description, OrderID: orderID})
+	if err != nil {
+		return 0, err
+	}
+	return points, nil
+}
+
+// Earn credits the points an order earned.
+func Earn(tx *gorm.DB, order models.Order) error {
+	orderID := order.ID
+	return Post(tx, Entry{
+		UserID:      order.UserID,
+		Points:      order.PointsEarned,
+		Type:        HistoryEarned,
+		Description: fmt.Sprintf("Earned on order %s", order.OrderNumber),
+		OrderID:     &orderID,
+	})
+}
+
+// ReverseCancelled takes back whatever is left of the points a cancelled order
+// earned. It is safe to call more than once.
+func ReverseCancelled(db *gorm.DB, order models.Order) (int, error) {
+	return reverseEarned(db, order, 0, fmt.Sprintf("Points reversed for cancelled order %s", order.OrderNumber))
+}
+
+// ReverseRefunded takes back the points earned on an order's refunded goods.
+// The points kept are the order's points in proportion to the goods kept, so
+// tier multipliers and campaign bonuses are clawed back at the rate they were
+// earned. Reversals are recomputed from the goods kept rather than per refund,
+// so rounding never reverses more than was originally awarded.
+func ReverseRefunded(tx *gorm.DB, order models.Order, refundedGoods float64) (int, error) {
+	kept := 0
+	if order.Subtotal > 0 {
+		kept = int(math.Floor(float64(order.PointsEarned) * math.Max(order.Subtotal-refundedGoods, 0) / order.Subtotal))
+	}
+	return reverseEarned(tx, order, kept, fmt.Sprintf("Points reversed for refund on order %s", order.OrderNumber))
+}
+
+// reverseEarned brings the order's net earned points down to keep, counting
+// what earlier reversals already took. The balance is never taken below zero.
+func reverseEarned(db *gorm.DB, order models.Order, keep int, description string) (int, error) {
+	var reversed int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var already int
+		if err := tx.Model(&models.LoyaltyHistory{}).
+			Where("order_id = ? AND type = ?", order.ID, HistoryReversed).
+			Select("COALESCE(SUM(-points), 0)").Scan(&already).Error; err != nil {
+			return err
+		}
+		points := order.PointsEarned - keep - already
+		if points <= 0 {
+			return nil
+		}
+		orderID := order.ID
+		var err error
+		reversed, err = Take(tx, order.UserID, points, HistoryReversed, description, &orderID)
+		return err
+	})
+	return reversed, err
+}
+
+// Discrepancy is a user whose stored balance differs from their ledger.
+type Discrepancy struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Balance int       `json:"balance"`
+	Ledger  int       `json:"ledger"`
+}
+
+// Reconcile finds users whose balance is not the sum of their ledger and,
+// unless dryRun is set, resets the balance to it. A balance that moved since it
+// was read is left for the next run.
+func Reconcile(db *gorm.DB, dryRun bool) ([]Discrepancy, error) {
+	var found []Discrepancy
+	err := db.Table("users").
+		Select("users.id AS user_id, users.loyalty_points AS balance, COALESCE(SUM(loyalty_histories.points), 0) AS ledger").
+		Joins("LEFT JOIN loyalty_histories ON loyalty_histories.user_id = users.id").
+		Where("users.deleted_at IS NULL").
+		Group("users.id, users.loyalty_points").
+		Having("users.loyalty_points <> COALESCE(SUM(loyalty_histories.points), 0)").
+		Scan(&found).Error
+	if err != nil || dryRun {
+		return found, err
+	}
+
+	for _, d := range found {
+		if err := db.Model(&models.User{}).Where("id = ? AND loyalty_points = ?", d.UserID, d.Balance).
+			UpdateColumn("loyalty_points", d.Ledger).Error; err != nil {
+			return found, err
+		}
+	}
+	return found, nil
+}
+
+// MigrateLedger brings history written before the ledger into line with it so
+// that balances reconcile: redemptions through the old redeem endpoint were
+// recorded as positive amounts, and orders placed before earnings were
+// recorded have no earned row. It is safe to run on every start.
+func MigrateLedger(db *gorm.DB) error {
+	if err := db.Model(&models.LoyaltyHistory{}).
+		Where("type = ? AND points > 0", HistoryRedeemed).
+		UpdateColumn("points", gorm.Expr("-points")).Error; err != nil {
+		return err
+	}
+
+	var orders []models.Order
+	if err := db.Unscoped().Where("points_earned > 0").
+		Where("NOT EXISTS (SELECT 1 FROM loyalty_histories WHERE loyalty_histories.order_id = orders.id AND loyalty_histories.type = ?)", HistoryEarned).
+		Find(&orders).Error; err != nil {
+		return err
+	}
+	for _, order := range orders {
+		orderID := order.ID
+		if err := db.Create(&models.LoyaltyHistory{
+			UserID:      order.UserID,
+			Points:      order.PointsEarned,
+			Type:        HistoryEarned,
+			Description: fmt.Sprintf("Earned on order %s", order.OrderNumber),
+			OrderID:     &orderID,
+			CreatedAt:   order.CreatedAt,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package loyalty
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+)
+
+func TestPostKeepsBalanceAndLedgerInStep(t *testing.T) {
+	db := setupLoyaltyDB(t)
+	userID := seedPoints(t, db, 0)
+
+	if err := Post(db, Entry{UserID: userID, Points: 120, Type: HistoryEarned}); err != nil {
+		t.Fatalf("credit: %v", err)
+	}
+	if err := Post(db, Entry{UserID: userID, Points: -200, Type: HistoryRedeemed}); !errors.Is(err, ErrInsufficientPoints) {
+		t.Errorf("expected ErrInsufficientPoints, got %v", err)
+	}
+	taken, err := Take(db, userID, 200, HistoryReversed, "clawback", nil)
+	if err != nil || taken != 120 {
+		t.Errorf("expected Take to stop at the 120 held, got %d (%v)", taken, err)
+	}
+
+	var ledger int
+	db.Model(&models.LoyaltyHistory{}).Where("user_id = ?", userID).Select("COALESCE(SUM(points), 0)").Scan(&ledger)
+	if got := balanceOf(db, userID); got != 0 || ledger != 0 {
+		t.Errorf("expected balance and ledger at 0, got %d and %d", got, ledger)
+	}
+}
+
+func TestReverseCancelledCountsEarlierReversals(t *testing.T) {
+	db := setupLoyaltyDB(t)
+	userID := seedPoints(t, db, 0)
+	order := models.Order{ID: uuid.New(), UserID: userID, OrderNumber: "ORD-1", Subtotal: 40, PointsEarned: 40}
+
+	if err := Earn(db, order); err != nil {
+		t.Fatalf("Earn: %v", err)
+	}
+	// A quarter of the goods were refunded first
+	if points, err := ReverseRefunded(db, order, 10); err != nil || points != 10 {
+		t.Fatalf("expected 10 points reversed for the refund, got %d (%v)", points, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ReverseCancelled(db, order); err != nil {
+			t.Fatalf("ReverseCancelled: %v", err)
+		}
+	}
+	if got := balanceOf(db, userID); got != 0 {
+		t.Errorf("expected all 40 earned points reversed once, got balance %d", got)
+	}
+}
+
+func TestReconcileResetsBalancesToLedger(t *testing.T) {
+	db := setupLoyaltyDB(t)
+	driftedID := seedPoints(t, db, 500)
+	seedHistory(t, db, driftedID, 300, HistoryEarned, time.Now())
+	seedHistory(t, db, driftedID, -100, HistoryRedeemed, time.Now())
+	matchingID := seedPoints(t, db, 50)
+	seedHistory(t, db, matchingID, 50, HistoryEarned, time.Now())
+
+	found, err := Reconcile(db, true)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if len(found) != 1 || found[0].UserID != driftedID || found[0].Balance != 500 || found[0].Ledger != 200 {
+		t.Fatalf("expected one discrepancy of 500 against 200, got %+v", found)
+	}
+	if got := balanceOf(db, driftedID); got != 500 {
+		t.Errorf("expected a dry run to leave the balance, got %d", got)
+	}
+
+	if _, err := Reconcile(db, false); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if got := balanceOf(db, driftedID); got != 200 {
+		t.Errorf("expected the balance reset to 200, got %d", got)
+	}
+	if found, _ := Reconcile(db, true); len(found) != 0 {
+		t.Errorf("expected nothing left to reconcile, got %+v", found)
+	}
+}
+
+func TestMigrateLedgerBackfillsLegacyHistory(t *testing.T) {
+	db := setupLoyaltyDB(t)
+	userID := seedPoints(t, db, 70)
+	placed := time.Now().AddDate(0, -2, 0)
+	// An order from before earnings were recorded, and a redemption through
+	// the old endpoint stored as a positive amount
+	db.Exec(`INSERT INTO orders (id, user_id, order_number, status, total, points_earned, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		uuid.New(), userID, "ORD-OLD", models.OrderStatusDelivered, 100, 100, placed)
+	seedHistory(t, db, userID, 30, HistoryRedeemed, time.Now())
+
+	for i := 0; i < 2; i++ {
+		if err := MigrateLedger(db); err != nil {
+			t.Fatalf("MigrateLedger: %v", err)
+		}
+	}
+	if found, err := Reconcile(db, true); err != nil || len(found) != 0 {
+		t.Errorf("expected the migrated ledger to match the balance, got %+v (%v)", found, err)
+	}
+	var earned models.LoyaltyHistory
+	db.Where("user_id = ? AND type = ?", userID, HistoryEarned).First(&earned)
+	if earned.Points != 100 || earned.CreatedAt.Unix() != placed.Unix() {
+		t.Errorf("expected 100 points earned dated with the order, got %d at %v", earned.Points, earned.CreatedAt)
+	}
+}
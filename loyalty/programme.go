@@ -0,0 +1,104 @@
+package loyalty
+
+import (
+	"math"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TierWindow is how far back spend counts towards a customer's tier.
+const TierWindow = 12 // months
+
+// RollingSpend returns what a user has paid for orders in the TierWindow months
+// up to at, net of refunds. Cancelled orders do not count.
+func RollingSpend(db *gorm.DB, userID uuid.UUID, at time.Time) (float64, error) {
+	var spend float64
+	err := db.Model(&models.Order{}).
+		Where("user_id = ? AND status <> ? AND created_at >= ? AND created_at <= ?", userID, models.OrderStatusCancelled, at.AddDate(0, -TierWindow, 0), at).
+		Select("COALESCE(SUM(total - refunded_amount), 0)").Scan(&spend).Error
+	return spend, err
+}
+
+// Tiers returns the programme's tiers, lowest first.
+func Tiers(db *gorm.DB) ([]models.LoyaltyTier, error) {
+	var tiers []models.LoyaltyTier
+	err := db.Order("min_spend ASC").Find(&tiers).Error
+	return tiers, err
+}
+
+// TierFor returns the highest tier the spend reaches and the one above it.
+// Either is nil when there is no such tier.
+func TierFor(tiers []models.LoyaltyTier, spend float64) (current, next *models.LoyaltyTier) {
+	for i := range tiers {
+		if spend >= tiers[i].MinSpend {
+			current = &tiers[i]
+		} else if next == nil {
+			next = &tiers[i]
+		}
+	}
+	return current, next
+}
+
+// Earning is how a customer earns points on an order.
+type Earning struct {
+	Tier *models.LoyaltyTier
+	// Campaigns running on the products being bought
+	Campaigns []models.LoyaltyCampaign
+}
+
+// EarningFor works out a user's tier and the campaigns running at at on the
+// given products.
+func EarningFor(db *gorm.DB, userID uuid.UUID, productIDs []uuid.UUID, at time.Time) (Earning, error) {
+	var earning Earning
+
+	tiers, err := Tiers(db)
+	if err != nil {
+		return earning, err
+	}
+	if len(tiers) > 0 {
+		spend, err := RollingSpend(db, userID, at)
+		if err != nil {
+			return earning, err
+		}
+		earning.Tier, _ = TierFor(tiers, spend)
+	}
+
+	if len(productIDs) > 0 {
+		var campaigns []models.LoyaltyCampaign
+		if err := db.Where("product_id IN ? AND is_active = ?", productIDs, true).Find(&campaigns).Error; err != nil {
+			return earning, err
+		}
+		for _, c := range campaigns {
+			if c.IsRunningAt(at) {
+				earning.Campaigns = append(earning.Campaigns, c)
+			}
+		}
+	}
+	return earning, nil
+}
+
+// Multiplier is the tier's points multiplier, 1 without a tier.
+func (e Earning) Multiplier() float64 {
+	if e.Tier == nil || e.Tier.Multiplier <= 0 {
+		return 1
+	}
+	return e.Tier.Multiplier
+}
+
+// Points returns the points earned by paying amount for the given quantities
+// of each product: one point per whole unit of money, times the tier
+// multiplier, plus campaign bonuses per unit.
+func (e Earning) Points(amount float64, quantities map[uuid.UUID]int) int {
+	if amount < 0 {
+		amount = 0
+	}
+	points := int(math.Floor(math.Floor(amount) * e.Multiplier()))
+	for _, c := range e.Campaigns {
+		points += c.BonusPoints * quantities[c.ProductID]
+	}
+	return points
+}
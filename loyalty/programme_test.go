@@ -0,0 +1,145 @@
+package loyalty
+
+import (
+	"testing"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func seedOrder(t *testing.T, db *gorm.DB, userID uuid.UUID, status models.OrderStatus, total, refunded float64, at time.Time) {
+	t.Helper()
+	if err := db.Exec(`INSERT INTO orders (id, user_id, status, total, refunded_amount, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		uuid.New(), userID, status, total, refunded, at).Error; err != nil {
+		t.Fatalf("failed to seed order: %v", err)
+	}
+}
+
+func seedHistory(t *testing.T, db *gorm.DB, userID uuid.UUID, points int, kind string, at time.Time) {
+	t.Helper()
+	if err := db.Exec(`INSERT INTO loyalty_histories (id, user_id, points, type, created_at) VALUES (?, ?, ?, ?, ?)`,
+		uuid.New(), userID, points, kind, at).Error; err != nil {
+		t.Fatalf("failed to seed history: %v", err)
+	}
+}
+
+func TestTierForPicksHighestReached(t *testing.T) {
+	tiers := []models.LoyaltyTier{
+		{Name: "Bronze", MinSpend: 0, Multiplier: 1},
+		{Name: "Silver", MinSpend: 250, Multiplier: 1.25},
+		{Name: "Gold", MinSpend: 1000, Multiplier: 1.5},
+	}
+
+	tests := []struct {
+		spend         float64
+		current, next string
+	}{
+		{0, "Bronze", "Silver"},
+		{249.99, "Bronze", "Silver"},
+		{250, "Silver", "Gold"},
+		{5000, "Gold", ""},
+	}
+	for _, tt := range tests {
+		current, next := TierFor(tiers, tt.spend)
+		if current == nil || current.Name != tt.current {
+			t.Errorf("spend %v: expected tier %s, got %v", tt.spend, tt.current, current)
+		}
+		if (next == nil) != (tt.next == "") || (next != nil && next.Name != tt.next) {
+			t.Errorf("spend %v: expected next tier %q, got %v", tt.spend, tt.next, next)
+		}
+	}
+}
+
+func TestEarningPoints(t *testing.T) {
+	productID := uuid.New()
+	earning := Earning{
+		Tier:      &models.LoyaltyTier{Name: "Silver", Multiplier: 1.25},
+		Campaigns: []models.LoyaltyCampaign{{ProductID: productID, BonusPoints: 20}},
+	}
+
+	// floor(45.99) = 45, x1.25 = 56, plus 20 on each of 2 units
+	if got := earning.Points(45.99, map[uuid.UUID]int{productID: 2, uuid.New(): 1}); got != 96 {
+		t.Errorf("expected 96 points, got %d", got)
+	}
+	if got := (Earning{}).Points(12.5, nil); got != 12 {
+		t.Errorf("expected 12 points without a tier, got %d", got)
+	}
+}
+
+func TestEarningForUsesRollingSpendAndRunningCampaigns(t *testing.T) {
+	db := setupLoyaltyDB(t)
+	now := time.Now()
+	userID := seedPoints(t, db, 0)
+	db.Create(&models.LoyaltyTier{Name: "Bronze", MinSpend: 0, Multiplier: 1})
+	db.Create(&models.LoyaltyTier{Name: "Silver", MinSpend: 100, Multiplier: 1.25})
+
+	// Only 60 + (80 - 20) counts: one order is cancelled and one is too old
+	seedOrder(t, db, userID, models.OrderStatusDelivered, 60, 0, now.AddDate(0, -1, 0))
+	seedOrder(t, db, userID, models.OrderStatusDelivered, 80, 20, now.AddDate(0, -11, 0))
+	seedOrder(t, db, userID, models.OrderStatusCancelled, 500, 0, now.AddDate(0, -1, 0))
+	seedOrder(t, db, userID, models.OrderStatusDelivered, 500, 0, now.AddDate(-2, 0, 0))
+
+	productID := uuid.New()
+	ended := now.Add(-time.Hour)
+	db.Create(&models.LoyaltyCampaign{Name: "Running", ProductID: productID, BonusPoints: 5, IsActive: true})
+	db.Create(&models.LoyaltyCampaign{Name: "Ended", ProductID: productID, BonusPoints: 50, IsActive: true, EndsAt: &ended})
+
+	spend, err := RollingSpend(db, userID, now)
+	if err != nil || spend != 120 {
+		t.Fatalf("expected rolling spend 120, got %v (%v)", spend, err)
+	}
+	earning, err := EarningFor(db, userID, []uuid.UUID{productID}, now)
+	if err != nil {
+		t.Fatalf("EarningFor: %v", err)
+	}
+	if earning.Tier == nil || earning.Tier.Name != "Silver" {
+		t.Errorf("expected Silver tier, got %v", earning.Tier)
+	}
+	if len(earning.Campaigns) != 1 || earning.Campaigns[0].Name != "Running" {
+		t.Errorf("expected only the running campaign, got %v", earning.Campaigns)
+	}
+}
+
+func TestExpirePointsUsesOldestFirst(t *testing.T) {
+	db := setupLoyaltyDB(t)
+	now := time.Now()
+	old := now.AddDate(0, -13, 0)
+
+	// 300 earned over a year ago, 100 of it spent since: 200 expire and the
+	// 50 earned recently stay
+	userID := seedPoints(t, db, 250)
+	seedHistory(t, db, userID, 300, HistoryEarned, old)
+	seedHistory(t, db, userID, -100, HistoryRedeemed, now.AddDate(0, -6, 0))
+	seedHistory(t, db, userID, 50, HistoryEarned, now.AddDate(0, -1, 0))
+
+	// Spent everything old already: nothing to expire
+	spentID := seedPoints(t, db, 40)
+	seedHistory(t, db, spentID, 100, HistoryEarned, old)
+	seedHistory(t, db, spentID, -100, HistoryRedeemed, old.AddDate(0, 0, 1))
+	seedHistory(t, db, spentID, 40, HistoryEarned, now)
+
+	n, err := ExpirePoints(db, now, 12)
+	if err != nil {
+		t.Fatalf("ExpirePoints: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected points to expire for 1 user, got %d", n)
+	}
+	if got := balanceOf(db, userID); got != 50 {
+		t.Errorf("expected 50 points left, got %d", got)
+	}
+	if got := balanceOf(db, spentID); got != 40 {
+		t.Errorf("expected 40 points left, got %d", got)
+	}
+
+	// The expired row counts as a debit, so a second run changes nothing
+	if n, err := ExpirePoints(db, now, 12); err != nil || n != 0 {
+		t.Errorf("expected a rerun to expire nothing, got %d (%v)", n, err)
+	}
+	if got := balanceOf(db, userID); got != 50 {
+		t.Errorf("expected 50 points left after rerun, got %d", got)
+	}
+}
@@ -0,0 +1,125 @@
+// Package loyalty runs the loyalty programme: the tiers and campaigns that
+// decide what an order earns, referral rewards, spending points as money off
+// at checkout, and expiring points that go unspent.
+package loyalty
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Redemption settings used when the LOYALTY_* environment variables are not set.
+const (
+	DefaultPointValue       = 0.01 // 100 points are worth 1.00
+	DefaultMaxRedeemPercent = 50.0
+	DefaultMinRedeemPoints  = 100
+)
+
+// History types for points movements.
+const (
+	HistoryEarned   = "earned"
+	HistoryRedeemed = "redeemed"
+	HistoryReversed = "reversed"
+	HistoryRestored = "restored"
+	HistoryExpired  = "expired"
+	HistoryReferral = "referral"
+)
+
+// ErrInsufficientPoints is returned when the balance no longer covers a redemption.
+var ErrInsufficientPoints = errors.New("insufficient loyalty points")
+
+// Policy is how points convert into a checkout discount.
+type Policy struct {
+	// Money off per point
+	PointValue float64
+	// Most of the basket, after discounts, that points may pay for
+	MaxPercent float64
+	// Fewest points accepted in one redemption
+	MinPoints int
+}
+
+// RedemptionPolicy returns the policy configured by LOYALTY_POINT_VALUE,
+// LOYALTY_MAX_REDEEM_PERCENT and LOYALTY_MIN_REDEEM_POINTS.
+func RedemptionPolicy() Policy {
+	return Policy{
+		PointValue: envFloat("LOYALTY_POINT_VALUE", DefaultPointValue),
+		MaxPercent: math.Min(envFloat("LOYALTY_MAX_REDEEM_PERCENT", DefaultMaxRedeemPercent), 100),
+		MinPoints:  int(envFloat("LOYALTY_MIN_REDEEM_POINTS", DefaultMinRedeemPoints)),
+	}
+}
+
+func envFloat(key string, fallback float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && f > 0 {
+			return f
+		}
+		log.Printf("Invalid %s %q, using %v", key, value, fallback)
+	}
+	return fallback
+}
+
+// Redeemable returns how many of the requested points can be spent against
+// amount, after the percentage cap, and the money off they give.
+func (p Policy) Redeemable(points int, amount float64) (int, float64) {
+	if points <= 0 || amount <= 0 || p.PointValue <= 0 {
+		return 0, 0
+	}
+	// The epsilon keeps an exact cap such as 5.00 / 0.01 from flooring to 499
+	maxPoints := int(math.Floor(amount*p.MaxPercent/100/p.PointValue + 1e-9))
+	if points > maxPoints {
+		points = maxPoints
+	}
+	return points, math.Round(float64(points)*p.PointValue*100) / 100
+}
+
+// Redeem takes points from the user's balance for an order. Call it inside the
+// order's transaction; concurrent checkouts cannot spend the same points twice.
+func Redeem(tx *gorm.DB, userID uuid.UUID, points int, order models.Order) error {
+	if points <= 0 {
+		return nil
+	}
+	orderID := order.ID
+	return Post(tx, Entry{
+		UserID:      userID,
+		Points:      -points,
+		Type:        HistoryRedeemed,
+		Description: fmt.Sprintf("Redeemed %d points on order %s", points, order.OrderNumber),
+		OrderID:     &orderID,
+	})
+}
+
+// RestoreRedeemed gives back the points spent on an order that was cancelled.
+// It is safe to call more than once; points are only restored the first time.
+func RestoreRedeemed(db *gorm.DB, order models.Order) error {
+	if order.PointsRedeemed <= 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var restored int64
+		if err := tx.Model(&models.LoyaltyHistory{}).
+			Where("order_id = ? AND type = ?", order.ID, HistoryRestored).Count(&restored).Error; err != nil {
+			return err
+		}
+		if restored > 0 {
+			return nil
+		}
+
+		orderID := order.ID
+		return Post(tx, Entry{
+			UserID:      order.UserID,
+			Points:      order.PointsRedeemed,
+			Type:        HistoryRestored,
+			Description: fmt.Sprintf("Points restored for cancelled order %s", order.OrderNumber),
+			OrderID:     &orderID,
+		})
+	})
+}
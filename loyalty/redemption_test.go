@@ -0,0 +1,161 @@
+package loyalty
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupLoyaltyDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", uuid.New())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// Only the columns the programme reads are needed; raw DDL because the
+	// models' uuid defaults are PostgreSQL-specific
+	for _, sql := range []string{
+		`CREATE TABLE "users" (
+			"id" TEXT PRIMARY KEY,
+			"loyalty_points" INTEGER DEFAULT 0,
+			"updated_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+		`CREATE TABLE "loyalty_histories" (
+			"id" TEXT PRIMARY KEY,
+			"user_id" TEXT NOT NULL,
+			"points" INTEGER NOT NULL,
+			"type" TEXT NOT NULL,
+			"description" TEXT,
+			"order_id" TEXT,
+			"created_at" DATETIME
+		)`,
+		`CREATE TABLE "orders" (
+			"id" TEXT PRIMARY KEY,
+			"user_id" TEXT NOT NULL,
+			"order_number" TEXT,
+			"status" TEXT,
+			"subtotal" REAL DEFAULT 0,
+			"total" REAL DEFAULT 0,
+			"points_earned" INTEGER DEFAULT 0,
+			"refunded_amount" REAL DEFAULT 0,
+			"created_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+		`CREATE TABLE "loyalty_tiers" (
+			"id" TEXT PRIMARY KEY,
+			"name" TEXT NOT NULL UNIQUE,
+			"min_spend" REAL NOT NULL DEFAULT 0,
+			"multiplier" REAL NOT NULL DEFAULT 1,
+			"created_at" DATETIME,
+			"updated_at" DATETIME
+		)`,
+		`CREATE TABLE "loyalty_campaigns" (
+			"id" TEXT PRIMARY KEY,
+			"name" TEXT NOT NULL,
+			"product_id" TEXT NOT NULL,
+			"bonus_points" INTEGER NOT NULL,
+			"starts_at" DATETIME,
+			"ends_at" DATETIME,
+			"is_active" NUMERIC DEFAULT true,
+			"created_at" DATETIME,
+			"updated_at" DATETIME,
+			"deleted_at" DATETIME
+		)`,
+	} {
+		if err := db.Exec(sql).Error; err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	return db
+}
+
+func seedPoints(t *testing.T, db *gorm.DB, points int) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	if err := db.Exec(`INSERT INTO users (id, loyalty_points) VALUES (?, ?)`, id, points).Error; err != nil {
+		t.Fatalf("failed to seed user: %v", err)
+	}
+	return id
+}
+
+func balanceOf(db *gorm.DB, userID uuid.UUID) int {
+	var points int
+	db.Raw(`SELECT loyalty_points FROM users WHERE id = ?`, userID).Scan(&points)
+	return points
+}
+
+func TestRedeemableCapsPoints(t *testing.T) {
+	policy := Policy{PointValue: 0.01, MaxPercent: 50, MinPoints: 100}
+
+	if points, value := policy.Redeemable(300, 20); points != 300 || value != 3 {
+		t.Errorf("expected 300 points worth 3.00, got %d worth %v", points, value)
+	}
+	// Half of 10.00 is 500 points
+	if points, value := policy.Redeemable(2000, 10); points != 500 || value != 5 {
+		t.Errorf("expected the cap of 500 points worth 5.00, got %d worth %v", points, value)
+	}
+	if points, value := policy.Redeemable(300, 0); points != 0 || value != 0 {
+		t.Errorf("expected nothing off an empty basket, got %d worth %v", points, value)
+	}
+}
+
+func TestRedemptionPolicyFromEnv(t *testing.T) {
+	t.Setenv("LOYALTY_POINT_VALUE", "0.05")
+	t.Setenv("LOYALTY_MAX_REDEEM_PERCENT", "250")
+	t.Setenv("LOYALTY_MIN_REDEEM_POINTS", "nope")
+
+	policy := RedemptionPolicy()
+	if policy.PointValue != 0.05 {
+		t.Errorf("expected point value 0.05, got %v", policy.PointValue)
+	}
+	if policy.MaxPercent != 100 {
+		t.Errorf("expected the percentage cap to stop at 100, got %v", policy.MaxPercent)
+	}
+	if policy.MinPoints != DefaultMinRedeemPoints {
+		t.Errorf("expected an invalid minimum to fall back to %d, got %d", DefaultMinRedeemPoints, policy.MinPoints)
+	}
+}
+
+func TestRedeemRejectsOverspend(t *testing.T) {
+	db := setupLoyaltyDB(t)
+	userID := seedPoints(t, db, 250)
+	order := models.Order{ID: uuid.New(), UserID: userID, OrderNumber: "ORD-1"}
+
+	if err := Redeem(db, userID, 200, order); err != nil {
+		t.Fatalf("failed to redeem: %v", err)
+	}
+	if err := Redeem(db, userID, 200, order); !errors.Is(err, ErrInsufficientPoints) {
+		t.Errorf("expected ErrInsufficientPoints, got %v", err)
+	}
+	if balance := balanceOf(db, userID); balance != 50 {
+		t.Errorf("expected 50 points left, got %d", balance)
+	}
+
+	var history models.LoyaltyHistory
+	db.Where("user_id = ?", userID).First(&history)
+	if history.Type != HistoryRedeemed || history.Points != -200 || history.OrderID == nil || *history.OrderID != order.ID {
+		t.Errorf("expected a redeemed row of -200 for the order, got %+v", history)
+	}
+}
+
+func TestRestoreRedeemedOnlyOnce(t *testing.T) {
+	db := setupLoyaltyDB(t)
+	userID := seedPoints(t, db, 0)
+	order := models.Order{ID: uuid.New(), UserID: userID, OrderNumber: "ORD-2", PointsRedeemed: 150}
+
+	for i := 0; i < 2; i++ {
+		if err := RestoreRedeemed(db, order); err != nil {
+			t.Fatalf("failed to restore: %v", err)
+		}
+	}
+	if balance := balanceOf(db, userID); balance != 150 {
+		t.Errorf("expected 150 points restored once, got %d", balance)
+	}
+}
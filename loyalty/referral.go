@@ -0,0 +1,275 @@
+package loyalty
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Referral rewards used when the REFERRAL_* environment variables are not set.
+const (
+	DefaultReferrerPoints = 500
+	DefaultRefereePoints  = 250
+)
+
+// Reasons a referral is rejected.
+const (
+	ReferralSelf        = "self_referral"
+	ReferralSameDomain  = "same_email_domain"
+	ReferralSameAddress = "same_address"
+)
+
+// ErrInvalidReferralCode is returned for a code that belongs to no active customer.
+var ErrInvalidReferralCode = errors.New("invalid referral code")
+
+// referralAlphabet leaves out characters that are easily confused when a code
+// is read out or typed: 0/O and 1/I/L.
+const referralAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+const referralCodeLength = 8
+
+// publicEmailDomains are shared by unrelated customers, so a referral between
+// two of their addresses is not suspicious.
+var publicEmailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "outlook.com": true, "hotmail.com": true,
+	"hotmail.co.uk": true, "live.com": true, "live.co.uk": true, "yahoo.com": true,
+	"yahoo.co.uk": true, "icloud.com": true, "me.com": true, "aol.com": true,
+	"proton.me": true, "protonmail.com": true,
+}
+
+// ReferralRewards returns the points given to the referrer and the referee,
+// configured by REFERRAL_REFERRER_POINTS and REFERRAL_REFEREE_POINTS.
+func ReferralRewards() (referrer, referee int) {
+	return int(envFloat("REFERRAL_REFERRER_POINTS", DefaultReferrerPoints)),
+		int(envFloat("REFERRAL_REFEREE_POINTS", DefaultRefereePoints))
+}
+
+// NewReferralCode returns a referral code no user has yet.
+func NewReferralCode(db *gorm.DB) (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		b := make([]byte, referralCodeLength)
+		for i := range b {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(referralAlphabet))))
+			if err != nil {
+				return "", err
+			}
+			b[i] = referralAlphabet[n.Int64()]
+		}
+		code := string(b)
+
+		var count int64
+		if err := db.Model(&models.User{}).Unscoped().Where("referral_code = ?", code).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return code, nil
+		}
+	}
+	return "", errors.New("could not generate a unique referral code")
+}
+
+// EnsureReferralCode returns the user's referral code, giving them one first
+// if they signed up before referral codes existed.
+func EnsureReferralCode(db *gorm.DB, user *models.User) (string, error) {
+	if user.ReferralCode != nil {
+		return *user.ReferralCode, nil
+	}
+	code, err := NewReferralCode(db)
+	if err != nil {
+		return "", err
+	}
+	if err := db.Model(user).UpdateColumn("referral_code", code).Error; err != nil {
+		return "", err
+	}
+	user.ReferralCode = &code
+	return code, nil
+}
+
+// FindReferrer returns the active customer a referral code belongs to.
+func FindReferrer(db *gorm.DB, code string) (*models.User, error) {
+	var referrer models.User
+	err := db.Where("referral_code = ? AND is_blocked = ?", strings.ToUpper(strings.TrimSpace(code)), false).First(&referrer).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidReferralCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &referrer, nil
+}
+
+// Refer records that referee signed up with referrer's code. Self-referrals
+// and referrals within a private email domain are recorded as rejected rather
+// than failing the sign-up, so they still show in the referral report.
+func Refer(tx *gorm.DB, referrer, referee models.User) (*models.Referral, error) {
+	referral := models.Referral{
+		ReferrerID: referrer.ID,
+		RefereeID:  referee.ID,
+		Status:     models.ReferralStatusPending,
+	}
+	if referrer.ReferralCode != nil {
+		referral.Code = *referrer.ReferralCode
+	}
+	if reason := referralSignupRejection(referrer.Email, referee.Email); reason != "" {
+		referral.Status = models.ReferralStatusRejected
+		referral.RejectReason = reason
+	}
+	if err := tx.Create(&referral).Error; err != nil {
+		return nil, err
+	}
+	return &referral, nil
+}
+
+// referralSignupRejection returns why a referral between the two email
+// addresses is not allowed, or "" when it is.
+func referralSignupRejection(referrerEmail, refereeEmail string) string {
+	referrerLocal, referrerDomain := splitEmail(referrerEmail)
+	refereeLocal, refereeDomain := splitEmail(refereeEmail)
+	if referrerDomain == refereeDomain {
+		// Sub-addresses such as jo+2@example.com reach the same inbox
+		if strings.SplitN(referrerLocal, "+", 2)[0] == strings.SplitN(refereeLocal, "+", 2)[0] {
+			return ReferralSelf
+		}
+		if !publicEmailDomains[referrerDomain] {
+			return ReferralSameDomain
+		}
+	}
+	return ""
+}
+
+func splitEmail(email string) (local, domain string) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email, ""
+	}
+	return email[:at], email[at+1:]
+}
+
+// normaliseAddress makes addresses that differ only in case, spacing or
+// punctuation compare equal.
+func normaliseAddress(address string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(address) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// RewardReferral pays out the referee's pending referral now that order has
+// been completed. A referral whose order is delivered to an address the
+// referrer has also had orders delivered to is rejected instead. Only the
+// first completed order counts; later calls do nothing.
+func RewardReferral(db *gorm.DB, order models.Order) (*models.Referral, error) {
+	var referral models.Referral
+	err := db.Where("referee_id = ? AND status = ?", order.UserID, models.ReferralStatusPending).First(&referral).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if address := normaliseAddress(order.DeliveryAddress); address != "" {
+		var addresses []string
+		if err := db.Model(&models.Order{}).Where("user_id = ? AND delivery_address <> ''", referral.ReferrerID).
+			Distinct().Pluck("delivery_address", &addresses).Error; err != nil {
+			return nil, err
+		}
+		for _, a := range addresses {
+			if normaliseAddress(a) == address {
+				result := db.Model(&referral).Where("status = ?", models.ReferralStatusPending).Updates(map[string]interface{}{
+					"status":        models.ReferralStatusRejected,
+					"reject_reason": ReferralSameAddress,
+					"order_id":      order.ID,
+				})
+				return &referral, result.Error
+			}
+		}
+	}
+
+	referrerPoints, refereePoints := ReferralRewards()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// Moving the referral out of pending first means two completions racing
+		// each other cannot both pay out
+		result := tx.Model(&referral).Where("status = ?", models.ReferralStatusPending).Updates(map[string]interface{}{
+			"status":          models.ReferralStatusRewarded,
+			"order_id":        order.ID,
+			"referrer_points": referrerPoints,
+			"referee_points":  refereePoints,
+			"rewarded_at":     now,
+		})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		orderID := order.ID
+		if err := Post(tx, Entry{
+			UserID:      referral.ReferrerID,
+			Points:      referrerPoints,
+			Type:        HistoryReferral,
+			Description: "Referral reward for inviting a friend",
+			OrderID:     &orderID,
+		}); err != nil {
+			return err
+		}
+		return Post(tx, Entry{
+			UserID:      referral.RefereeID,
+			Points:      refereePoints,
+			Type:        HistoryReferral,
+			Description: fmt.Sprintf("Referral reward on your first order %s", order.OrderNumber),
+			OrderID:     &orderID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &referral, nil
+}
+
+// ReferralSummary counts a referrer's referrals and the points they earned.
+type ReferralSummary struct {
+	Pending      int64 `json:"pending"`
+	Rewarded     int64 `json:"rewarded"`
+	Rejected     int64 `json:"rejected"`
+	PointsEarned int   `json:"points_earned"`
+}
+
+// SummariseReferrals returns the referrals made with the user's code.
+func SummariseReferrals(db *gorm.DB, referrerID uuid.UUID) (ReferralSummary, error) {
+	var rows []struct {
+		Status models.ReferralStatus
+		Count  int64
+		Points int
+	}
+	var summary ReferralSummary
+	err := db.Model(&models.Referral{}).Where("referrer_id = ?", referrerID).
+		Select("status, COUNT(*) AS count, COALESCE(SUM(referrer_points), 0) AS points").
+		Group("status").Scan(&rows).Error
+	if err != nil {
+		return summary, err
+	}
+	for _, r := range rows {
+		switch r.Status {
+		case models.ReferralStatusPending:
+			summary.Pending = r.Count
+		case models.ReferralStatusRewarded:
+			summary.Rewarded = r.Count
+			summary.PointsEarned = r.Points
+		case models.ReferralStatusRejected:
+			summary.Rejected = r.Count
+		}
+	}
+	return summary, nil
+}
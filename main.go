@@ -12,7 +12,13 @@ import (
 	"grabbi-backend/config"
 	"grabbi-backend/database"
 	"grabbi-backend/firebase"
+	"grabbi-backend/handlers"
+	"grabbi-backend/inventory"
+	"grabbi-backend/loyalty"
+	"grabbi-backend/middleware"
+	"grabbi-backend/payments"
 	"grabbi-backend/routes"
+	"grabbi-backend/utils"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -50,10 +56,36 @@ func main() {
 		log.Printf("Warning: Could not create default franchise: %v", err)
 	}
 
+	// Create default loyalty tiers if none are configured
+	if err := database.CreateDefaultLoyaltyTiers(db); err != nil {
+		log.Printf("Warning: Could not create default loyalty tiers: %v", err)
+	}
+
+	// Bring loyalty history written before the points ledger into line with it
+	if err := loyalty.MigrateLedger(db); err != nil {
+		log.Printf("Warning: Could not migrate loyalty ledger: %v", err)
+	}
+
 	//firebase init
 	firebase.Init()
 	storageClient := firebase.NewStorageClient()
 
+	// Payment provider
+	paymentProvider, err := payments.NewProvider()
+	if err != nil {
+		log.Fatal("Failed to configure payment provider: ", err)
+	}
+
+	// Give back stock held by abandoned checkouts
+	stopReservationSweeper := inventory.StartSweeper(db, time.Minute)
+	// Forget idempotency keys and guest carts once they have expired
+	stopIdempotencySweeper := middleware.StartIdempotencySweeper(db, time.Hour)
+	stopGuestCartSweeper := handlers.StartGuestCartSweeper(db, time.Hour)
+	// Expire loyalty points that have gone unspent for too long
+	stopPointsExpiry := loyalty.StartExpiryJob(db, time.Hour)
+	// Place the orders subscriptions have due
+	stopSubscriptions := handlers.StartSubscriptionJob(&handlers.OrderHandler{DB: db, Storage: storageClient, Payments: paymentProvider}, 5*time.Minute)
+
 	// Setup Gin router
 	r := gin.Default()
 
@@ -83,13 +115,13 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     filteredOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Cart-Token"},
+		ExposeHeaders:    []string{"Content-Length", "X-Cart-Token"},
 		AllowCredentials: true,
 	}))
 
 	// Setup routes
-	routes.SetupRoutes(r, db, storageClient)
+	routes.SetupRoutes(r, db, storageClient, paymentProvider)
 
 	// Start server with graceful shutdown
 	port := os.Getenv("PORT")
@@ -101,6 +133,8 @@ func main() {
 		Addr:    ":" + port,
 		Handler: r,
 	}
+	// Close live order streams so Shutdown does not wait on them
+	srv.RegisterOnShutdown(utils.OrderEvents.Close)
 
 	// Run server in a goroutine
 	go func() {
@@ -123,6 +157,11 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server forced to shutdown:", err)
 	}
+	stopReservationSweeper()
+	stopIdempotencySweeper()
+	stopGuestCartSweeper()
+	stopPointsExpiry()
+	stopSubscriptions()
 
 	// Close database connection
 	sqlDB, err := db.DB()
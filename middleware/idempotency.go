@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header clients set to make a write safe to retry.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayHeader is set on responses replayed from a stored record.
+	IdempotentReplayHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	// Keys are remembered for a day; after that the same key starts a new request.
+	idempotencyKeyTTL = 24 * time.Hour
+	// An in-flight record older than this is assumed abandoned (e.g. the server
+	// restarted mid-request) and may be taken over by a retry.
+	idempotencyLockTimeout = time.Minute
+)
+
+// idempotencyWriter captures the response body so it can be stored for replay.
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency replays the stored response when an authenticated client retries a
+// write with the same Idempotency-Key. Keys are scoped per user; reusing a key
+// for a different request is rejected. Requests without the header run normally.
+// Must run after AuthMiddleware.
+func Idempotency(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader(IdempotencyKeyHeader))
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			c.Abort()
+			return
+		}
+
+		value, _ := c.Get("user_id")
+		userID, ok := value.(uuid.UUID)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash := idempotencyRequestHash(c.Request.Method, c.Request.URL.Path, body)
+
+		var existing models.IdempotencyRecord
+		err = db.Where("user_id = ? AND idempotency_key = ?", userID, key).First(&existing).Error
+		switch {
+		case err == nil:
+			if !idempotencyRecordExpired(existing) {
+				replayIdempotentResponse(c, existing, hash)
+				return
+			}
+			db.Delete(&existing)
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check idempotency key"})
+			c.Abort()
+			return
+		}
+
+		record := models.IdempotencyRecord{
+			UserID:         userID,
+			IdempotencyKey: key,
+			Method:         c.Request.Method,
+			Path:           c.Request.URL.Path,
+			RequestHash:    hash,
+		}
+		// The unique index on (user, key) means only one concurrent request can claim it
+		if err := db.Create(&record).Error; err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is already in progress"})
+			c.Abort()
+			return
+		}
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			// Server errors are not remembered so the client can retry them
+			db.Delete(&record)
+			return
+		}
+
+		now := time.Now()
+		db.Model(&record).Updates(map[string]interface{}{
+			"status_code":   status,
+			"content_type":  writer.Header().Get("Content-Type"),
+			"response_body": writer.body.String(),
+			"completed_at":  now,
+		})
+	}
+}
+
+func idempotencyRequestHash(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func idempotencyRecordExpired(record models.IdempotencyRecord) bool {
+	if record.CompletedAt == nil {
+		return time.Since(record.CreatedAt) > idempotencyLockTimeout
+	}
+	return time.Since(record.CreatedAt) > idempotencyKeyTTL
+}
+
+func replayIdempotentResponse(c *gin.Context, record models.IdempotencyRecord, hash string) {
+	if record.RequestHash != hash {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key has already been used for a different request"})
+		c.Abort()
+		return
+	}
+	if record.CompletedAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is already in progress"})
+		c.Abort()
+		return
+	}
+
+	c.Header(IdempotentReplayHeader, "true")
+	c.Data(record.StatusCode, record.ContentType, []byte(record.ResponseBody))
+	c.Abort()
+}
+
+// SweepIdempotencyRecords deletes the records of keys that expired before now
+// and returns how many were removed.
+func SweepIdempotencyRecords(db *gorm.DB, now time.Time) (int64, error) {
+	result := db.Where("created_at < ?", now.Add(-idempotencyKeyTTL)).Delete(&models.IdempotencyRecord{})
+	return result.RowsAffected, result.Error
+}
+
+// StartIdempotencySweeper deletes expired idempotency records every interval
+// until the returned stop function is called.
+func StartIdempotencySweeper(db *gorm.DB, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				n, err := SweepIdempotencyRecords(db, now)
+				if err != nil {
+					log.Printf("Failed to sweep idempotency records: %v", err)
+				} else if n > 0 {
+					log.Printf("Deleted %d expired idempotency records", n)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
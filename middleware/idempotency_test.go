@@ -0,0 +1,231 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupIdempotencyDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", uuid.New())), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// Raw DDL because the model's uuid default is PostgreSQL-specific
+	for _, sql := range []string{
+		`CREATE TABLE "idempotency_records" (
+			"id" TEXT PRIMARY KEY,
+			"user_id" TEXT NOT NULL,
+			"idempotency_key" TEXT NOT NULL,
+			"method" TEXT NOT NULL,
+			"path" TEXT NOT NULL,
+			"request_hash" TEXT NOT NULL,
+			"status_code" INTEGER DEFAULT 0,
+			"content_type" TEXT,
+			"response_body" TEXT,
+			"completed_at" DATETIME,
+			"created_at" DATETIME
+		)`,
+		`CREATE UNIQUE INDEX idx_idempotency_user_key ON "idempotency_records"("user_id", "idempotency_key")`,
+	} {
+		if err := db.Exec(sql).Error; err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	return db
+}
+
+// setupIdempotencyRouter mounts a counting handler behind the middleware. The
+// caller's user ID comes from the X-User header in place of a JWT.
+func setupIdempotencyRouter(db *gorm.DB, status int, calls *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if id, err := uuid.Parse(c.GetHeader("X-User")); err == nil {
+			c.Set("user_id", id)
+		}
+	})
+	r.POST("/orders", Idempotency(db), func(c *gin.Context) {
+		*calls++
+		c.JSON(status, gin.H{"call": *calls})
+	})
+	return r
+}
+
+func idempotentRequest(userID uuid.UUID, key, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/orders", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", userID.String())
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	return req
+}
+
+func TestIdempotencyReplaysCompletedResponse(t *testing.T) {
+	db := setupIdempotencyDB(t)
+	calls := 0
+	r := setupIdempotencyRouter(db, http.StatusCreated, &calls)
+	user := uuid.New()
+
+	first := httptest.NewRecorder()
+	r.ServeHTTP(first, idempotentRequest(user, "key-1", `{"a":1}`))
+	second := httptest.NewRecorder()
+	r.ServeHTTP(second, idempotentRequest(user, "key-1", `{"a":1}`))
+
+	if calls != 1 {
+		t.Fatalf("expected handler to run once, ran %d times", calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("expected replay of %d %s, got %d %s", first.Code, first.Body.String(), second.Code, second.Body.String())
+	}
+	if second.Header().Get(IdempotentReplayHeader) != "true" {
+		t.Error("expected replayed response to be marked")
+	}
+	if ct := second.Header().Get("Content-Type"); ct != first.Header().Get("Content-Type") {
+		t.Errorf("expected content type %q, got %q", first.Header().Get("Content-Type"), ct)
+	}
+}
+
+func TestIdempotencyRejectsKeyReuseWithDifferentBody(t *testing.T) {
+	db := setupIdempotencyDB(t)
+	calls := 0
+	r := setupIdempotencyRouter(db, http.StatusCreated, &calls)
+	user := uuid.New()
+
+	r.ServeHTTP(httptest.NewRecorder(), idempotentRequest(user, "key-1", `{"a":1}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, idempotentRequest(user, "key-1", `{"a":2}`))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d", w.Code)
+	}
+	if calls != 1 {
+		t.Errorf("expected handler to run once, ran %d times", calls)
+	}
+}
+
+func TestIdempotencyKeysAreScopedPerUser(t *testing.T) {
+	db := setupIdempotencyDB(t)
+	calls := 0
+	r := setupIdempotencyRouter(db, http.StatusCreated, &calls)
+
+	r.ServeHTTP(httptest.NewRecorder(), idempotentRequest(uuid.New(), "shared", `{}`))
+	r.ServeHTTP(httptest.NewRecorder(), idempotentRequest(uuid.New(), "shared", `{}`))
+
+	if calls != 2 {
+		t.Errorf("expected each user's request to run, ran %d times", calls)
+	}
+}
+
+func TestIdempotencyWithoutKeyAlwaysRuns(t *testing.T) {
+	db := setupIdempotencyDB(t)
+	calls := 0
+	r := setupIdempotencyRouter(db, http.StatusCreated, &calls)
+	user := uuid.New()
+
+	r.ServeHTTP(httptest.NewRecorder(), idempotentRequest(user, "", `{}`))
+	r.ServeHTTP(httptest.NewRecorder(), idempotentRequest(user, "", `{}`))
+
+	if calls != 2 {
+		t.Errorf("expected both requests to run, ran %d times", calls)
+	}
+}
+
+func TestIdempotencyDoesNotRememberServerErrors(t *testing.T) {
+	db := setupIdempotencyDB(t)
+	calls := 0
+	r := setupIdempotencyRouter(db, http.StatusInternalServerError, &calls)
+	user := uuid.New()
+
+	r.ServeHTTP(httptest.NewRecorder(), idempotentRequest(user, "key-1", `{}`))
+	r.ServeHTTP(httptest.NewRecorder(), idempotentRequest(user, "key-1", `{}`))
+
+	if calls != 2 {
+		t.Errorf("expected retry after a server error to run again, ran %d times", calls)
+	}
+}
+
+func TestIdempotencyInFlightKeyConflicts(t *testing.T) {
+	db := setupIdempotencyDB(t)
+	calls := 0
+	r := setupIdempotencyRouter(db, http.StatusCreated, &calls)
+	user := uuid.New()
+
+	db.Create(&models.IdempotencyRecord{
+		UserID:         user,
+		IdempotencyKey: "key-1",
+		Method:         "POST",
+		Path:           "/orders",
+		RequestHash:    idempotencyRequestHash("POST", "/orders", []byte(`{}`)),
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, idempotentRequest(user, "key-1", `{}`))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", w.Code)
+	}
+	if calls != 0 {
+		t.Errorf("expected handler not to run, ran %d times", calls)
+	}
+}
+
+func TestIdempotencyAbandonedInFlightKeyIsTakenOver(t *testing.T) {
+	db := setupIdempotencyDB(t)
+	calls := 0
+	r := setupIdempotencyRouter(db, http.StatusCreated, &calls)
+	user := uuid.New()
+
+	db.Create(&models.IdempotencyRecord{
+		UserID:         user,
+		IdempotencyKey: "key-1",
+		Method:         "POST",
+		Path:           "/orders",
+		RequestHash:    idempotencyRequestHash("POST", "/orders", []byte(`{}`)),
+		CreatedAt:      time.Now().Add(-2 * idempotencyLockTimeout),
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, idempotentRequest(user, "key-1", `{}`))
+	if w.Code != http.StatusCreated || calls != 1 {
+		t.Errorf("expected the retry to run, got %d after %d calls", w.Code, calls)
+	}
+}
+
+func TestSweepIdempotencyRecordsDeletesExpiredKeys(t *testing.T) {
+	db := setupIdempotencyDB(t)
+	user := uuid.New()
+	now := time.Now()
+
+	for key, age := range map[string]time.Duration{"old": 2 * idempotencyKeyTTL, "recent": time.Hour} {
+		db.Create(&models.IdempotencyRecord{
+			UserID:         user,
+			IdempotencyKey: key,
+			Method:         "POST",
+			Path:           "/orders",
+			RequestHash:    idempotencyRequestHash("POST", "/orders", []byte(`{}`)),
+			CreatedAt:      now.Add(-age),
+		})
+	}
+
+	n, err := SweepIdempotencyRecords(db, now)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 record swept, got %d, %v", n, err)
+	}
+	var keys []string
+	db.Model(&models.IdempotencyRecord{}).Pluck("idempotency_key", &keys)
+	if len(keys) != 1 || keys[0] != "recent" {
+		t.Errorf("expected only the recent key to remain, got %v", keys)
+	}
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CouponType is how a coupon discounts an order.
+type CouponType string
+
+const (
+	// CouponPercentage takes Value percent off the eligible items
+	CouponPercentage CouponType = "percentage"
+	// CouponFixed takes Value off the eligible items, up to their total
+	CouponFixed CouponType = "fixed"
+	// CouponFreeDelivery waives the delivery fee
+	CouponFreeDelivery CouponType = "free_delivery"
+)
+
+// Coupon is a discount code customers enter at checkout. A coupon with a
+// FranchiseID can only be used at that franchise; one without can be used
+// anywhere. When it has Targets only the matching products and categories are
+// discounted, otherwise the whole basket is.
+type Coupon struct {
+	ID           uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Code         string         `gorm:"uniqueIndex;not null" json:"code"` // Stored upper case
+	Description  string         `json:"description"`
+	Type         CouponType     `gorm:"not null" json:"type"`
+	Value        float64        `gorm:"default:0" json:"value"`
+	MinBasket    float64        `gorm:"default:0" json:"min_basket"`
+	MaxDiscount  *float64       `json:"max_discount"`   // Cap on percentage discounts
+	UsageLimit   *int           `json:"usage_limit"`    // Total redemptions allowed; nil is unlimited
+	PerUserLimit *int           `json:"per_user_limit"` // Redemptions allowed per customer; nil is unlimited
+	UsedCount    int            `gorm:"default:0" json:"used_count"`
+	StartsAt     *time.Time     `json:"starts_at"`
+	EndsAt       *time.Time     `json:"ends_at"`
+	FranchiseID  *uuid.UUID     `gorm:"type:uuid;index" json:"franchise_id,omitempty"`
+	IsActive     bool           `gorm:"default:true" json:"is_active"`
+	Targets      []CouponTarget `gorm:"foreignKey:CouponID" json:"targets"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// CouponTarget limits a coupon to a product or a whole category. Exactly one of
+// ProductID and CategoryID is set.
+type CouponTarget struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	CouponID   uuid.UUID  `gorm:"type:uuid;not null;index" json:"coupon_id"`
+	ProductID  *uuid.UUID `gorm:"type:uuid" json:"product_id,omitempty"`
+	CategoryID *uuid.UUID `gorm:"type:uuid" json:"category_id,omitempty"`
+}
+
+// CouponRedemption records a coupon used on an order. It is removed again if
+// the order is cancelled, giving the use back.
+type CouponRedemption struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	CouponID  uuid.UUID `gorm:"type:uuid;not null;index:idx_coupon_redemption_user" json:"coupon_id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index:idx_coupon_redemption_user" json:"user_id"`
+	OrderID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"order_id"`
+	Discount  float64   `json:"discount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IsRedeemableAt reports whether the coupon is switched on and inside its
+// validity window at t.
+func (c *Coupon) IsRedeemableAt(t time.Time) bool {
+	if !c.IsActive {
+		return false
+	}
+	if c.StartsAt != nil && t.Before(*c.StartsAt) {
+		return false
+	}
+	if c.EndsAt != nil && !t.Before(*c.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// Applies reports whether the coupon discounts a product in the given category.
+func (c *Coupon) Applies(productID, categoryID uuid.UUID) bool {
+	if len(c.Targets) == 0 {
+		return true
+	}
+	for _, t := range c.Targets {
+		if (t.ProductID != nil && *t.ProductID == productID) || (t.CategoryID != nil && *t.CategoryID == categoryID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Coupon) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+func (t *CouponTarget) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+func (r *CouponRedemption) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DeliverySlot is a bookable delivery window for a franchise. Slots are
+// generated from the franchise's StoreHours; Reserved counts the orders booked
+// into the slot and may not exceed Capacity.
+type DeliverySlot struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	FranchiseID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_delivery_slot_franchise_start" json:"franchise_id"`
+	StartsAt    time.Time `gorm:"not null;uniqueIndex:idx_delivery_slot_franchise_start" json:"starts_at"`
+	EndsAt      time.Time `gorm:"not null" json:"ends_at"`
+	Capacity    int       `gorm:"not null" json:"capacity"`
+	Reserved    int       `gorm:"not null;default:0" json:"reserved"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Available returns how many more orders the slot can take.
+func (s *DeliverySlot) Available() int {
+	if s.Reserved >= s.Capacity {
+		return 0
+	}
+	return s.Capacity - s.Reserved
+}
+
+func (s *DeliverySlot) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
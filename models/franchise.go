@@ -21,6 +21,7 @@ type Franchise struct {
 	DeliveryRadius  float64        `gorm:"default:5" json:"delivery_radius"`
 	DeliveryFee     float64        `gorm:"default:4.99" json:"delivery_fee"`
 	FreeDeliveryMin float64        `gorm:"default:50" json:"free_delivery_min"`
+	SlotCapacity    int            `gorm:"default:10" json:"slot_capacity"` // Orders per delivery slot
 	Phone           string         `json:"phone"`
 	Email           string         `json:"email"`
 	IsActive        bool           `gorm:"default:true" json:"is_active"`
@@ -32,3 +32,19 @@ func (fp *FranchiseProduct) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// IsPromotionActiveAt returns true if the franchise's promotion price applies at
+// t. Unlike the master catalogue, a promotion override without dates runs until
+// the franchise removes it; an unset start or end leaves that side open.
+func (fp *FranchiseProduct) IsPromotionActiveAt(t time.Time) bool {
+	if fp.PromotionPriceOverride == nil {
+		return false
+	}
+	if fp.PromotionStartOverride != nil && t.Before(*fp.PromotionStartOverride) {
+		return false
+	}
+	if fp.PromotionEndOverride != nil && t.After(*fp.PromotionEndOverride) {
+		return false
+	}
+	return true
+}
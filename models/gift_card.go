@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GiftCard is a stored balance that customers spend at checkout. Cards issued
+// by a franchise can only be spent at that franchise; cards issued by an admin
+// work everywhere. Balance is the running total of the card's transactions.
+type GiftCard struct {
+	ID             uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Code           string         `gorm:"uniqueIndex;not null" json:"code"`
+	InitialBalance float64        `gorm:"not null" json:"initial_balance"`
+	Balance        float64        `gorm:"not null" json:"balance"`
+	FranchiseID    *uuid.UUID     `gorm:"type:uuid;index" json:"franchise_id,omitempty"`
+	IssuedByID     uuid.UUID      `gorm:"type:uuid;not null" json:"issued_by_id"`
+	ExpiresAt      *time.Time     `json:"expires_at,omitempty"`
+	IsActive       bool           `gorm:"default:true" json:"is_active"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (g *GiftCard) BeforeCreate(tx *gorm.DB) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return nil
+}
+
+// GiftCardTransaction is one movement of a gift card's balance: positive when
+// money is loaded or refunded onto the card, negative when it is spent.
+type GiftCardTransaction struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	GiftCardID   uuid.UUID  `gorm:"type:uuid;not null;index" json:"gift_card_id"`
+	OrderID      *uuid.UUID `gorm:"type:uuid;index" json:"order_id,omitempty"`
+	Type         string     `gorm:"not null" json:"type"` // "issued", "redeemed" or "refunded"
+	Amount       float64    `gorm:"not null" json:"amount"`
+	BalanceAfter float64    `gorm:"not null" json:"balance_after"`
+	Description  string     `json:"description"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+func (t *GiftCardTransaction) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GuestCart is the basket of a visitor who has not signed in. The visitor holds
+// a signed token for it, and it is folded into their own cart when they log in
+// or register.
+type GuestCart struct {
+	ID        uuid.UUID       `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ExpiresAt time.Time       `gorm:"not null;index" json:"expires_at"` // Pushed back on every change
+	Items     []GuestCartItem `gorm:"foreignKey:GuestCartID;constraint:OnDelete:CASCADE" json:"items"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func (g *GuestCart) BeforeCreate(tx *gorm.DB) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return nil
+}
+
+// GuestCartItem is a line in a guest cart. Like CartItem, every line in a cart
+// shares one store.
+type GuestCartItem struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	GuestCartID uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_guest_cart_product" json:"guest_cart_id"`
+	ProductID   uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_guest_cart_product" json:"product_id"`
+	Product     Product    `gorm:"foreignKey:ProductID" json:"product"`
+	FranchiseID *uuid.UUID `gorm:"type:uuid;index" json:"franchise_id,omitempty"`
+	Quantity    int        `gorm:"default:1" json:"quantity"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func (g *GuestCartItem) BeforeCreate(tx *gorm.DB) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// IdempotencyRecord stores the outcome of a write made with an Idempotency-Key
+// header so a retried request gets the original response instead of running
+// again. CompletedAt is nil while the first request is still in flight.
+type IdempotencyRecord struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_idempotency_user_key" json:"user_id"`
+	IdempotencyKey string     `gorm:"not null;uniqueIndex:idx_idempotency_user_key" json:"idempotency_key"`
+	Method         string     `gorm:"not null" json:"method"`
+	Path           string     `gorm:"not null" json:"path"`
+	RequestHash    string     `gorm:"not null" json:"request_hash"`
+	StatusCode     int        `json:"status_code"`
+	ContentType    string     `json:"content_type"`
+	ResponseBody   string     `gorm:"type:text" json:"response_body"`
+	CompletedAt    *time.Time `json:"completed_at"`
+	CreatedAt      time.Time  `gorm:"index" json:"created_at"`
+}
+
+func (r *IdempotencyRecord) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LoyaltyTier is a level of the loyalty programme, reached by spending at least
+// MinSpend over the last 12 months. Points earned at the tier are multiplied by
+// Multiplier.
+type LoyaltyTier struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name       string    `gorm:"uniqueIndex;not null" json:"name"`
+	MinSpend   float64   `gorm:"not null;default:0" json:"min_spend"`
+	Multiplier float64   `gorm:"not null;default:1" json:"multiplier"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (t *LoyaltyTier) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// LoyaltyCampaign awards BonusPoints for every unit of a product bought while
+// it runs, on top of the points the order earns anyway.
+type LoyaltyCampaign struct {
+	ID          uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name        string         `gorm:"not null" json:"name"`
+	ProductID   uuid.UUID      `gorm:"type:uuid;not null;index" json:"product_id"`
+	BonusPoints int            `gorm:"not null" json:"bonus_points"`
+	StartsAt    *time.Time     `json:"starts_at"`
+	EndsAt      *time.Time     `json:"ends_at"`
+	IsActive    bool           `gorm:"default:true" json:"is_active"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (c *LoyaltyCampaign) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsRunningAt reports whether the campaign awards points at t.
+func (c *LoyaltyCampaign) IsRunningAt(t time.Time) bool {
+	if !c.IsActive {
+		return false
+	}
+	if c.StartsAt != nil && t.Before(*c.StartsAt) {
+		return false
+	}
+	if c.EndsAt != nil && !t.Before(*c.EndsAt) {
+		return false
+	}
+	return true
+}
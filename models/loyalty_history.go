@@ -7,14 +7,17 @@ import (
 	"gorm.io/gorm"
 )
 
+// LoyaltyHistory is the points ledger: one row per movement of a user's points,
+// which User.LoyaltyPoints is the running total of. Write it through the
+// loyalty package, which keeps the two in step.
 type LoyaltyHistory struct {
 	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	UserID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
 	User        User       `gorm:"foreignKey:UserID" json:"-"`
 	Points      int        `gorm:"not null" json:"points"`
-	Type        string     `gorm:"not null" json:"type"` // "earned" or "redeemed"
+	Type        string     `gorm:"not null" json:"type"` // "earned", "redeemed", "reversed", "restored", "expired" or "referral"
 	Description string     `json:"description"`
-	OrderID     *uuid.UUID `gorm:"type:uuid" json:"order_id,omitempty"`
+	OrderID     *uuid.UUID `gorm:"type:uuid;index" json:"order_id,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
 }
 
@@ -19,7 +19,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		`CREATE TABLE IF NOT EXISTS "users" (
 			"id" TEXT PRIMARY KEY, "email" TEXT NOT NULL UNIQUE, "password" TEXT NOT NULL,
 			"name" TEXT, "role" TEXT DEFAULT 'customer', "franchise_id" TEXT,
-			"loyalty_points" INTEGER DEFAULT 0, "phone" TEXT, "is_blocked" INTEGER DEFAULT 0,
+			"loyalty_points" INTEGER DEFAULT 0, "referral_code" TEXT UNIQUE, "phone" TEXT, "is_blocked" INTEGER DEFAULT 0,
 			"created_at" DATETIME, "updated_at" DATETIME, "deleted_at" DATETIME
 		)`,
 		`CREATE TABLE IF NOT EXISTS "categories" (
@@ -379,3 +379,47 @@ func TestGetCurrentPricePromo(t *testing.T) {
 		t.Errorf("expected 5.0, got %f", p.GetCurrentPrice())
 	}
 }
+
+func TestIsValidTransitionForKeepsFulfilmentPathsApart(t *testing.T) {
+	cases := []struct {
+		fulfilment FulfilmentType
+		from, to   OrderStatus
+		want       bool
+	}{
+		{FulfilmentDelivery, OrderStatusPreparing, OrderStatusReady, true},
+		{FulfilmentDelivery, OrderStatusPreparing, OrderStatusReadyForCollection, false},
+		{FulfilmentCollection, OrderStatusPreparing, OrderStatusReadyForCollection, true},
+		{FulfilmentCollection, OrderStatusPreparing, OrderStatusReady, false},
+		{FulfilmentCollection, OrderStatusReadyForCollection, OrderStatusCollected, true},
+		{FulfilmentCollection, OrderStatusReadyForCollection, OrderStatusCancelled, true},
+		{FulfilmentCollection, OrderStatusConfirmed, OrderStatusCollected, false},
+	}
+	for _, tc := range cases {
+		if got := IsValidTransitionFor(tc.fulfilment, tc.from, tc.to); got != tc.want {
+			t.Errorf("%s %s -> %s: expected %v, got %v", tc.fulfilment, tc.from, tc.to, tc.want, got)
+		}
+	}
+}
+
+func TestCanTransitionLimitsCustomersToCancelling(t *testing.T) {
+	cases := []struct {
+		role     string
+		from, to OrderStatus
+		want     bool
+	}{
+		{"customer", OrderStatusPending, OrderStatusCancelled, true},
+		{"customer", OrderStatusConfirmed, OrderStatusCancelled, true},
+		{"customer", OrderStatusPreparing, OrderStatusCancelled, false},
+		{"customer", OrderStatusPending, OrderStatusConfirmed, false},
+		{"customer", OrderStatusOutForDelivery, OrderStatusDelivered, false},
+		{"franchise_staff", OrderStatusOutForDelivery, OrderStatusDelivered, true},
+		{"admin", OrderStatusPreparing, OrderStatusCancelled, true},
+		{"admin", OrderStatusDelivered, OrderStatusCancelled, false},
+		{"unknown", OrderStatusPending, OrderStatusCancelled, false},
+	}
+	for _, tc := range cases {
+		if got := CanTransition(tc.role, FulfilmentDelivery, tc.from, tc.to); got != tc.want {
+			t.Errorf("%s %s -> %s: expected %v, got %v", tc.role, tc.from, tc.to, tc.want, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"math"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,13 +11,35 @@ import (
 type OrderStatus string
 
 const (
-	OrderStatusPending   OrderStatus = "pending"
-	OrderStatusConfirmed OrderStatus = "confirmed"
-	OrderStatusPreparing OrderStatus = "preparing"
-	OrderStatusReady     OrderStatus = "ready"
+	OrderStatusPending        OrderStatus = "pending"
+	OrderStatusConfirmed      OrderStatus = "confirmed"
+	OrderStatusPreparing      OrderStatus = "preparing"
+	OrderStatusReady          OrderStatus = "ready"
 	OrderStatusOutForDelivery OrderStatus = "out_for_delivery"
-	OrderStatusDelivered OrderStatus = "delivered"
-	OrderStatusCancelled OrderStatus = "cancelled"
+	OrderStatusDelivered      OrderStatus = "delivered"
+	OrderStatusCancelled      OrderStatus = "cancelled"
+
+	// Collection orders go from preparing to ready_for_collection to collected
+	OrderStatusReadyForCollection OrderStatus = "ready_for_collection"
+	OrderStatusCollected          OrderStatus = "collected"
+)
+
+// FulfilmentType is how an order reaches the customer.
+type FulfilmentType string
+
+const (
+	FulfilmentDelivery   FulfilmentType = "delivery"
+	FulfilmentCollection FulfilmentType = "collection"
+)
+
+// SubstitutionPreference is what the customer wants done with an item the
+// store runs out of while picking.
+type SubstitutionPreference string
+
+const (
+	SubstitutionAllow    SubstitutionPreference = "allow"    // Any similar product
+	SubstitutionNone     SubstitutionPreference = "none"     // Leave it out and refund it
+	SubstitutionSpecific SubstitutionPreference = "specific" // Only the product the customer chose
 )
 
 type Order struct {
@@ -28,32 +51,55 @@ type Order struct {
 	OrderNumber     string         `gorm:"uniqueIndex;not null" json:"order_number"`
 	Status          OrderStatus    `gorm:"default:pending" json:"status"`
 	Subtotal        float64        `gorm:"not null" json:"subtotal"`
+	Discount        float64        `gorm:"default:0" json:"discount"`
 	DeliveryFee     float64        `gorm:"default:0" json:"delivery_fee"`
 	Total           float64        `gorm:"not null" json:"total"`
+	FulfilmentType  FulfilmentType `gorm:"default:delivery" json:"fulfilment_type"`
 	DeliveryAddress string         `json:"delivery_address"`
+	AddressID       *uuid.UUID     `gorm:"type:uuid;index" json:"address_id,omitempty"` // Address book entry the order was placed to
+	DeliveryNotes   string         `json:"delivery_notes,omitempty"`                    // The address's delivery instructions at the time of order
+	PickupCode      string         `json:"pickup_code,omitempty"`                       // Shown by the customer at collection
 	PaymentMethod   string         `json:"payment_method"`
 	PointsEarned    int            `gorm:"default:0" json:"points_earned"`
+	PointsRedeemed  int            `gorm:"default:0" json:"points_redeemed"`
+	PointsDiscount  float64        `gorm:"default:0" json:"points_discount"`  // Paid with PointsRedeemed, already off Total
+	GiftCardAmount  float64        `gorm:"default:0" json:"gift_card_amount"` // Part of Total paid with gift cards
 	CustomerLat     *float64       `json:"customer_lat,omitempty"`
 	CustomerLng     *float64       `json:"customer_lng,omitempty"`
+	DeliverySlotID  *uuid.UUID     `gorm:"type:uuid;index" json:"delivery_slot_id,omitempty"`
+	DeliverySlot    *DeliverySlot  `gorm:"foreignKey:DeliverySlotID" json:"delivery_slot,omitempty"`
+	CouponID        *uuid.UUID     `gorm:"type:uuid;index" json:"coupon_id,omitempty"`
+	CouponCode      string         `json:"coupon_code,omitempty"` // Snapshot of the code as entered
+	SubscriptionID  *uuid.UUID     `gorm:"type:uuid;index" json:"subscription_id,omitempty"`
 	Items           []OrderItem    `gorm:"foreignKey:OrderID" json:"items"`
+	Payments        []Payment      `gorm:"foreignKey:OrderID" json:"payments,omitempty"`
+	RefundedAmount  float64        `gorm:"default:0" json:"refunded_amount"`
+	Refunds         []Refund       `gorm:"foreignKey:OrderID" json:"refunds,omitempty"`
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 type OrderItem struct {
-	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	OrderID     uuid.UUID `gorm:"type:uuid;not null;index" json:"order_id"`
-	Order       Order     `gorm:"foreignKey:OrderID" json:"-"`
-	ProductID   uuid.UUID `gorm:"type:uuid;not null;index" json:"product_id"`
-	Product     Product   `gorm:"foreignKey:ProductID" json:"product"`
-	ImageURL    string    `json:"image_url"`
-	ProductName string    `json:"product_name"` // Snapshot of product name at time of order
-	ProductSKU  string    `json:"product_sku"`  // Snapshot of product SKU at time of order
-	Quantity    int       `gorm:"not null" json:"quantity"`
-	Price       float64   `gorm:"not null" json:"price"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID                     uuid.UUID              `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	OrderID                uuid.UUID              `gorm:"type:uuid;not null;index" json:"order_id"`
+	Order                  Order                  `gorm:"foreignKey:OrderID" json:"-"`
+	ProductID              uuid.UUID              `gorm:"type:uuid;not null;index" json:"product_id"`
+	Product                Product                `gorm:"foreignKey:ProductID" json:"product"`
+	ImageURL               string                 `json:"image_url"`
+	ProductName            string                 `json:"product_name"` // Snapshot of product name at time of order
+	ProductSKU             string                 `json:"product_sku"`  // Snapshot of product SKU at time of order
+	Quantity               int                    `gorm:"not null" json:"quantity"`
+	Price                  float64                `gorm:"not null" json:"price"`
+	Discount               float64                `gorm:"default:0" json:"discount"` // Off the line total of Price x Quantity
+	RefundedQuantity       int                    `gorm:"default:0" json:"refunded_quantity"`
+	SubstitutionPreference SubstitutionPreference `gorm:"default:allow" json:"substitution_preference"`
+	PreferredSubstituteID  *uuid.UUID             `gorm:"type:uuid" json:"preferred_substitute_id,omitempty"` // The product to pick for a specific preference
+	SubstitutedQuantity    int                    `gorm:"default:0" json:"substituted_quantity"`              // Units replaced by a substitute line
+	SubstituteForID        *uuid.UUID             `gorm:"type:uuid;index" json:"substitute_for_id,omitempty"` // The item this line was picked in place of
+	Discounts              []OrderItemDiscount    `gorm:"foreignKey:OrderItemID" json:"discounts,omitempty"`
+	CreatedAt              time.Time              `json:"created_at"`
+	UpdatedAt              time.Time              `json:"updated_at"`
 }
 
 func (o *Order) BeforeCreate(tx *gorm.DB) error {
@@ -66,17 +112,56 @@ func (o *Order) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// AmountDue is what is left of the total to pay by card or cash once gift
+// cards have been applied.
+func (o *Order) AmountDue() float64 {
+	return math.Round((o.Total-o.GiftCardAmount)*100) / 100
+}
+
+func (oi *OrderItem) BeforeCreate(tx *gorm.DB) error {
+	if oi.ID == uuid.Nil {
+		oi.ID = uuid.New()
+	}
+	return nil
+}
+
 // AllowedTransitions defines the valid order status state machine.
 var AllowedTransitions = map[OrderStatus][]OrderStatus{
 	OrderStatusPending:        {OrderStatusConfirmed, OrderStatusCancelled},
 	OrderStatusConfirmed:      {OrderStatusPreparing, OrderStatusCancelled},
-	OrderStatusPreparing:      {OrderStatusReady, OrderStatusCancelled},
+	OrderStatusPreparing:      {OrderStatusReady, OrderStatusReadyForCollection, OrderStatusCancelled},
 	OrderStatusReady:          {OrderStatusOutForDelivery, OrderStatusCancelled},
 	OrderStatusOutForDelivery: {OrderStatusDelivered, OrderStatusCancelled},
 	OrderStatusDelivered:      {},
 	OrderStatusCancelled:      {},
+
+	OrderStatusReadyForCollection: {OrderStatusCollected, OrderStatusCancelled},
+	OrderStatusCollected:          {},
 }
 
+// customerTransitions are the only status changes customers may make to their
+// own orders: cancelling them before the store starts preparing them.
+var customerTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending:   {OrderStatusCancelled},
+	OrderStatusConfirmed: {OrderStatusCancelled},
+}
+
+// RoleTransitions is the part of the state machine each role may use. Roles
+// not listed may not change an order's status at all.
+var RoleTransitions = map[string]map[OrderStatus][]OrderStatus{
+	"admin":           AllowedTransitions,
+	"franchise_owner": AllowedTransitions,
+	"franchise_staff": AllowedTransitions,
+	"customer":        customerTransitions,
+}
+
+// deliveryOnlyStatuses and collectionOnlyStatuses are the parts of the state
+// machine that apply to one fulfilment type only.
+var (
+	deliveryOnlyStatuses   = []OrderStatus{OrderStatusReady, OrderStatusOutForDelivery, OrderStatusDelivered}
+	collectionOnlyStatuses = []OrderStatus{OrderStatusReadyForCollection, OrderStatusCollected}
+)
+
 // IsValidTransition checks if a status transition is allowed.
 func IsValidTransition(from, to OrderStatus) bool {
 	allowed, exists := AllowedTransitions[from]
@@ -90,3 +175,46 @@ func IsValidTransition(from, to OrderStatus) bool {
 	}
 	return false
 }
+
+// IsValidTransitionFor checks a status transition for an order with the given
+// fulfilment type, keeping delivery orders off the collection path and vice versa.
+func IsValidTransitionFor(fulfilment FulfilmentType, from, to OrderStatus) bool {
+	if !IsValidTransition(from, to) {
+		return false
+	}
+	excluded := collectionOnlyStatuses
+	if fulfilment == FulfilmentCollection {
+		excluded = deliveryOnlyStatuses
+	}
+	for _, s := range excluded {
+		if s == to {
+			return false
+		}
+	}
+	return true
+}
+
+// CanTransition checks a status transition for an order with the given
+// fulfilment type against both the state machine and what role may do.
+func CanTransition(role string, fulfilment FulfilmentType, from, to OrderStatus) bool {
+	if !IsValidTransitionFor(fulfilment, from, to) {
+		return false
+	}
+	for _, s := range RoleTransitions[role][from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAmendable reports whether the customer can still change the order's items
+// or address, which is until the store starts preparing it.
+func (o *Order) IsAmendable() bool {
+	return o.Status == OrderStatusPending || o.Status == OrderStatusConfirmed
+}
+
+// IsCompleted reports whether the order has reached the customer.
+func (o *Order) IsCompleted() bool {
+	return o.Status == OrderStatusDelivered || o.Status == OrderStatusCollected
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OrderItemChangeType is what the store did with an item it could not pick.
+type OrderItemChangeType string
+
+const (
+	OrderItemSubstituted OrderItemChangeType = "substituted"
+	OrderItemShortPicked OrderItemChangeType = "short_picked"
+)
+
+// OrderItemChange records an item substituted or left out while an order was
+// being prepared, so the customer can see what changed and what was refunded.
+// PreviousPrice is what each unit was paid at; Price is what each substitute
+// unit costs, and is zero for a short pick.
+type OrderItemChange struct {
+	ID               uuid.UUID           `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	OrderID          uuid.UUID           `gorm:"type:uuid;not null;index" json:"order_id"`
+	OrderItemID      uuid.UUID           `gorm:"type:uuid;not null;index" json:"order_item_id"`
+	Type             OrderItemChangeType `gorm:"not null" json:"type"`
+	ProductName      string              `json:"product_name"`
+	Quantity         int                 `gorm:"not null" json:"quantity"`
+	SubstituteItemID *uuid.UUID          `gorm:"type:uuid" json:"substitute_item_id,omitempty"`
+	SubstituteName   string              `json:"substitute_name,omitempty"`
+	PreviousPrice    float64             `json:"previous_price"`
+	Price            float64             `json:"price"`
+	RefundID         *uuid.UUID          `gorm:"type:uuid" json:"refund_id,omitempty"`
+	RefundAmount     float64             `gorm:"default:0" json:"refund_amount"`
+	ActorID          *uuid.UUID          `gorm:"type:uuid" json:"actor_id,omitempty"`
+	CreatedAt        time.Time           `json:"created_at"`
+}
+
+func (c *OrderItemChange) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OrderStatusEvent is one entry in an order's status history. FromStatus is
+// empty for the event recorded when the order is placed; ActorID is nil when
+// the change was made by the system, e.g. a payment webhook.
+type OrderStatusEvent struct {
+	ID         uuid.UUID   `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	OrderID    uuid.UUID   `gorm:"type:uuid;not null;index" json:"order_id"`
+	FromStatus OrderStatus `json:"from_status"`
+	ToStatus   OrderStatus `gorm:"not null" json:"to_status"`
+	ActorID    *uuid.UUID  `gorm:"type:uuid" json:"actor_id,omitempty"`
+	ActorRole  string      `json:"actor_role"`
+	Note       string      `json:"note,omitempty"`
+	CreatedAt  time.Time   `gorm:"index" json:"created_at"`
+}
+
+func (e *OrderStatusEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
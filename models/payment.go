@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentStatusAuthorized        PaymentStatus = "authorized"
+	PaymentStatusCaptured          PaymentStatus = "captured"
+	PaymentStatusVoided            PaymentStatus = "voided"
+	PaymentStatusRefunded          PaymentStatus = "refunded"
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentStatusFailed            PaymentStatus = "failed"
+	PaymentStatusDisputed          PaymentStatus = "disputed"
+)
+
+// Payment methods accepted at checkout. Cash is settled on delivery and never
+// goes through the payment provider. Orders paid for entirely with gift cards
+// use the gift card method.
+const (
+	PaymentMethodCard     = "card"
+	PaymentMethodCash     = "cash"
+	PaymentMethodGiftCard = "gift_card"
+)
+
+type Payment struct {
+	ID                uuid.UUID     `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	OrderID           uuid.UUID     `gorm:"type:uuid;not null;index" json:"order_id"`
+	Order             Order         `gorm:"foreignKey:OrderID" json:"-"`
+	Provider          string        `gorm:"not null" json:"provider"`
+	ProviderReference string        `gorm:"index" json:"provider_reference"`
+	Method            string        `json:"method"`
+	Amount            float64       `gorm:"not null" json:"amount"`
+	CapturedAmount    float64       `gorm:"default:0" json:"captured_amount"`
+	RefundedAmount    float64       `gorm:"default:0" json:"refunded_amount"`
+	Currency          string        `gorm:"default:GBP" json:"currency"`
+	Status            PaymentStatus `gorm:"not null;index" json:"status"`
+	FailureReason     string        `json:"failure_reason,omitempty"`
+	CreatedAt         time.Time     `json:"created_at"`
+	UpdatedAt         time.Time     `json:"updated_at"`
+}
+
+func (p *Payment) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
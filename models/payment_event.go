@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Outcomes recorded against a payment webhook event.
+const (
+	PaymentEventProcessed = "processed"
+	PaymentEventIgnored   = "ignored"
+)
+
+// PaymentEvent records every webhook received from the payment provider.
+// EventID is unique so redelivered events are only processed once.
+type PaymentEvent struct {
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	EventID           string     `gorm:"uniqueIndex;not null" json:"event_id"`
+	Type              string     `gorm:"not null" json:"type"`
+	ProviderReference string     `gorm:"index" json:"provider_reference"`
+	OrderID           *uuid.UUID `gorm:"type:uuid;index" json:"order_id,omitempty"`
+	Payload           string     `gorm:"type:text" json:"payload"`
+	Outcome           string     `json:"outcome"` // processed, ignored
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+func (e *PaymentEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PricingRuleType is the kind of deal a pricing rule gives.
+type PricingRuleType string
+
+const (
+	// PricingRuleMultiBuy sells Quantity units of a product for BundlePrice ("3 for £5")
+	PricingRuleMultiBuy PricingRuleType = "multi_buy"
+	// PricingRuleBOGO gives FreeQuantity units of a product free for every Quantity bought
+	PricingRuleBOGO PricingRuleType = "bogo"
+	// PricingRuleMixAndMatch sells any Quantity units across the covered products for BundlePrice
+	PricingRuleMixAndMatch PricingRuleType = "mix_and_match"
+)
+
+// PricingRule is a basket deal evaluated over the whole cart at checkout. It
+// covers a single product, a category or a subcategory; exactly one of
+// ProductID, CategoryID and SubcategoryID is set. Rules with a FranchiseID
+// only apply at that franchise. Rules are tried highest Priority first and a
+// unit counts towards at most one deal.
+type PricingRule struct {
+	ID            uuid.UUID       `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name          string          `gorm:"not null" json:"name"` // Shown to customers, e.g. "3 for £5"
+	Type          PricingRuleType `gorm:"not null" json:"type"`
+	ProductID     *uuid.UUID      `gorm:"type:uuid;index" json:"product_id,omitempty"`
+	CategoryID    *uuid.UUID      `gorm:"type:uuid;index" json:"category_id,omitempty"`
+	SubcategoryID *uuid.UUID      `gorm:"type:uuid;index" json:"subcategory_id,omitempty"`
+	Quantity      int             `gorm:"not null" json:"quantity"`
+	FreeQuantity  int             `gorm:"default:0" json:"free_quantity"`
+	BundlePrice   float64         `gorm:"default:0" json:"bundle_price"`
+	FranchiseID   *uuid.UUID      `gorm:"type:uuid;index" json:"franchise_id,omitempty"`
+	Priority      int             `gorm:"default:0" json:"priority"`
+	IsActive      bool            `gorm:"default:true" json:"is_active"`
+	StartsAt      *time.Time      `json:"starts_at"`
+	EndsAt        *time.Time      `json:"ends_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt  `gorm:"index" json:"-"`
+}
+
+// Covers reports whether the rule applies to a product.
+func (r *PricingRule) Covers(p Product) bool {
+	switch {
+	case r.ProductID != nil:
+		return *r.ProductID == p.ID
+	case r.CategoryID != nil:
+		return *r.CategoryID == p.CategoryID
+	case r.SubcategoryID != nil:
+		return p.SubcategoryID != nil && *r.SubcategoryID == *p.SubcategoryID
+	}
+	return false
+}
+
+func (r *PricingRule) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// OrderItemDiscount is one discount applied to an order item, from either a
+// pricing rule or a coupon. An item's Discount is the sum of these.
+type OrderItemDiscount struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	OrderItemID   uuid.UUID  `gorm:"type:uuid;not null;index" json:"order_item_id"`
+	PricingRuleID *uuid.UUID `gorm:"type:uuid" json:"pricing_rule_id,omitempty"`
+	CouponID      *uuid.UUID `gorm:"type:uuid" json:"coupon_id,omitempty"`
+	Description   string     `json:"description"`
+	Amount        float64    `gorm:"not null" json:"amount"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func (d *OrderItemDiscount) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
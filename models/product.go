@@ -86,24 +86,27 @@ func (p *Product) BeforeCreate(tx *gorm.DB) error {
 
 // IsPromotionActive returns true if a promotion is currently active
 func (p *Product) IsPromotionActive() bool {
+	return p.IsPromotionActiveAt(time.Now())
+}
+
+// IsPromotionActiveAt returns true if the promotion is running at t
+func (p *Product) IsPromotionActiveAt(t time.Time) bool {
 	if p.PromotionPrice == nil {
 		return false
 	}
 
-	now := time.Now()
-
 	// If no dates set, promotion is inactive
 	if p.PromotionStart == nil && p.PromotionEnd == nil {
 		return false
 	}
 
 	// Check start date
-	if p.PromotionStart != nil && now.Before(*p.PromotionStart) {
+	if p.PromotionStart != nil && t.Before(*p.PromotionStart) {
 		return false
 	}
 
 	// Check end date
-	if p.PromotionEnd != nil && now.After(*p.PromotionEnd) {
+	if p.PromotionEnd != nil && t.After(*p.PromotionEnd) {
 		return false
 	}
 
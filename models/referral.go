@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ReferralStatus string
+
+const (
+	ReferralStatusPending  ReferralStatus = "pending"
+	ReferralStatusRewarded ReferralStatus = "rewarded"
+	ReferralStatusRejected ReferralStatus = "rejected"
+)
+
+// Referral records a customer who signed up with another customer's referral
+// code. It stays pending until the referee's first order is completed, when
+// both are rewarded, unless a fraud check rejects it first.
+type Referral struct {
+	ID             uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ReferrerID     uuid.UUID      `gorm:"type:uuid;not null;index" json:"referrer_id"`
+	Referrer       User           `gorm:"foreignKey:ReferrerID" json:"referrer,omitempty"`
+	RefereeID      uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex" json:"referee_id"`
+	Referee        User           `gorm:"foreignKey:RefereeID" json:"referee,omitempty"`
+	Code           string         `gorm:"not null" json:"code"`
+	Status         ReferralStatus `gorm:"not null;default:pending;index" json:"status"`
+	RejectReason   string         `json:"reject_reason,omitempty"`
+	OrderID        *uuid.UUID     `gorm:"type:uuid" json:"order_id,omitempty"` // order that earned the reward
+	ReferrerPoints int            `gorm:"default:0" json:"referrer_points"`
+	RefereePoints  int            `gorm:"default:0" json:"referee_points"`
+	RewardedAt     *time.Time     `json:"rewarded_at,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+func (r *Referral) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Refund records money returned to the customer for an order, either for
+// specific items (which are restocked) or as a plain amount such as a goodwill
+// gesture or a refunded delivery fee.
+type Refund struct {
+	ID             uuid.UUID    `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	OrderID        uuid.UUID    `gorm:"type:uuid;not null;index" json:"order_id"`
+	Order          Order        `gorm:"foreignKey:OrderID" json:"-"`
+	Amount         float64      `gorm:"not null" json:"amount"`
+	Reason         string       `json:"reason"`
+	PointsReversed int          `gorm:"default:0" json:"points_reversed"`
+	GiftCardAmount float64      `gorm:"default:0" json:"gift_card_amount"` // Part of Amount returned to gift cards
+	CreatedByID    uuid.UUID    `gorm:"type:uuid;not null" json:"created_by_id"`
+	Items          []RefundItem `gorm:"foreignKey:RefundID" json:"items,omitempty"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+type RefundItem struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	RefundID    uuid.UUID `gorm:"type:uuid;not null;index" json:"refund_id"`
+	OrderItemID uuid.UUID `gorm:"type:uuid;not null;index" json:"order_item_id"`
+	ProductID   uuid.UUID `gorm:"type:uuid;not null" json:"product_id"`
+	Quantity    int       `gorm:"not null" json:"quantity"`
+	Amount      float64   `gorm:"not null" json:"amount"`
+}
+
+func (r *Refund) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (ri *RefundItem) BeforeCreate(tx *gorm.DB) error {
+	if ri.ID == uuid.Nil {
+		ri.ID = uuid.New()
+	}
+	return nil
+}
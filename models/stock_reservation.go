@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// StockReservation holds stock for a customer part way through checkout. The
+// quantity is taken out of the franchise's FranchiseProduct stock, or the
+// master Product stock when FranchiseProductID is nil, for as long as the
+// reservation lives. Placing the order keeps the decrement; expiry gives the
+// stock back.
+type StockReservation struct {
+	ID                 uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID             uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	ProductID          uuid.UUID  `gorm:"type:uuid;not null;index" json:"product_id"`
+	FranchiseID        *uuid.UUID `gorm:"type:uuid" json:"franchise_id,omitempty"`
+	FranchiseProductID *uuid.UUID `gorm:"type:uuid" json:"franchise_product_id,omitempty"`
+	Quantity           int        `gorm:"not null" json:"quantity"`
+	ExpiresAt          time.Time  `gorm:"not null;index" json:"expires_at"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+func (r *StockReservation) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
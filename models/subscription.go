@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SubscriptionFrequency is how often a subscription places an order.
+type SubscriptionFrequency string
+
+const (
+	SubscriptionWeekly      SubscriptionFrequency = "weekly"
+	SubscriptionFortnightly SubscriptionFrequency = "fortnightly"
+)
+
+// Days returns the number of days between orders, or 0 for an unknown frequency.
+func (f SubscriptionFrequency) Days() int {
+	switch f {
+	case SubscriptionWeekly:
+		return 7
+	case SubscriptionFortnightly:
+		return 14
+	}
+	return 0
+}
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionActive    SubscriptionStatus = "active"
+	SubscriptionPaused    SubscriptionStatus = "paused"
+	SubscriptionCancelled SubscriptionStatus = "cancelled"
+)
+
+// Subscription places an order for a saved basket on a schedule, with the
+// delivery and payment settings it was set up with.
+type Subscription struct {
+	ID             uuid.UUID             `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID         uuid.UUID             `gorm:"type:uuid;not null;index" json:"user_id"`
+	FranchiseID    *uuid.UUID            `gorm:"type:uuid;index" json:"franchise_id,omitempty"`
+	Frequency      SubscriptionFrequency `gorm:"not null" json:"frequency"`
+	Status         SubscriptionStatus    `gorm:"default:active;index" json:"status"`
+	FulfilmentType FulfilmentType        `gorm:"default:delivery" json:"fulfilment_type"`
+	AddressID      *uuid.UUID            `gorm:"type:uuid" json:"address_id,omitempty"`
+	PaymentMethod  string                `json:"payment_method"`
+	SavedMethod    string                `json:"-"` // Reusable provider reference; checkout tokens can only be charged once
+	NextOrderAt    time.Time             `gorm:"not null;index" json:"next_order_at"`
+	LastOrderID    *uuid.UUID            `gorm:"type:uuid" json:"last_order_id,omitempty"`
+	LastError      string                `json:"last_error,omitempty"` // Why the last scheduled order was not placed
+	Items          []SubscriptionItem    `gorm:"foreignKey:SubscriptionID;constraint:OnDelete:CASCADE" json:"items"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+}
+
+func (s *Subscription) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// SubscriptionItem is a line in a subscription's basket.
+type SubscriptionItem struct {
+	ID             uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	SubscriptionID uuid.UUID `gorm:"type:uuid;not null;index" json:"subscription_id"`
+	ProductID      uuid.UUID `gorm:"type:uuid;not null" json:"product_id"`
+	Product        Product   `gorm:"foreignKey:ProductID" json:"product"`
+	Quantity       int       `gorm:"not null" json:"quantity"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (s *SubscriptionItem) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
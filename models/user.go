@@ -15,6 +15,7 @@ type User struct {
 	Role                  string    `gorm:"default:customer" json:"role"` // customer, franchise_owner, franchise_staff, admin
 	FranchiseID           *uuid.UUID `gorm:"type:uuid;index" json:"franchise_id,omitempty"`
 	LoyaltyPoints         int        `gorm:"default:0" json:"loyalty_points"`
+	ReferralCode          *string    `gorm:"type:varchar(16);uniqueIndex" json:"referral_code,omitempty"`
 	Phone                 string `json:"phone"`
 	IsBlocked             bool   `gorm:"default:false" json:"is_blocked"`
 	CreatedAt    time.Time `json:"created_at"`
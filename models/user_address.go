@@ -0,0 +1,47 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserAddress is a saved delivery address in a customer's address book. Its
+// coordinates pick the franchise that delivers an order placed to it. A
+// customer has at most one default address, used when an order names none.
+type UserAddress struct {
+	ID                   uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID               uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	Label                string         `json:"label"` // e.g. "Home", "Work"
+	Line1                string         `gorm:"not null" json:"line1"`
+	Line2                string         `json:"line2"`
+	City                 string         `json:"city"`
+	Postcode             string         `gorm:"not null" json:"postcode"`
+	Latitude             float64        `gorm:"not null" json:"latitude"`
+	Longitude            float64        `gorm:"not null" json:"longitude"`
+	IsDefault            bool           `gorm:"default:false" json:"is_default"`
+	DeliveryInstructions string         `json:"delivery_instructions"`
+	CreatedAt            time.Time      `json:"created_at"`
+	UpdatedAt            time.Time      `json:"updated_at"`
+	DeletedAt            gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (a *UserAddress) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// String formats the address on one line, as recorded on an order.
+func (a UserAddress) String() string {
+	var parts []string
+	for _, part := range []string{a.Line1, a.Line2, a.City, a.Postcode} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
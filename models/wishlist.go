@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WishlistItem is a product a customer has parked for later, either from the
+// catalogue or by saving a cart line for later. FranchiseID is the store it was
+// saved from, and the one whose restock triggers a back-in-stock notification.
+type WishlistItem struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_wishlist_user_product" json:"user_id"`
+	User        User       `gorm:"foreignKey:UserID" json:"-"`
+	ProductID   uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_wishlist_user_product;index" json:"product_id"`
+	Product     Product    `gorm:"foreignKey:ProductID" json:"product"`
+	FranchiseID *uuid.UUID `gorm:"type:uuid;index" json:"franchise_id,omitempty"`
+	Quantity    int        `gorm:"default:1" json:"quantity"`
+	NotifiedAt  *time.Time `json:"notified_at,omitempty"` // Last back-in-stock notification
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func (w *WishlistItem) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package payments
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// DeclineToken makes FakeProvider reject an authorization, mimicking a declined card.
+const DeclineToken = "tok_decline"
+
+type fakeCharge struct {
+	authorized float64
+	captured   float64
+	refunded   float64
+	voided     bool
+}
+
+// FakeProvider is an in-process PaymentProvider that keeps charges in memory.
+// It is used for tests and for local development where no gateway is configured.
+type FakeProvider struct {
+	mu      sync.Mutex
+	charges map[string]*fakeCharge
+	methods map[string]bool
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{charges: make(map[string]*fakeCharge), methods: make(map[string]bool)}
+}
+
+func (f *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *FakeProvider) Authorize(req AuthorizeRequest) (*Result, error) {
+	if req.PaymentToken == DeclineToken {
+		return nil, ErrDeclined
+	}
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("invalid amount %.2f", req.Amount)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if req.SavedMethod != "" && !f.methods[req.SavedMethod] {
+		return nil, fmt.Errorf("unknown saved payment method %q", req.SavedMethod)
+	}
+	ref := "fake_" + uuid.New().String()
+	f.charges[ref] = &fakeCharge{authorized: req.Amount}
+	return &Result{Reference: ref, Amount: req.Amount}, nil
+}
+
+func (f *FakeProvider) Capture(reference string, amount float64) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	charge, ok := f.charges[reference]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment reference %s", reference)
+	}
+	if charge.voided {
+		return nil, fmt.Errorf("payment %s has been voided", reference)
+	}
+	if amount > charge.authorized-charge.captured+0.001 {
+		return nil, fmt.Errorf("capture of %.2f exceeds authorized amount", amount)
+	}
+	charge.captured += amount
+	return &Result{Reference: reference, Amount: amount}, nil
+}
+
+func (f *FakeProvider) Void(reference string) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	charge, ok := f.charges[reference]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment reference %s", reference)
+	}
+	if charge.captured > 0 {
+		return nil, fmt.Errorf("payment %s has already been captured", reference)
+	}
+	charge.voided = true
+	return &Result{Reference: reference, Amount: charge.authorized}, nil
+}
+
+func (f *FakeProvider) Refund(reference string, amount float64) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	charge, ok := f.charges[reference]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment reference %s", reference)
+	}
+	if amount > charge.captured-charge.refunded+0.001 {
+		return nil, fmt.Errorf("refund of %.2f exceeds captured amount", amount)
+	}
+	charge.refunded += amount
+	return &Result{Reference: reference, Amount: amount}, nil
+}
+
+func (f *FakeProvider) SavePaymentMethod(paymentToken string) (string, error) {
+	if paymentToken == DeclineToken {
+		return "", ErrDeclined
+	}
+	if paymentToken == "" {
+		return "", fmt.Errorf("missing payment token")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ref := "pm_fake_" + uuid.New().String()
+	f.methods[ref] = true
+	return ref, nil
+}
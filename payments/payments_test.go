@@ -0,0 +1,210 @@
+package payments
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFakeProviderAuthorizeAndCapture(t *testing.T) {
+	p := NewFakeProvider()
+
+	res, err := p.Authorize(AuthorizeRequest{OrderID: "o1", Amount: 25.50, Currency: "GBP", PaymentToken: "tok_visa"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Reference == "" {
+		t.Fatal("expected a provider reference")
+	}
+
+	if _, err := p.Capture(res.Reference, 25.50); err != nil {
+		t.Fatalf("capture failed: %v", err)
+	}
+}
+
+func TestFakeProviderDeclineToken(t *testing.T) {
+	p := NewFakeProvider()
+
+	_, err := p.Authorize(AuthorizeRequest{OrderID: "o1", Amount: 10, PaymentToken: DeclineToken})
+	if !errors.Is(err, ErrDeclined) {
+		t.Fatalf("expected ErrDeclined, got %v", err)
+	}
+}
+
+func TestFakeProviderSavedMethodCanBeChargedAgain(t *testing.T) {
+	p := NewFakeProvider()
+
+	if _, err := p.SavePaymentMethod(DeclineToken); !errors.Is(err, ErrDeclined) {
+		t.Fatalf("expected ErrDeclined saving a declined card, got %v", err)
+	}
+	method, err := p.SavePaymentMethod("tok_visa")
+	if err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := p.Authorize(AuthorizeRequest{OrderID: "o1", Amount: 10, SavedMethod: method}); err != nil {
+			t.Fatalf("authorization %d against saved method failed: %v", i, err)
+		}
+	}
+	if _, err := p.Authorize(AuthorizeRequest{OrderID: "o1", Amount: 10, SavedMethod: "pm_unknown"}); err == nil {
+		t.Fatal("expected error for an unknown saved method")
+	}
+}
+
+func TestFakeProviderRejectsInvalidAmount(t *testing.T) {
+	p := NewFakeProvider()
+
+	if _, err := p.Authorize(AuthorizeRequest{OrderID: "o1", Amount: 0}); err == nil {
+		t.Fatal("expected error for zero amount")
+	}
+}
+
+func TestFakeProviderCaptureExceedsAuthorized(t *testing.T) {
+	p := NewFakeProvider()
+	res, _ := p.Authorize(AuthorizeRequest{OrderID: "o1", Amount: 10})
+
+	if _, err := p.Capture(res.Reference, 12); err == nil {
+		t.Fatal("expected error when capturing more than authorized")
+	}
+}
+
+func TestFakeProviderVoidThenCaptureFails(t *testing.T) {
+	p := NewFakeProvider()
+	res, _ := p.Authorize(AuthorizeRequest{OrderID: "o1", Amount: 10})
+
+	if _, err := p.Void(res.Reference); err != nil {
+		t.Fatalf("void failed: %v", err)
+	}
+	if _, err := p.Capture(res.Reference, 10); err == nil {
+		t.Fatal("expected capture of voided payment to fail")
+	}
+}
+
+func TestFakeProviderVoidAfterCaptureFails(t *testing.T) {
+	p := NewFakeProvider()
+	res, _ := p.Authorize(AuthorizeRequest{OrderID: "o1", Amount: 10})
+	p.Capture(res.Reference, 10)
+
+	if _, err := p.Void(res.Reference); err == nil {
+		t.Fatal("expected void of captured payment to fail")
+	}
+}
+
+func TestFakeProviderRefund(t *testing.T) {
+	p := NewFakeProvider()
+	res, _ := p.Authorize(AuthorizeRequest{OrderID: "o1", Amount: 10})
+
+	if _, err := p.Refund(res.Reference, 5); err == nil {
+		t.Fatal("expected refund before capture to fail")
+	}
+
+	p.Capture(res.Reference, 10)
+	if _, err := p.Refund(res.Reference, 4); err != nil {
+		t.Fatalf("partial refund failed: %v", err)
+	}
+	if _, err := p.Refund(res.Reference, 6); err != nil {
+		t.Fatalf("remaining refund failed: %v", err)
+	}
+	if _, err := p.Refund(res.Reference, 0.5); err == nil {
+		t.Fatal("expected over-refund to fail")
+	}
+}
+
+func TestFakeProviderUnknownReference(t *testing.T) {
+	p := NewFakeProvider()
+
+	if _, err := p.Capture("nope", 1); err == nil {
+		t.Error("expected capture error for unknown reference")
+	}
+	if _, err := p.Void("nope"); err == nil {
+		t.Error("expected void error for unknown reference")
+	}
+	if _, err := p.Refund("nope", 1); err == nil {
+		t.Error("expected refund error for unknown reference")
+	}
+}
+
+func TestNewProviderRequiresExplicitChoice(t *testing.T) {
+	defer os.Unsetenv("PAYMENT_PROVIDER")
+
+	os.Setenv("PAYMENT_PROVIDER", "fake")
+	if p, err := NewProvider(); err != nil || p.Name() != "fake" {
+		t.Errorf("expected fake provider, got %v, %v", p, err)
+	}
+
+	for _, name := range []string{"", "strpie"} {
+		os.Setenv("PAYMENT_PROVIDER", name)
+		if _, err := NewProvider(); err == nil {
+			t.Errorf("expected an error for PAYMENT_PROVIDER %q", name)
+		}
+	}
+}
+
+func TestCurrencyDefault(t *testing.T) {
+	os.Unsetenv("PAYMENT_CURRENCY")
+	if Currency() != "GBP" {
+		t.Errorf("expected GBP, got %s", Currency())
+	}
+
+	os.Setenv("PAYMENT_CURRENCY", "EUR")
+	defer os.Unsetenv("PAYMENT_CURRENCY")
+	if Currency() != "EUR" {
+		t.Errorf("expected EUR, got %s", Currency())
+	}
+}
+
+func TestVerifyWebhookValidSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"evt_1","type":"payment.succeeded"}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := SignWebhook("whsec", ts, body)
+
+	if err := VerifyWebhook("whsec", ts, sig, body, now); err != nil {
+		t.Fatalf("expected valid signature, got %v", err)
+	}
+}
+
+func TestVerifyWebhookRejectsTamperedBody(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := SignWebhook("whsec", ts, []byte(`{"amount":10}`))
+
+	err := VerifyWebhook("whsec", ts, sig, []byte(`{"amount":1000}`), now)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestVerifyWebhookRejectsWrongSecret(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	err := VerifyWebhook("whsec", ts, SignWebhook("other", ts, body), body, now)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestVerifyWebhookRejectsStaleTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{}`)
+
+	for _, sent := range []time.Time{now.Add(-WebhookTolerance - time.Second), now.Add(WebhookTolerance + time.Second)} {
+		ts := strconv.FormatInt(sent.Unix(), 10)
+		err := VerifyWebhook("whsec", ts, SignWebhook("whsec", ts, body), body, now)
+		if !errors.Is(err, ErrStaleTimestamp) {
+			t.Errorf("expected ErrStaleTimestamp for %s, got %v", ts, err)
+		}
+	}
+}
+
+func TestVerifyWebhookRejectsMalformedTimestamp(t *testing.T) {
+	body := []byte(`{}`)
+	err := VerifyWebhook("whsec", "yesterday", SignWebhook("whsec", "yesterday", body), body, time.Now())
+	if !errors.Is(err, ErrStaleTimestamp) {
+		t.Fatalf("expected ErrStaleTimestamp, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// ErrDeclined is returned by a provider when the card issuer refuses the charge.
+var ErrDeclined = errors.New("payment declined")
+
+// AuthorizeRequest describes the hold placed on a customer's payment method at checkout.
+type AuthorizeRequest struct {
+	OrderID      string
+	Amount       float64
+	Currency     string
+	PaymentToken string // Opaque token produced by the provider's client-side SDK
+	SavedMethod  string // Reusable reference from SavePaymentMethod, charged when there is no token
+}
+
+// Result is the provider's view of a payment after an operation.
+type Result struct {
+	Reference string  // Provider-side identifier used for all follow-up calls
+	Amount    float64 // Amount affected by the operation
+}
+
+// PaymentProvider abstracts a card payment gateway for dependency injection and testing.
+type PaymentProvider interface {
+	Name() string
+	Authorize(req AuthorizeRequest) (*Result, error)
+	Capture(reference string, amount float64) (*Result, error)
+	Void(reference string) (*Result, error)
+	Refund(reference string, amount float64) (*Result, error)
+	// SavePaymentMethod exchanges a one-time token for a reusable reference
+	// that can be authorized again later without the customer present.
+	SavePaymentMethod(paymentToken string) (string, error)
+}
+
+// NewProvider returns the provider selected by PAYMENT_PROVIDER. Only the
+// in-process fake is bundled, and it must be asked for explicitly so a missing
+// or misspelled setting cannot confirm orders without taking any money.
+func NewProvider() (PaymentProvider, error) {
+	switch name := os.Getenv("PAYMENT_PROVIDER"); name {
+	case "fake":
+		log.Println("WARNING: PAYMENT_PROVIDER is fake - using in-process fake provider, no real charges will be made")
+		return NewFakeProvider(), nil
+	case "":
+		return nil, errors.New("PAYMENT_PROVIDER not set")
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q", name)
+	}
+}
+
+// Currency returns the ISO currency code used for all charges.
+func Currency() string {
+	if currency := os.Getenv("PAYMENT_CURRENCY"); currency != "" {
+		return currency
+	}
+	return "GBP"
+}
@@ -0,0 +1,188 @@
+package payments
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuthorizeOrder places a hold for what the order leaves to pay after gift
+// cards with the provider and records the resulting Payment. Pass the order
+// transaction so a failed insert rolls back together with the order; the
+// caller is responsible for voiding the returned payment if that transaction
+// later fails to commit. The hold is placed on paymentToken, or on
+// savedMethod from SavePaymentMethod when the customer is not at checkout.
+func AuthorizeOrder(tx *gorm.DB, provider PaymentProvider, order *models.Order, paymentToken, savedMethod string) (*models.Payment, error) {
+	result, err := provider.Authorize(AuthorizeRequest{
+		OrderID:      order.ID.String(),
+		Amount:       order.AmountDue(),
+		Currency:     Currency(),
+		PaymentToken: paymentToken,
+		SavedMethod:  savedMethod,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	payment := models.Payment{
+		OrderID:           order.ID,
+		Provider:          provider.Name(),
+		ProviderReference: result.Reference,
+		Method:            models.PaymentMethodCard,
+		Amount:            order.AmountDue(),
+		Currency:          Currency(),
+		Status:            models.PaymentStatusAuthorized,
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		if _, voidErr := provider.Void(result.Reference); voidErr != nil {
+			log.Printf("CRITICAL: failed to void payment %s for order %s that was not recorded: %v", result.Reference, order.ID, voidErr)
+		}
+		return nil, fmt.Errorf("failed to record payment: %w", err)
+	}
+	return &payment, nil
+}
+
+// CaptureOrder captures every authorized payment on the order.
+func CaptureOrder(db *gorm.DB, provider PaymentProvider, orderID uuid.UUID) error {
+	var payments []models.Payment
+	if err := db.Where("order_id = ? AND status = ?", orderID, models.PaymentStatusAuthorized).Find(&payments).Error; err != nil {
+		return err
+	}
+
+	for i := range payments {
+		p := &payments[i]
+		if _, err := provider.Capture(p.ProviderReference, p.Amount); err != nil {
+			return fmt.Errorf("failed to capture payment %s: %w", p.ID, err)
+		}
+		p.CapturedAmount = p.Amount
+		p.Status = models.PaymentStatusCaptured
+		if err := db.Save(p).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseOrder returns all money held for a cancelled order: authorized payments
+// are voided and captured payments are refunded in full.
+func ReleaseOrder(db *gorm.DB, provider PaymentProvider, orderID uuid.UUID) error {
+	var payments []models.Payment
+	if err := db.Where("order_id = ? AND status IN ?", orderID, []models.PaymentStatus{
+		models.PaymentStatusAuthorized,
+		models.PaymentStatusCaptured,
+		models.PaymentStatusPartiallyRefunded,
+	}).Find(&payments).Error; err != nil {
+		return err
+	}
+
+	for i := range payments {
+		p := &payments[i]
+		if p.Status == models.PaymentStatusAuthorized {
+			if _, err := provider.Void(p.ProviderReference); err != nil {
+				return fmt.Errorf("failed to void payment %s: %w", p.ID, err)
+			}
+			p.Status = models.PaymentStatusVoided
+		} else {
+			remaining := p.CapturedAmount - p.RefundedAmount
+			if remaining > 0 {
+				if _, err := provider.Refund(p.ProviderReference, remaining); err != nil {
+					return fmt.Errorf("failed to refund payment %s: %w", p.ID, err)
+				}
+			}
+			p.RefundedAmount = p.CapturedAmount
+			p.Status = models.PaymentStatusRefunded
+		}
+		if err := db.Save(p).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SettleForStatus moves money in step with an order status change: payments are
+// captured once the order leaves the store and released when it is cancelled.
+// A nil provider means payments are not configured and nothing is done.
+func SettleForStatus(db *gorm.DB, provider PaymentProvider, orderID uuid.UUID, status models.OrderStatus) error {
+	if provider == nil {
+		return nil
+	}
+	switch status {
+	case models.OrderStatusOutForDelivery, models.OrderStatusCollected:
+		return CaptureOrder(db, provider, orderID)
+	case models.OrderStatusCancelled:
+		return ReleaseOrder(db, provider, orderID)
+	}
+	return nil
+}
+
+// RefundOrder returns amount to the customer across the order's payments.
+// Captured money is refunded through the provider; money that is still only
+// authorized is released by lowering the amount that will later be captured,
+// or voided outright once nothing is left to capture. It returns the amount
+// that could not be placed on any card payment, e.g. for cash orders, which
+// the caller settles outside the provider.
+func RefundOrder(db *gorm.DB, provider PaymentProvider, orderID uuid.UUID, amount float64) (float64, error) {
+	if provider == nil {
+		return amount, nil
+	}
+
+	var payments []models.Payment
+	if err := db.Where("order_id = ? AND status IN ?", orderID, []models.PaymentStatus{
+		models.PaymentStatusAuthorized,
+		models.PaymentStatusCaptured,
+		models.PaymentStatusPartiallyRefunded,
+	}).Order("created_at ASC").Find(&payments).Error; err != nil {
+		return amount, err
+	}
+
+	remaining := amount
+	for i := range payments {
+		if remaining <= 0.001 {
+			break
+		}
+		p := &payments[i]
+
+		if p.Status == models.PaymentStatusAuthorized {
+			portion := math.Min(remaining, p.Amount)
+			if p.Amount-portion <= 0.001 {
+				if _, err := provider.Void(p.ProviderReference); err != nil {
+					return remaining, fmt.Errorf("failed to void payment %s: %w", p.ID, err)
+				}
+				p.Status = models.PaymentStatusVoided
+			} else {
+				p.Amount -= portion
+			}
+			remaining -= portion
+		} else {
+			available := p.CapturedAmount - p.RefundedAmount
+			portion := math.Min(remaining, available)
+			if portion <= 0 {
+				continue
+			}
+			if _, err := provider.Refund(p.ProviderReference, portion); err != nil {
+				return remaining, fmt.Errorf("failed to refund payment %s: %w", p.ID, err)
+			}
+			p.RefundedAmount += portion
+			if p.CapturedAmount-p.RefundedAmount <= 0.001 {
+				p.Status = models.PaymentStatusRefunded
+			} else {
+				p.Status = models.PaymentStatusPartiallyRefunded
+			}
+			remaining -= portion
+		}
+
+		if err := db.Save(p).Error; err != nil {
+			return remaining, err
+		}
+	}
+
+	if remaining < 0.001 {
+		remaining = 0
+	}
+	return remaining, nil
+}
@@ -0,0 +1,78 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Webhook event types sent by the provider.
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentFailed    = "payment.failed"
+	EventPaymentDisputed  = "payment.disputed"
+)
+
+// Headers carrying the webhook signature and the unix timestamp it was computed over.
+const (
+	SignatureHeader = "X-Payment-Signature"
+	TimestampHeader = "X-Payment-Timestamp"
+)
+
+// WebhookTolerance is how far a webhook timestamp may drift from our clock before
+// the request is treated as a replay.
+const WebhookTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrStaleTimestamp   = errors.New("webhook timestamp outside tolerance")
+)
+
+// WebhookSecret returns the shared secret used to sign webhooks, or "" when webhooks
+// are not configured.
+func WebhookSecret() string {
+	return os.Getenv("PAYMENT_WEBHOOK_SECRET")
+}
+
+// WebhookEvent is the payload the provider posts to the webhook endpoint.
+type WebhookEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Reference string  `json:"reference"`
+		Amount    float64 `json:"amount"`
+		Reason    string  `json:"reason"`
+	} `json:"data"`
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "timestamp.body" keyed by secret.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a webhook body and rejects timestamps
+// outside WebhookTolerance so captured requests cannot be replayed later.
+func VerifyWebhook(secret, timestamp, signature string, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	sent := time.Unix(ts, 0)
+	if now.Sub(sent) > WebhookTolerance || sent.Sub(now) > WebhookTolerance {
+		return ErrStaleTimestamp
+	}
+
+	expected := SignWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+// Package pricing works out what a basket costs. Checkout quotes and order
+// creation both price through here, so the total a customer is shown is the
+// total they are charged.
+package pricing
+
+import (
+	"math"
+	"time"
+
+	"grabbi-backend/loyalty"
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Delivery charges used when no franchise is serving the order.
+const (
+	DefaultDeliveryFee     = 3.75
+	DefaultFreeDeliveryMin = 20.0
+)
+
+// Line is a product and quantity to be priced.
+type Line struct {
+	Product  models.Product
+	Quantity int
+}
+
+// LineQuote is the priced form of a Line. LineTotal is before discounts;
+// Discount is the sum of Discounts and Tax is the VAT included in what is
+// left to pay.
+type LineQuote struct {
+	ProductID   uuid.UUID      `json:"product_id"`
+	ProductName string         `json:"product_name"`
+	ProductSKU  string         `json:"product_sku"`
+	Quantity    int            `json:"quantity"`
+	UnitPrice   float64        `json:"unit_price"`
+	LineTotal   float64        `json:"line_total"`
+	Discount    float64        `json:"discount"`
+	Discounts   []LineDiscount `json:"discounts,omitempty"`
+	Tax         float64        `json:"tax"`
+}
+
+// LineDiscount is one deal or coupon taken off a line.
+type LineDiscount struct {
+	PricingRuleID *uuid.UUID `json:"pricing_rule_id,omitempty"`
+	CouponID      *uuid.UUID `json:"coupon_id,omitempty"`
+	Description   string     `json:"description"`
+	Amount        float64    `json:"amount"`
+}
+
+// addDiscount takes amount off the line, recording where it came from.
+func (l *LineQuote) addDiscount(d LineDiscount) {
+	d.Amount = RoundMoney(d.Amount)
+	if d.Amount <= 0 {
+		return
+	}
+	l.Discounts = append(l.Discounts, d)
+	l.Discount = RoundMoney(l.Discount + d.Amount)
+}
+
+// Quote is a fully priced basket. Prices include VAT, so Tax is informational
+// and already part of Total.
+type Quote struct {
+	Lines           []LineQuote `json:"lines"`
+	Subtotal        float64     `json:"subtotal"`
+	Discount        float64     `json:"discount"`
+	DeliveryFee     float64     `json:"delivery_fee"`
+	FreeDeliveryMin float64     `json:"free_delivery_min"`
+	Tax             float64     `json:"tax"`
+	Total           float64     `json:"total"`
+	PointsEarned    int         `json:"points_earned"`
+	CouponCode      string      `json:"coupon_code,omitempty"`
+	CouponDiscount  float64     `json:"coupon_discount"` // The part of Discount given by the coupon
+	PointsRedeemed  int         `json:"points_redeemed"`
+	PointsDiscount  float64     `json:"points_discount"` // Paid with PointsRedeemed, already off Total
+}
+
+// Options describe how the basket will be fulfilled.
+type Options struct {
+	// Franchise serving the order; nil prices from the master catalogue
+	Franchise  *models.Franchise
+	Fulfilment models.FulfilmentType
+	// Coupon to apply, already checked as redeemable by the caller
+	Coupon *models.Coupon
+	// Pricing rules to evaluate over the basket; QuoteLines loads the active ones
+	Rules []models.PricingRule
+	// When to price at, for promotion windows; zero means now
+	At time.Time
+	// Loyalty points the customer wants to spend, already checked against
+	// their balance by the caller
+	RedeemPoints int
+	// How points convert to money; QuoteLines loads the configured policy
+	Points loyalty.Policy
+	// The customer's tier and the bonus campaigns on the basket; the zero
+	// value earns one point per whole unit of money
+	Earning loyalty.Earning
+}
+
+// QuoteLines prices lines for the given options, loading the franchise's price
+// overrides for the products involved and the pricing rules in force.
+func QuoteLines(db *gorm.DB, lines []Line, opts Options) (*Quote, error) {
+	if opts.At.IsZero() {
+		opts.At = time.Now()
+	}
+	rules, err := ActiveRules(db, opts.Franchise, opts.At)
+	if err != nil {
+		return nil, err
+	}
+	opts.Rules = rules
+	if opts.RedeemPoints > 0 {
+		opts.Points = loyalty.RedemptionPolicy()
+	}
+
+	overrides := make(map[uuid.UUID]models.FranchiseProduct)
+	if opts.Franchise != nil && len(lines) > 0 {
+		productIDs := make([]uuid.UUID, len(lines))
+		for i, line := range lines {
+			productIDs[i] = line.Product.ID
+		}
+		var fps []models.FranchiseProduct
+		if err := db.Where("franchise_id = ? AND product_id IN ?", opts.Franchise.ID, productIDs).Find(&fps).Error; err != nil {
+			return nil, err
+		}
+		for _, fp := range fps {
+			overrides[fp.ProductID] = fp
+		}
+	}
+
+	quote := Calculate(lines, overrides, opts)
+	return &quote, nil
+}
+
+// Calculate prices lines using the given franchise overrides, keyed by product.
+func Calculate(lines []Line, overrides map[uuid.UUID]models.FranchiseProduct, opts Options) Quote {
+	quote := Quote{Lines: make([]LineQuote, 0, len(lines))}
+	at := opts.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	for _, line := range lines {
+		var fp *models.FranchiseProduct
+		if override, ok := overrides[line.Product.ID]; ok {
+			fp = &override
+		}
+		unitPrice := EffectivePrice(line.Product, fp, at)
+
+		lineTotal := RoundMoney(unitPrice * float64(line.Quantity))
+		quote.Lines = append(quote.Lines, LineQuote{
+			ProductID:   line.Product.ID,
+			ProductName: line.Product.ItemName,
+			ProductSKU:  line.Product.SKU,
+			Quantity:    line.Quantity,
+			UnitPrice:   unitPrice,
+			LineTotal:   lineTotal,
+		})
+		quote.Subtotal += lineTotal
+	}
+
+	quote.Subtotal = RoundMoney(quote.Subtotal)
+
+	// Deals come off first; a coupon then applies to what is left
+	applyPricingRules(&quote, lines, opts.Rules)
+	if opts.Coupon != nil {
+		applyCoupon(&quote, lines, opts.Coupon)
+	}
+	for i := range quote.Lines {
+		line := &quote.Lines[i]
+		line.Tax = IncludedTax(line.LineTotal-line.Discount, lines[i].Product.TaxRate)
+		quote.Discount += line.Discount
+		quote.Tax += line.Tax
+	}
+	quote.Discount = RoundMoney(quote.Discount)
+	quote.Tax = RoundMoney(quote.Tax)
+
+	quote.DeliveryFee, quote.FreeDeliveryMin = DeliveryFee(quote.Subtotal, opts)
+	if opts.Coupon != nil && opts.Coupon.Type == models.CouponFreeDelivery {
+		quote.DeliveryFee = 0
+	}
+	// Points pay for goods only, never delivery. They are part-payment rather
+	// than a discount, so the VAT on the lines is unchanged.
+	quote.PointsRedeemed, quote.PointsDiscount = opts.Points.Redeemable(opts.RedeemPoints, quote.Subtotal-quote.Discount)
+	quote.Total = RoundMoney(quote.Subtotal - quote.Discount - quote.PointsDiscount + quote.DeliveryFee)
+	quantities := make(map[uuid.UUID]int, len(lines))
+	for _, line := range lines {
+		quantities[line.Product.ID] += line.Quantity
+	}
+	quote.PointsEarned = opts.Earning.Points(quote.Subtotal-quote.Discount-quote.PointsDiscount, quantities)
+
+	return quote
+}
+
+// applyCoupon works out a coupon's discount on the lines it applies to and
+// spreads it over them in proportion to what is left to pay on each, so
+// refunds of single items can give back what was actually paid. Lines and
+// quote.Lines are in the same order.
+func applyCoupon(quote *Quote, lines []Line, coupon *models.Coupon) {
+	quote.CouponCode = coupon.Code
+
+	var eligible []int
+	var eligibleTotal float64
+	for i, line := range lines {
+		if coupon.Applies(line.Product.ID, line.Product.CategoryID) {
+			eligible = append(eligible, i)
+			eligibleTotal += quote.Lines[i].LineTotal - quote.Lines[i].Discount
+		}
+	}
+	if eligibleTotal <= 0 {
+		return
+	}
+
+	var discount float64
+	switch coupon.Type {
+	case models.CouponPercentage:
+		discount = eligibleTotal * coupon.Value / 100
+		if coupon.MaxDiscount != nil && discount > *coupon.MaxDiscount {
+			discount = *coupon.MaxDiscount
+		}
+	case models.CouponFixed:
+		discount = math.Min(coupon.Value, eligibleTotal)
+	default:
+		return
+	}
+	discount = RoundMoney(discount)
+
+	// The last line takes the rounding remainder so the parts add up exactly
+	remaining := discount
+	for n, i := range eligible {
+		line := &quote.Lines[i]
+		share := RoundMoney(discount * (line.LineTotal - line.Discount) / eligibleTotal)
+		if n == len(eligible)-1 {
+			share = RoundMoney(remaining)
+		}
+		remaining -= share
+		line.addDiscount(LineDiscount{CouponID: &coupon.ID, Description: "Coupon " + coupon.Code, Amount: share})
+	}
+	quote.CouponDiscount = discount
+}
+
+// EffectivePrice is what one unit of product costs at time at. It is the
+// single pricing policy for carts, listings, quotes and orders:
+//
+//   - the catalogue price is the retail price, or the promotion price while the
+//     product's promotion runs;
+//   - at a franchise (fp non-nil) a retail override replaces the catalogue
+//     price, including a catalogue promotion;
+//   - a franchise promotion override wins over both while its window is open.
+func EffectivePrice(product models.Product, fp *models.FranchiseProduct, at time.Time) float64 {
+	price := product.RetailPrice
+	if product.IsPromotionActiveAt(at) {
+		price = *product.PromotionPrice
+	}
+	if fp == nil {
+		return price
+	}
+	if fp.RetailPriceOverride != nil {
+		price = *fp.RetailPriceOverride
+	}
+	if fp.IsPromotionActiveAt(at) {
+		price = *fp.PromotionPriceOverride
+	}
+	return price
+}
+
+// DeliveryFee returns the delivery charge for a basket subtotal and the
+// threshold above which delivery is free. Collection orders are never charged.
+func DeliveryFee(subtotal float64, opts Options) (float64, float64) {
+	fee, freeMin := DefaultDeliveryFee, DefaultFreeDeliveryMin
+	if opts.Franchise != nil {
+		fee, freeMin = opts.Franchise.DeliveryFee, opts.Franchise.FreeDeliveryMin
+	}
+	if opts.Fulfilment == models.FulfilmentCollection || subtotal >= freeMin {
+		return 0, freeMin
+	}
+	return fee, freeMin
+}
+
+// IncludedTax returns the VAT contained in a tax-inclusive amount at rate percent.
+func IncludedTax(amount, rate float64) float64 {
+	if rate <= 0 {
+		return 0
+	}
+	return RoundMoney(amount * rate / (100 + rate))
+}
+
+// RoundMoney rounds an amount to whole pence.
+func RoundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
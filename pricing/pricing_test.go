@@ -0,0 +1,208 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"grabbi-backend/loyalty"
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+)
+
+func newProduct(price, taxRate float64) models.Product {
+	return models.Product{ID: uuid.New(), ItemName: "Item", SKU: "SKU", RetailPrice: price, TaxRate: taxRate}
+}
+
+func TestCalculateMasterPricesAndDefaultDelivery(t *testing.T) {
+	q := Calculate([]Line{{Product: newProduct(2.50, 0), Quantity: 3}}, nil, Options{})
+
+	if q.Subtotal != 7.50 {
+		t.Errorf("expected subtotal 7.50, got %v", q.Subtotal)
+	}
+	if q.DeliveryFee != DefaultDeliveryFee || q.FreeDeliveryMin != DefaultFreeDeliveryMin {
+		t.Errorf("expected default delivery charges, got fee %v min %v", q.DeliveryFee, q.FreeDeliveryMin)
+	}
+	if q.Total != 11.25 {
+		t.Errorf("expected total 11.25, got %v", q.Total)
+	}
+	if q.PointsEarned != 7 {
+		t.Errorf("expected 7 points, got %d", q.PointsEarned)
+	}
+}
+
+func TestCalculateUsesActivePromotionPrice(t *testing.T) {
+	p := newProduct(10, 0)
+	promo := 6.0
+	start, end := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	p.PromotionPrice, p.PromotionStart, p.PromotionEnd = &promo, &start, &end
+
+	q := Calculate([]Line{{Product: p, Quantity: 1}}, nil, Options{})
+	if q.Lines[0].UnitPrice != 6 {
+		t.Errorf("expected promotion price 6, got %v", q.Lines[0].UnitPrice)
+	}
+}
+
+func TestCalculateFranchiseOverridesAndFreeDelivery(t *testing.T) {
+	p := newProduct(10, 0)
+	retail, promo := 12.0, 11.0
+	franchise := &models.Franchise{DeliveryFee: 4.99, FreeDeliveryMin: 30}
+
+	overrides := map[uuid.UUID]models.FranchiseProduct{
+		p.ID: {ProductID: p.ID, RetailPriceOverride: &retail, PromotionPriceOverride: &promo},
+	}
+	q := Calculate([]Line{{Product: p, Quantity: 3}}, overrides, Options{Franchise: franchise})
+
+	if q.Lines[0].UnitPrice != 11 {
+		t.Errorf("expected franchise promotion price 11, got %v", q.Lines[0].UnitPrice)
+	}
+	if q.Subtotal != 33 || q.DeliveryFee != 0 || q.Total != 33 {
+		t.Errorf("expected free delivery over the minimum, got subtotal %v fee %v total %v", q.Subtotal, q.DeliveryFee, q.Total)
+	}
+}
+
+func TestCalculateCollectionHasNoDeliveryFee(t *testing.T) {
+	franchise := &models.Franchise{DeliveryFee: 4.99, FreeDeliveryMin: 50}
+	q := Calculate([]Line{{Product: newProduct(5, 0), Quantity: 1}}, nil, Options{Franchise: franchise, Fulfilment: models.FulfilmentCollection})
+
+	if q.DeliveryFee != 0 || q.Total != 5 {
+		t.Errorf("expected no fee for collection, got fee %v total %v", q.DeliveryFee, q.Total)
+	}
+}
+
+func TestCalculateIncludedTax(t *testing.T) {
+	q := Calculate([]Line{
+		{Product: newProduct(12, 20), Quantity: 1},
+		{Product: newProduct(3, 0), Quantity: 1},
+	}, nil, Options{})
+
+	if q.Lines[0].Tax != 2 || q.Lines[1].Tax != 0 {
+		t.Errorf("expected line tax 2 and 0, got %v and %v", q.Lines[0].Tax, q.Lines[1].Tax)
+	}
+	if q.Tax != 2 {
+		t.Errorf("expected total tax 2, got %v", q.Tax)
+	}
+}
+
+func TestCalculateSpreadsCouponOverEligibleLines(t *testing.T) {
+	eligible, other := newProduct(6, 20), newProduct(4, 0)
+	eligible.CategoryID = uuid.New()
+	maxDiscount := 1.5
+	coupon := &models.Coupon{
+		Code: "HALF", Type: models.CouponPercentage, Value: 50, MaxDiscount: &maxDiscount,
+		Targets: []models.CouponTarget{{CategoryID: &eligible.CategoryID}},
+	}
+
+	q := Calculate([]Line{{Product: eligible, Quantity: 2}, {Product: other, Quantity: 1}}, nil, Options{Coupon: coupon})
+	if q.Discount != 1.5 {
+		t.Errorf("expected the discount to be capped at 1.50, got %v", q.Discount)
+	}
+	if q.Lines[0].Discount != 1.5 || q.Lines[1].Discount != 0 {
+		t.Errorf("expected the discount on the eligible line only, got %v and %v", q.Lines[0].Discount, q.Lines[1].Discount)
+	}
+	if q.Total != RoundMoney(16-1.5+DefaultDeliveryFee) {
+		t.Errorf("expected total %v, got %v", RoundMoney(16-1.5+DefaultDeliveryFee), q.Total)
+	}
+	if q.Lines[0].Tax != 1.75 {
+		t.Errorf("expected VAT on the discounted line total, got %v", q.Lines[0].Tax)
+	}
+}
+
+func TestCalculateFreeDeliveryCoupon(t *testing.T) {
+	coupon := &models.Coupon{Code: "FREEDEL", Type: models.CouponFreeDelivery}
+	q := Calculate([]Line{{Product: newProduct(5, 0), Quantity: 1}}, nil, Options{Coupon: coupon})
+	if q.DeliveryFee != 0 || q.Total != 5 {
+		t.Errorf("expected delivery to be waived, got fee %v total %v", q.DeliveryFee, q.Total)
+	}
+}
+
+func TestEffectivePriceOverrideCombinations(t *testing.T) {
+	now := time.Date(2030, time.June, 15, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-24*time.Hour), now.Add(24*time.Hour)
+	longAgo := now.Add(-48 * time.Hour)
+	price := func(v float64) *float64 { return &v }
+	at := func(v time.Time) *time.Time { return &v }
+
+	cases := []struct {
+		name string
+		// Master catalogue promotion
+		promo      *float64
+		promoStart *time.Time
+		promoEnd   *time.Time
+		// Franchise listing; nil means master catalogue pricing
+		fp   *models.FranchiseProduct
+		want float64
+	}{
+		{name: "retail price", want: 10},
+		{name: "catalogue promotion running", promo: price(8), promoStart: at(past), promoEnd: at(future), want: 8},
+		{name: "catalogue promotion ended", promo: price(8), promoStart: at(longAgo), promoEnd: at(past), want: 10},
+		{name: "catalogue promotion not started", promo: price(8), promoStart: at(future), want: 10},
+		{name: "catalogue promotion without dates", promo: price(8), want: 10},
+		{name: "franchise without overrides", fp: &models.FranchiseProduct{}, want: 10},
+		{name: "franchise keeps catalogue promotion", promo: price(8), promoStart: at(past), fp: &models.FranchiseProduct{}, want: 8},
+		{name: "franchise retail override", fp: &models.FranchiseProduct{RetailPriceOverride: price(12)}, want: 12},
+		{name: "franchise retail override replaces catalogue promotion", promo: price(8), promoStart: at(past), fp: &models.FranchiseProduct{RetailPriceOverride: price(12)}, want: 12},
+		{name: "franchise promotion without dates", fp: &models.FranchiseProduct{PromotionPriceOverride: price(7)}, want: 7},
+		{name: "franchise promotion in window", fp: &models.FranchiseProduct{PromotionPriceOverride: price(7), PromotionStartOverride: at(past), PromotionEndOverride: at(future)}, want: 7},
+		{name: "franchise promotion open start", fp: &models.FranchiseProduct{PromotionPriceOverride: price(7), PromotionEndOverride: at(future)}, want: 7},
+		{name: "franchise promotion open end", fp: &models.FranchiseProduct{PromotionPriceOverride: price(7), PromotionStartOverride: at(past)}, want: 7},
+		{name: "franchise promotion not started", fp: &models.FranchiseProduct{PromotionPriceOverride: price(7), PromotionStartOverride: at(future)}, want: 10},
+		{name: "franchise promotion ended", fp: &models.FranchiseProduct{PromotionPriceOverride: price(7), PromotionStartOverride: at(longAgo), PromotionEndOverride: at(past)}, want: 10},
+		{name: "franchise promotion ended falls back to retail override", fp: &models.FranchiseProduct{RetailPriceOverride: price(12), PromotionPriceOverride: price(7), PromotionEndOverride: at(past)}, want: 12},
+		{name: "franchise promotion ended falls back to catalogue promotion", promo: price(8), promoStart: at(past), fp: &models.FranchiseProduct{PromotionPriceOverride: price(7), PromotionEndOverride: at(past)}, want: 8},
+		{name: "franchise promotion beats retail override", fp: &models.FranchiseProduct{RetailPriceOverride: price(12), PromotionPriceOverride: price(7), PromotionStartOverride: at(past)}, want: 7},
+		{name: "franchise promotion beats catalogue promotion", promo: price(8), promoStart: at(past), fp: &models.FranchiseProduct{PromotionPriceOverride: price(7)}, want: 7},
+		{name: "franchise window without promotion price", fp: &models.FranchiseProduct{PromotionStartOverride: at(past), PromotionEndOverride: at(future)}, want: 10},
+	}
+	for _, tc := range cases {
+		p := newProduct(10, 0)
+		p.PromotionPrice, p.PromotionStart, p.PromotionEnd = tc.promo, tc.promoStart, tc.promoEnd
+		if got := EffectivePrice(p, tc.fp, now); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestCalculatePricesAtGivenTime(t *testing.T) {
+	p := newProduct(10, 0)
+	promo, end := 6.0, time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	overrides := map[uuid.UUID]models.FranchiseProduct{
+		p.ID: {ProductID: p.ID, PromotionPriceOverride: &promo, PromotionEndOverride: &end},
+	}
+
+	before := Calculate([]Line{{Product: p, Quantity: 1}}, overrides, Options{At: end.Add(-time.Hour)})
+	after := Calculate([]Line{{Product: p, Quantity: 1}}, overrides, Options{At: end.Add(time.Hour)})
+	if before.Lines[0].UnitPrice != 6 || after.Lines[0].UnitPrice != 10 {
+		t.Errorf("expected 6 before the promotion ends and 10 after, got %v and %v", before.Lines[0].UnitPrice, after.Lines[0].UnitPrice)
+	}
+}
+
+func TestCalculateRedeemsPointsAgainstGoods(t *testing.T) {
+	policy := loyalty.Policy{PointValue: 0.01, MaxPercent: 50}
+
+	q := Calculate([]Line{{Product: newProduct(4, 0), Quantity: 2}}, nil, Options{RedeemPoints: 1000, Points: policy})
+	if q.PointsRedeemed != 400 || q.PointsDiscount != 4 {
+		t.Errorf("expected points capped at half the goods, got %d worth %v", q.PointsRedeemed, q.PointsDiscount)
+	}
+	if q.Total != RoundMoney(4+DefaultDeliveryFee) {
+		t.Errorf("expected points off the goods but not delivery, got total %v", q.Total)
+	}
+	if q.Discount != 0 || q.PointsEarned != 4 {
+		t.Errorf("expected points kept out of discount and not earning points, got discount %v earned %d", q.Discount, q.PointsEarned)
+	}
+}
+
+func TestCalculateEarnsTierAndCampaignPoints(t *testing.T) {
+	bonus := newProduct(5, 0)
+	plain := newProduct(10.5, 0)
+	earning := loyalty.Earning{
+		Tier:      &models.LoyaltyTier{Name: "Gold", Multiplier: 1.5},
+		Campaigns: []models.LoyaltyCampaign{{ProductID: bonus.ID, BonusPoints: 10}},
+	}
+
+	q := Calculate([]Line{{Product: bonus, Quantity: 2}, {Product: plain, Quantity: 1}}, nil, Options{Earning: earning})
+	// floor(20.50) = 20, x1.5 = 30, plus 10 on each of the 2 campaign units
+	if q.PointsEarned != 50 {
+		t.Errorf("expected 50 points earned, got %d", q.PointsEarned)
+	}
+}
@@ -0,0 +1,147 @@
+package pricing
+
+import (
+	"sort"
+	"time"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ActiveRules loads the pricing rules in force at t for a franchise: its own
+// rules and those for every store, highest priority first. A nil franchise
+// gets only the rules for every store.
+func ActiveRules(db *gorm.DB, franchise *models.Franchise, t time.Time) ([]models.PricingRule, error) {
+	query := db.Where("is_active = ?", true).
+		Where("starts_at IS NULL OR starts_at <= ?", t).
+		Where("ends_at IS NULL OR ends_at > ?", t)
+	if franchise != nil {
+		query = query.Where("franchise_id IS NULL OR franchise_id = ?", franchise.ID)
+	} else {
+		query = query.Where("franchise_id IS NULL")
+	}
+
+	var rules []models.PricingRule
+	err := query.Order("priority DESC, created_at ASC").Find(&rules).Error
+	return rules, err
+}
+
+// ruleUnit is a single unit of a line that a mix-and-match rule can group.
+type ruleUnit struct {
+	line  int
+	price float64
+}
+
+// applyPricingRules discounts the quote's lines by the basket deals. Rules are
+// tried in the order given and each unit counts towards at most one deal, so a
+// product on both a multi-buy and a mix-and-match only gets the first.
+func applyPricingRules(quote *Quote, lines []Line, rules []models.PricingRule) {
+	if len(rules) == 0 {
+		return
+	}
+	sorted := make([]models.PricingRule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority > sorted[j].Priority })
+
+	remaining := make([]int, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.Quantity
+	}
+
+	for i := range sorted {
+		rule := &sorted[i]
+		switch rule.Type {
+		case models.PricingRuleMultiBuy, models.PricingRuleBOGO:
+			applyProductDeal(quote, lines, remaining, rule)
+		case models.PricingRuleMixAndMatch:
+			applyMixAndMatch(quote, lines, remaining, rule)
+		}
+	}
+}
+
+// applyProductDeal applies a multi-buy or BOGO rule to each covered product on
+// its own.
+func applyProductDeal(quote *Quote, lines []Line, remaining []int, rule *models.PricingRule) {
+	groupSize := rule.Quantity
+	if rule.Type == models.PricingRuleBOGO {
+		groupSize += rule.FreeQuantity
+	}
+	if groupSize <= 0 {
+		return
+	}
+
+	for i, line := range lines {
+		if !rule.Covers(line.Product) {
+			continue
+		}
+		groups := remaining[i] / groupSize
+		if groups == 0 {
+			continue
+		}
+
+		unitPrice := quote.Lines[i].UnitPrice
+		var discount float64
+		if rule.Type == models.PricingRuleBOGO {
+			discount = float64(groups*rule.FreeQuantity) * unitPrice
+		} else {
+			discount = float64(groups) * (float64(rule.Quantity)*unitPrice - rule.BundlePrice)
+		}
+		if RoundMoney(discount) <= 0 {
+			continue
+		}
+
+		remaining[i] -= groups * groupSize
+		quote.Lines[i].addDiscount(LineDiscount{PricingRuleID: ruleID(rule), Description: rule.Name, Amount: discount})
+	}
+}
+
+// applyMixAndMatch groups units across all covered products into bundles of
+// rule.Quantity, dearest first so the customer gets the best deal. Each
+// bundle's saving is shared between its lines by the price of their units.
+func applyMixAndMatch(quote *Quote, lines []Line, remaining []int, rule *models.PricingRule) {
+	if rule.Quantity <= 0 {
+		return
+	}
+
+	var units []ruleUnit
+	for i, line := range lines {
+		if !rule.Covers(line.Product) {
+			continue
+		}
+		for n := 0; n < remaining[i]; n++ {
+			units = append(units, ruleUnit{line: i, price: quote.Lines[i].UnitPrice})
+		}
+	}
+	sort.SliceStable(units, func(a, b int) bool { return units[a].price > units[b].price })
+
+	savings := make(map[int]float64)
+	for start := 0; start+rule.Quantity <= len(units); start += rule.Quantity {
+		bundle := units[start : start+rule.Quantity]
+		var full float64
+		for _, u := range bundle {
+			full += u.price
+		}
+		saving := full - rule.BundlePrice
+		if saving <= 0 {
+			// Later bundles are cheaper still
+			break
+		}
+		for _, u := range bundle {
+			savings[u.line] += saving * u.price / full
+			remaining[u.line]--
+		}
+	}
+
+	for i := range lines {
+		if saving, ok := savings[i]; ok {
+			quote.Lines[i].addDiscount(LineDiscount{PricingRuleID: ruleID(rule), Description: rule.Name, Amount: saving})
+		}
+	}
+}
+
+func ruleID(rule *models.PricingRule) *uuid.UUID {
+	id := rule.ID
+	return &id
+}
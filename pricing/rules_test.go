@@ -0,0 +1,86 @@
+package pricing
+
+import (
+	"testing"
+
+	"grabbi-backend/models"
+
+	"github.com/google/uuid"
+)
+
+func TestPricingRuleMultiBuy(t *testing.T) {
+	p := newProduct(2, 0)
+	rule := models.PricingRule{ID: uuid.New(), Name: "3 for £5", Type: models.PricingRuleMultiBuy, ProductID: &p.ID, Quantity: 3, BundlePrice: 5}
+
+	// 7 units make two bundles of 3 with one left at full price
+	q := Calculate([]Line{{Product: p, Quantity: 7}}, nil, Options{Rules: []models.PricingRule{rule}})
+	if q.Discount != 2 {
+		t.Errorf("expected discount 2.00, got %v", q.Discount)
+	}
+	if len(q.Lines[0].Discounts) != 1 || q.Lines[0].Discounts[0].Description != "3 for £5" {
+		t.Errorf("expected the deal to be itemised on the line, got %+v", q.Lines[0].Discounts)
+	}
+	if q.PointsEarned != 12 {
+		t.Errorf("expected points on the discounted subtotal, got %d", q.PointsEarned)
+	}
+}
+
+func TestPricingRuleBOGO(t *testing.T) {
+	p := newProduct(1.5, 0)
+	rule := models.PricingRule{ID: uuid.New(), Name: "Buy one get one free", Type: models.PricingRuleBOGO, ProductID: &p.ID, Quantity: 1, FreeQuantity: 1}
+
+	q := Calculate([]Line{{Product: p, Quantity: 5}}, nil, Options{Rules: []models.PricingRule{rule}})
+	if q.Lines[0].Discount != 3 {
+		t.Errorf("expected 2 free units worth 3.00, got %v", q.Lines[0].Discount)
+	}
+}
+
+func TestPricingRuleMixAndMatchAcrossCategory(t *testing.T) {
+	categoryID := uuid.New()
+	dear, cheap, outside := newProduct(4, 0), newProduct(2, 0), newProduct(3, 0)
+	dear.CategoryID, cheap.CategoryID = categoryID, categoryID
+	rule := models.PricingRule{ID: uuid.New(), Name: "Any 3 for £6", Type: models.PricingRuleMixAndMatch, CategoryID: &categoryID, Quantity: 3, BundlePrice: 6}
+
+	// Bundles are made from the dearest units: 4+4+2 saves 4.00, leaving 2+2
+	q := Calculate([]Line{{Product: dear, Quantity: 2}, {Product: cheap, Quantity: 3}, {Product: outside, Quantity: 2}}, nil, Options{Rules: []models.PricingRule{rule}})
+	if q.Discount != 4 {
+		t.Errorf("expected discount 4.00, got %v", q.Discount)
+	}
+	if q.Lines[0].Discount != 3.2 || q.Lines[1].Discount != 0.8 {
+		t.Errorf("expected the saving shared by price, got %v and %v", q.Lines[0].Discount, q.Lines[1].Discount)
+	}
+	if q.Lines[2].Discount != 0 {
+		t.Errorf("expected no discount outside the category, got %v", q.Lines[2].Discount)
+	}
+}
+
+func TestPricingRulesCountEachUnitOnce(t *testing.T) {
+	categoryID := uuid.New()
+	p, other := newProduct(2, 0), newProduct(2, 0)
+	p.CategoryID, other.CategoryID = categoryID, categoryID
+	bogo := models.PricingRule{ID: uuid.New(), Name: "BOGOF", Type: models.PricingRuleBOGO, ProductID: &p.ID, Quantity: 1, FreeQuantity: 1, Priority: 10}
+	mix := models.PricingRule{ID: uuid.New(), Name: "Any 2 for £3", Type: models.PricingRuleMixAndMatch, CategoryID: &categoryID, Quantity: 2, BundlePrice: 3}
+
+	// The BOGO takes 2 units; the third joins the other product in a mix and match
+	q := Calculate([]Line{{Product: p, Quantity: 3}, {Product: other, Quantity: 1}}, nil, Options{Rules: []models.PricingRule{mix, bogo}})
+	if q.Discount != 3 {
+		t.Errorf("expected discount 3.00, got %v", q.Discount)
+	}
+	if len(q.Lines[0].Discounts) != 2 || q.Lines[0].Discounts[0].Description != "BOGOF" {
+		t.Errorf("expected the higher priority deal first, got %+v", q.Lines[0].Discounts)
+	}
+}
+
+func TestCouponAppliesAfterPricingRules(t *testing.T) {
+	p := newProduct(10, 0)
+	rule := models.PricingRule{ID: uuid.New(), Name: "2 for £15", Type: models.PricingRuleMultiBuy, ProductID: &p.ID, Quantity: 2, BundlePrice: 15}
+	coupon := &models.Coupon{ID: uuid.New(), Code: "TEN", Type: models.CouponPercentage, Value: 10}
+
+	q := Calculate([]Line{{Product: p, Quantity: 2}}, nil, Options{Rules: []models.PricingRule{rule}, Coupon: coupon})
+	if q.CouponDiscount != 1.5 {
+		t.Errorf("expected 10%% off the deal price, got %v", q.CouponDiscount)
+	}
+	if q.Discount != 6.5 || q.Total != 13.5 {
+		t.Errorf("expected discount 6.50 and total 13.50, got %v and %v", q.Discount, q.Total)
+	}
+}
@@ -7,27 +7,44 @@ import (
 	"grabbi-backend/firebase"
 	"grabbi-backend/handlers"
 	"grabbi-backend/middleware"
+	"grabbi-backend/payments"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func SetupRoutes(r *gin.Engine, db *gorm.DB, storage firebase.StorageClient) {
+func SetupRoutes(r *gin.Engine, db *gorm.DB, storage firebase.StorageClient, paymentProvider payments.PaymentProvider) {
 	// Initialize handlers
 	authHandler := &handlers.AuthHandler{DB: db}
 	productHandler := &handlers.ProductHandler{DB: db, Storage: storage}
 	categoryHandler := &handlers.CategoryHandler{DB: db}
 	subcategoryHandler := &handlers.SubcategoryHandler{DB: db}
 	cartHandler := &handlers.CartHandler{DB: db}
-	orderHandler := &handlers.OrderHandler{DB: db, Storage: storage}
+	guestCartHandler := &handlers.GuestCartHandler{DB: db}
+	wishlistHandler := &handlers.WishlistHandler{DB: db}
+	subscriptionHandler := &handlers.SubscriptionHandler{DB: db, Payments: paymentProvider}
+	checkoutHandler := &handlers.CheckoutHandler{DB: db}
+	orderHandler := &handlers.OrderHandler{DB: db, Storage: storage, Payments: paymentProvider}
 	promotionHandler := &handlers.PromotionHandler{DB: db, Storage: storage}
-	franchiseHandler := &handlers.FranchiseHandler{DB: db, Storage: storage}
+	franchiseHandler := &handlers.FranchiseHandler{DB: db, Storage: storage, Payments: paymentProvider}
+	refundHandler := &handlers.RefundHandler{DB: db, Payments: paymentProvider}
+	couponHandler := &handlers.CouponHandler{DB: db}
+	pricingRuleHandler := &handlers.PricingRuleHandler{DB: db}
+	loyaltyHandler := &handlers.LoyaltyHandler{DB: db}
+	referralHandler := &handlers.ReferralHandler{DB: db}
+	giftCardHandler := &handlers.GiftCardHandler{DB: db}
+	addressHandler := &handlers.AddressHandler{DB: db}
+	paymentWebhookHandler := &handlers.PaymentWebhookHandler{DB: db, Secret: payments.WebhookSecret()}
 
 	// Rate limiters
 	authRateLimiter := middleware.NewRateLimiter(5, 1*time.Minute)
 	passwordResetRateLimiter := middleware.NewRateLimiter(3, 1*time.Minute)
 	orderRateLimiter := middleware.NewRateLimiter(10, 1*time.Minute)
 	cartRateLimiter := middleware.NewRateLimiter(30, 1*time.Minute)
+	giftCardRateLimiter := middleware.NewRateLimiter(10, 1*time.Minute)
+
+	// Replays the original response when a client retries a write with the same Idempotency-Key
+	idempotent := middleware.Idempotency(db)
 
 	// Public routes
 	api := r.Group("/api")
@@ -72,6 +89,24 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, storage firebase.StorageClient) {
 		api.GET("/franchises/:id", franchiseHandler.GetFranchise)
 		api.GET("/franchises/:id/products", franchiseHandler.GetFranchiseProducts)
 		api.GET("/franchises/:id/promotions", franchiseHandler.GetFranchisePromotions)
+		api.GET("/franchises/:id/delivery-slots", franchiseHandler.ListDeliverySlots)
+
+		// Guest cart, identified by the X-Cart-Token header (writes rate limited: 30 requests/minute per IP)
+		api.GET("/guest-cart", guestCartHandler.GetCart)
+		guestCartWrite := api.Group("/guest-cart")
+		guestCartWrite.Use(cartRateLimiter.Middleware())
+		{
+			guestCartWrite.POST("", guestCartHandler.AddToCart)
+			guestCartWrite.PUT("/:id", guestCartHandler.UpdateCartItem)
+			guestCartWrite.DELETE("/:id", guestCartHandler.RemoveFromCart)
+			guestCartWrite.DELETE("", guestCartHandler.ClearCart)
+		}
+
+		// Gift card balance check (rate limited: 10 requests/minute per IP, as codes are bearer secrets)
+		api.GET("/gift-cards/:code/balance", giftCardRateLimiter.Middleware(), giftCardHandler.GetBalance)
+
+		// Payment provider callbacks (authenticated by HMAC signature, not JWT)
+		api.POST("/webhooks/payments", paymentWebhookHandler.HandlePaymentWebhook)
 	}
 
 	// Protected routes (require authentication)
@@ -83,9 +118,17 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, storage firebase.StorageClient) {
 		protected.PUT("/auth/profile", authHandler.UpdateProfile)
 		protected.PUT("/auth/password", authHandler.ChangePassword)
 
+		// Address book
+		protected.GET("/auth/addresses", addressHandler.ListAddresses)
+		protected.POST("/auth/addresses", addressHandler.CreateAddress)
+		protected.PUT("/auth/addresses/:id", addressHandler.UpdateAddress)
+		protected.DELETE("/auth/addresses/:id", addressHandler.DeleteAddress)
+
 		// Loyalty
 		protected.POST("/auth/redeem-points", authHandler.RedeemPoints)
 		protected.GET("/auth/loyalty-history", authHandler.GetLoyaltyHistory)
+		protected.GET("/auth/loyalty", loyaltyHandler.GetLoyaltyStatus)
+		protected.GET("/auth/referral", referralHandler.GetMyReferral)
 
 		// Cart routes
 		protected.GET("/cart", cartHandler.GetCart)
@@ -94,21 +137,50 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, storage firebase.StorageClient) {
 		cartWrite := protected.Group("")
 		cartWrite.Use(cartRateLimiter.Middleware())
 		{
-			cartWrite.POST("/cart", cartHandler.AddToCart)
-			cartWrite.PUT("/cart/:id", cartHandler.UpdateCartItem)
+			cartWrite.POST("/cart", idempotent, cartHandler.AddToCart)
+			cartWrite.PUT("/cart/:id", idempotent, cartHandler.UpdateCartItem)
+			cartWrite.PUT("/cart/franchise", idempotent, cartHandler.SwitchFranchise)
+			cartWrite.POST("/cart/:id/save-for-later", idempotent, cartHandler.SaveForLater)
+			cartWrite.POST("/wishlist/:id/move-to-cart", idempotent, cartHandler.MoveToCart)
+			cartWrite.POST("/orders/:id/reorder", idempotent, cartHandler.Reorder)
 		}
-		protected.DELETE("/cart/:id", cartHandler.RemoveFromCart)
-		protected.DELETE("/cart", cartHandler.ClearCart)
+		protected.DELETE("/cart/:id", idempotent, cartHandler.RemoveFromCart)
+		protected.DELETE("/cart", idempotent, cartHandler.ClearCart)
+
+		// Wishlist
+		protected.GET("/wishlist", wishlistHandler.GetWishlist)
+		protected.POST("/wishlist", wishlistHandler.AddToWishlist)
+		protected.DELETE("/wishlist/:id", wishlistHandler.RemoveFromWishlist)
+
+		// Checkout
+		protected.POST("/checkout/quote", checkoutHandler.Quote)
+		protected.POST("/checkout/reserve", checkoutHandler.ReserveStock)
+		protected.DELETE("/checkout/reserve", checkoutHandler.ReleaseStock)
 
 		// Order routes
 		orderWrite := protected.Group("")
 		orderWrite.Use(orderRateLimiter.Middleware())
 		{
-			orderWrite.POST("/orders", orderHandler.CreateOrder)
+			orderWrite.POST("/orders", idempotent, orderHandler.CreateOrder)
+			orderWrite.PUT("/orders/:id", idempotent, orderHandler.AmendOrder)
+			orderWrite.POST("/orders/:id/cancel", idempotent, orderHandler.CancelOrder)
 		}
 		protected.GET("/orders", orderHandler.GetOrders)
 		protected.GET("/orders/:id", orderHandler.GetOrder)
+		protected.GET("/orders/:id/timeline", orderHandler.GetOrderTimeline)
+		protected.GET("/orders/:id/changes", orderHandler.GetOrderChanges)
+		protected.GET("/orders/:id/events", orderHandler.StreamOrderEvents)
 		protected.GET("/orders/transitions", orderHandler.GetOrderTransitions)
+
+		// Subscriptions
+		protected.GET("/subscriptions", subscriptionHandler.ListSubscriptions)
+		protected.POST("/subscriptions", subscriptionHandler.CreateSubscription)
+		protected.GET("/subscriptions/:id", subscriptionHandler.GetSubscription)
+		protected.PUT("/subscriptions/:id", subscriptionHandler.UpdateSubscription)
+		protected.POST("/subscriptions/:id/skip", subscriptionHandler.SkipSubscription)
+		protected.POST("/subscriptions/:id/pause", subscriptionHandler.PauseSubscription)
+		protected.POST("/subscriptions/:id/resume", subscriptionHandler.ResumeSubscription)
+		protected.DELETE("/subscriptions/:id", subscriptionHandler.CancelSubscription)
 	}
 
 	// Franchise portal routes (require franchise role)
@@ -121,6 +193,7 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, storage firebase.StorageClient) {
 		franchise.GET("/me", franchiseHandler.GetMyFranchise)
 		franchise.GET("/products", franchiseHandler.GetMyProducts)
 		franchise.GET("/orders", franchiseHandler.GetMyOrders)
+		franchise.GET("/orders/stream", franchiseHandler.StreamMyOrders)
 		franchise.GET("/staff", franchiseHandler.GetMyStaff)
 		franchise.GET("/hours", franchiseHandler.GetStoreHours)
 		franchise.GET("/promotions", franchiseHandler.GetMyPromotions)
@@ -140,8 +213,30 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, storage firebase.StorageClient) {
 		franchise.PUT("/promotions/:id", franchiseHandler.UpdatePromotion)
 		franchise.DELETE("/promotions/:id", franchiseHandler.DeletePromotion)
 
+		// Coupon management (limited to the store's own coupons)
+		franchise.GET("/coupons", couponHandler.ListCoupons)
+		franchise.POST("/coupons", couponHandler.CreateCoupon)
+		franchise.PUT("/coupons/:id", couponHandler.UpdateCoupon)
+		franchise.DELETE("/coupons/:id", couponHandler.DeleteCoupon)
+
+		// Gift cards (limited to cards issued for the store)
+		franchise.GET("/gift-cards", giftCardHandler.ListGiftCards)
+		franchise.POST("/gift-cards", giftCardHandler.CreateGiftCard)
+		franchise.GET("/gift-cards/:id", giftCardHandler.GetGiftCard)
+		franchise.PUT("/gift-cards/:id", giftCardHandler.UpdateGiftCard)
+
+		// Basket deals (limited to the store's own rules)
+		franchise.GET("/pricing-rules", pricingRuleHandler.ListPricingRules)
+		franchise.POST("/pricing-rules", pricingRuleHandler.CreatePricingRule)
+		franchise.PUT("/pricing-rules/:id", pricingRuleHandler.UpdatePricingRule)
+		franchise.DELETE("/pricing-rules/:id", pricingRuleHandler.DeletePricingRule)
+
 		// Order management
 		franchise.PUT("/orders/:id/status", franchiseHandler.UpdateOrderStatus)
+		franchise.POST("/orders/:id/refunds", idempotent, refundHandler.CreateRefund)
+		franchise.GET("/orders/:id/refunds", refundHandler.GetRefunds)
+		franchise.POST("/orders/:id/items/:item_id/substitute", idempotent, franchiseHandler.SubstituteOrderItem)
+		franchise.POST("/orders/:id/items/:item_id/short-pick", idempotent, franchiseHandler.ShortPickOrderItem)
 	}
 
 	// Franchise owner-only routes (restricted operations)
@@ -189,6 +284,8 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, storage firebase.StorageClient) {
 
 		// Order management
 		admin.PUT("/orders/:id/status", orderHandler.UpdateOrderStatus)
+		admin.POST("/orders/:id/refunds", idempotent, refundHandler.CreateRefund)
+		admin.GET("/orders/:id/refunds", refundHandler.GetRefunds)
 
 		// Promotion management
 		admin.GET("/promotions", promotionHandler.GetAllPromotions)
@@ -196,6 +293,36 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, storage firebase.StorageClient) {
 		admin.PUT("/promotions/:id", promotionHandler.UpdatePromotion)
 		admin.DELETE("/promotions/:id", promotionHandler.DeletePromotion)
 
+		// Coupon management
+		admin.GET("/coupons", couponHandler.ListCoupons)
+		admin.POST("/coupons", couponHandler.CreateCoupon)
+		admin.PUT("/coupons/:id", couponHandler.UpdateCoupon)
+		admin.DELETE("/coupons/:id", couponHandler.DeleteCoupon)
+
+		// Gift cards
+		admin.GET("/gift-cards", giftCardHandler.ListGiftCards)
+		admin.POST("/gift-cards", giftCardHandler.CreateGiftCard)
+		admin.GET("/gift-cards/:id", giftCardHandler.GetGiftCard)
+		admin.PUT("/gift-cards/:id", giftCardHandler.UpdateGiftCard)
+
+		// Basket deals
+		admin.GET("/pricing-rules", pricingRuleHandler.ListPricingRules)
+		admin.POST("/pricing-rules", pricingRuleHandler.CreatePricingRule)
+		admin.PUT("/pricing-rules/:id", pricingRuleHandler.UpdatePricingRule)
+		admin.DELETE("/pricing-rules/:id", pricingRuleHandler.DeletePricingRule)
+
+		// Loyalty programme
+		admin.GET("/loyalty/tiers", loyaltyHandler.ListTiers)
+		admin.POST("/loyalty/tiers", loyaltyHandler.CreateTier)
+		admin.PUT("/loyalty/tiers/:id", loyaltyHandler.UpdateTier)
+		admin.DELETE("/loyalty/tiers/:id", loyaltyHandler.DeleteTier)
+		admin.GET("/loyalty/campaigns", loyaltyHandler.ListCampaigns)
+		admin.POST("/loyalty/campaigns", loyaltyHandler.CreateCampaign)
+		admin.PUT("/loyalty/campaigns/:id", loyaltyHandler.UpdateCampaign)
+		admin.DELETE("/loyalty/campaigns/:id", loyaltyHandler.DeleteCampaign)
+		admin.POST("/loyalty/reconcile", loyaltyHandler.ReconcilePoints)
+		admin.GET("/referrals", referralHandler.ListReferrals)
+
 		// Franchise management (super admin)
 		admin.GET("/franchises", franchiseHandler.ListFranchises)
 		admin.POST("/franchises", franchiseHandler.CreateFranchise)
@@ -7,6 +7,7 @@ import (
 	"os"
 	"testing"
 
+	"grabbi-backend/payments"
 	"grabbi-backend/utils"
 
 	"github.com/gin-gonic/gin"
@@ -46,7 +47,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		`CREATE TABLE IF NOT EXISTS "users" (
 			"id" TEXT PRIMARY KEY, "email" TEXT NOT NULL UNIQUE, "password" TEXT NOT NULL,
 			"name" TEXT, "role" TEXT DEFAULT 'customer', "franchise_id" TEXT,
-			"loyalty_points" INTEGER DEFAULT 0, "phone" TEXT, "is_blocked" INTEGER DEFAULT 0,
+			"loyalty_points" INTEGER DEFAULT 0, "referral_code" TEXT UNIQUE, "phone" TEXT, "is_blocked" INTEGER DEFAULT 0,
 			"created_at" DATETIME, "updated_at" DATETIME, "deleted_at" DATETIME
 		)`,
 		`CREATE TABLE IF NOT EXISTS "categories" (
@@ -86,7 +87,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 			"id" TEXT PRIMARY KEY, "name" TEXT NOT NULL, "slug" TEXT NOT NULL UNIQUE,
 			"owner_id" TEXT NOT NULL, "address" TEXT, "city" TEXT, "post_code" TEXT,
 			"latitude" REAL NOT NULL, "longitude" REAL NOT NULL, "delivery_radius" REAL DEFAULT 5,
-			"delivery_fee" REAL DEFAULT 4.99, "free_delivery_min" REAL DEFAULT 50,
+			"delivery_fee" REAL DEFAULT 4.99, "free_delivery_min" REAL DEFAULT 50, "slot_capacity" INTEGER DEFAULT 10,
 			"phone" TEXT, "email" TEXT, "is_active" INTEGER DEFAULT 1,
 			"created_at" DATETIME, "updated_at" DATETIME, "deleted_at" DATETIME
 		)`,
@@ -122,14 +123,18 @@ func setupTestDB(t *testing.T) *gorm.DB {
 			"id" TEXT PRIMARY KEY, "user_id" TEXT NOT NULL, "franchise_id" TEXT,
 			"order_number" TEXT NOT NULL UNIQUE, "status" TEXT DEFAULT 'pending',
 			"subtotal" REAL NOT NULL, "delivery_fee" REAL DEFAULT 0, "total" REAL NOT NULL,
-			"delivery_address" TEXT, "payment_method" TEXT, "points_earned" INTEGER DEFAULT 0,
-			"customer_lat" REAL, "customer_lng" REAL,
+			"fulfilment_type" TEXT DEFAULT 'delivery', "delivery_address" TEXT, "address_id" TEXT, "delivery_notes" TEXT, "pickup_code" TEXT, "payment_method" TEXT, "points_earned" INTEGER DEFAULT 0,
+			"points_redeemed" INTEGER DEFAULT 0, "points_discount" REAL DEFAULT 0,
+			"customer_lat" REAL, "customer_lng" REAL, "refunded_amount" REAL DEFAULT 0, "delivery_slot_id" TEXT, "gift_card_amount" REAL DEFAULT 0,
+			"discount" REAL DEFAULT 0, "coupon_id" TEXT, "coupon_code" TEXT, "subscription_id" TEXT,
 			"created_at" DATETIME, "updated_at" DATETIME, "deleted_at" DATETIME
 		)`,
 		`CREATE TABLE IF NOT EXISTS "order_items" (
 			"id" TEXT PRIMARY KEY, "order_id" TEXT NOT NULL, "product_id" TEXT NOT NULL,
 			"image_url" TEXT, "product_name" TEXT, "product_sku" TEXT,
-			"quantity" INTEGER NOT NULL, "price" REAL NOT NULL,
+			"quantity" INTEGER NOT NULL, "price" REAL NOT NULL, "discount" REAL DEFAULT 0, "refunded_quantity" INTEGER DEFAULT 0,
+			"substitution_preference" TEXT DEFAULT 'allow', "preferred_substitute_id" TEXT,
+			"substituted_quantity" INTEGER DEFAULT 0, "substitute_for_id" TEXT,
 			"created_at" DATETIME, "updated_at" DATETIME
 		)`,
 		`CREATE TABLE IF NOT EXISTS "password_reset_tokens" (
@@ -157,7 +162,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 func setupRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
 	db := setupTestDB(t)
 	r := gin.New()
-	SetupRoutes(r, db, &mockStorage{})
+	SetupRoutes(r, db, &mockStorage{}, payments.NewFakeProvider())
 	return r, db
 }
 
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCartToken = errors.New("invalid cart token")
+
+// cartTokenKey derives the guest cart signing key from the JWT secret, so a
+// cart token can never pass as an access token.
+func cartTokenKey() []byte {
+	mac := hmac.New(sha256.New, []byte(getJWTSecret()))
+	mac.Write([]byte("guest-cart"))
+	return mac.Sum(nil)
+}
+
+func cartTokenSignature(cartID string) string {
+	mac := hmac.New(sha256.New, cartTokenKey())
+	mac.Write([]byte(cartID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateCartToken returns the token a guest presents to use their cart: the
+// cart ID and a signature over it.
+func GenerateCartToken(cartID uuid.UUID) string {
+	id := cartID.String()
+	return id + "." + cartTokenSignature(id)
+}
+
+// ValidateCartToken returns the cart ID in a token made by GenerateCartToken.
+func ValidateCartToken(token string) (uuid.UUID, error) {
+	id, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(cartTokenSignature(id))) {
+		return uuid.Nil, ErrInvalidCartToken
+	}
+	cartID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, ErrInvalidCartToken
+	}
+	return cartID, nil
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCartTokenRoundTrip(t *testing.T) {
+	cartID := uuid.New()
+
+	got, err := ValidateCartToken(GenerateCartToken(cartID))
+	if err != nil {
+		t.Fatalf("expected valid token, got %v", err)
+	}
+	if got != cartID {
+		t.Errorf("expected cart %s, got %s", cartID, got)
+	}
+}
+
+func TestCartTokenRejectsTampering(t *testing.T) {
+	token := GenerateCartToken(uuid.New())
+	forged := uuid.New().String() + token[len(uuid.Nil.String()):]
+
+	for _, bad := range []string{"", "not-a-token", forged, token + "x"} {
+		if _, err := ValidateCartToken(bad); !errors.Is(err, ErrInvalidCartToken) {
+			t.Errorf("expected ErrInvalidCartToken for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestCartTokenIsNotAnAccessToken(t *testing.T) {
+	if _, err := ValidateToken(GenerateCartToken(uuid.New())); err == nil {
+		t.Error("expected a cart token to be rejected as an access token")
+	}
+}
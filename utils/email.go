@@ -91,6 +91,24 @@ func SendOrderStatusUpdate(email, name, orderNumber, status string) {
 	}()
 }
 
+func SendBackInStockEmail(email, name, productName, storeName string) {
+	go func() {
+		subject := fmt.Sprintf("%s is back in stock - Grabbi", productName)
+		where := ""
+		if storeName != "" {
+			where = fmt.Sprintf(" at <strong>%s</strong>", storeName)
+		}
+		body := fmt.Sprintf(`<h2>Back in Stock</h2>
+<p>Hi %s,</p>
+<p><strong>%s</strong> from your wishlist is back in stock%s.</p>
+<p>Grab it before it goes again!</p>
+<p>The Grabbi Team</p>`, strings.Split(name, " ")[0], productName, where)
+		if err := SendEmail(email, subject, body); err != nil {
+			log.Printf("Failed to send back in stock email to %s: %v", email, err)
+		}
+	}()
+}
+
 func SendPasswordResetEmail(email, name, resetToken, frontendURL string) {
 	go func() {
 		resetLink := fmt.Sprintf("%s/reset-password?token=%s", frontendURL, resetToken)
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Order update types published to subscribers.
+const (
+	OrderUpdateSnapshot      = "order.snapshot"
+	OrderUpdateCreated       = "order.created"
+	OrderUpdateStatusChanged = "order.status_changed"
+	OrderUpdateItemsChanged  = "order.items_changed"
+	OrderUpdateAmended       = "order.amended"
+)
+
+// subscriberBuffer is how many updates a slow subscriber may fall behind before
+// further updates to it are dropped.
+const subscriberBuffer = 16
+
+// OrderUpdate is a change to an order broadcast to live subscribers.
+type OrderUpdate struct {
+	Type        string     `json:"type"`
+	OrderID     uuid.UUID  `json:"order_id"`
+	OrderNumber string     `json:"order_number"`
+	UserID      uuid.UUID  `json:"user_id"`
+	FranchiseID *uuid.UUID `json:"franchise_id,omitempty"`
+	Status      string     `json:"status"`
+	At          time.Time  `json:"at"`
+}
+
+type orderSubscriber struct {
+	ch     chan OrderUpdate
+	filter func(OrderUpdate) bool
+}
+
+// OrderBroker is an in-process pub/sub hub for order updates. Publishing never
+// blocks: updates to a subscriber whose buffer is full are dropped.
+type OrderBroker struct {
+	subscribers map[*orderSubscriber]struct{}
+	closed      bool
+	mu          sync.RWMutex
+}
+
+// Global order broker instance
+var OrderEvents = NewOrderBroker()
+
+func NewOrderBroker() *OrderBroker {
+	return &OrderBroker{subscribers: make(map[*orderSubscriber]struct{})}
+}
+
+// Subscribe registers for updates matching filter. The returned function must be
+// called to unsubscribe; the channel is closed when the broker shuts down.
+func (b *OrderBroker) Subscribe(filter func(OrderUpdate) bool) (<-chan OrderUpdate, func()) {
+	sub := &orderSubscriber{ch: make(chan OrderUpdate, subscriberBuffer), filter: filter}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	b.subscribers[sub] = struct{}{}
+
+	return sub.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[sub]; ok {
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Publish delivers update to every matching subscriber.
+func (b *OrderBroker) Publish(update OrderUpdate) {
+	if update.At.IsZero() {
+		update.At = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscribers {
+		if sub.filter != nil && !sub.filter(update) {
+			continue
+		}
+		select {
+		case sub.ch <- update:
+		default:
+		}
+	}
+}
+
+// Close ends every subscription so open streams finish during shutdown.
+func (b *OrderBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		close(sub.ch)
+	}
+	b.subscribers = make(map[*orderSubscriber]struct{})
+	b.closed = true
+}
+
+// SubscriberCount returns the number of open subscriptions.
+func (b *OrderBroker) SubscriberCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func receiveUpdate(t *testing.T, ch <-chan OrderUpdate) OrderUpdate {
+	t.Helper()
+	select {
+	case update := <-ch:
+		return update
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for update")
+	}
+	return OrderUpdate{}
+}
+
+func TestOrderBrokerDeliversMatchingUpdates(t *testing.T) {
+	broker := NewOrderBroker()
+	orderID := uuid.New()
+
+	ch, unsubscribe := broker.Subscribe(func(u OrderUpdate) bool { return u.OrderID == orderID })
+	defer unsubscribe()
+
+	broker.Publish(OrderUpdate{Type: OrderUpdateStatusChanged, OrderID: uuid.New(), Status: "confirmed"})
+	broker.Publish(OrderUpdate{Type: OrderUpdateStatusChanged, OrderID: orderID, Status: "preparing"})
+
+	update := receiveUpdate(t, ch)
+	if update.OrderID != orderID || update.Status != "preparing" {
+		t.Errorf("expected update for %s, got %+v", orderID, update)
+	}
+	if update.At.IsZero() {
+		t.Error("expected publish time to be set")
+	}
+
+	select {
+	case extra := <-ch:
+		t.Errorf("expected no further updates, got %+v", extra)
+	default:
+	}
+}
+
+func TestOrderBrokerUnsubscribe(t *testing.T) {
+	broker := NewOrderBroker()
+	ch, unsubscribe := broker.Subscribe(nil)
+
+	if broker.SubscriberCount() != 1 {
+		t.Fatalf("expected 1 subscriber, got %d", broker.SubscriberCount())
+	}
+
+	unsubscribe()
+	unsubscribe() // safe to call twice
+
+	if broker.SubscriberCount() != 0 {
+		t.Errorf("expected 0 subscribers, got %d", broker.SubscriberCount())
+	}
+	if _, ok := <-ch; ok {
+		t.Error("expected channel to be closed")
+	}
+}
+
+func TestOrderBrokerDropsWhenSubscriberIsSlow(t *testing.T) {
+	broker := NewOrderBroker()
+	ch, unsubscribe := broker.Subscribe(nil)
+	defer unsubscribe()
+
+	for i := 0; i < subscriberBuffer+5; i++ {
+		broker.Publish(OrderUpdate{OrderID: uuid.New()})
+	}
+
+	if len(ch) != subscriberBuffer {
+		t.Errorf("expected buffer to hold %d updates, got %d", subscriberBuffer, len(ch))
+	}
+}
+
+func TestOrderBrokerClose(t *testing.T) {
+	broker := NewOrderBroker()
+	ch, unsubscribe := broker.Subscribe(nil)
+
+	broker.Close()
+	if _, ok := <-ch; ok {
+		t.Error("expected channel to be closed")
+	}
+	unsubscribe() // must not panic after close
+
+	late, _ := broker.Subscribe(nil)
+	if _, ok := <-late; ok {
+		t.Error("expected subscription after close to be closed immediately")
+	}
+}